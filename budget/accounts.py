@@ -0,0 +1,94 @@
+"""
+Friendly names for the accounts of every source, from the `accounts` section of the config.
+
+Sources name accounts their own way, `ACT-9f2c`, `csv:chase-checking` or `Chase 1234`. An alias
+gives the raw accounts listed under it one name and one ID, the first listed, which the sheet's
+columns, exclusion rules, per-account tab templates and the composite dedup key then see. Listing
+the raw accounts of several sources under one alias marks them as the same real-world account, so
+a CSV backfill of the SimpleFIN checking account lands in the same tab and dedups against it.
+
+An alias can also tag its accounts with an owner and a purpose, personal or business. The owner
+replaces the one of the SimpleFIN connection, both are written to the owner and purpose columns,
+passed to WASM rules and matched by exclusion rules. The purpose is available to tab templates as
+`{account.purpose}`, so `transactions-{account.purpose}` keeps business accounts on a tab of their own.
+
+Sample config:
+```yaml
+accounts:
+  - name: Joint checking
+    ids: [ACT-9f2c, "csv:chase-checking", "ofx:000123456789"]
+  - name: Amex
+    ids: ACT-77aa
+    owner: Sam
+  - name: Shop checking
+    ids: ACT-41d0
+    purpose: business
+```
+"""
+
+import logging
+from collections.abc import Sequence
+from typing import Any, NamedTuple, Self
+
+from budget.config import ConfigError
+from budget.models.simplefin import AccountPurpose, AccountRef, SimpleFinAccount
+
+logger = logging.getLogger(__name__)
+
+
+class AccountAlias(NamedTuple):
+    name: str
+    ids: tuple[str, ...]
+    owner: str | None = None
+    purpose: AccountPurpose = AccountPurpose.PERSONAL
+
+    @property
+    def id(self) -> str:
+        """The canonical ID, the first raw one so composite keys of rows already in the sheet stay valid."""
+        return self.ids[0]
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not data.get("name") or not data.get("ids"):
+            msg = f"Invalid account alias {data!r}, name and ids are required"
+            raise ConfigError(msg)
+        if data.get("purpose", AccountPurpose.PERSONAL) not in set(AccountPurpose):
+            msg = f"Invalid purpose {data['purpose']!r} of the {data['name']} account, expected personal or business"
+            raise ConfigError(msg)
+        ids = data["ids"]
+        return cls(
+            name=str(data["name"]),
+            ids=tuple(str(raw_id) for raw_id in ([ids] if isinstance(ids, str) else ids)),
+            owner=str(data["owner"]) if data.get("owner") else None,
+            purpose=AccountPurpose(data.get("purpose", AccountPurpose.PERSONAL)),
+        )
+
+
+def validate_aliases(aliases: Sequence[AccountAlias]) -> str | None:
+    """Returns why the aliases are ambiguous, or None when each raw account has at most one alias."""
+    seen: dict[str, str] = {}
+    for alias in aliases:
+        for raw_id in alias.ids:
+            if raw_id in seen:
+                return f"Account {raw_id} is listed under both the {seen[raw_id]} and {alias.name} aliases"
+            seen[raw_id] = alias.name
+    return None
+
+
+def apply_aliases(accounts: Sequence[SimpleFinAccount], aliases: Sequence[AccountAlias]) -> None:
+    """Renames and tags aliased accounts and their transactions' account, before any other processing sees them."""
+    if not aliases:
+        return
+    index = {raw_id: alias for alias in aliases for raw_id in alias.ids}
+    for account in accounts:
+        alias = index.get(account.id)
+        if not alias:
+            continue
+        logger.debug("Account %s (%s) is %s", account.id, account.name, alias.name)
+        account.id, account.name = alias.id, alias.name
+        account_ref = AccountRef(
+            id=alias.id, name=alias.name, org=account.org.name, currency=account.currency, purpose=alias.purpose
+        )
+        for transaction in account.transactions:
+            transaction.account = account_ref
+            transaction.owner = alias.owner or transaction.owner
@@ -0,0 +1,43 @@
+import json
+import logging
+import urllib.request
+from typing import Final
+
+logger = logging.getLogger(__name__)
+
+WEBHOOK_TIMEOUT: Final = 10
+
+_webhook_url: str | None = None
+_sent: set[str] = set()
+
+
+def configure(webhook_url: str | None) -> None:
+    """Sets the webhook alerts are posted to in addition to the log."""
+    global _webhook_url  # noqa: PLW0603
+    _webhook_url = webhook_url
+
+
+def send_alert(message: str, *, once: bool = False) -> None:
+    """
+    Logs the alert and posts `{"text": message}` to the configured webhook, if any.
+
+    With once, a message that was already sent by this process is only logged, so a notice repeated
+    on every scheduled run doesn't post to the webhook every hour.
+    """
+    logger.error("ALERT: %s", message)
+    if once and message in _sent:
+        return
+    _sent.add(message)
+    if not _webhook_url:
+        return
+    request = urllib.request.Request(  # noqa: S310 - the URL comes from the user's config
+        _webhook_url,
+        data=json.dumps({"text": message}).encode(),
+        headers={"Content-Type": "application/json"},
+        method="POST",
+    )
+    try:
+        with urllib.request.urlopen(request, timeout=WEBHOOK_TIMEOUT):  # noqa: S310
+            pass
+    except OSError:
+        logger.exception("Failed to post alert to the webhook")
@@ -0,0 +1,64 @@
+"""
+Spreads lumpy bills, like yearly insurance or an annual subscription, over the months they pay for.
+
+A mapping rule marks its payee's transactions for amortization with a number of months in the lookup sheet's
+fifth column, after the budget. The row is imported as it is, only the budget status and the summary see the
+amount split into equal parts, one in the month it was paid and one on the first of each following month, the
+first part taking what the rounding left over. With amortized rules the summary is a table written by each
+import rather than a pivot table, which can't split rows.
+"""
+
+import logging
+from collections.abc import Mapping, Sequence
+from datetime import date
+from decimal import Decimal
+from typing import Final
+
+from budget.models.google import SheetTransaction
+
+logger = logging.getLogger(__name__)
+
+AMORTIZE_COLUMN: Final = 4
+
+
+def parse_amortization(rows: Sequence[list[str]]) -> dict[str, int]:
+    """
+    The months each payee's transactions are spread over, from the lookup sheet's fifth column.
+
+    Rules are also keyed by the name they give the payee, as that is what the transactions tab holds.
+    """
+    months: dict[str, int] = {}
+    for row in rows:
+        if len(row) <= AMORTIZE_COLUMN or not row[0] or not (value := row[AMORTIZE_COLUMN].strip()):
+            continue
+        if not value.isdigit() or int(value) < 1:
+            logger.warning("Ignoring the amortization of %s, %r isn't a number of months", row[0], value)
+            continue
+        months[row[0]] = int(value)
+        if len(row) > 2 and row[2]:
+            months[row[2]] = int(value)
+    return months
+
+
+def add_months(day: date, months: int) -> date:
+    """The first of the month the given number of months after the day's."""
+    year, month = divmod(day.month - 1 + months, 12)
+    return date(day.year + year, month + 1, 1)
+
+
+def amortize(transactions: Sequence[SheetTransaction], months: Mapping[str, int]) -> list[SheetTransaction]:
+    """The transactions with those of amortized payees split into their monthly parts."""
+    if not months:
+        return list(transactions)
+    spread: list[SheetTransaction] = []
+    for transaction in transactions:
+        count = months.get(transaction.payee, 1)
+        if count == 1:
+            spread.append(transaction)
+            continue
+        part = (transaction.amount / count).quantize(Decimal("0.01"))
+        spread.append(transaction._replace(amount=transaction.amount - part * (count - 1)))
+        spread.extend(
+            transaction._replace(amount=part, date=add_months(transaction.date, offset)) for offset in range(1, count)
+        )
+    return spread
@@ -0,0 +1,70 @@
+import logging
+from dataclasses import dataclass
+from datetime import UTC, date, datetime
+from typing import Final
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.main import Args
+from budget.models.google import SheetTransaction
+from budget.routing import ARCHIVE_SUFFIX
+
+logger = logging.getLogger(__name__)
+
+ARCHIVE_MONTHS: Final = 12
+
+
+@dataclass()
+class ArchiveArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    months: int = ARCHIVE_MONTHS
+    archive_tab: str | None = None
+
+    @property
+    def destination(self) -> str:
+        return self.archive_tab or f"{self.args.sheets_range_name}-{ARCHIVE_SUFFIX}"
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if self.months < 1:
+            errors.append(f"Months must be at least 1, got {self.months}")
+        if self.destination == self.args.sheets_range_name:
+            errors.append("The archive tab must differ from the transactions tab")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise ArchiveArgs.Error(msg)
+
+
+def archive_cutoff(today: date, months: int) -> date:
+    """The first day of the month `months` months before today, whole months are archived at a time."""
+    year, month = divmod(today.year * 12 + today.month - 1 - months, 12)
+    return date(year, month + 1, 1)
+
+
+def archive(args: ArchiveArgs) -> int:
+    """
+    Moves rows dated before the cutoff to the archive tab, returning how many were moved.
+
+    Rows are appended to the archive before they are deleted, so an interrupted run leaves duplicates
+    behind rather than losing transactions.
+    """
+    cutoff = archive_cutoff(datetime.now(UTC).date(), args.months)
+    spreadsheet_id = args.args.sheets_spreadsheet_id
+    source = args.args.sheets_range_name
+    with GoogleClient(args.args.google_credentials) as google, breaker("google").guard():
+        rows = google.get_rows(spreadsheet_id, source)
+        old = [
+            (index, row)
+            for index, row in enumerate(rows, start=1)
+            if (transaction := SheetTransaction.from_row(row)) and transaction.date < cutoff
+        ]
+        if not old:
+            logger.info("Nothing older than %s to archive", cutoff)
+            return 0
+        google.append_rows(spreadsheet_id, args.destination, [row for _, row in old])
+        google.delete_rows(spreadsheet_id, source, [index for index, _ in old])
+    logger.info("Archived %d rows older than %s to %s", len(old), cutoff, args.destination)
+    return len(old)
@@ -0,0 +1,92 @@
+"""
+Per-run artifacts, an audit trail of every import kept outside the spreadsheet.
+
+Each run writes a file to the artifacts folder listing every transaction it considered, the tab it was
+routed to, what deduplication decided, the mapping rule that matched and the row values for the sheet.
+The file is JSON, or CSV with one row per transaction, named after the time and ID of the run.
+
+Sample config:
+```yaml
+artifacts:
+  dir: /data/artifacts
+  format: json
+```
+"""
+
+import csv
+import json
+import logging
+from collections.abc import Sequence
+from datetime import UTC, datetime
+from enum import StrEnum
+from pathlib import Path
+from typing import Any, NamedTuple
+
+from budget.clients.google import convert_to_row
+from budget.models.google import SheetLayout
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+class ArtifactFormat(StrEnum):
+    JSON = "json"
+    CSV = "csv"
+
+
+class Decision(StrEnum):
+    """What the run did with a transaction."""
+
+    NEW = "new"
+    EXISTING = "existing"
+    UPDATED = "updated"
+    DUPLICATE = "duplicate"
+    SKIPPED = "skipped"
+
+
+class ArtifactEntry(NamedTuple):
+    tab: str
+    transaction: SimpleFinTransaction
+    decision: Decision
+
+
+def entry_dict(entry: ArtifactEntry, layout: SheetLayout) -> dict[str, Any]:
+    transaction = entry.transaction
+    row = convert_to_row(transaction, layout)
+    return {
+        "tab": entry.tab,
+        "row_id": transaction.row_id,
+        "decision": entry.decision.value,
+        "source": transaction.source,
+        "account": transaction.account.name if transaction.account else None,
+        "rule": transaction.rule,
+        "mapped": transaction.mapped,
+        "row": dict(zip(layout.columns(), (str(cell) for cell in row), strict=False)),
+    }
+
+
+def write_artifact(
+    directory: str,
+    run_id: str,
+    entries: Sequence[ArtifactEntry],
+    layout: SheetLayout,
+    artifact_format: ArtifactFormat = ArtifactFormat.JSON,
+) -> Path:
+    """Writes the run's artifact to the directory, created when missing, and returns its path."""
+    created_at = datetime.now(UTC)
+    path = Path(directory) / f"{created_at:%Y%m%dT%H%M%SZ}-{run_id}.{artifact_format}"
+    path.parent.mkdir(parents=True, exist_ok=True)
+    records = [entry_dict(entry, layout) for entry in entries]
+    with path.open("w", encoding="utf-8", newline="") as file:
+        if artifact_format == ArtifactFormat.JSON:
+            data = {"run_id": run_id, "created_at": created_at.isoformat(), "transactions": records}
+            json.dump(data, file, indent=2)
+            _ = file.write("\n")
+        else:
+            fields = ["tab", "row_id", "decision", "source", "account", "rule", "mapped", *layout.columns()]
+            writer = csv.DictWriter(file, fields)
+            writer.writeheader()
+            for record in records:
+                writer.writerow({**{key: value for key, value in record.items() if key != "row"}, **record["row"]})
+    logger.info("Wrote the artifact of run %s with %d transactions to %s", run_id, len(entries), path)
+    return path
@@ -0,0 +1,100 @@
+import logging
+from dataclasses import dataclass, replace
+from datetime import UTC, date, datetime, time, timedelta
+from typing import Final
+
+from budget import shutdown
+from budget.main import Args, run_once
+from budget.state import ImportState
+
+logger = logging.getLogger(__name__)
+
+BACKFILL_WINDOW_DAYS: Final = 30
+
+
+@dataclass()
+class BackfillArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    since: str
+    window_days: int = BACKFILL_WINDOW_DAYS
+
+    @property
+    def since_date(self) -> date:
+        return date.fromisoformat(self.since)
+
+    @property
+    def state_file(self) -> str:
+        return self.args.state_file or ""
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        try:
+            if self.since_date >= self.args.clock().date():
+                errors.append(f"A backfill starts in the past, got {self.since}")
+        except ValueError:
+            errors.append(f"Invalid date {self.since!r}, expected YYYY-MM-DD")
+        if self.window_days < 1:
+            errors.append(f"Window days must be at least 1, got {self.window_days}")
+        if not self.args.state_file:
+            errors.append("A backfill keeps its progress in the state file, set --state-file")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise BackfillArgs.Error(msg)
+
+
+def backfill_windows(start: date, end: datetime, days: int) -> list[tuple[datetime, datetime | None]]:
+    """
+    Splits the range into windows of `days` from midnight UTC, oldest first.
+
+    The last window is open ended like a run's lookback, so nothing posted while backfilling is missed.
+    """
+    windows: list[tuple[datetime, datetime | None]] = []
+    window_start = datetime.combine(start, time(), UTC)
+    while end - window_start > timedelta(days=days):
+        window_end = window_start + timedelta(days=days)
+        windows.append((window_start, window_end))
+        window_start = window_end
+    windows.append((window_start, None))
+    return windows
+
+
+def backfill(args: BackfillArgs) -> int:
+    """
+    Imports the history since a date a window at a time, oldest first, returning how many transactions it added.
+
+    Each window is imported as a run of its own, with the window in place of the lookback, reported to Sentry
+    and exported to the metrics like any other run, so a crash halfway through a multi-month backfill loses at
+    most the window in progress. The end of each imported window is saved to the state file, and running the
+    backfill again from the same date skips the windows already done, the windows of a different start date
+    begin anew. Within a window the write checkpoint tracks the appended batches, so
+    the retry of a window interrupted while writing doesn't append them twice. The progress is cleared once
+    the last window, which is open ended like a run's lookback, is imported.
+    """
+    windows = backfill_windows(args.since_date, args.args.clock(), args.window_days)
+    progress = ImportState(args.state_file).backfill
+    if progress.get("since") == args.since:
+        completed = datetime.fromisoformat(progress["completed"])
+        done = sum(1 for _, window_end in windows if window_end and window_end <= completed)
+        logger.info("Resuming the backfill since %s after %d of %d windows", args.since, done, len(windows))
+        windows = [(max(window_start, completed), window_end) for window_start, window_end in windows[done:]]
+    elif progress:
+        logger.info("Starting over, the backfill in progress started on %s", progress.get("since"))
+
+    imported = 0
+    # a signal lets the window in progress finish its writes, the next windows are left for the resume
+    with shutdown.graceful():
+        for window_start, window_end in windows:
+            shutdown.check()
+            logger.info("Backfilling from %s to %s", window_start.date(), window_end.date() if window_end else "now")
+            transactions = run_once(replace(args.args, window=(window_start, window_end)))
+            imported += len(transactions)
+            if window_end:
+                progress = {"since": args.since, "completed": window_end.isoformat()}
+                ImportState(args.state_file).record_backfill(progress)
+
+    ImportState(args.state_file).record_backfill({})
+    logger.info("Backfilled %d transactions since %s", imported, args.since)
+    return imported
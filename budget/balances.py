@@ -0,0 +1,66 @@
+import logging
+from collections import defaultdict
+from collections.abc import Sequence
+from datetime import UTC, datetime
+from decimal import Decimal, InvalidOperation
+from typing import Final
+
+from budget.models.google import GoogleSheetRow
+from budget.models.simplefin import SimpleFinAccount
+
+logger = logging.getLogger(__name__)
+
+HEADER: Final = ["Account", "Institution", "Currency", "Balance", "Holdings", "Total", "As of"]
+NET_WORTH: Final = "Net worth"
+
+
+def parse_decimal(value: str | None, context: str) -> Decimal:
+    """Parses a decimal string from the bridge, an empty or malformed value counts as zero."""
+    if not value:
+        return Decimal(0)
+    try:
+        return Decimal(value.strip().replace(",", ""))
+    except InvalidOperation:
+        logger.warning("Ignoring invalid amount %r for %s", value, context)
+        return Decimal(0)
+
+
+def holdings_value(account: SimpleFinAccount) -> Decimal:
+    """The summed market value of an investment account's holdings."""
+    return sum(
+        (parse_decimal(holding.market_value, f"{account.name} {holding.symbol}") for holding in account.holdings),
+        Decimal(0),
+    )
+
+
+def balance_rows(accounts: Sequence[SimpleFinAccount]) -> list[GoogleSheetRow]:
+    """
+    One row per account with its cash balance, holdings value and total, followed by the net worth per currency.
+
+    Brokerage accounts report cash in their balance, adding the holdings makes them count at portfolio value.
+    Accounts linked by an alias are listed once, with the balance of the first source reporting one.
+    """
+    rows: list[GoogleSheetRow] = [list(HEADER)]
+    totals: defaultdict[str, Decimal] = defaultdict(Decimal)
+    unique: dict[str, SimpleFinAccount] = {}
+    for account in accounts:
+        if account.id not in unique or (account.balance and not unique[account.id].balance):
+            unique[account.id] = account
+    for account in sorted(unique.values(), key=lambda a: (a.org.name, a.name)):
+        balance = parse_decimal(account.balance, account.name)
+        holdings = holdings_value(account)
+        totals[account.currency] += balance + holdings
+        as_of = datetime.fromtimestamp(account.balance_date, tz=UTC) if account.balance_date else None
+        rows.append(
+            [
+                account.name,
+                account.org.name,
+                account.currency,
+                float(balance),
+                float(holdings),
+                float(balance + holdings),
+                f"{as_of:%Y-%m-%d %H:%M}" if as_of else "",
+            ]
+        )
+    rows.extend([NET_WORTH, "", currency, "", "", float(total), ""] for currency, total in sorted(totals.items()))
+    return rows
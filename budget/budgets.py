@@ -0,0 +1,88 @@
+import logging
+from collections import defaultdict
+from collections.abc import Sequence
+from datetime import date
+from decimal import Decimal
+from typing import Final, NamedTuple
+
+from budget.models.google import GoogleSheetRow, SheetTransaction, parse_amount, split_category
+from budget.periods import MONTHLY, Periods
+
+logger = logging.getLogger(__name__)
+
+BUDGET_COLUMN: Final = 3
+HEADER: Final = ["Category", "Budget", "Rollover", "Spent", "Remaining"]
+
+
+class BudgetStatus(NamedTuple):
+    category: str
+    budget: Decimal
+    rollover: Decimal
+    spent: Decimal
+
+    @property
+    def remaining(self) -> Decimal:
+        return self.budget + self.rollover - self.spent
+
+    def to_row(self) -> GoogleSheetRow:
+        return [self.category, float(self.budget), float(self.rollover), float(self.spent), float(self.remaining)]
+
+
+def parse_budgets(rows: Sequence[list[str]], *, groups: bool = False) -> dict[str, Decimal]:
+    """
+    Reads the budget per category and period, monthly by default, from the lookup sheet's fourth column.
+
+    The first row naming a category with a budget sets it, with groups budgets are keyed by the
+    category without its group as that is what the transactions sheet holds.
+    """
+    budgets: dict[str, Decimal] = {}
+    for row in rows:
+        if len(row) <= BUDGET_COLUMN or not row[1] or (amount := parse_amount(row[BUDGET_COLUMN])) is None:
+            continue
+        category = split_category(row[1])[1] if groups else row[1]
+        if category in budgets and budgets[category] != amount:
+            logger.warning("Conflicting budgets for %s, keeping %s", category, budgets[category])
+            continue
+        budgets[category] = amount
+    return budgets
+
+
+def period_spend(transactions: Sequence[SheetTransaction], periods: Periods) -> dict[date, dict[str, Decimal]]:
+    """Net spend per period, keyed by its first day, and category, refunds reduce the spend."""
+    spend: defaultdict[date, defaultdict[str, Decimal]] = defaultdict(lambda: defaultdict(Decimal))
+    for transaction in transactions:
+        spend[periods.start(transaction.date)][transaction.category] -= transaction.amount
+    return {start: dict(categories) for start, categories in spend.items()}
+
+
+def month_spend(transactions: Sequence[SheetTransaction]) -> dict[date, dict[str, Decimal]]:
+    """Net spend per month and category, refunds reduce the spend."""
+    return period_spend(transactions, MONTHLY)
+
+
+def budget_status(
+    budgets: dict[str, Decimal],
+    transactions: Sequence[SheetTransaction],
+    day: date,
+    *,
+    rollover: bool = False,
+    periods: Periods = MONTHLY,
+) -> list[BudgetStatus]:
+    """
+    What is left of each category's budget in the period holding `day`.
+
+    With rollover, the unspent part of every earlier period of the same year is carried forward,
+    overspending a period doesn't eat into the next one.
+    """
+    spend = period_spend(transactions, periods)
+    start = periods.start(day)
+    statuses: list[BudgetStatus] = []
+    for category, budget in sorted(budgets.items()):
+        carried = Decimal(0)
+        if rollover:
+            for previous in periods.earlier_in_year(day):
+                spent = spend.get(previous, {}).get(category, Decimal(0))
+                carried = max(Decimal(0), carried + budget - spent)
+        spent = spend.get(start, {}).get(category, Decimal(0))
+        statuses.append(BudgetStatus(category=category, budget=budget, rollover=carried, spent=spent))
+    return statuses
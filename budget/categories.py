@@ -0,0 +1,124 @@
+import logging
+from collections.abc import Sequence
+from dataclasses import dataclass
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.ledger import Ledger
+from budget.main import Args
+from budget.models.google import CATEGORY_SEPARATOR, SheetLayout, SheetTransaction, split_category
+from budget.styles import cell_text
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class RenameCategoryArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    old: str
+    new: str
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if not self.old.strip() or not self.new.strip():
+            errors.append("The category to rename and its new name are required")
+        elif self.old == self.new:
+            errors.append(f"The category is already named {self.new}")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise RenameCategoryArgs.Error(msg)
+
+
+def row_category(row: list[str], layout: SheetLayout) -> str:
+    """The full category of a transactions row, with its group when the group has a column of its own."""
+    sheet_row = SheetTransaction.from_row(row)
+    category = sheet_row.category if sheet_row else ""
+    if not layout.category_groups:
+        return category
+    group_column = layout.columns().index("category_group")
+    group = row[group_column] if len(row) > group_column else ""
+    return f"{group}{CATEGORY_SEPARATOR}{category}" if group else category
+
+
+def is_untouched(row: list[str], layout: SheetLayout) -> bool:
+    """Whether the row still holds what was imported, only known with the checksum column."""
+    if not layout.checksum:
+        return False
+    checksum_column = layout.columns().index("checksum")
+    sheet_row = SheetTransaction.from_row(row)
+    return bool(sheet_row) and len(row) > checksum_column and row[checksum_column] == sheet_row.checksum
+
+
+def row_cells(
+    index: int, row: list[str], layout: SheetLayout, *, payee: str | None = None, category: str | None = None
+) -> dict[tuple[int, int], str]:
+    """
+    The cells to rewrite, by their 1-based row and column, to give the row the payee and category.
+
+    A row untouched since its import gets the checksum of the new values and stays untouched, an edited
+    one keeps looking edited.
+    """
+    columns = layout.columns()
+    sheet_row = SheetTransaction.from_row(row)
+    if not sheet_row:
+        return {}
+    cells: dict[tuple[int, int], str] = {}
+    if payee is not None:
+        cells[index, columns.index("payee") + 1] = payee
+        sheet_row = sheet_row._replace(payee=payee)
+    if category is not None:
+        group, name = split_category(category) if layout.category_groups else ("", category)
+        cells[index, columns.index("category") + 1] = cell_text(name, dict(layout.category_emoji).get(category, ""))
+        if layout.category_groups:
+            cells[index, columns.index("category_group") + 1] = group
+        sheet_row = sheet_row._replace(category=name)
+    if is_untouched(row, layout):
+        cells[index, columns.index("checksum") + 1] = sheet_row.checksum
+    return cells
+
+
+def renamed_cells(rows: Sequence[list[str]], old: str, new: str, layout: SheetLayout) -> dict[tuple[int, int], str]:
+    """The cells of a transactions tab to rewrite, by their 1-based row and column, for the rows in the category."""
+    cells: dict[tuple[int, int], str] = {}
+    for index, row in enumerate(rows, start=1):
+        if SheetTransaction.from_row(row) and row_category(row, layout) == old:
+            cells.update(row_cells(index, row, layout, category=new))
+    return cells
+
+
+def rename_category(args: RenameCategoryArgs) -> int:
+    """
+    Renames a category in the lookup sheet, every transactions tab and the ledger, returning the rows renamed.
+
+    Renaming to a category that already exists merges the two. Each tab is rewritten in one batch, only the
+    category cells change, and the checksum of rows that weren't edited by hand.
+    """
+    base = args.args
+    spreadsheet_id = base.sheets_spreadsheet_id
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        mapping = google.get_rows(base.mapping_spreadsheet, base.mapping_range_name)
+        # the category is the lookup sheet's second column
+        rules = {(index, 2): args.new for index, row in enumerate(mapping, start=1) if row[1:2] == [args.old]}
+        google.update_cells(base.mapping_spreadsheet, base.mapping_range_name, rules)
+        logger.info("Renamed %s to %s in %d mapping rules", args.old, args.new, len(rules))
+
+        tabs = base.transaction_tabs(google.worksheet_titles(spreadsheet_id))
+        renamed = 0
+        for tab in tabs:
+            cells = renamed_cells(google.get_rows(spreadsheet_id, tab), args.old, args.new, base.layout)
+            rows = len({row for row, _ in cells})
+            google.update_cells(spreadsheet_id, tab, cells)
+            renamed += rows
+            if rows:
+                logger.info("Renamed %s to %s in %d rows of %s", args.old, args.new, rows, tab)
+
+    if base.ledger_file:
+        with Ledger(base.ledger_file) as ledger:
+            recorded = ledger.rename_category(args.old, args.new)
+        logger.info("Renamed %s to %s in %d ledger transactions", args.old, args.new, recorded)
+    if args.old in base.category_styles:
+        logger.warning("%s has an emoji or color in the config, move them to %s under the rules", args.old, args.new)
+    return renamed
@@ -0,0 +1,128 @@
+"""
+Records which of a run's appends to the sheet landed, so a run interrupted while writing is picked up exactly.
+
+Before the new rows of a tab are appended, the run saves the batch, the tab and the IDs of its transactions,
+to the state file as pending, and marks it committed once the append returned. A run killed in between, by
+a second signal, a crash or a lost connection, leaves the batch pending without knowing whether the sheet
+applied it. The next run settles the checkpoint it finds before deduplicating: a pending batch landed when
+its rows are in the tab, found by the run ID column when it's enabled and by their IDs otherwise. The
+transactions of the batches that landed count as already in the sheet, so they are never appended twice,
+those of a batch that didn't land are imported again. The checkpoint is cleared once a run finished its
+writes.
+
+Sample config:
+```yaml
+state_file: ~/.local/state/budget-import/state.json
+destinations:
+  - type: sheets
+    run_id_column: true
+```
+"""
+
+import logging
+from collections.abc import Mapping, Sequence
+from typing import Any, NamedTuple, Self
+
+from budget.models.google import SheetLayout
+from budget.state import ImportState
+
+logger = logging.getLogger(__name__)
+
+
+class Batch(NamedTuple):
+    tab: str
+    ids: list[str]
+    committed: bool = False
+
+    @classmethod
+    def from_dict(cls, data: Mapping[str, Any]) -> Self:
+        return cls(
+            tab=str(data["tab"]),
+            ids=[str(row_id) for row_id in data["ids"]],
+            committed=bool(data["committed"]),
+        )
+
+    def to_dict(self) -> dict[str, Any]:
+        return {"tab": self.tab, "ids": self.ids, "committed": self.committed}
+
+
+class WriteCheckpoint:
+    """
+    The appends of a run, saved to the state file as each starts and ends.
+
+    Sample usage:
+    ```python
+    checkpoint = WriteCheckpoint(state_file, run_id)
+    checkpoint.begin(tab, [transaction.row_id for transaction in transactions])
+    google.insert_records_to_google_sheet(spreadsheet_id, tab, transactions)
+    checkpoint.commit()
+    ...
+    checkpoint.finish()
+    ```
+    """
+
+    path: str | None
+    run_id: str
+    batches: list[Batch]
+
+    def __init__(self, path: str | None, run_id: str) -> None:
+        self.path = path
+        self.run_id = run_id
+        self.batches = []
+
+    def begin(self, tab: str, ids: Sequence[str]) -> None:
+        self.batches.append(Batch(tab, list(ids)))
+        self.save()
+
+    def commit(self) -> None:
+        self.batches[-1] = self.batches[-1]._replace(committed=True)
+        self.save()
+
+    def finish(self) -> None:
+        """Clears the checkpoint, called once every write of the run is done."""
+        if self.path:
+            ImportState(self.path).record_writes({})
+
+    def save(self) -> None:
+        # read again, the state file may have been saved since by the sources' commits
+        if self.path:
+            batches = [batch.to_dict() for batch in self.batches]
+            ImportState(self.path).record_writes({"run": self.run_id, "batches": batches})
+
+
+def settle_writes(
+    path: str | None, rows: Mapping[str, Sequence[Sequence[str]]], layout: SheetLayout
+) -> dict[str, set[str]]:
+    """
+    The IDs of the transactions an interrupted run appended, by tab, from the checkpoint it left behind.
+
+    Pending batches are looked up in the rows read from their tabs, tabs that weren't read count as not written.
+    """
+    writes = ImportState(path).writes if path else {}
+    if not writes:
+        return {}
+    run_id = str(writes["run"])
+    columns = layout.columns()
+    landed: dict[str, set[str]] = {}
+    for batch in map(Batch.from_dict, writes.get("batches", [])):
+        if batch.committed:
+            landed.setdefault(batch.tab, set()).update(batch.ids)
+            continue
+        tab_rows = [row for row in rows.get(batch.tab, []) if row]
+        if "run_id" in columns:
+            column = columns.index("run_id")
+            written = {row[0] for row in tab_rows if len(row) > column and row[column] == run_id}
+        else:
+            written = {row[0] for row in tab_rows}
+        found = written & set(batch.ids)
+        if found:
+            logger.info("The interrupted append of run %s to %s landed, %d of its rows", run_id, batch.tab, len(found))
+            landed.setdefault(batch.tab, set()).update(found)
+        else:
+            logger.warning("The interrupted append of run %s to %s didn't land, importing it again", run_id, batch.tab)
+    logger.info(
+        "Run %s was interrupted while writing, %d of its transactions are in the sheet",
+        run_id,
+        sum(len(ids) for ids in landed.values()),
+    )
+    return landed
@@ -0,0 +1,142 @@
+import logging
+from collections.abc import Mapping, Sequence
+from enum import StrEnum
+from typing import Final, NamedTuple
+
+from budget.clients.google import convert_to_row
+from budget.models.google import METADATA_COLUMNS, GoogleSheetRow, SheetLayout, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+class ChecksumPolicy(StrEnum):
+    """
+    What to do with a row edited in the sheet whose transaction changed upstream, off means no checksum column.
+
+    Preserve keeps the edited row and overwrite replaces it, merge takes each field from the side the conflict
+    fields name and review keeps the row and lists the conflicting fields for someone to look at.
+    """
+
+    OFF = "off"
+    PRESERVE = "preserve"
+    OVERWRITE = "overwrite"
+    MERGE = "merge"
+    REVIEW = "review"
+
+
+class ConflictSide(StrEnum):
+    SOURCE = "source"
+    SHEET = "sheet"
+
+
+# the fields people edit in the sheet, the other columns always follow the source
+CONFLICT_FIELDS: Final = {
+    "payee": ConflictSide.SHEET,
+    "amount": ConflictSide.SOURCE,
+    "date": ConflictSide.SOURCE,
+    "category": ConflictSide.SHEET,
+    "receipt": ConflictSide.SOURCE,
+}
+# columns that go with a conflict field
+LINKED_COLUMNS: Final = {"category_group": "category"}
+CONFLICTS_RANGE_NAME: Final = "conflicts"
+
+
+class Conflict(NamedTuple):
+    row_id: str
+    field: str
+    sheet: str
+    source: str
+
+
+def validate_conflict_fields(fields: Mapping[str, str]) -> str | None:
+    """Returns why the conflict fields are invalid, or None when each names a known field and side."""
+    for name, side in fields.items():
+        if name not in CONFLICT_FIELDS:
+            return f"Conflict field must be one of {', '.join(CONFLICT_FIELDS)}, got {name}"
+        if side not in list(ConflictSide):
+            return f"Conflict side of {name} must be one of {', '.join(ConflictSide)}, got {side}"
+    return None
+
+
+def merge_row(
+    row: list[str], new_row: GoogleSheetRow, layout: SheetLayout, fields: Mapping[str, str]
+) -> GoogleSheetRow:
+    """Takes each conflict field from the side the fields name, falling back to the defaults."""
+    sides = {**CONFLICT_FIELDS, **fields}
+    merged = list(new_row)
+    for index, column in enumerate(layout.columns()):
+        side = sides.get(LINKED_COLUMNS.get(column, column), ConflictSide.SOURCE)
+        if side == ConflictSide.SHEET and index < len(row):
+            merged[index] = row[index]
+    return merged
+
+
+def find_conflicts(row: list[str], new_row: GoogleSheetRow) -> list[Conflict]:
+    """The conflict fields whose value in the sheet differs from the source's."""
+    current = SheetTransaction.from_row(row)
+    upstream = SheetTransaction.from_row([str(cell) for cell in new_row])
+    if not current or not upstream:
+        return []
+    current_fields, upstream_fields = current._asdict(), upstream._asdict()
+    return [
+        Conflict(current.id, name, str(current_fields[name]), str(upstream_fields[name]))
+        for name in CONFLICT_FIELDS
+        if current_fields[name] != upstream_fields[name]
+    ]
+
+
+def find_updates(
+    rows: Sequence[list[str]],
+    transactions: Sequence[SimpleFinTransaction],
+    layout: SheetLayout,
+    policy: ChecksumPolicy,
+    fields: Mapping[str, str] | None = None,
+    conflicts: list[Conflict] | None = None,
+) -> dict[int, GoogleSheetRow]:
+    """
+    Returns the rows to rewrite, keyed by 1-based row number, for transactions that changed upstream.
+
+    The checksum column holds the digest of the row as it was imported. When the row no longer matches it
+    the row was edited by hand and the policy decides what is written, the review policy adds the fields
+    that differ to conflicts. Rows imported before the checksum column existed are left alone.
+    """
+    if policy == ChecksumPolicy.OFF:
+        return {}
+    columns = layout.columns()
+    checksum_column = columns.index("checksum")
+    metadata_columns = [index for index, column in enumerate(columns) if column in METADATA_COLUMNS]
+    by_id = {transaction.row_id: transaction for transaction in transactions}
+    updates: dict[int, GoogleSheetRow] = {}
+    for index, row in enumerate(rows, start=1):
+        transaction = by_id.get(row[0]) if row else None
+        stored = row[checksum_column] if transaction and len(row) > checksum_column else ""
+        if not transaction or not stored:
+            continue
+        new_row = convert_to_row(transaction, layout)
+        # the row keeps the import that appended it, so undoing a later run doesn't delete it
+        for column in metadata_columns:
+            new_row[column] = row[column] if len(row) > column else ""
+        if new_row[checksum_column] == stored:
+            continue
+        current = SheetTransaction.from_row(row)
+        # an untouched row, or one already edited to what the source now says, takes the new checksum
+        if current and current.checksum in (stored, new_row[checksum_column]):
+            updates[index] = new_row
+            continue
+        match policy:
+            case ChecksumPolicy.PRESERVE:
+                logger.info("Transaction %s changed upstream but was edited in the sheet, preserving the edit", row[0])
+            case ChecksumPolicy.MERGE:
+                logger.info("Transaction %s changed upstream and was edited in the sheet, merging them", row[0])
+                # the merged row counts as imported, so it isn't merged again until the source changes again
+                updates[index] = merge_row(row, new_row, layout, fields or {})
+                updates[index][checksum_column] = new_row[checksum_column]
+            case ChecksumPolicy.REVIEW:
+                logger.warning("Transaction %s changed upstream and was edited in the sheet, flag for review", row[0])
+                if conflicts is not None:
+                    conflicts.extend(find_conflicts(row, new_row))
+            case _:
+                updates[index] = new_row
+    return updates
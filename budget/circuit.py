@@ -0,0 +1,139 @@
+import logging
+import threading
+import time
+from collections.abc import Callable, Generator
+from contextlib import contextmanager
+from enum import StrEnum
+from typing import Final
+
+from budget.alerts import send_alert
+
+logger = logging.getLogger(__name__)
+
+FAILURE_THRESHOLD: Final = 3
+COOLDOWN: Final = 60 * 60
+
+_breakers: dict[str, "CircuitBreaker"] = {}
+_breakers_lock = threading.Lock()
+_settings = {"failure_threshold": FAILURE_THRESHOLD, "cooldown": COOLDOWN}
+
+
+class CircuitState(StrEnum):
+    CLOSED = "closed"
+    OPEN = "open"
+    HALF_OPEN = "half-open"
+
+
+class CircuitOpenError(Exception): ...
+
+
+class CircuitBreaker:
+    """
+    Stops calling an external API after repeated failures.
+
+    After `failure_threshold` consecutive failures the circuit opens and calls fail fast for
+    `cooldown` seconds, then a single trial call is let through. Other threads keep failing fast
+    until the trial call is done, calls nested in it are let through. A single alert is sent when
+    the circuit opens and not again until the API has recovered.
+    """
+
+    name: Final[str]
+    failure_threshold: Final[int]
+    cooldown: Final[float]
+
+    def __init__(
+        self,
+        name: str,
+        failure_threshold: int = FAILURE_THRESHOLD,
+        cooldown: float = COOLDOWN,
+        clock: Callable[[], float] = time.monotonic,
+    ) -> None:
+        self.name = name
+        self.failure_threshold = failure_threshold
+        self.cooldown = cooldown
+        self._clock = clock
+        self._lock = threading.Lock()
+        self._failures = 0
+        self._opened_at: float | None = None
+        self._alerted = False
+        # the thread making the trial call of a half-open circuit
+        self._probe: int | None = None
+
+    @property
+    def state(self) -> CircuitState:
+        if self._opened_at is None:
+            return CircuitState.CLOSED
+        if self._clock() - self._opened_at >= self.cooldown:
+            return CircuitState.HALF_OPEN
+        return CircuitState.OPEN
+
+    def before_call(self) -> None:
+        with self._lock:
+            if self.state == CircuitState.OPEN:
+                msg = f"{self.name} circuit is open, skipping call"
+                raise CircuitOpenError(msg)
+            if self.state == CircuitState.HALF_OPEN:
+                if self._probe not in (None, threading.get_ident()):
+                    msg = f"{self.name} circuit is half-open and a trial call is in progress, skipping call"
+                    raise CircuitOpenError(msg)
+                self._probe = threading.get_ident()
+
+    def record_success(self) -> None:
+        with self._lock:
+            if self._alerted:
+                logger.info("%s has recovered, closing the circuit", self.name)
+            self._failures = 0
+            self._opened_at = None
+            self._alerted = False
+            self._probe = None
+
+    def record_failure(self, error: BaseException) -> None:
+        with self._lock:
+            self._failures += 1
+            self._probe = None
+            if self.state == CircuitState.HALF_OPEN or self._failures >= self.failure_threshold:
+                self._opened_at = self._clock()
+                should_alert = not self._alerted
+                self._alerted = True
+            else:
+                should_alert = False
+        if should_alert:
+            send_alert(
+                f"{self.name} failed {self._failures} times in a row, pausing calls for {self.cooldown:.0f}s: {error}"
+            )
+
+    @contextmanager
+    def guard(self) -> Generator[None, None, None]:
+        self.before_call()
+        try:
+            yield
+        except Exception as e:
+            self.record_failure(e)
+            raise
+        except BaseException:
+            # an interrupted trial call tells nothing, the next call tries again
+            self.release_probe()
+            raise
+        self.record_success()
+
+    def release_probe(self) -> None:
+        with self._lock:
+            if self._probe == threading.get_ident():
+                self._probe = None
+
+
+def configure(failure_threshold: int, cooldown: float) -> None:
+    """Sets the thresholds used by breakers created from now on."""
+    with _breakers_lock:
+        _settings.update(failure_threshold=failure_threshold, cooldown=cooldown)
+        _breakers.clear()
+
+
+def breaker(name: str) -> CircuitBreaker:
+    """Returns the process wide breaker for an external API, so its state survives across runs."""
+    with _breakers_lock:
+        if name not in _breakers:
+            _breakers[name] = CircuitBreaker(
+                name, failure_threshold=int(_settings["failure_threshold"]), cooldown=_settings["cooldown"]
+            )
+        return _breakers[name]
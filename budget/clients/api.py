@@ -0,0 +1,157 @@
+import http.client
+import json
+import logging
+from collections.abc import Mapping
+from datetime import UTC, datetime
+from pathlib import Path
+from types import TracebackType
+from typing import Any, Final, Self
+from urllib.parse import ParseResult, urlencode, urlparse
+
+from budget.models.simplefin import AccountRef, SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+class ApiError(ValueError):
+    status: int | None
+
+    def __init__(self, message: str, status: int | None = None) -> None:
+        super().__init__(message)
+        self.status = status
+
+
+class JsonApiClient:
+    """
+    Base for the bank API sources, sends requests to a single host and decodes the JSON responses.
+
+    Sample usage:
+    ```python
+    with JsonApiClient("https://api.example.com", "Example") as client:
+        data = client.request_json("GET", "/accounts", params={"page": 1})
+    ```
+    """
+
+    url: Final[ParseResult]
+    name: Final[str]
+    conn: http.client.HTTPConnection | http.client.HTTPSConnection
+
+    def __init__(self, url: str, name: str) -> None:
+        self.url = urlparse(url)
+        self.name = name
+        connection = http.client.HTTPSConnection if self.url.scheme == "https" else http.client.HTTPConnection
+        self.conn = connection(self.url.hostname or self.url.netloc, self.url.port)
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        self.conn.close()
+
+    def headers(self) -> dict[str, str]:
+        """Headers sent with every request, subclasses add their authorization."""
+        return {"Accept": "application/json"}
+
+    def request_json(
+        self,
+        method: str,
+        path: str,
+        *,
+        params: Mapping[str, Any] | None = None,
+        body: Mapping[str, Any] | str | None = None,
+        headers: Mapping[str, str] | None = None,
+    ) -> Any:
+        """
+        Sends a request and returns the decoded JSON body.
+
+        A path may be an absolute URL on the same host, as pagination links often are. A mapping body is
+        sent as JSON, a string body as is. Any status other than 2xx raises an ApiError.
+        """
+        target = urlparse(path)
+        path = f"{self.url.path.rstrip('/')}{target.path}" if not target.netloc else target.path
+        query = "&".join(part for part in (target.query, urlencode(params or {})) if part)
+        request_headers = self.headers() | dict(headers or {})
+        payload = None
+        if isinstance(body, Mapping):
+            payload = json.dumps(body)
+            request_headers.setdefault("Content-Type", "application/json")
+        elif body is not None:
+            payload = body
+
+        self.conn.request(method, f"{path}?{query}" if query else path, body=payload, headers=request_headers)
+        with self.conn.getresponse() as response:
+            raw = response.read().decode()
+            if not 200 <= response.status < 300:  # noqa: PLR2004
+                msg = f"{self.name} request to {path} failed: {response.status} {raw[:200]}"
+                raise ApiError(msg, response.status)
+        try:
+            return json.loads(raw) if raw else None
+        except json.JSONDecodeError as e:
+            msg = f"{self.name} returned invalid JSON from {path}: {e}"
+            raise ApiError(msg) from e
+
+
+def parse_timestamp(value: str | None) -> datetime | None:
+    """Parses the ISO 8601 dates and datetimes the bank APIs return, dates are midnight UTC."""
+    if not value:
+        return None
+    try:
+        parsed = datetime.fromisoformat(value)
+    except ValueError:
+        logger.warning("Ignoring invalid date %r", value)
+        return None
+    return parsed if parsed.tzinfo else parsed.replace(tzinfo=UTC)
+
+
+def build_account(
+    *,
+    account_id: str,
+    name: str,
+    org: SimpleFinOrganization,
+    currency: str,
+    transactions: list[SimpleFinTransaction],
+    balance: str = "",
+    available_balance: str = "",
+    balance_date: datetime | None = None,
+) -> SimpleFinAccount:
+    """Assembles an account from a bank API the way SimpleFin accounts are read, linking its transactions."""
+    account_ref = AccountRef(id=account_id, name=name, org=org.name, currency=currency)
+    for transaction in transactions:
+        transaction.currency = transaction.currency or currency
+        transaction.account = account_ref
+    return SimpleFinAccount(
+        available_balance=available_balance,
+        balance=balance,
+        balance_date=int(balance_date.timestamp()) if balance_date else 0,
+        currency=currency,
+        holdings=[],
+        id=account_id,
+        name=name,
+        org=org,
+        transactions=transactions,
+    )
+
+
+def save_private_json(path: str, data: Any) -> None:
+    """Writes JSON readable by the owner only, for files holding tokens or credentials."""
+    file = Path(path)
+    file.parent.mkdir(parents=True, exist_ok=True)
+    file.touch(mode=0o600, exist_ok=True)
+    _ = file.write_text(json.dumps(data, indent=2), encoding="utf-8")
+
+
+def load_json(path: str) -> Any:
+    """Reads a JSON file written by `save_private_json`, None when it doesn't exist yet."""
+    try:
+        return json.loads(Path(path).read_text(encoding="utf-8"))
+    except FileNotFoundError:
+        return None
+    except (OSError, ValueError) as e:
+        msg = f"Unable to read {path}: {e}"
+        raise ApiError(msg) from e
@@ -0,0 +1,191 @@
+"""
+Basiq source for Australian banks connected through the Consumer Data Right.
+
+Connections are created by the user through Basiq's consent UI, the importer reads the user's
+accounts and transactions with a server API key. Connections that need to be re-authorized are
+reported as notices, with refresh the importer asks Basiq to refresh every connection before reading,
+the refresh runs in the background so its data usually lands in the next run.
+
+Sample config:
+```yaml
+sources:
+  - type: basiq
+    user_id: 7a8b9c
+    api_key: ...  # or the BASIQ_API_KEY environment variable
+    refresh: true
+```
+"""
+
+import logging
+import os
+import time
+from collections import defaultdict
+from datetime import datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Final, NamedTuple, Self
+from urllib.parse import urlencode
+
+from budget.clients.api import JsonApiClient, build_account, parse_timestamp
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+BASIQ_URL: Final = "https://au-api.basiq.io"
+BASIQ_VERSION: Final = "3.0"
+PAGE_SIZE: Final = 500
+TOKEN_MARGIN: Final = 60
+INVALID_STATUSES: Final = {"invalid", "expired", "revoked"}
+
+
+class BasiqSource(NamedTuple):
+    user_id: str
+    api_key: str
+    name: str = "basiq"
+    refresh: bool = False
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        api_key = (data.get("api_key") or os.getenv("BASIQ_API_KEY")) if isinstance(data, dict) else None
+        if not isinstance(data, dict) or not data.get("user_id") or not api_key:
+            msg = "Invalid Basiq source, user_id and api_key (or BASIQ_API_KEY) are required"
+            raise ConfigError(msg)
+        return cls(
+            user_id=str(data["user_id"]),
+            api_key=str(api_key),
+            name=str(data.get("name") or "basiq"),
+            refresh=bool(data.get("refresh")),
+        )
+
+
+def to_transaction(data: dict[str, Any]) -> SimpleFinTransaction | None:
+    posted = parse_timestamp(data.get("postDate"))
+    transacted_at = parse_timestamp(data.get("transactionDate")) or posted
+    try:
+        amount = Decimal(str(data["amount"]))
+    except (KeyError, InvalidOperation):
+        logger.warning("Skipping Basiq transaction %s without a valid amount", data.get("id"))
+        return None
+    if not transacted_at:
+        logger.warning("Skipping Basiq transaction %s without a date", data.get("id"))
+        return None
+    description = str(data.get("description") or "")
+    return SimpleFinTransaction(
+        id=str(data["id"]),
+        amount=amount,
+        description=description,
+        memo=str((data.get("subClass") or {}).get("title") or ""),
+        payee=description,
+        posted=posted or transacted_at,
+        transacted_at=transacted_at,
+        pending=data.get("status") == "pending",
+    )
+
+
+class BasiqClient(JsonApiClient):
+    """
+    Reads a Basiq user's accounts and transactions, the server token is renewed before it expires.
+
+    Sample usage:
+    ```python
+    with BasiqClient(api_key) as basiq:
+        accounts = basiq.fetch_data(source, start_date)
+    ```
+    """
+
+    api_key: Final[str]
+    token: str | None
+    token_expires: float
+    notices: list[str]
+
+    def __init__(self, api_key: str, url: str = BASIQ_URL) -> None:
+        super().__init__(url, "Basiq")
+        self.api_key = api_key
+        self.token = None
+        self.token_expires = 0.0
+        self.notices = []
+
+    def headers(self) -> dict[str, str]:
+        headers = super().headers() | {"basiq-version": BASIQ_VERSION}
+        if self.token:
+            headers["Authorization"] = f"Bearer {self.token}"
+        return headers
+
+    def authenticate(self) -> None:
+        if self.token and time.monotonic() < self.token_expires:
+            return
+        self.token = None
+        data = self.request_json(
+            "POST",
+            "/token",
+            body=urlencode({"scope": "SERVER_ACCESS"}),
+            headers={"Authorization": f"Basic {self.api_key}", "Content-Type": "application/x-www-form-urlencoded"},
+        )
+        self.token = str(data["access_token"])
+        self.token_expires = time.monotonic() + int(data.get("expires_in", 3600)) - TOKEN_MARGIN
+
+    def get(self, path: str, params: dict[str, Any] | None = None) -> Any:
+        self.authenticate()
+        return self.request_json("GET", path, params=params)
+
+    def get_all(self, path: str, params: dict[str, Any] | None = None) -> list[dict[str, Any]]:
+        """Follows the `links.next` pagination, returning the items of every page."""
+        items: list[dict[str, Any]] = []
+        page = self.get(path, params)
+        while True:
+            items.extend(page.get("data") or [])
+            next_link = (page.get("links") or {}).get("next")
+            if not next_link:
+                return items
+            page = self.get(next_link)
+
+    def connections(self, user_id: str) -> list[dict[str, Any]]:
+        """The user's connections, the ones that need the user to re-authorize them are added to the notices."""
+        connections = self.get_all(f"/users/{user_id}/connections")
+        for connection in connections:
+            if str(connection.get("status", "")).lower() in INVALID_STATUSES:
+                self.notices.append(f"Basiq connection to {institution_name(connection)} needs to be re-authorized")
+        return connections
+
+    def refresh_connections(self, user_id: str) -> None:
+        self.authenticate()
+        _ = self.request_json("POST", f"/users/{user_id}/connections/refresh")
+        logger.info("Requested a refresh of the Basiq connections")
+
+    def fetch_data(self, source: BasiqSource, start_date: datetime) -> list[SimpleFinAccount]:
+        connections = {connection["id"]: connection for connection in self.connections(source.user_id)}
+        if source.refresh:
+            self.refresh_connections(source.user_id)
+
+        transactions: defaultdict[str, list[SimpleFinTransaction]] = defaultdict(list)
+        for data in self.get_all(
+            f"/users/{source.user_id}/transactions",
+            {"limit": PAGE_SIZE, "filter": f"transaction.postDate.gteq('{start_date:%Y-%m-%d}')"},
+        ):
+            if transaction := to_transaction(data):
+                transactions[str(data.get("account"))].append(transaction)
+
+        accounts = [
+            build_account(
+                account_id=str(data["id"]),
+                name=str(data.get("name") or data.get("accountNo") or data["id"]),
+                org=SimpleFinOrganization(
+                    domain="basiq.io",
+                    name=institution_name(connections.get(data.get("connection"), {})) or str(data.get("institution")),
+                    sfin_url=None,
+                ),
+                currency=str(data.get("currency") or "AUD"),
+                transactions=transactions.get(str(data["id"]), []),
+                balance=str(data.get("balance") or ""),
+                available_balance=str(data.get("availableFunds") or ""),
+                balance_date=parse_timestamp(data.get("lastUpdated")),
+            )
+            for data in self.get_all(f"/users/{source.user_id}/accounts")
+        ]
+        logger.info("Fetched %d accounts from Basiq", len(accounts))
+        return accounts
+
+
+def institution_name(connection: dict[str, Any]) -> str:
+    institution = connection.get("institution") or {}
+    return str(institution.get("shortName") or institution.get("name") or institution.get("id") or "")
@@ -0,0 +1,240 @@
+"""
+Object storage source for statement files other automations drop into an S3 or GCS bucket.
+
+Objects under the prefix are read as statement files (see budget.statements). Objects are left in
+the bucket, the keys of the imported ones are kept in the state file once the run succeeded so
+each object is imported once. Objects that can't be read are reported and retried on the next run.
+
+Requests are signed with AWS Signature Version 4, which Google Cloud Storage accepts through its
+S3 interoperability API with HMAC keys. Other S3 compatible stores work by setting the endpoint.
+
+Sample config:
+```yaml
+sources:
+  - type: bucket
+    name: exports
+    url: s3://my-statements/bank/  # or gs://my-statements/bank/
+    region: us-east-1
+    access_key_id: AKIA...  # or the AWS_ACCESS_KEY_ID environment variable
+    secret_access_key: ...  # or the AWS_SECRET_ACCESS_KEY environment variable
+state_file: /data/state.json
+```
+"""
+
+import hashlib
+import hmac
+import http.client
+import logging
+import os
+import xml.etree.ElementTree as ET
+from collections.abc import Mapping
+from datetime import UTC, datetime
+from types import TracebackType
+from typing import Any, Final, NamedTuple, Self
+from urllib.parse import quote, urlparse
+
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount
+from budget.state import ImportState
+from budget.statements import StatementError, StatementOptions, is_statement, parse_statement
+
+logger = logging.getLogger(__name__)
+
+S3_REGION: Final = "us-east-1"
+GCS_ENDPOINT: Final = "https://storage.googleapis.com"
+GCS_REGION: Final = "auto"
+EMPTY_SHA256: Final = hashlib.sha256(b"").hexdigest()
+BUCKET_TIMEOUT: Final = 60
+
+
+class BucketError(ValueError): ...
+
+
+class BucketSource(NamedTuple):
+    bucket: str
+    prefix: str
+    endpoint: str
+    region: str
+    access_key_id: str
+    secret_access_key: str
+    statements: StatementOptions
+    session_token: str | None = None
+
+    @property
+    def name(self) -> str:
+        return self.statements.name
+
+    @property
+    def state_key(self) -> str:
+        return f"bucket:{self.name}"
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any], profiles_dir: str | None = None) -> Self:
+        if not isinstance(data, dict) or not data.get("name") or not data.get("url"):
+            msg = f"Invalid bucket source {data!r}, name and url are required"
+            raise ConfigError(msg)
+        url = urlparse(str(data["url"]))
+        if url.scheme not in {"s3", "gs"} or not url.netloc:
+            msg = f"Invalid bucket source {data['name']}, the url must look like s3://bucket/prefix or gs://..."
+            raise ConfigError(msg)
+        access_key_id = data.get("access_key_id") or os.getenv("AWS_ACCESS_KEY_ID")
+        secret_access_key = data.get("secret_access_key") or os.getenv("AWS_SECRET_ACCESS_KEY")
+        if not access_key_id or not secret_access_key:
+            msg = (
+                f"Invalid bucket source {data['name']}, access_key_id and secret_access_key"
+                " (or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) are required"
+            )
+            raise ConfigError(msg)
+        region = str(data.get("region") or (GCS_REGION if url.scheme == "gs" else S3_REGION))
+        default_endpoint = GCS_ENDPOINT if url.scheme == "gs" else f"https://s3.{region}.amazonaws.com"
+        return cls(
+            bucket=url.netloc,
+            prefix=url.path.lstrip("/"),
+            endpoint=str(data.get("endpoint") or default_endpoint),
+            region=region,
+            access_key_id=str(access_key_id),
+            secret_access_key=str(secret_access_key),
+            statements=StatementOptions.from_dict(data, profiles_dir),
+            session_token=data.get("session_token") or os.getenv("AWS_SESSION_TOKEN"),
+        )
+
+
+def canonical_query(query: Mapping[str, str]) -> str:
+    """The query string as signed, which must also be the one sent."""
+    return "&".join(f"{quote(k, safe='-_.~')}={quote(v, safe='-_.~')}" for k, v in sorted(query.items()))
+
+
+def signing_key(secret: str, datestamp: str, region: str) -> bytes:
+    key = f"AWS4{secret}".encode()
+    for part in (datestamp, region, "s3", "aws4_request"):
+        key = hmac.new(key, part.encode(), hashlib.sha256).digest()
+    return key
+
+
+def sign_request(
+    source: BucketSource, method: str, host: str, path: str, query: Mapping[str, str], now: datetime
+) -> dict[str, str]:
+    """The headers of a request signed with AWS Signature Version 4, for requests without a body."""
+    amz_date = f"{now:%Y%m%dT%H%M%SZ}"
+    headers = {"host": host, "x-amz-content-sha256": EMPTY_SHA256, "x-amz-date": amz_date}
+    if source.session_token:
+        headers["x-amz-security-token"] = source.session_token
+    signed_headers = ";".join(sorted(headers))
+    canonical_request = "\n".join(
+        (
+            method,
+            quote(path, safe="/-_.~"),
+            canonical_query(query),
+            "".join(f"{name}:{headers[name].strip()}\n" for name in sorted(headers)),
+            signed_headers,
+            EMPTY_SHA256,
+        )
+    )
+    scope = f"{now:%Y%m%d}/{source.region}/s3/aws4_request"
+    string_to_sign = "\n".join(
+        ("AWS4-HMAC-SHA256", amz_date, scope, hashlib.sha256(canonical_request.encode()).hexdigest())
+    )
+    key = signing_key(source.secret_access_key, f"{now:%Y%m%d}", source.region)
+    signature = hmac.new(key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+    headers["Authorization"] = (
+        f"AWS4-HMAC-SHA256 Credential={source.access_key_id}/{scope}, "
+        f"SignedHeaders={signed_headers}, Signature={signature}"
+    )
+    return headers
+
+
+class BucketClient:
+    """
+    Lists and reads the objects of a bucket with path-style requests.
+
+    Sample usage:
+    ```python
+    with BucketClient(source) as bucket:
+        for key in bucket.keys():
+            content = bucket.read(key)
+    ```
+    """
+
+    source: Final[BucketSource]
+    host: Final[str]
+    conn: http.client.HTTPConnection | http.client.HTTPSConnection
+
+    def __init__(self, source: BucketSource) -> None:
+        self.source = source
+        endpoint = urlparse(source.endpoint)
+        self.host = endpoint.netloc
+        connection = http.client.HTTPSConnection if endpoint.scheme == "https" else http.client.HTTPConnection
+        self.conn = connection(endpoint.hostname or endpoint.netloc, endpoint.port, timeout=BUCKET_TIMEOUT)
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        self.conn.close()
+
+    def get(self, key: str = "", query: Mapping[str, str] | None = None) -> bytes:
+        path = f"/{self.source.bucket}/{key}"
+        query = dict(query or {})
+        headers = sign_request(self.source, "GET", self.host, path, query, datetime.now(UTC))
+        target = quote(path, safe="/-_.~")
+        self.conn.request("GET", f"{target}?{canonical_query(query)}" if query else target, headers=headers)
+        with self.conn.getresponse() as response:
+            body = response.read()
+            if response.status != 200:  # noqa: PLR2004
+                msg = f"Bucket request to {path} failed: {response.status} {body[:200].decode(errors='replace')}"
+                raise BucketError(msg)
+        return body
+
+    def keys(self) -> list[str]:
+        """The keys of the objects under the source's prefix, following the listing's continuation tokens."""
+        keys: list[str] = []
+        query = {"list-type": "2", "prefix": self.source.prefix}
+        while True:
+            root = ET.fromstring(self.get(query=query))  # noqa: S314 - the bucket's own listing
+            keys.extend(element.text for element in root.iter() if local_name(element) == "Key" and element.text)
+            token = child_text(root, "NextContinuationToken")
+            if child_text(root, "IsTruncated") != "true" or not token:
+                return keys
+            query["continuation-token"] = token
+
+    def read(self, key: str) -> bytes:
+        return self.get(key)
+
+
+def local_name(element: ET.Element) -> str:
+    """The tag without its namespace, S3 and GCS list objects in namespaces of their own."""
+    return element.tag.rsplit("}", 1)[-1]
+
+
+def child_text(root: ET.Element, name: str) -> str | None:
+    return next((child.text for child in root if local_name(child) == name), None)
+
+
+def fetch_bucket(
+    source: BucketSource, state: ImportState, notices: list[str]
+) -> tuple[list[SimpleFinAccount], list[str]]:
+    """
+    Reads the statement objects the state doesn't list yet, returning their accounts and keys.
+
+    Objects that can't be read are added to the notices and left out of the keys.
+    """
+    accounts: list[SimpleFinAccount] = []
+    keys: list[str] = []
+    with BucketClient(source) as bucket:
+        for key in bucket.keys():
+            if not is_statement(key) or state.contains(source.state_key, key):
+                continue
+            try:
+                accounts.extend(parse_statement(key, bucket.read(key), source.statements))
+            except StatementError as e:
+                notices.append(f"Bucket source {source.name}: {e}")
+                continue
+            keys.append(key)
+    logger.info("Read %d new statement files from bucket source %s", len(keys), source.name)
+    return accounts, keys
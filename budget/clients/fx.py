@@ -0,0 +1,87 @@
+import http.client
+import json
+import logging
+from collections.abc import Iterable, Sequence
+from decimal import Decimal
+from types import TracebackType
+from typing import Final, Self
+from urllib.parse import ParseResult, urlencode, urlparse
+
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+FX_URL: Final = "https://api.frankfurter.app"
+CENT: Final = Decimal("0.01")
+
+
+class FxClient:
+    """
+    Looks up exchange rates from a Frankfurter compatible API (ECB reference rates).
+
+    Sample usage:
+    ```python
+    with FxClient() as fx:
+        rates = fx.latest_rates("USD", {"EUR", "GBP"})
+    ```
+    """
+
+    url: Final[ParseResult]
+    conn: http.client.HTTPConnection | http.client.HTTPSConnection
+
+    def __init__(self, url: str = FX_URL) -> None:
+        self.url = urlparse(url)
+        connection = http.client.HTTPSConnection if self.url.scheme == "https" else http.client.HTTPConnection
+        self.conn = connection(self.url.hostname or self.url.netloc, self.url.port)
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        self.conn.close()
+
+    def latest_rates(self, base: str, currencies: Iterable[str]) -> dict[str, Decimal]:
+        """Returns how many units of `base` one unit of each currency is worth."""
+        rates: dict[str, Decimal] = {}
+        for currency in sorted(set(currencies)):
+            query = urlencode({"from": currency, "to": base})
+            self.conn.request("GET", f"{self.url.path}/latest?{query}", headers={"Accept": "application/json"})
+            with self.conn.getresponse() as response:
+                if response.status != http.client.OK:
+                    msg = f"Failed to get the {currency} exchange rate: {response.status}"
+                    raise ValueError(msg)
+                data = json.loads(response.read().decode(), parse_float=Decimal)
+            try:
+                rates[currency] = Decimal(data["rates"][base])
+            except (KeyError, TypeError) as e:
+                msg = f"Invalid exchange rate response for {currency}: {data}"
+                raise ValueError(msg) from e
+        logger.info("Fetched %d exchange rates", len(rates))
+        return rates
+
+
+def convert_transactions(
+    transactions: Sequence[SimpleFinTransaction], base: str, rates: dict[str, Decimal]
+) -> None:
+    """
+    Converts foreign currency transactions into the base currency in place.
+
+    The original amount and currency are kept on the transaction so both can be written to the sheet.
+    """
+    for transaction in transactions:
+        currency = transaction.currency or base
+        transaction.original_amount = transaction.amount
+        transaction.original_currency = currency
+        if currency == base:
+            continue
+        if currency not in rates:
+            msg = f"No exchange rate from {currency} to {base}"
+            raise ValueError(msg)
+        transaction.amount = (transaction.amount * rates[currency]).quantize(CENT)
+        transaction.currency = base
@@ -1,39 +1,268 @@
+import json
 import logging
-from collections.abc import Sequence
+import os
+import threading
+from collections import defaultdict
+from collections.abc import Mapping, Sequence
+from datetime import date, datetime
 from types import TracebackType
-from typing import Self, TypeGuard
+from typing import Any, Final, Self, TypeGuard
 
 from gspread.auth import service_account
 from gspread.client import Client
-from gspread.utils import InsertDataOption, ValueInputOption
+from gspread.exceptions import WorksheetNotFound
+from gspread.spreadsheet import Spreadsheet
+from gspread.urls import DRIVE_FILES_API_V3_URL, SPREADSHEET_URL
+from gspread.utils import InsertDataOption, ValueInputOption, ValueRenderOption, rowcol_to_a1
+from gspread.worksheet import Worksheet
 
-from budget.models.google import Category, GoogleSheetRow
-from budget.models.simplefin import SimpleFinTransaction
+from budget.models.google import (
+    Category,
+    DateField,
+    DateFormat,
+    DriveFile,
+    GoogleSheetRow,
+    RowMetadata,
+    SheetLayout,
+    SheetTransaction,
+    parse_amount,
+    parse_date,
+    split_category,
+)
+from budget.models.simplefin import Merchant, SimpleFinTransaction, Trade
 
 logger = logging.getLogger(__name__)
 
+DEFAULT_LAYOUT: Final = SheetLayout()
+NO_METADATA: Final = RowMetadata()
+DRIVE_PAGE_SIZE: Final = 1000
+# the day Sheets counts date serial numbers from
+SERIAL_EPOCH: Final = datetime(1899, 12, 30)  # noqa: DTZ001
+CELL_FIELDS: Final = "userEnteredValue,userEnteredFormat.numberFormat"
+AMOUNT_FORMAT: Final = {"type": "NUMBER", "pattern": "#,##0.00"}
+AMOUNT_COLUMNS: Final = frozenset({"amount", "original_amount", "gross_amount", "fees"})
+# the developer metadata key carrying the transaction ID of a row
+ROW_ID_KEY: Final = "budget-importer.id"
+
+_clients: dict[tuple[str, int], Client] = {}
+_clients_lock = threading.Lock()
+
+
+def shared_client(credentials: str) -> Client:
+    """The authorized client of the credentials file, shared by every GoogleClient until the file is replaced."""
+    key = (credentials, os.stat(credentials).st_mtime_ns)
+    with _clients_lock:
+        client = _clients.get(key)
+        if client is None:
+            # a client of replaced credentials may still be in use, it is dropped rather than closed
+            for stale in [stale for stale in _clients if stale[0] == credentials]:
+                del _clients[stale]
+            client = _clients[key] = service_account(credentials)
+        return client
+
+
+def close_clients() -> None:
+    """Closes the sessions of every shared client, GoogleClients made afterwards authorize again."""
+    with _clients_lock:
+        clients = list(_clients.values())
+        _clients.clear()
+    for client in clients:
+        client.http_client.session.close()
+
 
 def is_list_of_strings(data: list[list[str]]) -> TypeGuard[list[list[str]]]:
     return bool(data)
 
 
-def convert_to_row(tran: SimpleFinTransaction) -> GoogleSheetRow:
+def parse_count(row: Sequence[str]) -> int | None:
+    """The occurrence count of an unmapped payees row."""
+    value = row[1].strip() if len(row) > 1 else ""
+    return int(value) if value.isdigit() else None
+
+
+def transaction_date(tran: SimpleFinTransaction, date_field: DateField) -> datetime:
+    """The chosen date, falling back to the other one when it is unset (pending transactions aren't posted yet)."""
+    preferred, fallback = (
+        (tran.posted, tran.transacted_at) if date_field == DateField.POSTED else (tran.transacted_at, tran.posted)
+    )
+    return preferred if preferred.timestamp() > 0 else fallback
+
+
+def convert_to_row(
+    tran: SimpleFinTransaction, layout: SheetLayout = DEFAULT_LAYOUT, metadata: RowMetadata = NO_METADATA
+) -> GoogleSheetRow:
     """Converts a SimpleFinTransaction to a row for Google Sheets."""
-    return [
-        tran.id,
+    group, category = split_category(tran.category) if layout.category_groups else ("", tran.category or "")
+    if category and (emoji := dict(layout.category_emoji).get(tran.category or "")):
+        category = f"{emoji} {category}"
+    row: GoogleSheetRow = [
+        tran.row_id,
         tran.payee,
         float(tran.amount),
-        tran.transacted_at.strftime("%-m/%-d/%Y"),
-        tran.category or "",
+        transaction_date(tran, layout.date_field).strftime(
+            "%Y-%m-%d" if layout.date_format == DateFormat.ISO else "%-m/%-d/%Y"
+        ),
+        category,
         str(tran.receipt) if tran.receipt else "",
     ]
+    if layout.category_groups:
+        row.append(group)
+    if layout.status:
+        row.append("pending" if tran.pending else "posted")
+    if layout.owner:
+        row.append(tran.owner or "")
+    if layout.purpose:
+        row.append(tran.account.purpose if tran.account else "")
+    if layout.currency:
+        row.append(tran.currency or "")
+    if layout.original_amount:
+        row.append(float(tran.original_amount) if tran.original_amount is not None else "")
+        row.append(tran.original_currency or "")
+    if layout.checksum:
+        sheet_transaction = SheetTransaction.from_row([str(value) for value in row])
+        row.append(sheet_transaction.checksum if sheet_transaction else "")
+    if layout.run_id or layout.metadata:
+        row.append(metadata.run_id)
+    if layout.metadata:
+        row.append(f"{metadata.imported_at:%Y-%m-%d %H:%M:%S}" if metadata.imported_at else "")
+        row.append(tran.source or "")
+    if layout.merchant:
+        row.extend(merchant_cells(tran.merchant))
+    if layout.trades:
+        row.extend(trade_cells(tran.trade))
+    if layout.refund_of:
+        row.append(tran.refund_of or "")
+    row.extend(extra_cell(tran.extra, key) for key in layout.extra)
+    return row
+
+
+def trade_cells(trade: Trade | None) -> list[str | float]:
+    """The symbol, units, unit price, gross amount and fees of a trade, blank for other transactions."""
+    if not trade:
+        return ["", "", "", "", ""]
+    return [trade.symbol, float(trade.units), float(trade.unit_price), float(trade.gross), float(trade.fees)]
+
+
+def extra_cell(extra: Mapping[str, Any], key: str) -> str | float | int:
+    """The value at a dotted key like `check.number` of a transaction's extra data, objects are written as JSON."""
+    value: Any = extra
+    for part in key.split("."):
+        value = value.get(part) if isinstance(value, Mapping) else None
+    if value is None:
+        return ""
+    if isinstance(value, bool):
+        return str(value).upper()
+    if isinstance(value, str | int | float):
+        return value
+    return json.dumps(value)
+
+
+def serial_number(value: datetime) -> float:
+    """A date or time as the number of days since the Sheets epoch, the value date cells hold."""
+    return (value - SERIAL_EPOCH).total_seconds() / 86400
+
+
+def amount_format(layout: SheetLayout) -> dict[str, str]:
+    """The currency format of the amount column, so amounts show the same for every viewer."""
+    if not layout.currency_symbol:
+        return AMOUNT_FORMAT
+    # the symbol is quoted so symbols like $ aren't taken for pattern characters
+    symbol = f"[${layout.currency_symbol}]"
+    pattern = f"#,##0.00 {symbol}" if layout.currency_symbol_after else f"{symbol}#,##0.00"
+    return {"type": "CURRENCY", "pattern": pattern}
+
+
+def cell_data(value: str | float | int, column: str, layout: SheetLayout) -> dict[str, Any]:
+    """
+    A typed cell, numbers as numbers and dates as date serial numbers with a date format.
+
+    The cells are written as they are, not parsed as if typed in, so they don't depend on the sheet's locale.
+    Amounts and times a rewritten row keeps from the sheet come as they are displayed and are typed again.
+    """
+    if column in AMOUNT_COLUMNS and isinstance(value, str) and value and (amount := parse_amount(value)) is not None:
+        value = float(amount)
+    if column == "date" and (day := parse_date(str(value))):
+        pattern = "yyyy-mm-dd" if layout.date_format == DateFormat.ISO else "m/d/yyyy"
+        return {
+            "userEnteredValue": {"numberValue": serial_number(datetime.combine(day, datetime.min.time()))},
+            "userEnteredFormat": {"numberFormat": {"type": "DATE", "pattern": pattern}},
+        }
+    if column == "imported_at" and (imported_at := parse_timestamp(str(value))):
+        return {
+            "userEnteredValue": {"numberValue": serial_number(imported_at)},
+            "userEnteredFormat": {"numberFormat": {"type": "DATE_TIME", "pattern": "yyyy-mm-dd hh:mm:ss"}},
+        }
+    if isinstance(value, int | float):
+        cell: dict[str, Any] = {"userEnteredValue": {"numberValue": value}}
+        if column in {"amount", "gross_amount", "fees"}:
+            cell["userEnteredFormat"] = {"numberFormat": amount_format(layout)}
+        elif column == "original_amount":
+            # in each row's own currency, the symbol would be wrong
+            cell["userEnteredFormat"] = {"numberFormat": AMOUNT_FORMAT}
+        return cell
+    if not value:
+        return {}
+    if value.startswith("="):
+        return {"userEnteredValue": {"formulaValue": value}}
+    return {"userEnteredValue": {"stringValue": value}}
+
+
+def parse_timestamp(value: str) -> datetime | None:
+    """Parses the import time of a row as it's written, `2024-01-31 08:00:00`."""
+    try:
+        return datetime.strptime(value, "%Y-%m-%d %H:%M:%S")  # noqa: DTZ007
+    except ValueError:
+        return None
+
+
+def row_data(row: GoogleSheetRow, layout: SheetLayout) -> dict[str, Any]:
+    return {"values": [cell_data(value, column, layout) for value, column in zip(row, layout.columns(), strict=True)]}
+
+
+def row_id_request(sheet_id: int, index: int, row_id: str) -> dict[str, Any]:
+    """The request attaching a transaction ID to the row at a 0-based index, it stays with the row as rows move."""
+    location = {"sheetId": sheet_id, "dimension": "ROWS", "startIndex": index, "endIndex": index + 1}
+    return {
+        "createDeveloperMetadata": {
+            "developerMetadata": {
+                "metadataKey": ROW_ID_KEY,
+                "metadataValue": row_id,
+                "location": {"dimensionRange": location},
+                "visibility": "DOCUMENT",
+            }
+        }
+    }
+
+
+def formula_string(value: str) -> str:
+    return '"' + value.replace('"', '""') + '"'
+
+
+def merchant_cells(merchant: Merchant | None) -> list[str]:
+    """The merchant's name, linked to its website when known, and an image formula showing its logo."""
+    if not merchant:
+        return ["", ""]
+    name = (
+        f"=HYPERLINK({formula_string(merchant.website)}, {formula_string(merchant.name)})"
+        if merchant.website
+        else merchant.name
+    )
+    return [name, f"=IMAGE({formula_string(merchant.logo)})" if merchant.logo else ""]
 
 
 class GoogleClient:
+    """
+    The Sheets and Drive API of a service account.
+
+    Clients of the same credentials share one authorized session, so a daemon's runs and a server's requests
+    reuse its connections and token. A client keeps no state of its own and is safe to use from several
+    threads, the session's connection pool and token refresh are thread-safe.
+    """
+
     google_client: Client
 
     def __init__(self, credentials: str) -> None:
-        self.google_client = service_account(credentials)
+        self.google_client = shared_client(credentials)
 
     def __enter__(self) -> Self:
         return self
@@ -44,8 +273,8 @@ class GoogleClient:
         exc_val: BaseException | None,
         exc_tb: TracebackType | None,
     ) -> None:
+        # the session is shared with the other clients of the credentials, close_clients closes it
         del exc_type, exc_val, exc_tb
-        self.google_client.http_client.session.close()
 
     def get_category_mapping(self, spreadsheet_id: str, sheet_name: str) -> tuple[set[str], dict[str, Category]]:
         """Returns a mapping of transaction descriptions to categories."""
@@ -53,26 +282,394 @@ class GoogleClient:
         ws = sheet.worksheet(sheet_name)
         values = ws.get_all_values()
         assert is_list_of_strings(values)
-        categories = {row[0] for row in values}
+        categories = {row[1] for row in values if len(row) > 1 and row[1]}
         mapping = {row[0]: Category.from_row(row) for row in values}
         return categories, mapping
 
-    def insert_records_to_google_sheet(
-        self, spreadsheet_id: str, sheet_name: str, transactions: Sequence[SimpleFinTransaction]
+    def update_category_mapping(self, spreadsheet_id: str, sheet_name: str, mapping: dict[str, Category]) -> None:
+        """Updates existing mapping rows by payee and appends the payees that are not mapped yet."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        values = ws.get_all_values()
+        rows = {row[0]: index for index, row in enumerate(values, start=1) if row}
+        updates = [
+            {
+                "range": f"A{rows[payee]}:C{rows[payee]}",
+                "values": [[payee, category.category or "", category.name or ""]],
+            }
+            for payee, category in mapping.items()
+            if payee in rows
+        ]
+        additions = [
+            [payee, category.category or "", category.name or ""]
+            for payee, category in mapping.items()
+            if payee not in rows
+        ]
+        logger.info("Updating %d and adding %d mapping rows", len(updates), len(additions))
+        if updates:
+            _ = ws.batch_update(updates, value_input_option=ValueInputOption.raw)
+        if additions:
+            _ = ws.append_rows(additions, value_input_option=ValueInputOption.raw)
+
+    def add_unmapped_payees(self, spreadsheet_id: str, sheet_name: str, counts: Mapping[str, int], seen: str) -> None:
+        """
+        Records payees that matched no mapping rule as payee, occurrence count and last seen date rows.
+
+        Payees already listed have their count increased, the tab is created on first use.
+        """
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = self._worksheet_or_create(sheet, sheet_name, 3)
+        values = ws.get_all_values()
+        rows = {row[0]: (index, row) for index, row in enumerate(values, start=1) if row}
+        updates = [
+            {
+                "range": f"B{rows[payee][0]}:C{rows[payee][0]}",
+                "values": [[(parse_count(rows[payee][1]) or 0) + count, seen]],
+            }
+            for payee, count in counts.items()
+            if payee in rows
+        ]
+        additions = [[payee, count, seen] for payee, count in counts.items() if payee not in rows]
+        logger.info("Recording %d unmapped payees", len(counts))
+        if updates:
+            _ = ws.batch_update(updates, value_input_option=ValueInputOption.raw)
+        if additions:
+            _ = ws.append_rows(additions, value_input_option=ValueInputOption.raw)
+
+    def replace_rows(
+        self,
+        spreadsheet_id: str,
+        sheet_name: str,
+        rows: Sequence[GoogleSheetRow],
+        value_input_option: ValueInputOption = ValueInputOption.raw,
     ) -> None:
-        """Inserts records into the Google Sheet."""
+        """Replaces the whole contents of a tab, creating it on first use."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = self._worksheet_or_create(sheet, sheet_name, max((len(row) for row in rows), default=1))
+        _ = ws.clear()
+        _ = ws.update(values=[list(row) for row in rows], range_name="A1", value_input_option=value_input_option)
+
+    def get_transactions(self, spreadsheet_id: str, sheet_name: str) -> list[SheetTransaction]:
+        """Returns the parsed transactions currently in the Google Sheet."""
         sheet = self.google_client.open_by_key(spreadsheet_id)
         ws = sheet.worksheet(sheet_name)
         values = ws.get_all_values()
         assert is_list_of_strings(values)
-        current_ids = {row[0] for row in values}
-        records = [convert_to_row(transaction) for transaction in transactions if transaction.id not in current_ids]
+        return [transaction for row in values if (transaction := SheetTransaction.from_row(row))]
+
+    def get_rows(self, spreadsheet_id: str, sheet_name: str, *, missing_ok: bool = False) -> list[list[str]]:
+        """Returns the raw rows of a sheet, with missing_ok a tab that doesn't exist yet has no rows."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        try:
+            ws = sheet.worksheet(sheet_name)
+        except WorksheetNotFound:
+            if missing_ok:
+                return []
+            raise
+        values = ws.get_all_values()
+        assert is_list_of_strings(values)
+        return values
+
+    def get_values(
+        self, spreadsheet_id: str, sheet_name: str, *, formulas: bool = False
+    ) -> list[list[str | float | int]]:
+        """
+        Returns the rows of a sheet as stored, numbers as numbers and text that looks like a number as text.
+
+        With formulas, the cells holding a formula return it in place of its result.
+        """
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        render = ValueRenderOption.formula if formulas else ValueRenderOption.unformatted
+        return ws.get_all_values(value_render_option=render)
+
+    def update_rows(
+        self, spreadsheet_id: str, sheet_name: str, rows: dict[int, GoogleSheetRow], layout: SheetLayout
+    ) -> None:
+        """Overwrites rows in place with typed cells, stored like appended rows, keyed by their 1-based row number."""
+        if not rows:
+            return
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        requests = [
+            {
+                "updateCells": {
+                    "start": {"sheetId": ws.id, "rowIndex": index - 1, "columnIndex": 0},
+                    "rows": [row_data(row, layout)],
+                    "fields": CELL_FIELDS,
+                }
+            }
+            for index, row in rows.items()
+        ]
+        logger.info("Updating %d rows in Google Sheet", len(requests))
+        _ = sheet.batch_update({"requests": requests})
+
+    def restore_rows(self, spreadsheet_id: str, sheet_name: str, rows: dict[int, GoogleSheetRow]) -> None:
+        """
+        Overwrites rows in place with values read by `get_values` with formulas, keyed by their 1-based row number.
+
+        Formulas are entered again, numbers stay numbers and text stays text, even when it looks like a number or
+        a date, so the cells get back their type and keep their formatting.
+        """
+        if not rows:
+            return
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        updates = [
+            {
+                "range": f"A{index}:{rowcol_to_a1(index, len(row))}",
+                # an apostrophe keeps text as text, the sheet doesn't store it
+                "values": [[f"'{cell}" if isinstance(cell, str) and cell and cell[0] != "=" else cell for cell in row]],
+            }
+            for index, row in rows.items()
+        ]
+        logger.info("Restoring %d rows in %s", len(updates), sheet_name)
+        _ = ws.batch_update(updates, value_input_option=ValueInputOption.user_entered)
+
+    def update_cells(
+        self, spreadsheet_id: str, sheet_name: str, cells: Mapping[tuple[int, int], str | float]
+    ) -> None:
+        """Overwrites single cells as they are written, keyed by their 1-based row and column, in one request."""
+        if not cells:
+            return
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        updates = [{"range": rowcol_to_a1(row, column), "values": [[value]]} for (row, column), value in cells.items()]
+        logger.info("Updating %d cells in %s", len(updates), sheet_name)
+        _ = ws.batch_update(updates, value_input_option=ValueInputOption.raw)
+
+    def get_existing_ids(self, spreadsheet_id: str, sheet_name: str) -> set[str]:
+        """Returns the transaction IDs already present in the Google Sheet."""
+        return {row[0] for row in self.get_rows(spreadsheet_id, sheet_name) if row}
+
+    def insert_records_to_google_sheet(
+        self,
+        spreadsheet_id: str,
+        sheet_name: str,
+        transactions: Sequence[SimpleFinTransaction],
+        layout: SheetLayout = DEFAULT_LAYOUT,
+        metadata: RowMetadata = NO_METADATA,
+        *,
+        identify: bool = False,
+    ) -> None:
+        """
+        Inserts records into the Google Sheet, the transactions should already be deduplicated.
+
+        With identify each row carries its transaction ID as developer metadata too, the rows are then inserted
+        at their place by date, sorting the tab would move the values away from the rows the metadata is on.
+        """
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = self._worksheet_or_create(sheet, sheet_name, len(layout.columns()))
+        records = [convert_to_row(transaction, layout, metadata) for transaction in transactions]
         logger.info("Inserting %d records into Google Sheet", len(records))
 
+        if identify:
+            self._insert_identified(sheet, ws, records, layout)
+        else:
+            append = {"sheetId": ws.id, "rows": [row_data(record, layout) for record in records], "fields": CELL_FIELDS}
+            _ = sheet.batch_update({"requests": [{"appendCells": append}]})
+            _ = ws.sort((4, "des"))
+        for column in layout.hidden_columns():
+            _ = ws.hide_columns(column, column + 1)
+
+    def _insert_identified(
+        self, sheet: Spreadsheet, ws: Worksheet, records: Sequence[GoogleSheetRow], layout: SheetLayout
+    ) -> None:
+        """Inserts the records above the first older row, as sorting by date would, with their IDs as metadata."""
+        rows = ws.get_all_values()
+        # the first row is left in place like sorting leaves it, a header or the first row of a new tab
+        first = 1 if rows else 0
+        dates = [parse_date(row[3]) if len(row) > 3 else None for row in rows]
+        blocks: defaultdict[int, list[GoogleSheetRow]] = defaultdict(list)
+        for record in sorted(records, key=lambda record: parse_date(str(record[3])) or date.min, reverse=True):
+            day = parse_date(str(record[3]))
+            position = next(
+                (
+                    index
+                    for index in range(first, len(rows))
+                    if (existing := dates[index]) is None or (day is not None and existing < day)
+                ),
+                len(rows),
+            )
+            blocks[position].append(record)
+
+        # inserted from the bottom so the positions above stay put, then each block lands below the earlier ones
+        requests: list[dict[str, Any]] = [
+            {
+                "insertDimension": {
+                    "range": {
+                        "sheetId": ws.id,
+                        "dimension": "ROWS",
+                        "startIndex": position,
+                        "endIndex": position + len(block),
+                    },
+                    "inheritFromBefore": position > 0,
+                }
+            }
+            for position, block in sorted(blocks.items(), reverse=True)
+        ]
+        shift = 0
+        for position, block in sorted(blocks.items()):
+            start = position + shift
+            shift += len(block)
+            requests.append(
+                {
+                    "updateCells": {
+                        "start": {"sheetId": ws.id, "rowIndex": start, "columnIndex": 0},
+                        "rows": [row_data(record, layout) for record in block],
+                        "fields": CELL_FIELDS,
+                    }
+                }
+            )
+            requests.extend(
+                row_id_request(ws.id, start + offset, str(record[0])) for offset, record in enumerate(block)
+            )
+        _ = sheet.batch_update({"requests": requests})
+
+    def row_ids(self, spreadsheet_id: str, sheet_name: str) -> dict[int, str]:
+        """The transaction IDs the tab's rows carry as developer metadata, by 1-based row number."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        body = {"dataFilters": [{"developerMetadataLookup": self._row_id_lookup(sheet.worksheet(sheet_name).id)}]}
+        url = f"{SPREADSHEET_URL % spreadsheet_id}/developerMetadata:search"
+        data = self.google_client.http_client.request("post", url, json=body).json()
+        ids: dict[int, str] = {}
+        for match in data.get("matchedDeveloperMetadata", []):
+            entry = match["developerMetadata"]
+            ids[entry["location"]["dimensionRange"]["startIndex"] + 1] = entry["metadataValue"]
+        return ids
+
+    def identify_rows(self, spreadsheet_id: str, sheet_name: str, ids: Mapping[int, str]) -> None:
+        """Replaces the transaction IDs the tab's rows carry, keyed by 1-based row number."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        sheet_id = sheet.worksheet(sheet_name).id
+        requests: list[dict[str, Any]] = [
+            {"deleteDeveloperMetadata": {"dataFilter": {"developerMetadataLookup": self._row_id_lookup(sheet_id)}}}
+        ]
+        requests.extend(row_id_request(sheet_id, index - 1, row_id) for index, row_id in ids.items())
+        _ = sheet.batch_update({"requests": requests})
+
+    @staticmethod
+    def _row_id_lookup(sheet_id: int) -> dict[str, Any]:
+        return {
+            "metadataKey": ROW_ID_KEY,
+            "metadataLocation": {"sheetId": sheet_id},
+            "locationMatchingStrategy": "INTERSECTING_LOCATION",
+            "locationType": "ROW",
+        }
+
+    def worksheet_titles(self, spreadsheet_id: str) -> list[str]:
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        return [ws.title for ws in sheet.worksheets()]
+
+    def drive_files(self, folder_id: str) -> list[DriveFile]:
+        """The files in a Drive folder shared with the service account, following the listing's page tokens."""
+        files: list[DriveFile] = []
+        params = {
+            "q": f"'{folder_id}' in parents and trashed = false",
+            "fields": "nextPageToken, files(id, name)",
+            "pageSize": DRIVE_PAGE_SIZE,
+            "supportsAllDrives": True,
+            "includeItemsFromAllDrives": True,
+        }
+        while True:
+            data = self.google_client.http_client.request("get", DRIVE_FILES_API_V3_URL, params=params).json()
+            files.extend(DriveFile(id=file["id"], name=file["name"]) for file in data.get("files", []))
+            if not data.get("nextPageToken"):
+                return files
+            params["pageToken"] = data["nextPageToken"]
+
+    def download_file(self, file_id: str) -> bytes:
+        response = self.google_client.http_client.request(
+            "get", f"{DRIVE_FILES_API_V3_URL}/{file_id}", params={"alt": "media", "supportsAllDrives": True}
+        )
+        return response.content
+
+    def worksheet_id(self, spreadsheet_id: str, sheet_name: str, cols: int | None = None) -> int:
+        """The ID requests address the tab by, the tab is created with the columns when they are given."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        if cols is None:
+            return sheet.worksheet(sheet_name).id
+        return self._worksheet_or_create(sheet, sheet_name, cols).id
+
+    def batch_update(self, spreadsheet_id: str, requests: Sequence[Mapping[str, Any]]) -> None:
+        """Applies raw Sheets API requests, for the formatting and objects gspread has no methods for."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        _ = sheet.batch_update({"requests": list(requests)})
+
+    def chart_ids(self, spreadsheet_id: str, sheet_name: str) -> list[int]:
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        metadata = sheet.fetch_sheet_metadata({"fields": "sheets(properties(title),charts(chartId))"})
+        return [
+            chart["chartId"]
+            for tab in metadata.get("sheets", [])
+            if tab["properties"]["title"] == sheet_name
+            for chart in tab.get("charts", [])
+        ]
+
+    def conditional_formats(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, list[dict[str, Any]]]:
+        """The tab's ID and its conditional formatting rules, in the order the sheet applies them."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        metadata = sheet.fetch_sheet_metadata({"fields": "sheets(properties(sheetId,title),conditionalFormats)"})
+        for tab in metadata.get("sheets", []):
+            if tab["properties"]["title"] == sheet_name:
+                return tab["properties"]["sheetId"], tab.get("conditionalFormats", [])
+        raise WorksheetNotFound(sheet_name)
+
+    def protected_ranges(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, list[dict[str, Any]]]:
+        """The tab's ID and its protected ranges."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        metadata = sheet.fetch_sheet_metadata({"fields": "sheets(properties(sheetId,title),protectedRanges)"})
+        for tab in metadata.get("sheets", []):
+            if tab["properties"]["title"] == sheet_name:
+                return tab["properties"]["sheetId"], tab.get("protectedRanges", [])
+        raise WorksheetNotFound(sheet_name)
+
+    def developer_metadata(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, dict[str, dict[str, Any]]]:
+        """The tab's ID and the developer metadata attached to the tab, by key."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        metadata = sheet.fetch_sheet_metadata({"fields": "sheets(properties(sheetId,title),developerMetadata)"})
+        for tab in metadata.get("sheets", []):
+            if tab["properties"]["title"] == sheet_name:
+                entries = tab.get("developerMetadata", [])
+                return tab["properties"]["sheetId"], {entry["metadataKey"]: entry for entry in entries}
+        raise WorksheetNotFound(sheet_name)
+
+    def _worksheet_or_create(self, sheet: Spreadsheet, sheet_name: str, cols: int) -> Worksheet:
+        try:
+            return sheet.worksheet(sheet_name)
+        except WorksheetNotFound:
+            logger.info("Creating tab %s", sheet_name)
+            return sheet.add_worksheet(sheet_name, rows=1, cols=cols)
+
+    def delete_rows_where(self, spreadsheet_id: str, sheet_name: str, column: int, value: str) -> int:
+        """Deletes every row whose 0-based `column` equals `value`, returning how many were deleted."""
+        values = self.get_rows(spreadsheet_id, sheet_name)
+        matches = [index for index, row in enumerate(values, start=1) if len(row) > column and row[column] == value]
+        self.delete_rows(spreadsheet_id, sheet_name, matches)
+        return len(matches)
+
+    def delete_rows(self, spreadsheet_id: str, sheet_name: str, indexes: Sequence[int]) -> None:
+        """Deletes rows by their 1-based row number."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = sheet.worksheet(sheet_name)
+        # delete contiguous blocks bottom up so the remaining row numbers stay valid
+        blocks: list[list[int]] = []
+        for index in sorted(indexes):
+            if blocks and blocks[-1][1] == index - 1:
+                blocks[-1][1] = index
+            else:
+                blocks.append([index, index])
+        for start, end in reversed(blocks):
+            _ = ws.delete_rows(start, end)
+        logger.info("Deleted %d rows from Google Sheet", len(indexes))
+
+    def append_rows(self, spreadsheet_id: str, sheet_name: str, rows: Sequence[Sequence[str]]) -> None:
+        """Appends rows as the sheet displays them, creating the tab if it doesn't exist yet."""
+        sheet = self.google_client.open_by_key(spreadsheet_id)
+        ws = self._worksheet_or_create(sheet, sheet_name, max((len(row) for row in rows), default=1))
+        logger.info("Appending %d rows to %s", len(rows), sheet_name)
         _ = ws.append_rows(
-            records,
+            [list(row) for row in rows],
             insert_data_option=InsertDataOption.insert_rows,
             value_input_option=ValueInputOption.user_entered,
-            include_values_in_response=True,
         )
-        _ = ws.sort((4, "des"))
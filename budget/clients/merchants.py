@@ -0,0 +1,68 @@
+import http.client
+import json
+import logging
+from types import TracebackType
+from typing import Final, Self
+from urllib.parse import ParseResult, urlencode, urlparse
+
+from budget.models.simplefin import Merchant
+
+logger = logging.getLogger(__name__)
+
+MERCHANT_TIMEOUT: Final = 10
+
+
+class MerchantApiClient:
+    """
+    Resolves raw descriptors with a merchant lookup API.
+
+    The API is called as `GET <url>?descriptor=<descriptor>` and answers with a JSON object holding the
+    merchant's `name` and optionally its `website` and `logo` URLs, or 404 when it doesn't know the descriptor.
+
+    Sample usage:
+    ```python
+    with MerchantApiClient(url, token) as api:
+        merchant = api.lookup("AMZN MKTP US*2K4LL1")
+    ```
+    """
+
+    url: Final[ParseResult]
+    token: str | None
+    conn: http.client.HTTPConnection | http.client.HTTPSConnection
+
+    def __init__(self, url: str, token: str | None = None) -> None:
+        self.url = urlparse(url)
+        self.token = token
+        connection = http.client.HTTPSConnection if self.url.scheme == "https" else http.client.HTTPConnection
+        self.conn = connection(self.url.hostname or self.url.netloc, self.url.port, timeout=MERCHANT_TIMEOUT)
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        self.conn.close()
+
+    def lookup(self, descriptor: str) -> Merchant | None:
+        headers = {"Accept": "application/json"}
+        if self.token:
+            headers["Authorization"] = f"Bearer {self.token}"
+        query = urlencode({"descriptor": descriptor})
+        self.conn.request("GET", f"{self.url.path or '/'}?{query}", headers=headers)
+        with self.conn.getresponse() as response:
+            body = response.read()
+            if response.status == http.client.NOT_FOUND:
+                return None
+            if response.status != http.client.OK:
+                msg = f"Failed to look up merchant {descriptor!r}: {response.status}"
+                raise ValueError(msg)
+        data = json.loads(body.decode())
+        if not isinstance(data, dict) or not data.get("name"):
+            logger.debug("No merchant for %s", descriptor)
+            return None
+        return Merchant(name=str(data["name"]), website=data.get("website") or None, logo=data.get("logo") or None)
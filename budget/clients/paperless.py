@@ -7,6 +7,8 @@ from types import TracebackType
 from typing import Final, Self
 from urllib.parse import ParseResult, urlencode, urlparse
 
+from budget import httptrace
+from budget.clients.transport import ConnectionPool, Transport, shared_pool
 from budget.models.paperless import Document, ResponseDict, is_response_dict
 
 logger = logging.getLogger(__name__)
@@ -15,13 +17,14 @@ logger = logging.getLogger(__name__)
 class PaperlessClient:
     url: Final[ParseResult]
     token: Final[str]
-    conn: http.client.HTTPConnection
+    transport: Final[Transport]
+    pool: ConnectionPool
 
-    def __init__(self, url: str, token: str) -> None:
+    def __init__(self, url: str, token: str, transport: Transport | None = None) -> None:
         self.token = token
         self.url = urlparse(url)
-        hostname = self.url.hostname or self.url.netloc
-        self.conn = http.client.HTTPConnection(hostname, self.url.port)
+        self.transport = transport or Transport()
+        self.pool = shared_pool(self.url, self.transport)
 
     def __enter__(self) -> Self:
         return self
@@ -32,8 +35,8 @@ class PaperlessClient:
         exc_val: BaseException | None,
         exc_tb: TracebackType | None,
     ) -> None:
+        # the pool is shared with the other clients of the server, close_pools closes it
         del exc_type, exc_val, exc_tb  # unused
-        self.conn.close()
 
     @cached_property
     def headers(self) -> dict[str, str]:
@@ -41,6 +44,7 @@ class PaperlessClient:
             "Accept": "application/json",
             "Accept-Encoding": "application/json",
             "Authorization": f"Token {self.token}",
+            **self.transport.headers,
         }
 
     def fetch_documents(self, document_type: str = "receipt") -> list[Document]:
@@ -51,13 +55,18 @@ class PaperlessClient:
         return docs
 
     def _inner_fetch_documents(self, url: str) -> Generator[Document, None, None]:
-        self.conn.request("GET", url, headers=self.headers)
-        with self.conn.getresponse() as response:
-            if response.status != http.client.OK:
-                msg = f"Failed to get data: {response.status}"
-                raise ValueError(msg)
+        httptrace.trace_request("Paperless", "GET", url, self.headers)
+        with self.pool.connection() as conn:
+            conn.request("GET", url, headers=self.headers)
+            with conn.getresponse() as response:
+                httptrace.trace_response("Paperless", response.status, response.getheaders())
+                body = response.read()
+                httptrace.trace_body("Paperless", body)
+                if response.status != http.client.OK:
+                    msg = f"Failed to get data: {response.status}"
+                    raise ValueError(msg)
 
-            data: ResponseDict = json.loads(response.read().decode())
+        data: ResponseDict = json.loads(body.decode())
 
         if not is_response_dict(data):
             msg = f"Invalid response: {data}"
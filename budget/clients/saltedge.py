@@ -0,0 +1,196 @@
+"""
+Salt Edge source for the international banks it connects to.
+
+Connections are created by the user through Salt Edge Connect, the importer reads the accounts and
+transactions of the customer's connections, or of the listed connections only, with the app's ID and
+secret. Inactive connections are reported as notices. Salt Edge categorizes transactions, with
+categories the importer pre-fills them, either as Salt Edge names them or through a mapping to the
+sheet's categories, where categories left out of the mapping aren't pre-filled. The mapping sheet
+still applies to the transactions without a category.
+
+Sample config:
+```yaml
+sources:
+  - type: saltedge
+    app_id: 1a2b3c
+    secret: ...  # or the SALTEDGE_SECRET environment variable
+    customer_id: "222222222222222222"
+    categories:
+      groceries: Groceries
+      restaurants_and_cafes: Eating Out
+```
+"""
+
+import logging
+import os
+from datetime import datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Final, NamedTuple, Self
+
+from budget.clients.api import JsonApiClient, build_account, parse_timestamp
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+SALTEDGE_URL: Final = "https://www.saltedge.com/api/v5"
+ACTIVE_STATUS: Final = "active"
+
+
+class SaltEdgeSource(NamedTuple):
+    app_id: str
+    secret: str
+    customer_id: str | None = None
+    connection_ids: tuple[str, ...] = ()
+    name: str = "saltedge"
+    # True pre-fills Salt Edge's own category names, a mapping pre-fills only the categories it maps
+    categories: bool | dict[str, str] = False
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        secret = (data.get("secret") or os.getenv("SALTEDGE_SECRET")) if isinstance(data, dict) else None
+        if not isinstance(data, dict) or not data.get("app_id") or not secret:
+            msg = "Invalid Salt Edge source, app_id and secret (or SALTEDGE_SECRET) are required"
+            raise ConfigError(msg)
+        connection_ids = data.get("connection_ids") or []
+        if not data.get("customer_id") and not connection_ids:
+            msg = "Invalid Salt Edge source, a customer_id or connection_ids are required"
+            raise ConfigError(msg)
+        categories = data.get("categories") or False
+        if not isinstance(categories, bool | dict):
+            msg = "Invalid Salt Edge categories, expected true or a mapping of Salt Edge to sheet categories"
+            raise ConfigError(msg)
+        return cls(
+            app_id=str(data["app_id"]),
+            secret=str(secret),
+            customer_id=str(data["customer_id"]) if data.get("customer_id") else None,
+            connection_ids=tuple(str(connection_id) for connection_id in connection_ids),
+            name=str(data.get("name") or "saltedge"),
+            categories=categories if isinstance(categories, bool) else {str(k): str(v) for k, v in categories.items()},
+        )
+
+    def category(self, saltedge_category: str | None) -> str | None:
+        """The category pre-filled for a Salt Edge category, None when it isn't pre-filled."""
+        if not saltedge_category or not self.categories:
+            return None
+        if isinstance(self.categories, dict):
+            return self.categories.get(saltedge_category)
+        return saltedge_category.replace("_", " ").capitalize()
+
+
+def to_transaction(data: dict[str, Any], source: SaltEdgeSource) -> SimpleFinTransaction | None:
+    extra = data.get("extra") or {}
+    transacted_at = parse_timestamp(data.get("made_on"))
+    posted = parse_timestamp(extra.get("posting_date")) or transacted_at
+    try:
+        amount = Decimal(str(data["amount"]))
+    except (KeyError, InvalidOperation):
+        logger.warning("Skipping Salt Edge transaction %s without a valid amount", data.get("id"))
+        return None
+    if not transacted_at or not posted:
+        logger.warning("Skipping Salt Edge transaction %s without a date", data.get("id"))
+        return None
+    description = str(data.get("description") or "")
+    return SimpleFinTransaction(
+        id=str(data["id"]),
+        amount=amount,
+        description=description,
+        memo=str(extra.get("additional") or ""),
+        payee=str(extra.get("payee") or extra.get("merchant_name") or description),
+        posted=posted,
+        transacted_at=transacted_at,
+        category=source.category(data.get("category")),
+        currency=data.get("currency_code"),
+        pending=data.get("status") == "pending",
+    )
+
+
+class SaltEdgeClient(JsonApiClient):
+    """
+    Reads the accounts and transactions of Salt Edge connections with the app's credentials.
+
+    Sample usage:
+    ```python
+    with SaltEdgeClient(source.app_id, source.secret) as saltedge:
+        accounts = saltedge.fetch_data(source, start_date)
+    ```
+    """
+
+    app_id: Final[str]
+    secret: Final[str]
+    notices: list[str]
+
+    def __init__(self, app_id: str, secret: str, url: str = SALTEDGE_URL) -> None:
+        super().__init__(url, "Salt Edge")
+        self.app_id = app_id
+        self.secret = secret
+        self.notices = []
+
+    def headers(self) -> dict[str, str]:
+        return super().headers() | {"App-id": self.app_id, "Secret": self.secret}
+
+    def get_all(self, path: str, params: dict[str, Any] | None = None) -> list[dict[str, Any]]:
+        """Follows the `meta.next_page` pagination, returning the items of every page."""
+        items: list[dict[str, Any]] = []
+        page = self.request_json("GET", path, params=params)
+        while True:
+            items.extend(page.get("data") or [])
+            next_page = (page.get("meta") or {}).get("next_page")
+            if not next_page:
+                return items
+            # the next page is a path from the host's root, like /api/v5/transactions?from_id=...
+            page = self.request_json("GET", next_page.removeprefix(self.url.path))
+
+    def connections(self, source: SaltEdgeSource) -> list[dict[str, Any]]:
+        """The source's active connections, inactive ones are added to the notices."""
+        if source.connection_ids:
+            connections = [
+                self.request_json("GET", f"/connections/{connection_id}")["data"]
+                for connection_id in source.connection_ids
+            ]
+        else:
+            connections = self.get_all("/connections", {"customer_id": source.customer_id})
+        active: list[dict[str, Any]] = []
+        for connection in connections:
+            if connection.get("status") == ACTIVE_STATUS:
+                active.append(connection)
+            else:
+                self.notices.append(
+                    f"Salt Edge connection to {connection.get('provider_name')} is {connection.get('status')}"
+                    " and needs to be reconnected"
+                )
+        return active
+
+    def fetch_data(self, source: SaltEdgeSource, start_date: datetime) -> list[SimpleFinAccount]:
+        accounts: list[SimpleFinAccount] = []
+        for connection in self.connections(source):
+            org = SimpleFinOrganization(
+                domain="saltedge.com",
+                name=str(connection.get("provider_name") or connection.get("provider_code") or "Salt Edge"),
+                sfin_url=None,
+            )
+            for data in self.get_all("/accounts", {"connection_id": connection["id"]}):
+                # transactions can't be filtered by date, the ones before the start date are dropped here
+                transactions = [
+                    transaction
+                    for transaction_data in self.get_all(
+                        "/transactions", {"connection_id": connection["id"], "account_id": data["id"]}
+                    )
+                    if (transaction := to_transaction(transaction_data, source))
+                    and transaction.transacted_at.date() >= start_date.date()
+                ]
+                extra = data.get("extra") or {}
+                accounts.append(
+                    build_account(
+                        account_id=str(data["id"]),
+                        name=str(data.get("name") or data["id"]),
+                        org=org,
+                        currency=str(data.get("currency_code") or ""),
+                        transactions=transactions,
+                        balance=str(data.get("balance", "")),
+                        available_balance=str(extra.get("available_amount", "")),
+                        balance_date=parse_timestamp(data.get("updated_at")),
+                    )
+                )
+        logger.info("Fetched %d accounts from Salt Edge", len(accounts))
+        return accounts
@@ -0,0 +1,178 @@
+"""
+SFTP source for the statement files payroll providers and banks drop on an SFTP server.
+
+Files matching the path's pattern are read as statement files (see budget.statements) and, once
+the run that imported them succeeded, moved to the processed directory or, without one, renamed
+with an `.imported` suffix, which is never matched. Files that can't be read are reported and left
+in place, like files that aren't statements. Only the file name part of the path may hold wildcards.
+
+Authentication is by key only and the server's host key must be known, from the system's known
+hosts or the given file. Requires the sftp extra.
+
+Sample config:
+```yaml
+sources:
+  - type: sftp
+    name: payroll
+    host: sftp.example.com
+    username: budget
+    key_file: ~/.ssh/id_ed25519
+    path: /outgoing/*.ofx
+    processed_dir: /outgoing/imported
+```
+"""
+
+import fnmatch
+import logging
+import posixpath
+from collections.abc import Sequence
+from pathlib import Path
+from types import TracebackType
+from typing import TYPE_CHECKING, Any, Final, NamedTuple, Self
+
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount
+from budget.statements import StatementError, StatementOptions, parse_statement
+
+if TYPE_CHECKING:
+    import paramiko
+
+logger = logging.getLogger(__name__)
+
+SFTP_PORT: Final = 22
+SFTP_TIMEOUT: Final = 30
+IMPORTED_SUFFIX: Final = ".imported"
+
+
+class SftpSource(NamedTuple):
+    host: str
+    username: str
+    key_file: str
+    path: str
+    statements: StatementOptions
+    port: int = SFTP_PORT
+    key_passphrase: str | None = None
+    known_hosts: str | None = None
+    processed_dir: str | None = None
+
+    @property
+    def name(self) -> str:
+        return self.statements.name
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any], profiles_dir: str | None = None) -> Self:
+        required = ("name", "host", "username", "key_file", "path")
+        if not isinstance(data, dict) or not all(data.get(key) for key in required):
+            msg = f"Invalid SFTP source {data!r}, {', '.join(required)} are required"
+            raise ConfigError(msg)
+        return cls(
+            host=str(data["host"]),
+            username=str(data["username"]),
+            key_file=str(data["key_file"]),
+            path=str(data["path"]),
+            statements=StatementOptions.from_dict(data, profiles_dir),
+            port=int(data.get("port") or SFTP_PORT),
+            key_passphrase=data.get("key_passphrase"),
+            known_hosts=data.get("known_hosts"),
+            processed_dir=data.get("processed_dir"),
+        )
+
+
+class SftpClient:
+    """
+    Lists, reads and moves the files of an SFTP server.
+
+    Sample usage:
+    ```python
+    with SftpClient(source) as sftp:
+        for path in sftp.files(source.path):
+            content = sftp.read(path)
+    ```
+    """
+
+    ssh: "paramiko.SSHClient"
+    sftp: "paramiko.SFTPClient"
+
+    def __init__(self, source: SftpSource) -> None:
+        import paramiko  # noqa: PLC0415 - optional dependency
+
+        self.ssh = paramiko.SSHClient()
+        self.ssh.load_system_host_keys()
+        if source.known_hosts:
+            self.ssh.load_host_keys(str(Path(source.known_hosts).expanduser()))
+        self.ssh.set_missing_host_key_policy(paramiko.RejectPolicy())
+        self.ssh.connect(
+            source.host,
+            port=source.port,
+            username=source.username,
+            key_filename=str(Path(source.key_file).expanduser()),
+            passphrase=source.key_passphrase,
+            timeout=SFTP_TIMEOUT,
+            allow_agent=False,
+            look_for_keys=False,
+        )
+        self.sftp = self.ssh.open_sftp()
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        self.sftp.close()
+        self.ssh.close()
+
+    def files(self, pattern: str) -> list[str]:
+        """The paths matching a pattern like `/outgoing/*.csv`, in name order, without the files already imported."""
+        directory, name = posixpath.split(pattern)
+        return [
+            posixpath.join(directory, filename)
+            for filename in sorted(self.sftp.listdir(directory or "."))
+            if fnmatch.fnmatch(filename, name) and not filename.endswith(IMPORTED_SUFFIX)
+        ]
+
+    def read(self, path: str) -> bytes:
+        with self.sftp.open(path, "rb") as file:
+            return file.read()
+
+    def move(self, path: str, target: str) -> None:
+        self.sftp.posix_rename(path, target)
+
+
+def fetch_sftp(source: SftpSource, notices: list[str]) -> tuple[list[SimpleFinAccount], list[str]]:
+    """
+    Reads the statement files matching the source's path, returning their accounts and the paths read.
+
+    Files that can't be read are added to the notices and left out of the paths, like files that aren't
+    statements, so only the files that were imported are moved.
+    """
+    accounts: list[SimpleFinAccount] = []
+    paths: list[str] = []
+    with SftpClient(source) as sftp:
+        for path in sftp.files(source.path):
+            try:
+                statement_accounts = parse_statement(path, sftp.read(path), source.statements)
+            except StatementError as e:
+                notices.append(f"SFTP source {source.name}: {e}")
+                continue
+            if statement_accounts:
+                accounts.extend(statement_accounts)
+                paths.append(path)
+    logger.info("Read %d statement files from SFTP source %s", len(paths), source.name)
+    return accounts, paths
+
+
+def mark_processed(source: SftpSource, paths: Sequence[str]) -> None:
+    """Moves the files out of the pattern's way, called once their transactions are in the sheet."""
+    if not paths:
+        return
+    with SftpClient(source) as sftp:
+        for path in paths:
+            if source.processed_dir:
+                sftp.move(path, posixpath.join(source.processed_dir, posixpath.basename(path)))
+            else:
+                sftp.move(path, f"{path}{IMPORTED_SUFFIX}")
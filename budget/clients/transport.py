@@ -0,0 +1,159 @@
+"""
+HTTP transport settings of the SimpleFin and Paperless clients.
+
+Bridges doing live bank scrapes can take minutes to answer, while a local Paperless answers in
+milliseconds, so each client has its own settings. Connections are kept open between requests
+unless `keep_alive` is off, at most `max_idle_connections` of them.
+
+The clients of a server with the same settings share one pool, so the runs of a daemon and the
+threads of a server reuse the open connections instead of connecting for every client. Pools are
+thread-safe, each request borrows a connection of its own, and are closed by `close_pools`.
+
+Sample config:
+```yaml
+sources:
+  - type: simplefin
+    access_url: https://bridge.simplefin.org/simplefin
+    timeout: 120
+    tls_handshake_timeout: 10
+  - type: paperless
+    url: http://paperless.local
+    timeout: 10
+    keep_alive: false
+```
+"""
+
+import http.client
+import threading
+from collections.abc import Generator
+from contextlib import contextmanager
+from typing import Final, NamedTuple
+from urllib.parse import ParseResult
+
+TIMEOUT: Final = 30.0
+MAX_IDLE_CONNECTIONS: Final = 1
+
+_pools: dict[tuple[str, str, "Transport"], "ConnectionPool"] = {}
+_pools_lock = threading.Lock()
+
+
+class Transport(NamedTuple):
+    timeout: float = TIMEOUT
+    # the TLS handshake uses the timeout when unset
+    tls_handshake_timeout: float | None = None
+    keep_alive: bool = True
+    max_idle_connections: int = MAX_IDLE_CONNECTIONS
+
+    def validate(self, name: str) -> str | None:
+        """Returns why the settings are invalid, or None when they are valid."""
+        if self.timeout <= 0:
+            return f"{name} timeout must be positive, got {self.timeout}"
+        if self.tls_handshake_timeout is not None and self.tls_handshake_timeout <= 0:
+            return f"{name} TLS handshake timeout must be positive, got {self.tls_handshake_timeout}"
+        if self.max_idle_connections < 0:
+            return f"{name} max idle connections must not be negative, got {self.max_idle_connections}"
+        return None
+
+    @property
+    def headers(self) -> dict[str, str]:
+        return {} if self.keep_alive else {"Connection": "close"}
+
+
+class HandshakeTimeoutHTTPSConnection(http.client.HTTPSConnection):
+    """An HTTPS connection whose TLS handshake has its own timeout, requests use the connection's."""
+
+    handshake_timeout: float
+
+    def __init__(self, host: str, port: int | None, *, timeout: float, handshake_timeout: float) -> None:
+        super().__init__(host, port, timeout=timeout)
+        self.handshake_timeout = handshake_timeout
+
+    def connect(self) -> None:
+        http.client.HTTPConnection.connect(self)
+        self.sock.settimeout(self.handshake_timeout)
+        server_hostname = self._tunnel_host or self.host
+        self.sock = self._context.wrap_socket(self.sock, server_hostname=server_hostname)
+        self.sock.settimeout(self.timeout)
+
+
+def connect(url: ParseResult, transport: Transport) -> http.client.HTTPConnection:
+    host = url.hostname or url.netloc
+    if url.scheme != "https":
+        return http.client.HTTPConnection(host, url.port, timeout=transport.timeout)
+    if transport.tls_handshake_timeout is not None:
+        return HandshakeTimeoutHTTPSConnection(
+            host, url.port, timeout=transport.timeout, handshake_timeout=transport.tls_handshake_timeout
+        )
+    return http.client.HTTPSConnection(host, url.port, timeout=transport.timeout)
+
+
+class ConnectionPool:
+    """
+    Connections to one server, opened as requests need them and kept idle between requests.
+
+    Sample usage:
+    ```python
+    pool = ConnectionPool(urlparse("https://bridge.simplefin.org"), Transport(timeout=120))
+    with pool.connection() as conn:
+        conn.request("GET", "/simplefin/accounts")
+        body = conn.getresponse().read()
+    pool.close()
+    ```
+    """
+
+    url: Final[ParseResult]
+    transport: Final[Transport]
+    idle: list[http.client.HTTPConnection]
+    lock: threading.Lock
+
+    def __init__(self, url: ParseResult, transport: Transport) -> None:
+        self.url = url
+        self.transport = transport
+        self.idle = []
+        self.lock = threading.Lock()
+
+    @contextmanager
+    def connection(self) -> Generator[http.client.HTTPConnection, None, None]:
+        """
+        Lends a connection, returned to the pool when the block ends.
+
+        A connection is closed instead when the block raises, as it may be left mid-response,
+        when keep-alive is off, or when the pool already holds its maximum of idle connections.
+        """
+        with self.lock:
+            conn = self.idle.pop() if self.idle else connect(self.url, self.transport)
+        try:
+            yield conn
+        except BaseException:
+            conn.close()
+            raise
+        with self.lock:
+            if self.transport.keep_alive and len(self.idle) < self.transport.max_idle_connections:
+                self.idle.append(conn)
+                return
+        conn.close()
+
+    def close(self) -> None:
+        with self.lock:
+            idle, self.idle = self.idle, []
+        for conn in idle:
+            conn.close()
+
+
+def shared_pool(url: ParseResult, transport: Transport) -> ConnectionPool:
+    """Returns the pool shared by every client of the server with the same settings."""
+    with _pools_lock:
+        key = (url.scheme, url.netloc, transport)
+        pool = _pools.get(key)
+        if pool is None:
+            pool = _pools[key] = ConnectionPool(url, transport)
+        return pool
+
+
+def close_pools() -> None:
+    """Closes the idle connections of every shared pool, clients made afterwards start new pools."""
+    with _pools_lock:
+        pools = list(_pools.values())
+        _pools.clear()
+    for pool in pools:
+        pool.close()
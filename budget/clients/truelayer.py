@@ -0,0 +1,229 @@
+"""
+TrueLayer Data API source for UK and EU open banking institutions.
+
+Each connection is authorized once by the user through TrueLayer's auth link, which yields a refresh
+token. The importer trades it for an access token on every run with the app's client credentials,
+TrueLayer rotates refresh tokens so the latest one is kept in the token file and takes precedence
+over the one in the config. A connection whose consent expired is reported as a notice and skipped.
+
+Sample config:
+```yaml
+sources:
+  - type: truelayer
+    client_id: budget-1a2b3c
+    client_secret: ...  # or the TRUELAYER_CLIENT_SECRET environment variable
+    token_file: /data/truelayer-tokens.json
+    connections:
+      - name: monzo
+        refresh_token: ...
+```
+"""
+
+import logging
+import os
+from datetime import UTC, datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Final, NamedTuple, Self
+from urllib.parse import urlencode
+
+from budget.clients.api import ApiError, JsonApiClient, build_account, load_json, parse_timestamp, save_private_json
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+AUTH_URL: Final = "https://auth.truelayer.com"
+API_URL: Final = "https://api.truelayer.com"
+SANDBOX_AUTH_URL: Final = "https://auth.truelayer-sandbox.com"
+SANDBOX_API_URL: Final = "https://api.truelayer-sandbox.com"
+INVALID_GRANT: Final = 400
+
+
+class TrueLayerConnection(NamedTuple):
+    name: str
+    refresh_token: str
+
+
+class TrueLayerSource(NamedTuple):
+    client_id: str
+    client_secret: str
+    token_file: str
+    connections: list[TrueLayerConnection]
+    name: str = "truelayer"
+    sandbox: bool = False
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        secret = (data.get("client_secret") or os.getenv("TRUELAYER_CLIENT_SECRET")) if isinstance(data, dict) else None
+        if not isinstance(data, dict) or not all((data.get("client_id"), secret, data.get("token_file"))):
+            msg = (
+                "Invalid TrueLayer source, client_id, client_secret (or TRUELAYER_CLIENT_SECRET)"
+                " and token_file are required"
+            )
+            raise ConfigError(msg)
+        connections = data.get("connections") or []
+        if not isinstance(connections, list) or not all(
+            isinstance(connection, dict) and connection.get("name") for connection in connections
+        ):
+            msg = "Invalid TrueLayer connections, each connection needs a name"
+            raise ConfigError(msg)
+        return cls(
+            client_id=str(data["client_id"]),
+            client_secret=str(secret),
+            token_file=str(data["token_file"]),
+            connections=[
+                TrueLayerConnection(str(connection["name"]), str(connection.get("refresh_token") or ""))
+                for connection in connections
+            ],
+            name=str(data.get("name") or "truelayer"),
+            sandbox=bool(data.get("sandbox")),
+        )
+
+
+def to_transaction(data: dict[str, Any], *, pending: bool = False) -> SimpleFinTransaction | None:
+    timestamp = parse_timestamp(data.get("timestamp"))
+    try:
+        amount = abs(Decimal(str(data["amount"])))
+    except (KeyError, InvalidOperation):
+        logger.warning("Skipping TrueLayer transaction %s without a valid amount", data.get("transaction_id"))
+        return None
+    if not timestamp:
+        logger.warning("Skipping TrueLayer transaction %s without a timestamp", data.get("transaction_id"))
+        return None
+    # accounts sign their amounts but cards don't, the transaction type is reliable for both
+    if data.get("transaction_type") == "DEBIT":
+        amount = -amount
+    description = str(data.get("description") or "")
+    return SimpleFinTransaction(
+        id=str(data.get("transaction_id") or data.get("provider_transaction_id")),
+        amount=amount,
+        description=description,
+        memo=str(data.get("transaction_category") or ""),
+        payee=str(data.get("merchant_name") or description),
+        posted=timestamp,
+        transacted_at=timestamp,
+        currency=data.get("currency"),
+        pending=pending,
+    )
+
+
+class TrueLayerClient(JsonApiClient):
+    """
+    Reads the accounts and cards of one TrueLayer connection with its access token.
+
+    Sample usage:
+    ```python
+    with TrueLayerClient(access_token) as truelayer:
+        accounts = truelayer.fetch_data(start_date)
+    ```
+    """
+
+    access_token: Final[str]
+
+    def __init__(self, access_token: str, url: str = API_URL) -> None:
+        super().__init__(url, "TrueLayer")
+        self.access_token = access_token
+
+    def headers(self) -> dict[str, str]:
+        return super().headers() | {"Authorization": f"Bearer {self.access_token}"}
+
+    def results(self, path: str, params: dict[str, Any] | None = None) -> list[dict[str, Any]]:
+        return (self.request_json("GET", path, params=params) or {}).get("results") or []
+
+    def fetch_data(self, start_date: datetime) -> list[SimpleFinAccount]:
+        accounts: list[SimpleFinAccount] = []
+        for kind in ("accounts", "cards"):
+            try:
+                resources = self.results(f"/data/v1/{kind}")
+            except ApiError as e:
+                # connections to providers without cards (or accounts) answer 501
+                if e.status != 501:  # noqa: PLR2004
+                    raise
+                continue
+            accounts.extend(self.fetch_account(kind, resource, start_date) for resource in resources)
+        return accounts
+
+    def fetch_account(self, kind: str, resource: dict[str, Any], start_date: datetime) -> SimpleFinAccount:
+        account_id = str(resource["account_id"])
+        params = {"from": start_date.isoformat(), "to": datetime.now(UTC).isoformat()}
+        transactions = [
+            transaction
+            for data in self.results(f"/data/v1/{kind}/{account_id}/transactions", params)
+            if (transaction := to_transaction(data))
+        ]
+        transactions.extend(
+            transaction
+            for data in self.results(f"/data/v1/{kind}/{account_id}/transactions/pending")
+            if (transaction := to_transaction(data, pending=True))
+        )
+        balance = next(iter(self.results(f"/data/v1/{kind}/{account_id}/balance")), {})
+        provider = resource.get("provider") or {}
+        return build_account(
+            account_id=account_id,
+            name=str(resource.get("display_name") or account_id),
+            org=SimpleFinOrganization(
+                domain="truelayer.com",
+                name=str(provider.get("display_name") or provider.get("provider_id") or "TrueLayer"),
+                sfin_url=None,
+            ),
+            currency=str(resource.get("currency") or balance.get("currency") or ""),
+            transactions=transactions,
+            balance=str(balance.get("current", "")),
+            available_balance=str(balance.get("available", "")),
+            balance_date=parse_timestamp(balance.get("update_timestamp")),
+        )
+
+
+class TrueLayerAuth(JsonApiClient):
+    """Trades refresh tokens for access tokens with the app's client credentials."""
+
+    source: Final[TrueLayerSource]
+
+    def __init__(self, source: TrueLayerSource) -> None:
+        super().__init__(SANDBOX_AUTH_URL if source.sandbox else AUTH_URL, "TrueLayer auth")
+        self.source = source
+
+    def refresh(self, refresh_token: str) -> tuple[str, str]:
+        """Returns a new access token and the refresh token that replaces the one used."""
+        data = self.request_json(
+            "POST",
+            "/connect/token",
+            body=urlencode(
+                {
+                    "grant_type": "refresh_token",
+                    "client_id": self.source.client_id,
+                    "client_secret": self.source.client_secret,
+                    "refresh_token": refresh_token,
+                }
+            ),
+            headers={"Content-Type": "application/x-www-form-urlencoded"},
+        )
+        return str(data["access_token"]), str(data.get("refresh_token") or refresh_token)
+
+
+def fetch_truelayer(source: TrueLayerSource, start_date: datetime, notices: list[str]) -> list[SimpleFinAccount]:
+    """
+    Fetches every connection of the source, storing each rotated refresh token as soon as it is issued.
+
+    Connections whose consent expired are added to the notices and skipped, the others are still imported.
+    """
+    tokens: dict[str, str] = load_json(source.token_file) or {}
+    accounts: list[SimpleFinAccount] = []
+    with TrueLayerAuth(source) as auth:
+        for connection in source.connections:
+            refresh_token = tokens.get(connection.name) or connection.refresh_token
+            if not refresh_token:
+                notices.append(f"TrueLayer connection {connection.name} has no refresh token, authorize it first")
+                continue
+            try:
+                access_token, tokens[connection.name] = auth.refresh(refresh_token)
+            except ApiError as e:
+                if e.status != INVALID_GRANT:
+                    raise
+                notices.append(f"TrueLayer connection {connection.name} expired and needs to be re-authorized")
+                continue
+            save_private_json(source.token_file, tokens)
+            with TrueLayerClient(access_token, SANDBOX_API_URL if source.sandbox else API_URL) as truelayer:
+                accounts.extend(truelayer.fetch_data(start_date))
+    logger.info("Fetched %d accounts from TrueLayer", len(accounts))
+    return accounts
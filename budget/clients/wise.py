@@ -0,0 +1,166 @@
+"""
+Wise source for the balances of a multi-currency account.
+
+Every currency balance of the profile is read as its own account from its statement. A conversion
+between balances shows up in both statements, both legs share Wise's reference number, which is kept
+as the memo and as a `transfer:<reference>` tag, the legs are paired on it and categorized as a transfer.
+
+Wise requires strong customer authentication for the statements of UK and EEA profiles, a token that
+can't read them is reported as a notice.
+
+Sample config:
+```yaml
+sources:
+  - type: wise
+    api_token: ...  # or the WISE_API_TOKEN environment variable
+    profile_id: 12345678
+```
+"""
+
+import logging
+import os
+from datetime import UTC, datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Final, NamedTuple, Self
+
+from budget.clients.api import ApiError, JsonApiClient, build_account, parse_timestamp
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+from budget.transfers import TRANSFER_TAG
+
+logger = logging.getLogger(__name__)
+
+WISE_URL: Final = "https://api.wise.com"
+SANDBOX_URL: Final = "https://api.sandbox.transferwise.tech"
+SCA_REQUIRED: Final = 403
+CONVERSION: Final = "CONVERSION"
+
+
+class WiseSource(NamedTuple):
+    api_token: str
+    profile_id: str
+    name: str = "wise"
+    sandbox: bool = False
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        api_token = (data.get("api_token") or os.getenv("WISE_API_TOKEN")) if isinstance(data, dict) else None
+        if not isinstance(data, dict) or not data.get("profile_id") or not api_token:
+            msg = "Invalid Wise source, profile_id and api_token (or WISE_API_TOKEN) are required"
+            raise ConfigError(msg)
+        return cls(
+            api_token=str(api_token),
+            profile_id=str(data["profile_id"]),
+            name=str(data.get("name") or "wise"),
+            sandbox=bool(data.get("sandbox")),
+        )
+
+    @property
+    def url(self) -> str:
+        return SANDBOX_URL if self.sandbox else WISE_URL
+
+
+def to_transaction(data: dict[str, Any]) -> SimpleFinTransaction | None:
+    details = data.get("details") or {}
+    amount_data = data.get("amount") or {}
+    reference = str(data.get("referenceNumber") or "")
+    timestamp = parse_timestamp(data.get("date"))
+    try:
+        amount = abs(Decimal(str(amount_data["value"])))
+    except (KeyError, InvalidOperation):
+        logger.warning("Skipping Wise transaction %s without a valid amount", reference)
+        return None
+    if not timestamp or not reference:
+        logger.warning("Skipping Wise transaction %s without a date or reference", reference)
+        return None
+    if data.get("type") == "DEBIT":
+        amount = -amount
+    currency = amount_data.get("currency")
+    description = str(details.get("description") or "")
+    is_conversion = details.get("type") == CONVERSION
+    return SimpleFinTransaction(
+        # both legs of a conversion have the same reference, the currency keeps their IDs apart
+        id=f"{reference}:{currency}",
+        amount=amount,
+        description=description,
+        memo=reference,
+        payee=str(
+            (details.get("merchant") or {}).get("name")
+            or details.get("senderName")
+            or (details.get("recipient") or {}).get("name")
+            or description
+        ),
+        posted=timestamp,
+        transacted_at=timestamp,
+        tags=[f"{TRANSFER_TAG}{reference}"] if is_conversion else [],
+        currency=currency,
+    )
+
+
+class WiseClient(JsonApiClient):
+    """
+    Reads the currency balances of a Wise profile and their statements.
+
+    Sample usage:
+    ```python
+    with WiseClient(source.api_token, source.url) as wise:
+        accounts = wise.fetch_data(source, start_date)
+    ```
+    """
+
+    api_token: Final[str]
+    notices: list[str]
+
+    def __init__(self, api_token: str, url: str = WISE_URL) -> None:
+        super().__init__(url, "Wise")
+        self.api_token = api_token
+        self.notices = []
+
+    def headers(self) -> dict[str, str]:
+        return super().headers() | {"Authorization": f"Bearer {self.api_token}"}
+
+    def balances(self, profile_id: str) -> list[dict[str, Any]]:
+        return self.request_json("GET", f"/v4/profiles/{profile_id}/balances", params={"types": "STANDARD"}) or []
+
+    def statement(self, profile_id: str, balance: dict[str, Any], start_date: datetime) -> list[dict[str, Any]]:
+        currency = balance.get("currency")
+        data = self.request_json(
+            "GET",
+            f"/v1/profiles/{profile_id}/balance-statements/{balance['id']}/statement.json",
+            params={
+                "currency": currency,
+                "intervalStart": start_date.astimezone(UTC).strftime("%Y-%m-%dT%H:%M:%S.000Z"),
+                "intervalEnd": datetime.now(UTC).strftime("%Y-%m-%dT%H:%M:%S.000Z"),
+                "type": "COMPACT",
+            },
+        )
+        return (data or {}).get("transactions") or []
+
+    def fetch_data(self, source: WiseSource, start_date: datetime) -> list[SimpleFinAccount]:
+        org = SimpleFinOrganization(domain="wise.com", name="Wise", sfin_url=None)
+        accounts: list[SimpleFinAccount] = []
+        for balance in self.balances(source.profile_id):
+            currency = str(balance.get("currency") or "")
+            try:
+                statement = self.statement(source.profile_id, balance, start_date)
+            except ApiError as e:
+                if e.status != SCA_REQUIRED:
+                    raise
+                self.notices.append(f"Wise {currency} statement needs strong customer authentication, skipping it")
+                continue
+            transactions = [transaction for data in statement if (transaction := to_transaction(data))]
+            amount = balance.get("amount") or {}
+            accounts.append(
+                build_account(
+                    account_id=str(balance["id"]),
+                    name=str(balance.get("name") or f"{currency} balance"),
+                    org=org,
+                    currency=currency,
+                    transactions=transactions,
+                    balance=str(amount.get("value", "")),
+                    available_balance=str(amount.get("value", "")),
+                    balance_date=parse_timestamp(balance.get("modificationTime")),
+                )
+            )
+        logger.info("Fetched %d balances from Wise", len(accounts))
+        return accounts
@@ -0,0 +1,291 @@
+import logging
+import sys
+from collections.abc import Mapping
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any, Final
+
+import yaml
+
+from budget.schema import CONFIG_SCHEMA, describe_errors, line_numbers, validate
+
+logger = logging.getLogger(__name__)
+
+CONFIG_VERSION: Final = 2
+SECTIONS: Final = ("sources", "pipeline", "destinations")
+SOURCE_PREFIXES: Final = {"simplefin": "simplefin_", "paperless": "paperless_"}
+LIST_OPTIONS: Final = {
+    ("sources", "sheet"): "sheets_sources",
+    ("sources", "plugin"): "plugins_sources",
+    ("sources", "basiq"): "basiq_sources",
+    ("sources", "truelayer"): "truelayer_sources",
+    ("sources", "saltedge"): "saltedge_sources",
+    ("sources", "wise"): "wise_sources",
+    ("sources", "csv"): "csv_sources",
+    ("sources", "imap"): "imap_sources",
+    ("sources", "sftp"): "sftp_sources",
+    ("sources", "bucket"): "bucket_sources",
+    ("sources", "drive"): "drive_sources",
+    ("destinations", "plugin"): "plugins_destinations",
+}
+PIPELINE_OPTIONS: Final = {
+    ("rules", "fuzzy_threshold"): "mapping_fuzzy_threshold",
+    ("rules", "wasm"): "wasm_rules",
+    ("rules", "learn"): "learn_rules",
+    ("rules", "learn_threshold"): "learn_threshold",
+    ("rules", "categories"): "category_styles",
+    ("filters", "exclusions"): "exclusions",
+    ("filters", "min_amount"): "filters_min_amount",
+    ("filters", "aggregate_small"): "filters_aggregate_small",
+    ("dedup", "key"): "dedup_key",
+    ("dedup", "cross_source"): "dedup_cross_source",
+    ("transfers", "card_payments"): "card_payments",
+    ("transfers", "category"): "transfers_category",
+    ("transfers", "window_days"): "transfers_window_days",
+    ("refunds", "window_days"): "refunds_window_days",
+    ("refunds", "link"): "refunds_link",
+}
+SHEETS_OPTIONS: Final = {
+    "credentials": "google_credentials",
+    "mapping_spreadsheet_id": "mapping_spreadsheet_id",
+    "mapping_range_name": "mapping_range_name",
+    "unmapped_range_name": "mapping_unmapped_range_name",
+    "budget_range_name": "budget_range_name",
+    "budget_rollover": "budget_rollover",
+    "budget_period": "budget_period",
+    "budget_period_anchor": "budget_period_anchor",
+    "balances_range_name": "balances_range_name",
+    "funds_range_name": "funds_range_name",
+    "holdings_range_name": "holdings_range_name",
+}
+SHEETS_PREFIX: Final = "sheets_"
+FX_PREFIX: Final = "fx_"
+MERCHANTS_PREFIX: Final = "merchants_"
+
+
+class ConfigError(Exception): ...
+
+
+def load_config(path: str | None) -> dict[str, Any]:
+    """
+    Loads the YAML config file, returning an empty config when no path is given.
+
+    Sample config:
+    ```yaml
+    version: 2
+    sources:
+      - type: simplefin
+        access_url: https://bridge.simplefin.org/simplefin
+      - type: paperless
+        url: https://paperless.example.com
+      - type: plugin
+        name: my-bank
+        command: ["budget-my-bank", "--verbose"]
+    pipeline:
+      rules:
+        fuzzy_threshold: 0.8
+      filters:
+        min_amount: 1
+        exclusions:
+          - payee: "^TRANSFER"
+      enrichment:
+        fx:
+          base_currency: USD
+      dedup:
+        key: composite
+    destinations:
+      - type: sheets
+        spreadsheet_id: 1a2b3c
+        range_name: transactions
+    web:
+      port: 8080
+    ```
+    """
+    if not path:
+        return {}
+    try:
+        text = Path(path).read_text(encoding="utf-8")
+        config = yaml.safe_load(text) or {}
+    except (OSError, yaml.YAMLError) as e:
+        msg = f"Unable to load config {path}: {e}"
+        raise ConfigError(msg) from e
+
+    if not isinstance(config, dict):
+        msg = f"Invalid config {path}: expected a mapping at the top level"
+        raise ConfigError(msg)
+    check_config(config, text, path)
+    return config
+
+
+def check_config(config: dict[str, Any], text: str, path: str) -> None:
+    """
+    Validates the config against the schema, so typos are reported instead of silently ignored.
+
+    Version 1 configs are checked after migrating them, their errors name the version 2 location without a line.
+    """
+    if config_version(config) == CONFIG_VERSION:
+        errors = describe_errors(validate(config, CONFIG_SCHEMA), path, line_numbers(text))
+    else:
+        errors = describe_errors(validate(migrate(config), CONFIG_SCHEMA), path, {})
+        if errors:
+            errors.append("paths refer to the version 2 layout, `budget-import migrate-config` prints the config in it")
+    if errors:
+        msg = f"Invalid config {path}:\n{'\n'.join(errors)}"
+        raise ConfigError(msg)
+
+
+def flatten(config: Mapping[str, Any], prefix: str = "") -> dict[str, Any]:
+    """
+    Flattens nested sections into option names, `{"simplefin": {"access_url": ...}}` becomes `simplefin_access_url`.
+
+    Lists are kept as values so they can hold structured settings like plugin declarations.
+    """
+    flat: dict[str, Any] = {}
+    for key, value in config.items():
+        name = f"{prefix}{key}".replace("-", "_")
+        if isinstance(value, Mapping):
+            flat.update(flatten(value, f"{name}_"))
+        else:
+            flat[name] = value
+    return flat
+
+
+def config_version(config: Mapping[str, Any]) -> int:
+    version = config.get("version", 1)
+    if not isinstance(version, int) or not 1 <= version <= CONFIG_VERSION:
+        msg = f"Unsupported config version {version!r}, this release reads versions 1 to {CONFIG_VERSION}"
+        raise ConfigError(msg)
+    return version
+
+
+def options(config: Mapping[str, Any]) -> dict[str, Any]:
+    """
+    Resolves a config file into option names, version 1 configs are migrated first.
+
+    Sections other than sources, pipeline and destinations are read as before, e.g. `web: {port: ...}`.
+    """
+    if config and config_version(config) < CONFIG_VERSION:
+        logger.info("Config uses the version 1 layout, `budget-import migrate-config` prints it in the new layout")
+        config = migrate(config)
+
+    flat = flatten({key: value for key, value in config.items() if key not in {"version", *SECTIONS}})
+    for section in ("sources", "destinations"):
+        entries = config.get(section) or []
+        if not isinstance(entries, list):
+            msg = f"Invalid config: {section} must be a list"
+            raise ConfigError(msg)
+        for entry in entries:
+            flat.update(entry_options(section, entry, flat))
+
+    pipeline = config.get("pipeline") or {}
+    if not isinstance(pipeline, Mapping):
+        msg = "Invalid config: pipeline must be a mapping"
+        raise ConfigError(msg)
+    for (group, key), name in PIPELINE_OPTIONS.items():
+        if key in (pipeline.get(group) or {}):
+            flat[name] = pipeline[group][key]
+    flat.update(flatten((pipeline.get("enrichment") or {}).get("fx") or {}, FX_PREFIX))
+    flat.update(flatten((pipeline.get("enrichment") or {}).get("merchants") or {}, MERCHANTS_PREFIX))
+    return flat
+
+
+def entry_options(section: str, entry: Any, flat: Mapping[str, Any]) -> dict[str, Any]:
+    """The options set by one entry of the sources or destinations list."""
+    if not isinstance(entry, Mapping) or not entry.get("type"):
+        msg = f"Invalid {section} entry {entry!r}, a type is required"
+        raise ConfigError(msg)
+    kind = entry["type"]
+    settings = {key: value for key, value in entry.items() if key != "type"}
+    if (section, kind) in LIST_OPTIONS:
+        name = LIST_OPTIONS[section, kind]
+        return {name: [*flat.get(name, []), settings]}
+    # a household's further SimpleFin connections, the first one is the main connection
+    main = {"simplefin_access_url", "simplefin_username", "simplefin_setup_token"}
+    if (section, kind) == ("sources", "simplefin") and main & set(flat):
+        return {"simplefin_members": [*flat.get("simplefin_members", []), settings]}
+    if section == "sources" and kind in SOURCE_PREFIXES:
+        return flatten(settings, SOURCE_PREFIXES[kind])
+    if section == "destinations" and kind == "sheets":
+        return {SHEETS_OPTIONS.get(key, f"{SHEETS_PREFIX}{key}"): value for key, value in settings.items()}
+    msg = f"Unknown {section} type {kind!r}"
+    raise ConfigError(msg)
+
+
+def migrate(config: Mapping[str, Any]) -> dict[str, Any]:
+    """Converts a version 1 config, with its flat sections, into the version 2 layout, or raises ConfigError."""
+    flat = flatten(config)
+    consumed: set[str] = set()
+
+    def take(name: str) -> Any:
+        consumed.add(name)
+        return flat[name]
+
+    sources: list[dict[str, Any]] = []
+    for kind, prefix in SOURCE_PREFIXES.items():
+        settings = {name.removeprefix(prefix): take(name) for name in list(flat) if name.startswith(prefix)}
+        if settings:
+            sources.append({"type": kind, **settings})
+    destinations: list[dict[str, Any]] = []
+    sheets_names = {name: key for key, name in SHEETS_OPTIONS.items()}
+    sheets = {
+        sheets_names.get(name, name.removeprefix(SHEETS_PREFIX)): take(name)
+        for name in list(flat)
+        if name in sheets_names or (name.startswith(SHEETS_PREFIX) and name != LIST_OPTIONS["sources", "sheet"])
+    }
+    if sheets:
+        destinations.append({"type": "sheets", **sheets})
+    for (section, kind), name in LIST_OPTIONS.items():
+        entries = sources if section == "sources" else destinations
+        declared = take(name) if name in flat else []
+        if not isinstance(declared, list) or not all(isinstance(entry, Mapping) for entry in declared):
+            msg = f"Invalid config: {name.replace('_', '.', 1)} must be a list of mappings, one per {kind}"
+            raise ConfigError(msg)
+        entries.extend({"type": kind, **entry} for entry in declared)
+
+    pipeline: dict[str, dict[str, Any]] = {}
+    for (group, key), name in PIPELINE_OPTIONS.items():
+        if name in flat:
+            pipeline.setdefault(group, {})[key] = take(name)
+    if isinstance(config.get("fx"), Mapping):
+        pipeline.setdefault("enrichment", {})["fx"] = dict(config["fx"])
+        consumed.update(name for name in flat if name.startswith(FX_PREFIX))
+    if isinstance(config.get("merchants"), Mapping):
+        pipeline.setdefault("enrichment", {})["merchants"] = dict(config["merchants"])
+        consumed.update(name for name in flat if name.startswith(MERCHANTS_PREFIX))
+
+    migrated: dict[str, Any] = {"version": CONFIG_VERSION}
+    sections = zip(SECTIONS, (sources, pipeline, destinations), strict=True)
+    migrated.update((section, value) for section, value in sections if value)
+    migrated.update(remaining(config, consumed))
+    return migrated
+
+
+def remaining(config: Mapping[str, Any], consumed: set[str], prefix: str = "") -> dict[str, Any]:
+    """The nested config without the options in `consumed`, emptied sections are dropped."""
+    rest: dict[str, Any] = {}
+    for key, value in config.items():
+        name = f"{prefix}{key}".replace("-", "_")
+        if isinstance(value, Mapping):
+            if nested := remaining(value, consumed, f"{name}_"):
+                rest[key] = nested
+        elif name not in consumed and name != "version":
+            rest[key] = value
+    return rest
+
+
+@dataclass()
+class MigrateConfigArgs:
+    path: str | None
+
+
+def migrate_config(args: MigrateConfigArgs) -> None:
+    """Prints the config file in the current layout, the file itself is left untouched."""
+    if not args.path:
+        msg = "A config file is required, pass --config or set BUDGET_CONFIG"
+        raise ConfigError(msg)
+    config = load_config(args.path)
+    if config_version(config) == CONFIG_VERSION:
+        logger.info("%s already uses config version %d", args.path, CONFIG_VERSION)
+    else:
+        config = migrate(config)
+    yaml.safe_dump(config, sys.stdout, sort_keys=False)
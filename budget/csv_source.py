@@ -0,0 +1,342 @@
+"""
+Reads transactions from CSV exports, for banks without an API or to backfill history.
+
+Each source becomes one account. Its columns are described by a profile, either one of the bundled
+profiles for common banks or the source's own columns, settings in the source override the profile's.
+Rows keep the ID column when the export has one, the others are keyed by a digest of their date,
+amount, payee and position among identical rows.
+
+Profiles are YAML data files named after the profile, the bundled ones live in `budget/profiles` and
+the directory in the `csv.profiles_dir` setting is searched first, so a new bank can be added without
+a release and contributed upstream as is:
+```yaml
+name: Chase
+columns:
+  date: Posting Date
+  payee: Description
+  amount: Amount
+  memo: Type
+date_format: "%m/%d/%Y"
+```
+
+Columns are header names, or 0-based indexes for exports without a header row. Card exports that
+list charges as positive amounts set `negate`, exports with separate debit and credit columns name
+both instead of an amount.
+
+Sample config:
+```yaml
+sources:
+  - type: csv
+    name: chase-checking
+    path: ~/statements/chase/*.csv
+    profile: chase
+    currency: USD
+```
+"""
+
+import csv
+import hashlib
+import io
+import logging
+import sys
+from collections import Counter
+from collections.abc import Iterable, Iterator, Mapping, Sequence
+from dataclasses import dataclass
+from datetime import UTC, date, datetime, time
+from decimal import Decimal, InvalidOperation
+from importlib import resources
+from pathlib import Path
+from typing import Any, Final, NamedTuple, Self
+
+import yaml
+
+from budget.config import ConfigError
+from budget.models.simplefin import AccountRef, SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+ORGANIZATION: Final = SimpleFinOrganization(domain="", name="CSV", sfin_url=None)
+PROFILE_SUFFIX: Final = ".yaml"
+COLUMNS: Final = ("date", "payee", "amount", "debit", "credit", "memo", "id", "category", "posted")
+SETTINGS: Final = ("date_format", "negate", "delimiter", "header", "encoding")
+# lines searched for a profile's header when detecting the profile of an export
+DETECT_LINES: Final = 20
+
+Column = str | int
+
+
+class CsvProfile(NamedTuple):
+    name: str
+    date: Column
+    payee: Column
+    amount: Column | None = None
+    debit: Column | None = None
+    credit: Column | None = None
+    memo: Column | None = None
+    id: Column | None = None
+    category: Column | None = None
+    posted: Column | None = None
+    # strptime format of the dates, ISO 8601 when unset
+    date_format: str | None = None
+    # charges are listed as positive amounts, as most card exports do
+    negate: bool = False
+    delimiter: str = ","
+    header: bool = True
+    encoding: str = "utf-8-sig"
+
+    @classmethod
+    def from_dict(cls, data: Mapping[str, Any], name: str) -> Self:
+        columns = data.get("columns") or {}
+        if not isinstance(columns, Mapping) or columns.get("date") is None or columns.get("payee") is None:
+            msg = f"Invalid CSV profile {name}, the date and payee columns are required"
+            raise ConfigError(msg)
+        if columns.get("amount") is None and (columns.get("debit") is None or columns.get("credit") is None):
+            msg = f"Invalid CSV profile {name}, an amount column or debit and credit columns are required"
+            raise ConfigError(msg)
+        header = bool(data.get("header", True))
+        if not header and not all(isinstance(column, int) for column in columns.values()):
+            msg = f"Invalid CSV profile {name}, columns must be indexes when the export has no header"
+            raise ConfigError(msg)
+        return cls(
+            name=str(data.get("name") or name),
+            **{key: columns[key] for key in COLUMNS if columns.get(key) is not None},
+            date_format=data.get("date_format"),
+            negate=bool(data.get("negate")),
+            delimiter=str(data.get("delimiter") or ","),
+            header=header,
+            encoding=str(data.get("encoding") or "utf-8-sig"),
+        )
+
+
+def bundled_profiles() -> resources.abc.Traversable:
+    return resources.files("budget") / "profiles"
+
+
+def available_profiles(profiles_dir: str | None = None) -> list[str]:
+    """The names of the bundled profiles and the ones in the profiles directory."""
+    names = {path.name for path in bundled_profiles().iterdir()}
+    if profiles_dir and (directory := Path(profiles_dir).expanduser()).is_dir():
+        names.update(path.name for path in directory.iterdir())
+    return sorted(name.removesuffix(PROFILE_SUFFIX) for name in names if name.endswith(PROFILE_SUFFIX))
+
+
+def load_profile(name: str, profiles_dir: str | None = None) -> dict[str, Any]:
+    """Reads a profile's data file, the profiles directory takes precedence over the bundled profiles."""
+    filename = f"{name}{PROFILE_SUFFIX}"
+    candidates = [Path(profiles_dir).expanduser() / filename] if profiles_dir else []
+    candidates.append(bundled_profiles() / filename)
+    for candidate in candidates:
+        if not candidate.is_file():
+            continue
+        try:
+            data = yaml.safe_load(candidate.read_text(encoding="utf-8"))
+        except yaml.YAMLError as e:
+            msg = f"Unable to load CSV profile {candidate}: {e}"
+            raise ConfigError(msg) from e
+        if not isinstance(data, dict):
+            msg = f"Invalid CSV profile {candidate}, expected a mapping"
+            raise ConfigError(msg)
+        return data
+    msg = f"Unknown CSV profile {name!r}, available profiles: {', '.join(available_profiles(profiles_dir))}"
+    raise ConfigError(msg)
+
+
+class CsvSource(NamedTuple):
+    name: str
+    path: str
+    profile: CsvProfile
+    currency: str = ""
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any], profiles_dir: str | None = None) -> Self:
+        if not isinstance(data, dict) or not data.get("name") or not data.get("path"):
+            msg = f"Invalid CSV source {data!r}, name and path are required"
+            raise ConfigError(msg)
+        profile = source_profile(data, profiles_dir)
+        if not profile:
+            msg = f"Invalid CSV source {data['name']}, a profile or columns are required"
+            raise ConfigError(msg)
+        return cls(
+            name=str(data["name"]),
+            path=str(data["path"]),
+            profile=profile,
+            currency=str(data.get("currency") or "").upper(),
+        )
+
+
+def source_profile(data: Mapping[str, Any], profiles_dir: str | None = None) -> CsvProfile | None:
+    """The profile a source names, with the source's own columns and settings applied, None without either."""
+    if not data.get("profile") and not data.get("columns"):
+        return None
+    profile = load_profile(str(data["profile"]), profiles_dir) if data.get("profile") else {}
+    overrides = {key: data[key] for key in SETTINGS if key in data}
+    columns = {**(profile.get("columns") or {}), **(data.get("columns") or {})}
+    return CsvProfile.from_dict(profile | overrides | {"columns": columns}, str(data.get("name") or "csv"))
+
+
+def header_columns(profile: CsvProfile) -> set[str]:
+    return {column for key in COLUMNS if isinstance(column := getattr(profile, key), str)}
+
+
+def detect_profile(text: str, profiles_dir: str | None = None) -> CsvProfile | None:
+    """
+    The profile whose header columns the export has, the one naming the most columns when several match.
+
+    Profiles of exports without a header row can't be detected.
+    """
+    lines = text.splitlines()[:DETECT_LINES]
+    matches: list[CsvProfile] = []
+    for name in available_profiles(profiles_dir):
+        profile = CsvProfile.from_dict(load_profile(name, profiles_dir), name)
+        wanted = header_columns(profile)
+        if profile.header and any(
+            wanted <= {cell.strip() for cell in row} for row in csv.reader(lines, delimiter=profile.delimiter)
+        ):
+            matches.append(profile)
+    return max(matches, key=lambda profile: len(header_columns(profile)), default=None)
+
+
+def parse_amount(value: str) -> Decimal | None:
+    """Parses amounts like `-1,234.56`, `$12.00` or the accounting style `(12.00)`, None when empty."""
+    text = value.strip().replace(",", "").replace("$", "").replace(" ", "")
+    if not text:
+        return None
+    negative = text.startswith("(") and text.endswith(")")
+    try:
+        amount = Decimal(text.strip("()"))
+    except InvalidOperation:
+        return None
+    return -amount if negative else amount
+
+
+def parse_date(value: str, date_format: str | None) -> date | None:
+    try:
+        if date_format:
+            return datetime.strptime(value.strip(), date_format).date()  # noqa: DTZ007
+        return date.fromisoformat(value.strip())
+    except ValueError:
+        return None
+
+
+def read_rows(table: Iterable[Sequence[str]], profile: CsvProfile) -> Iterator[dict[Column, str]]:
+    """
+    Yields each row keyed by its header names and indexes.
+
+    Rows before the header, like the account summary some banks put at the top, are skipped.
+    """
+    rows = iter(table)
+    names: list[str] = []
+    if profile.header:
+        wanted = header_columns(profile)
+        for row in rows:
+            if wanted <= {cell.strip() for cell in row}:
+                names = [cell.strip() for cell in row]
+                break
+        else:
+            msg = f"No header with the columns {', '.join(sorted(wanted))} for the {profile.name} profile"
+            raise ConfigError(msg)
+    for row in rows:
+        if any(cell.strip() for cell in row):
+            yield dict(enumerate(row)) | dict(zip(names, row, strict=False))
+
+
+def parse_csv(text: str, profile: CsvProfile, account: AccountRef) -> list[SimpleFinTransaction]:
+    """Converts a CSV export to transactions, rows without a valid date or amount are skipped."""
+    return parse_table(csv.reader(io.StringIO(text), delimiter=profile.delimiter), profile, account)
+
+
+def parse_table(
+    table: Iterable[Sequence[str]], profile: CsvProfile, account: AccountRef, id_prefix: str = "csv"
+) -> list[SimpleFinTransaction]:
+    """Converts rows of cells laid out as the profile describes, rows without an ID get a stable digest."""
+
+    def cell(row: Mapping[Column, str], column: Column | None) -> str:
+        return row.get(column, "").strip() if column is not None else ""
+
+    transactions: list[SimpleFinTransaction] = []
+    occurrences: Counter[str] = Counter()
+    for number, row in enumerate(read_rows(table, profile), start=1):
+        transacted = parse_date(cell(row, profile.date), profile.date_format)
+        if profile.amount is not None:
+            amount = parse_amount(cell(row, profile.amount))
+        else:
+            debit, credit = parse_amount(cell(row, profile.debit)), parse_amount(cell(row, profile.credit))
+            amount = None if debit is None and credit is None else (credit or 0) - abs(debit or 0)
+        if not transacted or amount is None:
+            logger.warning("Skipping row %d of %s without a valid date or amount", number, account.name)
+            continue
+        amount = -amount if profile.negate else amount
+        payee = cell(row, profile.payee)
+        posted = parse_date(cell(row, profile.posted), profile.date_format) or transacted
+
+        fingerprint = f"{transacted.isoformat()}|{amount}|{payee}"
+        occurrences[fingerprint] += 1
+        digest = hashlib.sha256(f"{fingerprint}|{occurrences[fingerprint]}".encode()).hexdigest()[:16]
+        transactions.append(
+            SimpleFinTransaction(
+                id=cell(row, profile.id) or f"{id_prefix}-{digest}",
+                amount=amount,
+                description=payee,
+                memo=cell(row, profile.memo),
+                payee=payee,
+                posted=datetime.combine(posted, time(), tzinfo=UTC),
+                transacted_at=datetime.combine(transacted, time(), tzinfo=UTC),
+                category=cell(row, profile.category) or None,
+                currency=account.currency or None,
+                account=account,
+            )
+        )
+    return transactions
+
+
+def matching_files(path: str) -> list[Path]:
+    """The files matching a glob like `~/statements/*.csv`, in name order."""
+    pattern = Path(path).expanduser()
+    if not pattern.is_absolute():
+        return sorted(Path().glob(str(pattern)))
+    return sorted(Path(pattern.anchor).glob(str(pattern.relative_to(pattern.anchor))))
+
+
+def fetch_csv_source(source: CsvSource, start_date: datetime) -> SimpleFinAccount:
+    """Reads the rows dated on or after the start date from every file matching the source's path."""
+    account_ref = csv_account_ref(source.name, source.currency)
+    transactions: list[SimpleFinTransaction] = []
+    for file in matching_files(source.path):
+        text = file.read_text(encoding=source.profile.encoding)
+        transactions.extend(
+            transaction
+            for transaction in parse_csv(text, source.profile, account_ref)
+            if transaction.transacted_at.date() >= start_date.date()
+        )
+    logger.info("Read %d transactions from CSV source %s", len(transactions), source.name)
+    return csv_account(account_ref, transactions)
+
+
+def csv_account_ref(name: str, currency: str) -> AccountRef:
+    return AccountRef(id=f"csv:{name}", name=name, org=ORGANIZATION.name, currency=currency)
+
+
+def csv_account(account_ref: AccountRef, transactions: list[SimpleFinTransaction]) -> SimpleFinAccount:
+    return SimpleFinAccount(
+        available_balance="",
+        balance="",
+        balance_date=0,
+        currency=account_ref.currency,
+        holdings=[],
+        id=account_ref.id,
+        name=account_ref.name,
+        org=ORGANIZATION,
+        transactions=transactions,
+    )
+
+
+@dataclass()
+class CsvProfilesArgs:
+    profiles_dir: str | None
+
+
+def list_profiles(args: CsvProfilesArgs) -> None:
+    """Prints each profile's name and the bank it reads."""
+    for name in available_profiles(args.profiles_dir):
+        profile = load_profile(name, args.profiles_dir)
+        _ = sys.stdout.write(f"{name}\t{profile.get('name') or name}\n")
@@ -0,0 +1,299 @@
+import logging
+import threading
+import time
+from collections import deque
+from dataclasses import dataclass, field
+from datetime import UTC, datetime
+from functools import partial
+from pathlib import Path
+from typing import Final, Protocol
+
+from budget import alerts, circuit, shutdown, systemd
+from budget.circuit import COOLDOWN, FAILURE_THRESHOLD, CircuitOpenError
+from budget.clients.google import close_clients
+from budget.clients.simplefin import SimpleFinClient
+from budget.clients.transport import close_pools
+from budget.digest import WEEKDAYS, Digest, DigestSettings
+from budget.main import Args, main, simplefin_client
+from budget.metrics import export_metrics
+from budget.models.simplefin import SimpleFinTransaction
+from budget.runs import ProgressCallback, ProgressEvent, Run, RunStatus, RunTrigger, new_run_id
+from budget.sentry import capture_error
+from budget.watch import WATCH_INTERVAL
+from budget.web import create_server, serve_dashboard
+
+logger = logging.getLogger(__name__)
+
+HISTORY_SIZE: Final = 50
+# how long the dry run behind the pending review is served before fetching again, in seconds
+PENDING_REVIEW_TTL: Final = 15 * 60
+
+
+@dataclass()
+class DaemonArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    interval: int
+    web_host: str
+    web_port: int | None
+    api_token: str | None = None
+    circuit_failure_threshold: int = FAILURE_THRESHOLD
+    circuit_cooldown: int = COOLDOWN
+    digest_to: list[str] = field(default_factory=list)
+    digest_from: str | None = None
+    digest_day: str = WEEKDAYS[0]
+    digest_template: str | None = None
+    smtp_url: str | None = None
+    watch_interval: int = WATCH_INTERVAL
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if self.interval <= 0:
+            errors.append(f"Interval must be positive, got {self.interval}")
+        if self.watch_interval <= 0:
+            errors.append(f"Watch interval must be positive, got {self.watch_interval}")
+        if self.digest_to and not all((self.smtp_url, self.digest_from)):
+            errors.append("The digest requires an SMTP URL and a sender address")
+        if self.digest_day not in WEEKDAYS:
+            errors.append(f"Digest day must be one of {', '.join(WEEKDAYS)}")
+        if self.args.interactive:
+            errors.append("The daemon runs unattended, the interactive review can't be used with it")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise DaemonArgs.Error(msg)
+
+    @property
+    def digest(self) -> DigestSettings | None:
+        if not self.digest_to or not self.smtp_url or not self.digest_from:
+            return None
+        return DigestSettings(
+            smtp_url=self.smtp_url,
+            sender=self.digest_from,
+            recipients=self.digest_to,
+            weekday=WEEKDAYS.index(self.digest_day),
+            template=self.digest_template,
+        )
+
+
+class Runner(Protocol):
+    def __call__(
+        self,
+        args: Args,
+        progress: ProgressCallback | None = None,
+        *,
+        run_id: str | None = None,
+        simplefin: SimpleFinClient | None = None,
+    ) -> list[SimpleFinTransaction]: ...
+
+
+@dataclass()
+class ServeArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    web_host: str
+    web_port: int
+    api_token: str | None = None
+    grpc_port: int | None = None
+
+    def __post_init__(self) -> None:
+        if self.args.interactive:
+            msg = "Invalid CLI Args \nThe server runs imports unattended, the interactive review can't be used with it"
+            raise ServeArgs.Error(msg)
+
+
+class Daemon:
+    """
+    Runs the importer on a fixed interval and keeps a history of recent runs.
+
+    Imports never overlap, starting an import while another is in progress is a no-op. The runs share one
+    SimpleFin client, and the connections and Google session of the clients they make, which are closed
+    when the daemon stops.
+
+    The pending review is a dry run that holds the import lock like an import, its transactions are served
+    again until the TTL passes or the next import ran, so polling it doesn't spend the bridge's daily quota.
+    """
+
+    args: Final[Args]
+    runner: Final[Runner]
+    simplefin: Final[SimpleFinClient]
+    history: deque[Run]
+    digest: Digest | None
+    last_activity: float
+
+    def __init__(self, args: Args, runner: Runner = main, digest: DigestSettings | None = None) -> None:
+        self.args = args
+        self.runner = runner
+        self.simplefin = simplefin_client(args)
+        self.history = deque(maxlen=HISTORY_SIZE)
+        self.digest = Digest(args, digest, datetime.now(UTC).date()) if digest else None
+        self.last_activity = time.monotonic()
+        self._run_lock = threading.Lock()
+        self._stop = threading.Event()
+        self._pending_lock = threading.Lock()
+        self._pending: tuple[float, list[SimpleFinTransaction]] | None = None
+
+    @property
+    def last_run(self) -> Run | None:
+        return self.history[-1] if self.history else None
+
+    @property
+    def is_running(self) -> bool:
+        return self._run_lock.locked()
+
+    def stalled(self, timeout: float) -> bool:
+        """Whether an import is running that reported no progress for the timeout, in seconds."""
+        return self.is_running and time.monotonic() - self.last_activity > timeout
+
+    def get_run(self, run_id: str) -> Run | None:
+        return next((run for run in self.history if run.id == run_id), None)
+
+    def pending_review(self) -> list[SimpleFinTransaction] | None:
+        """The new transactions the next import would write, None when an import is in progress."""
+        with self._pending_lock:
+            if self._pending and time.monotonic() - self._pending[0] < PENDING_REVIEW_TTL:
+                return self._pending[1]
+            if not self._run_lock.acquire(blocking=False):
+                logger.info("Import in progress, not reviewing the pending transactions")
+                return None
+            self.last_activity = time.monotonic()
+            try:
+                pending = main(self.args, dry_run=True, simplefin=self.simplefin)
+            finally:
+                self._run_lock.release()
+            self._pending = (time.monotonic(), pending)
+            return pending
+
+    def run_import(self, trigger: RunTrigger) -> Run | None:
+        """Runs a single import, returning None if another import is already in progress."""
+        run = self._new_run(trigger)
+        if run:
+            self._execute(run)
+        return run
+
+    def start_import(self, trigger: RunTrigger) -> Run | None:
+        """Starts an import in the background, returning None if another import is already in progress."""
+        run = self._new_run(trigger)
+        if run:
+            threading.Thread(target=self._execute, args=(run,), name=f"run-{run.id}", daemon=True).start()
+        return run
+
+    def _new_run(self, trigger: RunTrigger) -> Run | None:
+        if not self._run_lock.acquire(blocking=False):
+            logger.info("Import already in progress, skipping %s run", trigger)
+            return None
+
+        run = Run(id=new_run_id(), trigger=trigger, started_at=datetime.now(UTC))
+        self.history.append(run)
+        self.last_activity = time.monotonic()
+        return run
+
+    def _record(self, run: Run, event: ProgressEvent) -> None:
+        run.events.append(event)
+        self.last_activity = time.monotonic()
+
+    def _execute(self, run: Run) -> None:
+        try:
+            logger.info("Starting %s run %s", run.trigger, run.id)
+            run.transactions = self.runner(
+                self.args, partial(self._record, run), run_id=run.id, simplefin=self.simplefin
+            )
+            run.status = RunStatus.SUCCEEDED
+            if self.digest:
+                self.digest.add(run.transactions)
+        except (CircuitOpenError, shutdown.ShutdownRequestedError) as e:
+            logger.info("Run %s skipped: %s", run.id, e)
+            run.status = RunStatus.SKIPPED
+            run.error = str(e)
+        except Exception as e:
+            logger.exception("Run %s failed", run.id)
+            run.status = RunStatus.FAILED
+            run.error = str(e) or type(e).__name__
+            capture_error(e, run)
+        finally:
+            # what was pending may have been imported now
+            self._pending = None
+            run.finished_at = datetime.now(UTC)
+            export_metrics(self.args.metrics, run)
+            _ = systemd.notify(f"STATUS=Last {run.trigger} run {run.status} at {run.finished_at:%Y-%m-%d %H:%M}")
+            self._run_lock.release()
+
+    def stop(self) -> None:
+        self._stop.set()
+
+    def wait(self) -> None:
+        """Waits for the import in progress, if any, to finish."""
+        with self._run_lock:
+            pass
+
+    def run_forever(self, interval: int) -> None:
+        while not self._stop.is_set():
+            _ = self.run_import(RunTrigger.SCHEDULE)
+            if self.digest:
+                _ = self.digest.send_if_due(datetime.now(UTC).date())
+            _ = self._stop.wait(interval)
+
+    def watch_folder(self, interval: int) -> None:
+        """
+        Starts an import when statement files the previous checks didn't see appear in the watch folder.
+
+        Files left in the folder by a failed run are picked up again by the scheduled runs.
+        """
+        folder = self.args.watch_folder
+        if not folder:
+            return
+        seen: set[Path] = set()
+        while not self._stop.wait(interval):
+            pending = set(folder.pending_files())
+            if pending - seen and self.start_import(RunTrigger.WATCH):
+                seen = pending
+
+
+def daemon(args: DaemonArgs) -> None:
+    alerts.configure(args.args.alert_webhook_url)
+    circuit.configure(args.circuit_failure_threshold, args.circuit_cooldown)
+    scheduler = Daemon(args.args, digest=args.digest)
+    server = serve_dashboard(scheduler, args.web_host, args.web_port, args.api_token) if args.web_port else None
+    if args.args.watch_folder:
+        threading.Thread(target=scheduler.watch_folder, args=(args.watch_interval,), name="watch", daemon=True).start()
+    stop_watchdog = threading.Event()
+    _ = systemd.start_watchdog(scheduler.stalled, stop_watchdog)
+    _ = systemd.notify("READY=1", f"STATUS=Importing every {args.interval}s")
+    try:
+        with shutdown.graceful(scheduler.stop):
+            scheduler.run_forever(args.interval)
+            # an import started by the API or the watch folder finishes its writes before the process exits
+            scheduler.wait()
+    finally:
+        _ = systemd.notify("STOPPING=1")
+        stop_watchdog.set()
+        scheduler.stop()
+        if server:
+            server.shutdown()
+        close_clients()
+        close_pools()
+
+
+def serve(args: ServeArgs) -> None:
+    alerts.configure(args.args.alert_webhook_url)
+    scheduler = Daemon(args.args)
+    server = create_server(scheduler, args.web_host, args.web_port, args.api_token)
+    grpc_server = None
+    if args.grpc_port:
+        from budget.rpc import serve_grpc  # noqa: PLC0415 - optional dependency
+
+        grpc_server = serve_grpc(scheduler, args.web_host, args.grpc_port, args.api_token)
+    logger.info("Serving API on http://%s:%d", args.web_host, args.web_port)
+    try:
+        # shutdown waits for serve_forever, which runs in this thread, so it is called from another
+        with shutdown.graceful(lambda: threading.Thread(target=server.shutdown, name="shutdown").start()):
+            server.serve_forever()
+            scheduler.wait()
+    finally:
+        server.server_close()
+        if grpc_server:
+            _ = grpc_server.stop(grace=None)
+        close_clients()
+        close_pools()
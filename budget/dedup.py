@@ -0,0 +1,129 @@
+import hashlib
+import logging
+import re
+from collections import Counter, defaultdict
+from collections.abc import Sequence
+from datetime import date
+from decimal import Decimal
+from enum import StrEnum
+from typing import Final
+
+from budget.clients.google import transaction_date
+from budget.models.google import DateField, SheetTransaction
+from budget.models.simplefin import SimpleFinAccount, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+KEY_PREFIX: Final = "k:"
+# the rows aggregating a day's small transactions
+SMALL_ID_PREFIX: Final = "small-"
+
+
+class DedupKey(StrEnum):
+    ID = "id"
+    COMPOSITE = "composite"
+
+
+def normalize_payee(payee: str) -> str:
+    """Lowercases and drops everything but letters, so "AMZN Mktp US*2K4" and "Amzn Mktp Us*7Q1" match."""
+    return re.sub(r"[^a-z]", "", payee.lower())
+
+
+def composite_key(account: SimpleFinAccount, transaction: SimpleFinTransaction) -> str:
+    parts = (
+        account.id,
+        transaction.transacted_at.date().isoformat(),
+        str(transaction.amount),
+        normalize_payee(transaction.payee),
+    )
+    return KEY_PREFIX + hashlib.sha256("|".join(parts).encode()).hexdigest()[:16]
+
+
+def is_aggregate(transaction: SimpleFinTransaction) -> bool:
+    return transaction.id.startswith(SMALL_ID_PREFIX)
+
+
+def fingerprint(account: str | None, day: date, amount: Decimal, payee: str) -> str:
+    """
+    What a transaction looks like in every source and in the sheet, whatever ID a source gave it.
+
+    The account is the aliased one, the same for the raw accounts of every source listed under an alias,
+    and None for sheet rows, which don't record it.
+    """
+    return f"{account or ''}|{day.isoformat()}|{amount.quantize(Decimal('0.01'))}|{normalize_payee(payee)}"
+
+
+def account_id(transaction: SimpleFinTransaction) -> str:
+    return transaction.account.id if transaction.account else ""
+
+
+def cross_source_duplicates(
+    transactions: Sequence[SimpleFinTransaction],
+    rows: Sequence[SheetTransaction],
+    new_ids: set[str],
+    sources: Sequence[str],
+    date_field: DateField,
+) -> list[SimpleFinTransaction]:
+    """
+    The new transactions another source already brought, in this fetch or as a row of the sheet.
+
+    Runs after categorization so payees compare like the sheet's. A fingerprint occurs as often as
+    the source seeing it the most says, or as the sheet has it, so two equal coffees from one source
+    stay two while the copy of each from a CSV backfill is dropped. New transactions fill the
+    missing occurrences in the order of the sources, the ones first in `sources` win.
+
+    Only transactions of the same account are copies, two cards charged the same subscription on
+    the same day are not. A sheet row belongs to the account of the fetched transaction with its ID,
+    a row none of the fetched transactions has, like one a CSV file of an earlier run brought, counts
+    as a copy for a single transaction of any account.
+    """
+    accounts = {transaction.row_id: account_id(transaction) for transaction in transactions}
+    in_sheet: Counter[str] = Counter()
+    unattributed: Counter[str] = Counter()
+    for row in rows:
+        if row.id in accounts:
+            in_sheet[fingerprint(accounts[row.id], row.date, row.amount, row.payee)] += 1
+        else:
+            unattributed[fingerprint(None, row.date, row.amount, row.payee)] += 1
+    per_source: defaultdict[str, Counter[str]] = defaultdict(Counter)
+    candidates: defaultdict[str, list[SimpleFinTransaction]] = defaultdict(list)
+    anonymous: dict[str, str] = {}
+    for transaction in transactions:
+        day = transaction_date(transaction, date_field).date()
+        key = fingerprint(account_id(transaction), day, transaction.amount, transaction.payee)
+        per_source[transaction.source or ""][key] += 1
+        if transaction.row_id in new_ids:
+            candidates[key].append(transaction)
+            anonymous[key] = fingerprint(None, day, transaction.amount, transaction.payee)
+
+    rank = {source: index for index, source in enumerate(sources)}
+    duplicates: list[SimpleFinTransaction] = []
+    for key, new in candidates.items():
+        missing = max(counts[key] for counts in per_source.values()) - in_sheet[key]
+        claimed = min(max(missing, 0), unattributed[anonymous[key]])
+        unattributed[anonymous[key]] -= claimed
+        missing -= claimed
+        new.sort(key=lambda transaction: rank.get(transaction.source or "", len(rank)))
+        duplicates.extend(new[max(missing, 0) :])
+    if duplicates:
+        logger.info("Dropped %d transactions another source already imported", len(duplicates))
+    return duplicates
+
+
+def assign_keys(accounts: Sequence[SimpleFinAccount], mode: DedupKey) -> None:
+    """
+    Sets the key each transaction is deduplicated by and written to the ID column under.
+
+    Must run before categorization renames payees. Identical composite keys in one fetch, like two
+    equal coffees on the same day, are told apart by their order. The aggregate of a day's small
+    transactions keeps its ID, its amount changes as the day fills in.
+    """
+    seen: Counter[str] = Counter()
+    for account in accounts:
+        for transaction in account.transactions:
+            if mode == DedupKey.ID or is_aggregate(transaction):
+                transaction.key = transaction.id
+                continue
+            key = composite_key(account, transaction)
+            seen[key] += 1
+            transaction.key = key if seen[key] == 1 else f"{key}-{seen[key]}"
@@ -0,0 +1,249 @@
+"""
+Checks and repairs for transactions tabs that got into a bad state, from past bugs or rows pasted by hand.
+
+`budget-import doctor` lists the rows the importer can't read or that don't match the sheet: blank IDs,
+dates and amounts that don't parse, amounts stored as text, cells past the layout's columns and categories
+missing from the lookup sheet. With `--fix` it repairs the safe cases, it gives rows without an ID a stable
+`manual-` ID, stores text amounts as numbers and gives categories the lookup sheet's spelling when they only
+differ in case or spacing. The other problems are left to fix by hand.
+
+`budget-import doctor dedupe` finds rows sharing an ID and rows of different IDs with the same date,
+amount and payee, shows each group with the copy it proposes to keep, the one with the most cells
+filled, lets the copy to keep be chosen and deletes the others once the deletion is confirmed.
+Identical purchases on the same day look like near duplicates too, their groups can be skipped.
+"""
+
+import hashlib
+import logging
+from collections import Counter, defaultdict
+from collections.abc import Callable, Mapping, Sequence
+from dataclasses import dataclass
+from typing import NamedTuple
+
+from budget.categories import row_category, row_cells
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.dedup import fingerprint
+from budget.ledger import Ledger
+from budget.main import Args
+from budget.models.google import SheetLayout, SheetTransaction, parse_amount, parse_date
+from budget.review import write
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class DoctorArgs:
+    args: Args
+    # repair the safe cases
+    fix: bool = False
+
+
+@dataclass()
+class DedupeArgs:
+    args: Args
+    # keep the proposed copies without asking
+    yes: bool = False
+
+
+class Problem(NamedTuple):
+    tab: str
+    # 1-based row number
+    row: int
+    message: str
+    # the cells that repair it, by their 1-based row and column, None when it needs to be fixed by hand
+    fix: Mapping[tuple[int, int], str | float] | None = None
+
+    def __str__(self) -> str:
+        return f"{self.tab} row {self.row}: {self.message}{' (fixable)' if self.fix else ''}"
+
+
+def manual_id(key: str, occurrence: int) -> str:
+    """A stable ID for a row added by hand, identical rows are told apart by their occurrence."""
+    return "manual-" + hashlib.sha256(f"{key}|{occurrence}".encode()).hexdigest()[:16]
+
+
+def check_rows(
+    tab: str,
+    rows: Sequence[list[str]],
+    values: Sequence[Sequence[str | float | int]],
+    layout: SheetLayout,
+    categories: set[str],
+) -> list[Problem]:
+    """
+    The problems of a transactions tab, from its rows as displayed and as stored.
+
+    A first row whose amount and date don't parse is taken for a header and isn't checked.
+    """
+    width = len(layout.columns())
+    # the lookup sheet's spelling of each category, by the category folded
+    spellings = {category.strip().casefold(): category for category in categories}
+    occurrences: Counter[str] = Counter()
+    problems: list[Problem] = []
+    for index, row in enumerate(rows, start=1):
+        if not any(cell.strip() for cell in row):
+            continue
+        cells = [*row, "", "", "", "", ""]
+        amount = parse_amount(cells[2])
+        day = parse_date(cells[3])
+        if index == 1 and amount is None and day is None:
+            continue
+
+        if not cells[0].strip():
+            fix = None
+            if amount is not None and day is not None:
+                key = f"{day.isoformat()}|{amount}|{cells[1]}"
+                occurrences[key] += 1
+                fix = {(index, 1): manual_id(key, occurrences[key])}
+            problems.append(Problem(tab, index, "blank ID", fix))
+        if day is None:
+            problems.append(Problem(tab, index, f"unparsable date {cells[3]!r}"))
+        stored = values[index - 1][2] if index <= len(values) and len(values[index - 1]) > 2 else ""
+        if amount is None:
+            problems.append(Problem(tab, index, f"unparsable amount {cells[2]!r}"))
+        elif isinstance(stored, str) and stored.strip():
+            problems.append(Problem(tab, index, f"amount {stored!r} stored as text", {(index, 3): float(amount)}))
+        if extra := sum(1 for cell in row[width:] if cell.strip()):
+            problems.append(Problem(tab, index, f"{extra} cells past the layout's {width} columns"))
+
+        category = row_category(row, layout)
+        if categories and category and category not in categories:
+            spelling = spellings.get(category.strip().casefold())
+            fix = row_cells(index, row, layout, category=spelling) if spelling else None
+            problems.append(Problem(tab, index, f"category {category!r} isn't in the lookup sheet", fix))
+    return problems
+
+
+def check(args: DoctorArgs) -> int:
+    """Lists the problems of every transactions tab and repairs the safe ones with fix, returning how many remain."""
+    base = args.args
+    spreadsheet_id = base.sheets_spreadsheet_id
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        tabs = base.transaction_tabs(google.worksheet_titles(spreadsheet_id))
+        categories, _ = google.get_category_mapping(base.mapping_spreadsheet, base.mapping_range_name)
+        problems: list[Problem] = []
+        for tab in tabs:
+            rows = google.get_rows(spreadsheet_id, tab)
+            tab_problems = check_rows(tab, rows, google.get_values(spreadsheet_id, tab), base.layout, categories)
+            problems.extend(tab_problems)
+            if args.fix:
+                cells = {cell: value for problem in tab_problems if problem.fix for cell, value in problem.fix.items()}
+                google.update_cells(spreadsheet_id, tab, cells)
+
+    for problem in problems:
+        write(f"{problem}\n")
+    fixed = sum(1 for problem in problems if problem.fix) if args.fix else 0
+    remaining = len(problems) - fixed
+    if not problems:
+        logger.info("Found no problems in %d tabs", len(tabs))
+    elif args.fix:
+        logger.info("Fixed %d problems, %d are left to fix by hand", fixed, remaining)
+    else:
+        fixable = sum(1 for problem in problems if problem.fix)
+        logger.info("Found %d problems, run again with --fix to repair %d of them", len(problems), fixable)
+    return remaining
+
+
+class DuplicateGroup(NamedTuple):
+    tab: str
+    reason: str
+    # 1-based row numbers and the rows, in sheet order
+    rows: list[tuple[int, list[str]]]
+
+    def proposed(self) -> int:
+        """The position of the copy to keep, the one with the most cells filled, the first on ties."""
+        filled = [sum(1 for cell in row if cell.strip()) for _, row in self.rows]
+        return filled.index(max(filled))
+
+
+def find_duplicates(tab: str, rows: Sequence[list[str]]) -> list[DuplicateGroup]:
+    """Rows sharing an ID, then rows of different IDs sharing a date, amount and payee."""
+    by_id: defaultdict[str, list[tuple[int, list[str]]]] = defaultdict(list)
+    by_fingerprint: defaultdict[str, list[tuple[int, list[str]]]] = defaultdict(list)
+    for index, row in enumerate(rows, start=1):
+        if not (sheet_row := SheetTransaction.from_row(row)):
+            continue
+        if sheet_row.id:
+            by_id[sheet_row.id].append((index, row))
+        by_fingerprint[fingerprint(None, sheet_row.date, sheet_row.amount, sheet_row.payee)].append((index, row))
+
+    groups = [DuplicateGroup(tab, f"ID {row_id}", copies) for row_id, copies in by_id.items() if len(copies) > 1]
+    groups.extend(
+        DuplicateGroup(tab, "same date, amount and payee", copies)
+        for copies in by_fingerprint.values()
+        # each ID counts once, copies of one ID are a group of their own
+        if len({row[0] for _, row in copies}) > 1
+    )
+    return groups
+
+
+def describe(group: DuplicateGroup, keep: int) -> str:
+    lines = [f"\n{group.tab}: {group.reason}"]
+    for position, (index, row) in enumerate(group.rows):
+        marker = "*" if position == keep else " "
+        lines.append(f" {marker} [{position + 1}] row {index}: {'  '.join(cell for cell in row[:6] if cell)}")
+    return "\n".join(lines) + "\n"
+
+
+def choose(group: DuplicateGroup, prompt: Callable[[str], str]) -> int | None:
+    """The position of the copy to keep, None to keep them all."""
+    keep = group.proposed()
+    write(describe(group, keep))
+    while True:
+        answer = prompt(f"Keep which copy? [1-{len(group.rows)}, Enter keeps *, s skips] ").strip().lower()
+        if not answer:
+            return keep
+        if answer == "s":
+            return None
+        if answer.isdigit() and 1 <= int(answer) <= len(group.rows):
+            return int(answer) - 1
+        write("Unknown answer\n")
+
+
+def dedupe(args: DedupeArgs, prompt: Callable[[str], str] = input) -> int:
+    """Finds the duplicate rows of every transactions tab and deletes the chosen copies, returning how many."""
+    base = args.args
+    spreadsheet_id = base.sheets_spreadsheet_id
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        tabs = base.transaction_tabs(google.worksheet_titles(spreadsheet_id))
+        sheet_rows = {tab: google.get_rows(spreadsheet_id, tab) for tab in tabs}
+        groups = [group for tab, rows in sheet_rows.items() for group in find_duplicates(tab, rows)]
+        if not groups:
+            logger.info("No duplicate rows found")
+            return 0
+
+        deletions: defaultdict[str, set[int]] = defaultdict(set)
+        kept: defaultdict[str, set[int]] = defaultdict(set)
+        for group in groups:
+            keep = group.proposed() if args.yes else choose(group, prompt)
+            if args.yes:
+                write(describe(group, keep))
+            if keep is not None:
+                kept[group.tab].add(group.rows[keep][0])
+                deletions[group.tab].update(index for index, _ in group.rows)
+        # a row can be in both kinds of group, the copy kept in either isn't deleted
+        for tab, indexes in deletions.items():
+            indexes -= kept[tab]
+        total = sum(len(indexes) for indexes in deletions.values())
+        if not total:
+            logger.info("Keeping every row")
+            return 0
+        if not args.yes and prompt(f"\nDelete {total} rows? [y/N] ").strip().lower() != "y":
+            logger.info("Deleted nothing")
+            return 0
+        for tab, indexes in deletions.items():
+            google.delete_rows(spreadsheet_id, tab, sorted(indexes))
+
+    if base.ledger_file:
+        deleted_ids = {sheet_rows[tab][index - 1][0] for tab, indexes in deletions.items() for index in indexes}
+        remaining_ids = {
+            row[0]
+            for tab, rows in sheet_rows.items()
+            for index, row in enumerate(rows, start=1)
+            if row and index not in deletions.get(tab, set())
+        }
+        with Ledger(base.ledger_file) as ledger:
+            removed = ledger.delete_transactions(deleted_ids - remaining_ids)
+        logger.info("Removed %d deleted transactions from the ledger", removed)
+    logger.info("Deleted %d duplicate rows", total)
+    return total
@@ -0,0 +1,73 @@
+"""
+Reads statement files from a Google Drive folder, so exports saved to Drive from a phone get imported.
+
+The folder must be shared with the service account of the Google credentials. Files in it are read
+as statement files (see budget.statements) and left in place, the IDs of the imported ones are kept
+in the state file once the run succeeded so each file is imported once. Files that can't be read are
+reported and retried on the next run. Subfolders aren't searched.
+
+Sample config:
+```yaml
+sources:
+  - type: drive
+    name: phone
+    folder_id: 1a2B3c4D5e6F
+    profile: chase  # for CSV files
+    currency: USD
+state_file: /data/state.json
+```
+"""
+
+import logging
+from typing import Any, NamedTuple, Self
+
+from budget.clients.google import GoogleClient
+from budget.config import ConfigError
+from budget.models.simplefin import SimpleFinAccount
+from budget.state import ImportState
+from budget.statements import StatementError, StatementOptions, is_statement, parse_statement
+
+logger = logging.getLogger(__name__)
+
+
+class DriveSource(NamedTuple):
+    folder_id: str
+    statements: StatementOptions
+
+    @property
+    def name(self) -> str:
+        return self.statements.name
+
+    @property
+    def state_key(self) -> str:
+        return f"drive:{self.name}"
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any], profiles_dir: str | None = None) -> Self:
+        if not isinstance(data, dict) or not data.get("name") or not data.get("folder_id"):
+            msg = f"Invalid Drive source {data!r}, name and folder_id are required"
+            raise ConfigError(msg)
+        return cls(folder_id=str(data["folder_id"]), statements=StatementOptions.from_dict(data, profiles_dir))
+
+
+def fetch_drive_source(
+    google: GoogleClient, source: DriveSource, state: ImportState, notices: list[str]
+) -> tuple[list[SimpleFinAccount], list[str]]:
+    """
+    Reads the statement files the state doesn't list yet, returning their accounts and file IDs.
+
+    Files that can't be read are added to the notices and left out of the IDs.
+    """
+    accounts: list[SimpleFinAccount] = []
+    file_ids: list[str] = []
+    for file in google.drive_files(source.folder_id):
+        if not is_statement(file.name) or state.contains(source.state_key, file.id):
+            continue
+        try:
+            accounts.extend(parse_statement(file.name, google.download_file(file.id), source.statements))
+        except StatementError as e:
+            notices.append(f"Drive source {source.name}: {e}")
+            continue
+        file_ids.append(file.id)
+    logger.info("Read %d new statement files from Drive source %s", len(file_ids), source.name)
+    return accounts, file_ids
@@ -0,0 +1,177 @@
+"""
+Rules that keep transactions out of the sheet entirely.
+
+Sample config:
+```yaml
+pipeline:
+  filters:
+    exclusions:
+      - payee: "^INTEREST CHARGE REVERSAL"
+      - account: Sweep
+        payee: "TRANSFER TO"
+      - payee: "PRE-TAX ADJ"
+        min_amount: -5
+        max_amount: 5
+      - purpose: business
+        owner: Sam
+```
+"""
+
+import logging
+import re
+from collections import defaultdict
+from collections.abc import Sequence
+from dataclasses import dataclass, replace
+from datetime import UTC, date, datetime
+from decimal import Decimal, InvalidOperation
+from typing import Any, Final, Self
+
+from budget.clients.google import convert_to_row
+from budget.config import ConfigError
+from budget.dedup import SMALL_ID_PREFIX, is_aggregate
+from budget.models.google import METADATA_COLUMNS, GoogleSheetRow, SheetLayout, SheetTransaction
+from budget.models.simplefin import AccountPurpose, SimpleFinAccount, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+SMALL_PAYEE: Final = "Small transactions"
+RULE_KEYS: Final = ("payee", "account", "min_amount", "max_amount", "owner", "purpose")
+
+
+def parse_bound(data: dict[str, Any], key: str) -> Decimal | None:
+    if data.get(key) is None:
+        return None
+    try:
+        return Decimal(str(data[key]))
+    except InvalidOperation as e:
+        msg = f"Invalid exclusion rule {data!r}, {key} must be a number"
+        raise ConfigError(msg) from e
+
+
+@dataclass(frozen=True)
+class ExclusionRule:
+    """Matches when every field that is set matches, payee is a case-insensitive regular expression."""
+
+    payee: re.Pattern[str] | None = None
+    account: str | None = None
+    min_amount: Decimal | None = None
+    max_amount: Decimal | None = None
+    # the tags of the account, from the accounts section
+    owner: str | None = None
+    purpose: AccountPurpose | None = None
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not set(data) & set(RULE_KEYS):
+            msg = f"Invalid exclusion rule {data!r}, expected at least one of {', '.join(RULE_KEYS)}"
+            raise ConfigError(msg)
+        if data.get("purpose") and data["purpose"] not in set(AccountPurpose):
+            msg = f"Invalid exclusion rule {data!r}, purpose must be one of {', '.join(AccountPurpose)}"
+            raise ConfigError(msg)
+        try:
+            payee = re.compile(str(data["payee"]), re.IGNORECASE) if data.get("payee") else None
+        except re.error as e:
+            msg = f"Invalid exclusion rule {data!r}, bad payee pattern: {e}"
+            raise ConfigError(msg) from e
+        return cls(
+            payee=payee,
+            account=str(data["account"]) if data.get("account") else None,
+            min_amount=parse_bound(data, "min_amount"),
+            max_amount=parse_bound(data, "max_amount"),
+            owner=str(data["owner"]) if data.get("owner") else None,
+            purpose=AccountPurpose(data["purpose"]) if data.get("purpose") else None,
+        )
+
+    def matches(self, account: SimpleFinAccount, transaction: SimpleFinTransaction) -> bool:
+        if self.payee and not self.payee.search(transaction.payee):
+            return False
+        if self.account and self.account not in {account.id, account.name}:
+            return False
+        if self.owner and transaction.owner != self.owner:
+            return False
+        if self.purpose and (not transaction.account or transaction.account.purpose != self.purpose):
+            return False
+        if self.min_amount is not None and transaction.amount < self.min_amount:
+            return False
+        return self.max_amount is None or transaction.amount <= self.max_amount
+
+
+def apply_exclusions(accounts: Sequence[SimpleFinAccount], rules: Sequence[ExclusionRule]) -> None:
+    """Drops excluded transactions from their accounts, before any other processing sees them."""
+    if not rules:
+        return
+    excluded = 0
+    for account in accounts:
+        kept = [t for t in account.transactions if not any(rule.matches(account, t) for rule in rules)]
+        excluded += len(account.transactions) - len(kept)
+        account.transactions = kept
+    logger.info("Excluded %d transactions", excluded)
+
+
+def aggregate_small(
+    account: SimpleFinAccount, day: date, transactions: list[SimpleFinTransaction]
+) -> SimpleFinTransaction:
+    """
+    Sums a day's small transactions into one row, its ID is stable so later runs deduplicate it.
+
+    The ID is also its dedup key under composite keys, and the row is rewritten by `aggregate_updates`
+    as the day fills in, so a later run with a new total updates it instead of appending another.
+    """
+    first = transactions[0]
+    return replace(
+        first,
+        id=f"{SMALL_ID_PREFIX}{account.id}-{day.isoformat()}",
+        amount=sum((t.amount for t in transactions), Decimal(0)),
+        description=f"{len(transactions)} transactions under the minimum amount",
+        memo="",
+        payee=SMALL_PAYEE,
+        posted=max(t.posted for t in transactions),
+        transacted_at=datetime(day.year, day.month, day.day, tzinfo=UTC),
+        tags=[],
+        key=None,
+    )
+
+
+def aggregate_updates(
+    rows: Sequence[list[str]], transactions: Sequence[SimpleFinTransaction], layout: SheetLayout
+) -> dict[int, GoogleSheetRow]:
+    """
+    Returns the aggregate rows whose total changed, keyed by 1-based row number, whatever the checksum policy.
+
+    Nobody edits a sum by hand, the row keeps only the import that appended it.
+    """
+    by_id = {transaction.row_id: transaction for transaction in transactions if is_aggregate(transaction)}
+    columns = layout.columns()
+    metadata_columns = [index for index, column in enumerate(columns) if column in METADATA_COLUMNS]
+    updates: dict[int, GoogleSheetRow] = {}
+    for index, row in enumerate(rows, start=1):
+        transaction = by_id.get(row[0]) if row else None
+        current = SheetTransaction.from_row(row) if transaction else None
+        if not transaction or not current or current.amount == transaction.amount:
+            continue
+        logger.info("The small transactions of %s now total %s", transaction.transacted_at.date(), transaction.amount)
+        new_row = convert_to_row(transaction, layout)
+        for column in metadata_columns:
+            new_row[column] = row[column] if len(row) > column else ""
+        updates[index] = new_row
+    return updates
+
+
+def apply_min_amount(accounts: Sequence[SimpleFinAccount], min_amount: Decimal | None, *, aggregate: bool) -> None:
+    """Drops transactions smaller than the minimum, or with aggregate replaces them with one row per account and day."""
+    if not min_amount:
+        return
+    small_count = 0
+    for account in accounts:
+        kept: list[SimpleFinTransaction] = []
+        small: defaultdict[date, list[SimpleFinTransaction]] = defaultdict(list)
+        for transaction in account.transactions:
+            if abs(transaction.amount) < min_amount:
+                small[transaction.transacted_at.date()].append(transaction)
+            else:
+                kept.append(transaction)
+        small_count += sum(len(transactions) for transactions in small.values())
+        if aggregate:
+            kept.extend(aggregate_small(account, day, transactions) for day, transactions in small.items())
+        account.transactions = kept
+    logger.info("%s %d transactions under %s", "Aggregated" if aggregate else "Skipped", small_count, min_amount)
@@ -0,0 +1,128 @@
+"""
+Sinking funds, money set aside each month for a car repair or a vacation and spent from its categories.
+
+Each fund accrues its monthly contribution from the month it starts, including the current one, and the
+spending in its categories since then is taken out of it, refunds put money back. The funds tab is
+rewritten after each import with a row per fund, read from every transactions tab so rotated and
+per-account tabs count too. A fund can start with an opening balance, money already saved for it, which
+counts as contributed.
+
+Sample config:
+```yaml
+funds:
+  - name: Car repair
+    monthly: 75
+    categories: [Auto:Repair, Auto:Tires]
+    start: 2024-01
+  - name: Vacation
+    monthly: "200.00"
+    categories: Travel
+    start: 2024-03
+    balance: 500
+destinations:
+  - type: sheets
+    funds_range_name: funds
+```
+"""
+
+import logging
+from collections.abc import Sequence
+from datetime import date
+from decimal import Decimal
+from typing import Any, Final, NamedTuple, Self
+
+from budget.config import ConfigError
+from budget.models.google import GoogleSheetRow, SheetTransaction, parse_amount, split_category
+
+logger = logging.getLogger(__name__)
+
+HEADER: Final = ["Fund", "Monthly", "Since", "Contributed", "Spent", "Balance"]
+
+
+class SinkingFund(NamedTuple):
+    name: str
+    monthly: Decimal
+    categories: tuple[str, ...]
+    # the first day of the first month a contribution is made
+    start: date
+    # saved before the start
+    balance: Decimal = Decimal(0)
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not all(data.get(key) for key in ("name", "monthly", "categories", "start")):
+            msg = f"Invalid fund {data!r}, name, monthly, categories and start are required"
+            raise ConfigError(msg)
+        monthly = parse_amount(str(data["monthly"]))
+        balance = parse_amount(str(data.get("balance") or 0))
+        if monthly is None or balance is None:
+            msg = f"Invalid amounts of the {data['name']} fund, expected numbers"
+            raise ConfigError(msg)
+        try:
+            # YAML reads 2024-01-15 as a date, the day is dropped either way
+            start = date.fromisoformat(f"{str(data['start'])[:7]}-01")
+        except ValueError as e:
+            msg = f"Invalid start {data['start']!r} of the {data['name']} fund, expected YYYY-MM"
+            raise ConfigError(msg) from e
+        categories = data["categories"]
+        return cls(
+            name=str(data["name"]),
+            monthly=monthly,
+            categories=tuple(str(name) for name in ([categories] if isinstance(categories, str) else categories)),
+            start=start,
+            balance=balance,
+        )
+
+    def contributed(self, day: date) -> Decimal:
+        """The contributions from the start through the month holding day."""
+        months = (day.year - self.start.year) * 12 + day.month - self.start.month + 1
+        return self.monthly * max(months, 0)
+
+
+def validate_funds(funds: Sequence[SinkingFund]) -> str | None:
+    """Returns why the funds are ambiguous, or None when each has its own name."""
+    names: set[str] = set()
+    for fund in funds:
+        if fund.name in names:
+            return f"The {fund.name} fund is defined twice"
+        names.add(fund.name)
+    return None
+
+
+def fund_spend(fund: SinkingFund, transactions: Sequence[SheetTransaction], *, groups: bool = False) -> Decimal:
+    """
+    The net spend in the fund's categories since its start, refunds reduce it.
+
+    With groups the transactions tab holds the category without its group, so the fund's are compared the same way.
+    """
+    categories = {split_category(category)[1] if groups else category for category in fund.categories}
+    return -sum(
+        (
+            transaction.amount
+            for transaction in transactions
+            if transaction.date >= fund.start and transaction.category in categories
+        ),
+        Decimal(0),
+    )
+
+
+def fund_rows(
+    funds: Sequence[SinkingFund], transactions: Sequence[SheetTransaction], day: date, *, groups: bool = False
+) -> list[GoogleSheetRow]:
+    """The header and a row per fund with what it accrued and spent up to the day and what is left in it."""
+    rows: list[GoogleSheetRow] = [list(HEADER)]
+    for fund in funds:
+        contributed = fund.balance + fund.contributed(day)
+        past = [transaction for transaction in transactions if transaction.date <= day]
+        spent = fund_spend(fund, past, groups=groups)
+        rows.append(
+            [
+                fund.name,
+                float(fund.monthly),
+                fund.start.isoformat(),
+                float(contributed),
+                float(spent),
+                float(contributed - spent),
+            ]
+        )
+    return rows
@@ -0,0 +1,51 @@
+import re
+from collections.abc import Iterable
+from difflib import SequenceMatcher
+from typing import Final
+
+FUZZY_THRESHOLD: Final = 0.8
+MIN_PREFIX: Final = 4
+
+
+def normalize(payee: str) -> list[str]:
+    """Lowercase word tokens without store numbers and punctuation, "WHOLEFDS MKT #10233" becomes wholefds, mkt."""
+    return re.findall(r"[a-z]+", payee.lower())
+
+
+def ratio(a: str, b: str) -> float:
+    return SequenceMatcher(None, a, b).ratio() if a and b else 0.0
+
+
+def similarity(payee: str, candidate: str) -> float:
+    """
+    Scores two payees between 0 and 1.
+
+    Takes the best of a token set comparison, which ignores word order and extra words, and an edit
+    distance ratio over the squashed names, where the longer name is cut to the shorter one's length
+    so trailing noise like "MKT" or a city doesn't count against a match.
+    """
+    payee_tokens, candidate_tokens = set(normalize(payee)), set(normalize(candidate))
+    if not payee_tokens or not candidate_tokens:
+        return 0.0
+    common = payee_tokens & candidate_tokens
+    token_set = len(common) / min(len(payee_tokens), len(candidate_tokens))
+    squashed_payee, squashed_candidate = "".join(normalize(payee)), "".join(normalize(candidate))
+    shortest = min(len(squashed_payee), len(squashed_candidate))
+    prefix = ratio(squashed_payee[:shortest], squashed_candidate[:shortest]) if shortest >= MIN_PREFIX else 0.0
+    return max(token_set, ratio(squashed_payee, squashed_candidate), prefix)
+
+
+class PayeeMatcher:
+    """Finds the closest mapping entry for a payee the mapping doesn't contain verbatim."""
+
+    def __init__(self, payees: Iterable[str], threshold: float = FUZZY_THRESHOLD) -> None:
+        self.payees = [payee for payee in payees if payee]
+        self.threshold = threshold
+        self._cache: dict[str, str | None] = {}
+
+    def match(self, payee: str) -> str | None:
+        if payee not in self._cache:
+            scored = ((similarity(payee, candidate), candidate) for candidate in self.payees)
+            score, best = max(scored, default=(0.0, None))
+            self._cache[payee] = best if score >= self.threshold else None
+        return self._cache[payee]
@@ -0,0 +1,127 @@
+"""
+Tracks the holdings of investment accounts between runs, a light portfolio tracker from data SimpleFIN already sends.
+
+Each run compares the holdings the accounts report with the snapshot the state file kept from the last run
+and appends a row per change to the activity tab: shares bought or sold, a position opened or closed, or
+only the market value moving. The first run records the snapshot without any activity. The snapshot is
+saved once the activity is written, so a failed run compares against the same snapshot next time.
+
+Sample config:
+```yaml
+state_file: ~/.local/state/budget-import/state.json
+destinations:
+  - type: sheets
+    holdings_range_name: activity
+```
+"""
+
+import logging
+from collections.abc import Mapping, Sequence
+from decimal import Decimal
+from enum import StrEnum
+from typing import Any, NamedTuple, Self
+
+from budget.balances import parse_decimal
+from budget.models.simplefin import SimpleFinAccount
+
+logger = logging.getLogger(__name__)
+
+
+class Activity(StrEnum):
+    OPENED = "opened"
+    BOUGHT = "bought"
+    SOLD = "sold"
+    CLOSED = "closed"
+    REVALUED = "revalued"
+
+
+class Position(NamedTuple):
+    account: str
+    symbol: str
+    shares: Decimal
+    market_value: Decimal
+
+    @classmethod
+    def from_dict(cls, data: Mapping[str, Any]) -> Self:
+        return cls(
+            account=str(data["account"]),
+            symbol=str(data["symbol"]),
+            shares=Decimal(str(data["shares"])),
+            market_value=Decimal(str(data["market_value"])),
+        )
+
+    def to_dict(self) -> dict[str, str]:
+        return {
+            "account": self.account,
+            "symbol": self.symbol,
+            "shares": str(self.shares),
+            "market_value": str(self.market_value),
+        }
+
+
+class HoldingChange(NamedTuple):
+    activity: Activity
+    account: str
+    symbol: str
+    shares: Decimal
+    shares_change: Decimal
+    market_value: Decimal
+    value_change: Decimal
+
+    def to_row(self, day: str) -> list[str]:
+        return [
+            day,
+            self.account,
+            self.symbol,
+            str(self.activity),
+            str(self.shares),
+            str(self.shares_change),
+            str(self.market_value),
+            str(self.value_change),
+        ]
+
+
+def positions(accounts: Sequence[SimpleFinAccount]) -> dict[str, Position]:
+    """The holdings of the accounts keyed by account ID and symbol, holdings without a symbol by their ID."""
+    current: dict[str, Position] = {}
+    for account in accounts:
+        for holding in account.holdings:
+            symbol = holding.symbol or holding.description or holding.id
+            context = f"{account.name} {symbol}"
+            current[f"{account.id}:{symbol}"] = Position(
+                account=account.name,
+                symbol=symbol,
+                shares=parse_decimal(holding.shares, context),
+                market_value=parse_decimal(holding.market_value, context),
+            )
+    return current
+
+
+def holding_changes(previous: Mapping[str, Position], current: Mapping[str, Position]) -> list[HoldingChange]:
+    """What changed in each position since the previous snapshot, in the order of the current one."""
+    changes: list[HoldingChange] = []
+    for key in [*current, *(key for key in previous if key not in current)]:
+        before, after = previous.get(key), current.get(key)
+        position = after or before
+        if not position:
+            continue
+        shares = after.shares if after else Decimal(0)
+        value = after.market_value if after else Decimal(0)
+        shares_change = shares - (before.shares if before else Decimal(0))
+        value_change = value - (before.market_value if before else Decimal(0))
+        if not shares_change and not value_change:
+            continue
+        if not before:
+            activity = Activity.OPENED
+        elif not shares:
+            activity = Activity.CLOSED
+        elif shares_change > 0:
+            activity = Activity.BOUGHT
+        elif shares_change < 0:
+            activity = Activity.SOLD
+        else:
+            activity = Activity.REVALUED
+        changes.append(
+            HoldingChange(activity, position.account, position.symbol, shares, shares_change, value, value_change)
+        )
+    return changes
@@ -0,0 +1,95 @@
+"""
+HTTP tracing for debugging what a bridge sends, enabled with `--trace-http`.
+
+The requests and responses of the SimpleFin and Paperless clients are logged with their headers
+and bodies. Credentials, tokens and account numbers are redacted before anything is logged, so a
+trace can be attached to a bug report as is.
+"""
+
+import io
+import logging
+import re
+from collections.abc import Iterable, Mapping
+from typing import Final
+
+from budget.jsonstream import Readable
+
+logger = logging.getLogger(__name__)
+
+REDACTED: Final = "[redacted]"
+MAX_BODY: Final = 64 * 1024
+CHUNK_SIZE: Final = 64 * 1024
+SECRET_HEADERS: Final = frozenset({"authorization", "proxy-authorization", "cookie", "set-cookie", "x-api-key"})
+SECRET_NAMES: Final = r"[\w-]*(?:password|token|secret|api_?key|access_url|credentials)[\w-]*"
+SECRET_FIELD: Final = re.compile(rf'("{SECRET_NAMES}"\s*:\s*)"(?:[^"\\]|\\.)*"', re.IGNORECASE)
+SECRET_PARAM: Final = re.compile(rf"\b({SECRET_NAMES}=)[^&\s\"]+", re.IGNORECASE)
+URL_CREDENTIALS: Final = re.compile(r"(\w+://)[^/\s:@\"]+:[^/\s@\"]+@")
+# the SimpleFin claim URL ends with the one-time token
+CLAIM_TOKEN: Final = re.compile(r"(/claim/)[^/\s?\"]+")
+JSON_STRING: Final = re.compile(r'"(?:[^"\\]|\\.)*"')
+# runs of 8 or more digits inside strings, numbers outside them are amounts and timestamps
+ACCOUNT_NUMBER: Final = re.compile(r"(?<!\d)\d{4,}(\d{4})(?!\d)")
+
+_enabled = False
+
+
+def configure(*, enabled: bool) -> None:
+    """Turns tracing on or off for every client in the process."""
+    global _enabled  # noqa: PLW0603
+    _enabled = enabled
+
+
+def enabled() -> bool:
+    return _enabled
+
+
+def mask_account_numbers(text: str) -> str:
+    return JSON_STRING.sub(lambda match: ACCOUNT_NUMBER.sub(r"****\1", match.group()), text)
+
+
+def redact(text: str) -> str:
+    """Redacts secret fields and parameters, credentials in URLs and account numbers."""
+    text = SECRET_FIELD.sub(rf'\1"{REDACTED}"', text)
+    text = SECRET_PARAM.sub(rf"\1{REDACTED}", text)
+    text = URL_CREDENTIALS.sub(rf"\1{REDACTED}@", text)
+    text = CLAIM_TOKEN.sub(rf"\1{REDACTED}", text)
+    return mask_account_numbers(text)
+
+
+def redact_headers(headers: Mapping[str, str] | Iterable[tuple[str, str]]) -> dict[str, str]:
+    items = headers.items() if isinstance(headers, Mapping) else headers
+    return {name: REDACTED if name.lower() in SECRET_HEADERS else redact(value) for name, value in items}
+
+
+def trace_request(client: str, method: str, path: str, headers: Mapping[str, str], body: str | None = None) -> None:
+    if not _enabled:
+        return
+    logger.info("%s request: %s %s %s", client, method, redact(path), redact_headers(headers))
+    if body:
+        trace_body(client, body.encode())
+
+
+def trace_response(client: str, status: int, headers: Iterable[tuple[str, str]]) -> None:
+    if not _enabled:
+        return
+    logger.info("%s response: %d %s", client, status, redact_headers(headers))
+
+
+def trace_body(client: str, body: bytes) -> None:
+    if not _enabled:
+        return
+    text = body[:MAX_BODY].decode(errors="replace")
+    truncated = f" ({len(body) - MAX_BODY} more bytes)" if len(body) > MAX_BODY else ""
+    logger.info("%s body: %s%s", client, redact(text), truncated)
+
+
+def traced_stream(client: str, stream: Readable) -> Readable:
+    """Reads and logs a streamed body, returning a stream over it so it can still be decoded."""
+    if not _enabled:
+        return stream
+    chunks: list[bytes] = []
+    while chunk := stream.read(CHUNK_SIZE):
+        chunks.append(chunk)
+    body = b"".join(chunks)
+    trace_body(client, body)
+    return io.BytesIO(body)
@@ -0,0 +1,145 @@
+"""
+Imports the history of an existing budget spreadsheet, one with its own column layout, into the managed tabs.
+
+The legacy tab's columns are described like a CSV export's, by one of the CSV profiles or by the columns
+given on the command line, which override the profile's. Rows become transactions of one account with
+stable IDs, so importing the same tab again adds nothing, and go through the current mapping before they
+are merged into the transactions tabs. Rows the tabs already have, by ID or as the same date, amount and
+payee imported from a bank, are skipped.
+
+Sample usage:
+```sh
+budget-import import-sheet --spreadsheet-id 4d5e6f --range-name "2019-2023" \
+    --columns date=Date,payee=Description,amount=Amount,category=Category --source-date-format %m/%d/%Y
+```
+"""
+
+import logging
+from collections.abc import Mapping
+from dataclasses import dataclass
+from datetime import UTC, datetime
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.clients.simplefin import categorize_transactions
+from budget.config import ConfigError
+from budget.csv_source import CsvProfile, csv_account_ref, parse_table, source_profile
+from budget.dedup import cross_source_duplicates
+from budget.fuzzy import PayeeMatcher
+from budget.layout import stamp_layout, upgrade_layout
+from budget.ledger import record_run
+from budget.main import Args, restore_ids
+from budget.models.google import DateField, RowMetadata, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+from budget.redaction import redact
+from budget.runs import new_run_id
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class ImportSheetArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    spreadsheet_id: str
+    range_name: str
+    account: str
+    profile: CsvProfile
+    dry_run: bool = False
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if not self.spreadsheet_id or not self.range_name:
+            errors.append("The spreadsheet and tab to import are required")
+        if not self.account:
+            errors.append("An account name for the imported rows is required")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise ImportSheetArgs.Error(msg)
+
+
+def parse_columns(value: str | None) -> dict[str, str | int]:
+    """Parses `date=Date,payee=Description`, the columns of a tab without a header row are 0-based indexes."""
+    columns: dict[str, str | int] = {}
+    for item in (value or "").split(","):
+        if not item.strip():
+            continue
+        key, separator, column = item.partition("=")
+        if not separator or not key.strip() or not column.strip():
+            msg = f"Invalid column {item!r}, expected field=column"
+            raise ConfigError(msg)
+        columns[key.strip()] = int(column) if column.strip().isdigit() else column.strip()
+    return columns
+
+
+def legacy_profile(options: Mapping[str, object]) -> CsvProfile:
+    """The column layout of the legacy tab, from a CSV profile and the columns and settings given."""
+    profile = source_profile({key: value for key, value in options.items() if value not in (None, {}, False)})
+    if not profile:
+        msg = "Describe the legacy tab's columns with --profile or --columns"
+        raise ConfigError(msg)
+    return profile
+
+
+def import_sheet(args: ImportSheetArgs) -> list[SimpleFinTransaction]:
+    """Merges the legacy tab's rows into the transactions tabs, returning the ones added."""
+    base = args.args
+    account = csv_account_ref(args.account, "")
+    source = f"legacy:{args.account}"
+    run_id = new_run_id()
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        table = google.get_rows(args.spreadsheet_id, args.range_name)
+        transactions = parse_table(table, args.profile, account, id_prefix="legacy")
+        for transaction in transactions:
+            transaction.key = transaction.id
+            transaction.source = source
+        logger.info("Read %d transactions from %s", len(transactions), args.range_name)
+
+        _, mapping = google.get_category_mapping(base.mapping_spreadsheet, base.mapping_range_name)
+        matcher = PayeeMatcher(mapping, base.fuzzy_threshold) if base.fuzzy_threshold else None
+        categorize_transactions(transactions, mapping, matcher)
+
+        added: list[SimpleFinTransaction] = []
+        metadata = RowMetadata(run_id=run_id, imported_at=datetime.now(UTC))
+        for tab, tab_transactions in base.route(transactions).items():
+            rows = google.get_rows(base.sheets_spreadsheet_id, tab, missing_ok=True)
+            rows = upgrade_layout(google, base.sheets_spreadsheet_id, tab, rows, base.layout, dry_run=args.dry_run)
+            if base.row_metadata and rows:
+                rows = restore_ids(rows, google.row_ids(base.sheets_spreadsheet_id, tab))
+            existing_ids = {row[0] for row in rows if row}
+            sheet_rows = [sheet_row for row in rows if (sheet_row := SheetTransaction.from_row(row))]
+            new_ids = {transaction.row_id for transaction in tab_transactions} - existing_ids
+            duplicates = {
+                id(transaction)
+                for transaction in cross_source_duplicates(
+                    tab_transactions, sheet_rows, new_ids, [source], DateField(base.date_field)
+                )
+            }
+            new = [
+                transaction
+                for transaction in tab_transactions
+                if transaction.row_id in new_ids and id(transaction) not in duplicates
+            ]
+            logger.info("Merging %d of %d rows into %s", len(new), len(tab_transactions), tab)
+            if new and not args.dry_run:
+                google.insert_records_to_google_sheet(
+                    base.sheets_spreadsheet_id,
+                    tab,
+                    redact(new, base.redaction),
+                    base.layout,
+                    metadata,
+                    identify=base.row_metadata,
+                )
+                if not rows:
+                    stamp_layout(google, base.sheets_spreadsheet_id, tab, base.layout)
+            added.extend(new)
+
+    if args.dry_run:
+        logger.info("Would import %d rows, run again without --dry-run to write them", len(added))
+        return added
+    if base.ledger_file and added:
+        record_run(base.ledger_file, added, [], metadata)
+    logger.info("Run %s imported %d rows from %s", run_id, len(added), args.range_name)
+    return added
@@ -0,0 +1,169 @@
+"""
+The importer as a library, for programs embedding the import instead of running the `budget-import` command.
+
+An `Importer` is made from the same `Args` the command builds, or from a config file and command line options
+with `from_config`. `run` imports like the command does and returns the new transactions, `preview` fetches
+and categorizes them without writing anything, and `categorize` applies the mapping rules of the lookup tab
+to transactions from elsewhere. Unlike the command, the importer installs no signal handlers, exports no
+metrics and doesn't catch errors, the embedding program decides what to do with them.
+
+`new` composes an importer from the settings and options: sources and destinations of the program's own,
+which are fetched from and written to like plugins, rules used instead of the lookup tab's, a clock for the
+time runs happen at, and hooks called with the progress events of each run, all of them or one stage's.
+
+Sample usage:
+```python
+from budget.importer import Importer, new, with_clock, with_destination, with_hook, with_rules, with_source
+
+importer = Importer.from_config("config.yaml", "--lookback-days", "7")
+for transaction in importer.preview():
+    print(transaction.payee, transaction.amount, transaction.category)
+imported = importer.run()
+
+importer = new(
+    importer.args,
+    with_source(MyBank()),
+    with_destination(Warehouse()),
+    with_rules({"STARBUCKS": Category(category="Coffee", name=None)}),
+    with_clock(lambda: datetime(2024, 3, 1, tzinfo=UTC)),
+    with_hook(RunStage.INSERTED, lambda event: print(f"{event.count} rows written")),
+)
+imported = importer.run()
+```
+"""
+
+import logging
+from collections.abc import Callable, Mapping, Sequence
+from dataclasses import replace
+from datetime import datetime
+from typing import Self
+
+from budget.circuit import breaker
+from budget.cli import get_args
+from budget.clients.google import GoogleClient
+from budget.clients.simplefin import categorize_transactions
+from budget.fuzzy import PayeeMatcher
+from budget.main import Args, main
+from budget.models.google import Category
+from budget.models.simplefin import SimpleFinTransaction
+from budget.plugins import Destination, Source
+from budget.runs import ProgressCallback, ProgressEvent, RunStage, new_run_id
+
+logger = logging.getLogger(__name__)
+
+
+class Importer:
+    """Imports transactions with the given settings, reporting the progress of each run to the hooks."""
+
+    args: Args
+    hooks: list[ProgressCallback]
+
+    def __init__(self, args: Args, progress: ProgressCallback | None = None) -> None:
+        self.args = args
+        self.hooks = [progress] if progress else []
+
+    def progress(self, event: ProgressEvent) -> None:
+        for hook in self.hooks:
+            hook(event)
+
+    @classmethod
+    def from_config(cls, path: str | None, *options: str, progress: ProgressCallback | None = None) -> Self:
+        """
+        An importer with the settings the command would use, from the config file and command line options.
+
+        Environment variables are read as the command reads them, the options take precedence over both.
+        """
+        args = get_args([*(("--config", path) if path else ()), *options])
+        if not isinstance(args, Args):
+            msg = f"The options select the {type(args).__name__.removesuffix('Args')} command, not an import"
+            raise Args.Error(msg)
+        return cls(args, progress)
+
+    def run(self, run_id: str | None = None) -> list[SimpleFinTransaction]:
+        """Imports the new transactions into the sheet and returns them, the run ID tags their rows."""
+        return main(self.args, self.progress if self.hooks else None, run_id=run_id or new_run_id())
+
+    def preview(self) -> list[SimpleFinTransaction]:
+        """The transactions the next run would import, fetched and categorized without writing anything."""
+        return main(self.args, self.progress if self.hooks else None, dry_run=True)
+
+    def categorize(self, transactions: Sequence[SimpleFinTransaction]) -> None:
+        """Categorizes transactions with the rules of the lookup tab, as an import categorizes fetched ones."""
+        mapping = self.rules()
+        matcher = PayeeMatcher(mapping, self.args.fuzzy_threshold) if self.args.fuzzy_threshold else None
+        categorize_transactions(transactions, mapping, matcher)
+        logger.info("Categorized %d transactions", len(transactions))
+
+    def rules(self) -> dict[str, Category]:
+        """The mapping rules runs categorize with, those of the lookup tab unless rules were given."""
+        if self.args.rules is not None:
+            return dict(self.args.rules)
+        with GoogleClient(self.args.google_credentials) as google, breaker("google").guard():
+            _, mapping = google.get_category_mapping(self.args.mapping_spreadsheet, self.args.mapping_range_name)
+        return mapping
+
+
+Option = Callable[[Importer], None]
+
+
+def new(args: Args, *options: Option) -> Importer:
+    """An importer with the settings changed by the options, the given settings are left as they are."""
+    importer = Importer(replace(args, sources=list(args.sources), destinations=list(args.destinations)))
+    for option in options:
+        option(importer)
+    return importer
+
+
+def with_source(source: Source) -> Option:
+    """Fetches accounts from the source too, its transactions are tagged with its name."""
+
+    def apply(importer: Importer) -> None:
+        importer.args.sources.append(source)
+
+    return apply
+
+
+def with_destination(destination: Destination) -> Option:
+    """Writes the new transactions of each run to the destination too, after the sheet."""
+
+    def apply(importer: Importer) -> None:
+        importer.args.destinations.append(destination)
+
+    return apply
+
+
+def with_rules(rules: Mapping[str, Category]) -> Option:
+    """Categorizes with the rules, keyed by payee, instead of the lookup tab's."""
+
+    def apply(importer: Importer) -> None:
+        importer.args.rules = dict(rules)
+
+    return apply
+
+
+def with_clock(clock: Callable[[], datetime]) -> Option:
+    """Takes the time runs happen at from the clock, which returns an aware datetime."""
+
+    def apply(importer: Importer) -> None:
+        importer.args.clock = clock
+
+    return apply
+
+
+def with_progress(callback: ProgressCallback) -> Option:
+    """Calls the callback with every progress event of each run."""
+
+    def apply(importer: Importer) -> None:
+        importer.hooks.append(callback)
+
+    return apply
+
+
+def with_hook(stage: RunStage, callback: ProgressCallback) -> Option:
+    """Calls the callback when a run reaches the stage, with the event's count of transactions."""
+
+    def hook(event: ProgressEvent) -> None:
+        if event.stage == stage:
+            callback(event)
+
+    return with_progress(hook)
@@ -0,0 +1,110 @@
+import codecs
+import json
+from collections.abc import Generator
+from typing import Any, Final, Protocol
+
+CHUNK_SIZE: Final = 64 * 1024
+WHITESPACE: Final = " \t\n\r"
+
+
+class Readable(Protocol):
+    def read(self, amt: int, /) -> bytes: ...
+
+
+class JSONStreamError(ValueError): ...
+
+
+class _Buffer:
+    """A text buffer over a byte stream that only keeps the unparsed tail in memory."""
+
+    def __init__(self, stream: Readable, chunk_size: int) -> None:
+        self.stream = stream
+        self.chunk_size = chunk_size
+        self.decoder = codecs.getincrementaldecoder("utf-8")()
+        self.text = ""
+        self.pos = 0
+        self.eof = False
+
+    def fill(self, size: int) -> bool:
+        if self.eof:
+            return False
+        chunk = self.stream.read(size)
+        self.eof = not chunk
+        self.text = self.text[self.pos :] + self.decoder.decode(chunk, final=self.eof)
+        self.pos = 0
+        return True
+
+    def peek(self) -> str:
+        """Returns the next non-whitespace character without consuming it, or "" at the end of the stream."""
+        while True:
+            while self.pos < len(self.text) and self.text[self.pos] in WHITESPACE:
+                self.pos += 1
+            if self.pos < len(self.text):
+                return self.text[self.pos]
+            if not self.fill(self.chunk_size):
+                return ""
+
+    def expect(self, chars: str) -> str:
+        char = self.peek()
+        if not char or char not in chars:
+            msg = f"Expected one of {chars!r} but found {char or 'end of stream'!r}"
+            raise JSONStreamError(msg)
+        self.pos += 1
+        return char
+
+    def value(self, decoder: json.JSONDecoder) -> Any:
+        """Decodes the next complete value, reading more of the stream (in growing chunks) until it is whole."""
+        size = self.chunk_size
+        _ = self.peek()
+        while True:
+            try:
+                value, end = decoder.raw_decode(self.text, self.pos)
+            except json.JSONDecodeError as e:
+                if not self.fill(size):
+                    raise JSONStreamError(str(e)) from e
+                size *= 2
+                continue
+            # a number at the end of the buffer may continue in the next chunk
+            if end == len(self.text) and self.fill(size):
+                continue
+            self.pos = end
+            return value
+
+
+def stream_object(
+    stream: Readable, stream_key: str, chunk_size: int = CHUNK_SIZE
+) -> Generator[tuple[str, Any], None, None]:
+    """
+    Incrementally decodes a top level JSON object from a byte stream.
+
+    Yields `(key, value)` for each member of the object, except the array under `stream_key`
+    which is yielded one `(stream_key, item)` pair per element. Only the element currently being
+    decoded is held in memory, so a large array never needs the whole body buffered.
+    """
+    decoder = json.JSONDecoder()
+    buffer = _Buffer(stream, chunk_size)
+    _ = buffer.expect("{")
+    if buffer.peek() == "}":
+        buffer.pos += 1
+        return
+
+    while True:
+        key = buffer.value(decoder)
+        if not isinstance(key, str):
+            msg = f"Expected an object key but found {key!r}"
+            raise JSONStreamError(msg)
+        _ = buffer.expect(":")
+        if key == stream_key and buffer.peek() == "[":
+            buffer.pos += 1
+            if buffer.peek() == "]":
+                buffer.pos += 1
+            else:
+                while True:
+                    yield key, buffer.value(decoder)
+                    if buffer.expect(",]") == "]":
+                        break
+        else:
+            yield key, buffer.value(decoder)
+
+        if buffer.expect(",}") == "}":
+            return
@@ -0,0 +1,146 @@
+"""
+Keeps the columns of the transactions tabs in step with the layout the options describe.
+
+The columns a tab was written with are stored in the tab's developer metadata, with the version of the
+layout format. When the options add or remove a column, like turning on the status column or adding an
+extra column, the next import migrates the tab before it writes: columns are inserted and deleted in the
+sheet, so existing rows, their formatting and formulas move with them, instead of new rows being appended
+under columns that don't line up. Tabs written before the layout was stored are taken to have the current
+layout, and a migration that would delete a column holding values or reorder columns stops the import.
+"""
+
+import json
+import logging
+from collections.abc import Sequence
+from typing import Any, Final
+
+from budget.clients.google import GoogleClient
+from budget.models.google import SheetLayout
+
+logger = logging.getLogger(__name__)
+
+LAYOUT_KEY: Final = "budget-importer.layout"
+# bumped when the meaning of the stored columns changes
+LAYOUT_VERSION: Final = 1
+
+
+class LayoutError(Exception): ...
+
+
+def layout_value(layout: SheetLayout) -> str:
+    return json.dumps({"version": LAYOUT_VERSION, "columns": layout.columns()})
+
+
+def stored_columns(tab: str, value: str) -> list[str]:
+    """The columns a tab was written with, from its stored layout."""
+    try:
+        stored = json.loads(value)
+        version, columns = int(stored["version"]), [str(column) for column in stored["columns"]]
+    except (ValueError, TypeError, KeyError) as e:
+        msg = f"The layout stored on {tab} is unreadable: {value!r}"
+        raise LayoutError(msg) from e
+    if version > LAYOUT_VERSION:
+        msg = f"{tab} was written by a newer version of budget-import, layout version {version}, upgrade to import"
+        raise LayoutError(msg)
+    return columns
+
+
+def migration_requests(
+    tab: str, sheet_id: int, rows: Sequence[list[str]], old: list[str], new: list[str]
+) -> list[dict[str, Any]]:
+    """The requests deleting the columns the layout dropped and inserting the ones it added, left to right."""
+    dropped = [index for index, column in enumerate(old) if column not in new]
+    filled = [old[index] for index in dropped if any(len(row) > index and row[index].strip() for row in rows)]
+    if filled:
+        msg = (
+            f"{tab} has values in the {', '.join(filled)} columns the options no longer write, "
+            "turn the options back on or clear the columns"
+        )
+        raise LayoutError(msg)
+    if [column for column in old if column in new] != [column for column in new if column in old]:
+        msg = f"The options reorder the columns of {tab}, from {', '.join(old)} to {', '.join(new)}"
+        raise LayoutError(msg)
+
+    def dimension(index: int) -> dict[str, Any]:
+        return {"sheetId": sheet_id, "dimension": "COLUMNS", "startIndex": index, "endIndex": index + 1}
+
+    # deleted from the right so the indexes to the left stay put, then inserted at their place in the new layout
+    requests: list[dict[str, Any]] = [{"deleteDimension": {"range": dimension(index)}} for index in reversed(dropped)]
+    requests.extend(
+        {"insertDimension": {"range": dimension(index), "inheritFromBefore": index > 0}}
+        for index, column in enumerate(new)
+        if column not in old
+    )
+    return requests
+
+
+def migrate_rows(rows: Sequence[list[str]], old: list[str], new: list[str]) -> list[list[str]]:
+    """The rows as the migration leaves them, cells past the old layout's columns stay after the new ones."""
+    return [
+        [row[old.index(column)] if column in old and old.index(column) < len(row) else "" for column in new]
+        + row[len(old) :]
+        for row in rows
+    ]
+
+
+def stamp_layout(google: GoogleClient, spreadsheet_id: str, tab: str, layout: SheetLayout) -> None:
+    """Stores the layout on the tab, replacing the one stored before."""
+    sheet_id, metadata = google.developer_metadata(spreadsheet_id, tab)
+    if entry := metadata.get(LAYOUT_KEY):
+        request: dict[str, Any] = {
+            "updateDeveloperMetadata": {
+                "dataFilters": [{"developerMetadataLookup": {"metadataId": entry["metadataId"]}}],
+                "developerMetadata": {"metadataValue": layout_value(layout)},
+                "fields": "metadataValue",
+            }
+        }
+    else:
+        request = {
+            "createDeveloperMetadata": {
+                "developerMetadata": {
+                    "metadataKey": LAYOUT_KEY,
+                    "metadataValue": layout_value(layout),
+                    "location": {"sheetId": sheet_id},
+                    "visibility": "DOCUMENT",
+                }
+            }
+        }
+    google.batch_update(spreadsheet_id, [request])
+
+
+def upgrade_layout(
+    google: GoogleClient,
+    spreadsheet_id: str,
+    tab: str,
+    rows: list[list[str]],
+    layout: SheetLayout,
+    *,
+    dry_run: bool = False,
+) -> list[list[str]]:
+    """
+    Migrates a tab written with other columns to the layout, returning its rows in the layout.
+
+    A tab without rows, which might not exist yet, is left for the import to stamp once it wrote to it.
+    """
+    if not rows:
+        return rows
+    sheet_id, metadata = google.developer_metadata(spreadsheet_id, tab)
+    new = layout.columns()
+    if not (entry := metadata.get(LAYOUT_KEY)):
+        if not dry_run:
+            stamp_layout(google, spreadsheet_id, tab, layout)
+        return rows
+    old = stored_columns(tab, entry.get("metadataValue", ""))
+    if old == new:
+        return rows
+
+    requests = migration_requests(tab, sheet_id, rows, old, new)
+    added = ", ".join(column for column in new if column not in old) or "no columns"
+    removed = ", ".join(column for column in old if column not in new) or "no columns"
+    if dry_run:
+        logger.info("Would migrate %s to the new layout, adding %s and removing %s", tab, added, removed)
+        return migrate_rows(rows, old, new)
+    logger.warning("Migrating %s to the new layout, adding %s and removing %s", tab, added, removed)
+    google.batch_update(spreadsheet_id, requests)
+    stamp_layout(google, spreadsheet_id, tab, layout)
+    return google.get_rows(spreadsheet_id, tab)
@@ -0,0 +1,53 @@
+"""
+Mapping rules learned from the categories corrected by hand in the sheet.
+
+Edits pulled into the ledger are grouped by the payee the source gave. A payee recategorized to the
+same category at least `learn_threshold` times, while its mapping rule says otherwise or it has none,
+is suggested as a new rule in the log, and with `learn` the rule is saved to the mapping tab so the
+next transactions of the payee get that category.
+
+Sample config:
+```yaml
+ledger_file: /data/ledger.sqlite
+pipeline:
+  rules:
+    learn: true
+    learn_threshold: 3
+```
+"""
+
+from collections import Counter, defaultdict
+from collections.abc import Iterable, Mapping
+from typing import Final, NamedTuple
+
+from budget.ledger import LedgerEntry
+from budget.models.google import Category
+
+LEARN_THRESHOLD: Final = 3
+
+
+class RuleSuggestion(NamedTuple):
+    payee: str
+    rule: Category
+    count: int
+
+
+def suggest_rules(
+    entries: Iterable[LedgerEntry], mapping: Mapping[str, Category], threshold: int = LEARN_THRESHOLD
+) -> list[RuleSuggestion]:
+    """Suggests a rule for each payee repeatedly recategorized the same way, unlike its current rule."""
+    edited: defaultdict[str, Counter[str]] = defaultdict(Counter)
+    for entry in entries:
+        transaction = entry.transaction
+        if entry.edited and transaction.category:
+            edited[transaction.original_payee or transaction.payee][transaction.category] += 1
+    suggestions: list[RuleSuggestion] = []
+    for payee, categories in sorted(edited.items()):
+        category, count = categories.most_common(1)[0]
+        current = mapping.get(payee)
+        if count < threshold or (current and current.category == category):
+            continue
+        # the learned rule only changes the category, a payee name the rule sets is kept
+        rule = Category(category=category, name=current.name if current else None)
+        suggestions.append(RuleSuggestion(payee, rule, count))
+    return suggestions
@@ -0,0 +1,432 @@
+"""
+Local ledger of every transaction ever imported, the source of truth the sheet is a projection of.
+
+Each run records the transactions it wrote and the ones it found in the sheet already, with the
+payee and category the source gave them next to the ones the mapping set. That lets the sheet be
+rebuilt from the ledger after the rules change (`budget-import reproject`), undo remove a run from
+both and every destination be fed from the same data. The ledger is a SQLite file.
+
+Payees and categories corrected by hand in the sheet are pulled back with `budget-import sync`, and
+before every run records and every reprojection, so the ledger keeps them over what the mapping says.
+
+Sample config:
+```yaml
+ledger_file: /data/ledger.sqlite
+```
+"""
+
+import json
+import logging
+import sqlite3
+from collections.abc import Iterable, Mapping, Sequence
+from datetime import date, datetime
+from decimal import Decimal
+from types import TracebackType
+from typing import Any, Final, NamedTuple, Self
+
+from budget.models.google import (
+    CATEGORY_SEPARATOR,
+    Category,
+    RowMetadata,
+    SheetLayout,
+    SheetTransaction,
+    split_category,
+)
+from budget.models.paperless import Document
+from budget.models.simplefin import AccountPurpose, AccountRef, Merchant, SimpleFinTransaction, Trade
+from budget.redaction import Redaction
+
+logger = logging.getLogger(__name__)
+
+SCHEMA_VERSION: Final = 8
+SCHEMA: Final = """
+CREATE TABLE IF NOT EXISTS transactions (
+    row_id TEXT PRIMARY KEY,
+    id TEXT NOT NULL,
+    account_id TEXT NOT NULL,
+    account_name TEXT NOT NULL,
+    account_org TEXT NOT NULL,
+    source TEXT,
+    payee TEXT NOT NULL,
+    original_payee TEXT,
+    description TEXT NOT NULL,
+    memo TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    currency TEXT,
+    original_amount TEXT,
+    original_currency TEXT,
+    transacted_at TEXT NOT NULL,
+    posted TEXT NOT NULL,
+    category TEXT,
+    mapped INTEGER NOT NULL,
+    pending INTEGER NOT NULL,
+    receipt TEXT,
+    run_id TEXT NOT NULL,
+    imported_at TEXT,
+    edited INTEGER NOT NULL DEFAULT 0,
+    merchant TEXT,
+    extra TEXT,
+    refund_of TEXT,
+    trade TEXT,
+    owner TEXT,
+    account_purpose TEXT
+);
+CREATE INDEX IF NOT EXISTS transactions_run_id ON transactions (run_id);
+"""
+COLUMNS: Final = (
+    "row_id",
+    "id",
+    "account_id",
+    "account_name",
+    "account_org",
+    "source",
+    "payee",
+    "original_payee",
+    "description",
+    "memo",
+    "amount",
+    "currency",
+    "original_amount",
+    "original_currency",
+    "transacted_at",
+    "posted",
+    "category",
+    "mapped",
+    "pending",
+    "receipt",
+    "run_id",
+    "imported_at",
+    "merchant",
+    "extra",
+    "refund_of",
+    "trade",
+    "owner",
+    "account_purpose",
+)
+# a transaction's import metadata is kept when a later run records it again
+KEPT_COLUMNS: Final = ("row_id", "run_id", "imported_at")
+# and so is what was edited in the sheet
+EDITED_COLUMNS: Final = ("payee", "original_payee", "category", "mapped")
+# and what only new transactions are enriched with
+ENRICHED_COLUMNS: Final = ("merchant",)
+MIGRATIONS: Final = {
+    2: "ALTER TABLE transactions ADD COLUMN edited INTEGER NOT NULL DEFAULT 0",
+    3: "ALTER TABLE transactions ADD COLUMN merchant TEXT",
+    4: "ALTER TABLE transactions ADD COLUMN extra TEXT",
+    5: "ALTER TABLE transactions ADD COLUMN refund_of TEXT",
+    6: "ALTER TABLE transactions ADD COLUMN trade TEXT",
+    7: "ALTER TABLE transactions ADD COLUMN owner TEXT",
+    8: "ALTER TABLE transactions ADD COLUMN account_purpose TEXT",
+}
+
+
+class LedgerEntry(NamedTuple):
+    transaction: SimpleFinTransaction
+    metadata: RowMetadata
+    edited: bool
+
+
+class SheetEdit(NamedTuple):
+    """A payee or category corrected by hand in the sheet."""
+
+    row_id: str
+    payee: str
+    category: str | None
+    # the payee the source gave, which a mapping rule for the edit is keyed by
+    source_payee: str
+
+
+def receipt_json(receipt: Document | None) -> str | None:
+    if not receipt:
+        return None
+    return json.dumps(
+        {
+            "id": receipt.id,
+            "date": receipt.date.isoformat(),
+            "total": str(receipt.total) if receipt.total is not None else None,
+            "title": receipt.title,
+            "category": receipt.category,
+        }
+    )
+
+
+def parse_receipt(value: str | None) -> Document | None:
+    if not value:
+        return None
+    data = json.loads(value)
+    return Document(
+        id=int(data["id"]),
+        date=date.fromisoformat(data["date"]),
+        total=Decimal(data["total"]) if data.get("total") is not None else None,
+        title=data["title"],
+        category=data.get("category"),
+    )
+
+
+def merchant_json(merchant: Merchant | None) -> str | None:
+    return json.dumps(merchant._asdict()) if merchant else None
+
+
+def parse_merchant(value: str | None) -> Merchant | None:
+    return Merchant(**json.loads(value)) if value else None
+
+
+def trade_json(trade: Trade | None) -> str | None:
+    return json.dumps({key: str(value) for key, value in trade._asdict().items()}) if trade else None
+
+
+def parse_trade(value: str | None) -> Trade | None:
+    if not value:
+        return None
+    data = json.loads(value)
+    return Trade(
+        action=data["action"],
+        symbol=data["symbol"],
+        units=Decimal(data["units"]),
+        unit_price=Decimal(data["unit_price"]),
+        gross=Decimal(data["gross"]),
+        fees=Decimal(data["fees"]),
+    )
+
+
+def to_record(transaction: SimpleFinTransaction, metadata: RowMetadata) -> tuple[Any, ...]:
+    account = transaction.account or AccountRef(id="", name="", org="", currency="")
+    return (
+        transaction.row_id,
+        transaction.id,
+        account.id,
+        account.name,
+        account.org,
+        transaction.source,
+        transaction.payee,
+        transaction.original_payee,
+        transaction.description,
+        transaction.memo,
+        str(transaction.amount),
+        transaction.currency,
+        str(transaction.original_amount) if transaction.original_amount is not None else None,
+        transaction.original_currency,
+        transaction.transacted_at.isoformat(),
+        transaction.posted.isoformat(),
+        transaction.category,
+        int(transaction.mapped),
+        int(transaction.pending),
+        receipt_json(transaction.receipt),
+        metadata.run_id,
+        metadata.imported_at.isoformat() if metadata.imported_at else None,
+        merchant_json(transaction.merchant),
+        json.dumps(transaction.extra) if transaction.extra else None,
+        transaction.refund_of,
+        trade_json(transaction.trade),
+        transaction.owner,
+        account.purpose,
+    )
+
+
+def from_record(row: sqlite3.Row) -> LedgerEntry:
+    account = AccountRef(
+        id=row["account_id"],
+        name=row["account_name"],
+        org=row["account_org"],
+        currency=row["currency"] or "",
+        purpose=AccountPurpose(row["account_purpose"] or AccountPurpose.PERSONAL),
+    )
+    transaction = SimpleFinTransaction(
+        id=row["id"],
+        amount=Decimal(row["amount"]),
+        description=row["description"],
+        memo=row["memo"],
+        payee=row["payee"],
+        posted=datetime.fromisoformat(row["posted"]),
+        transacted_at=datetime.fromisoformat(row["transacted_at"]),
+        category=row["category"],
+        receipt=parse_receipt(row["receipt"]),
+        currency=row["currency"],
+        original_amount=Decimal(row["original_amount"]) if row["original_amount"] is not None else None,
+        original_currency=row["original_currency"],
+        original_payee=row["original_payee"],
+        merchant=parse_merchant(row["merchant"]),
+        trade=parse_trade(row["trade"]),
+        owner=row["owner"],
+        extra=json.loads(row["extra"]) if row["extra"] else {},
+        key=row["row_id"],
+        source=row["source"],
+        account=account,
+        mapped=bool(row["mapped"]),
+        pending=bool(row["pending"]),
+        refund_of=row["refund_of"],
+    )
+    imported_at = datetime.fromisoformat(row["imported_at"]) if row["imported_at"] else None
+    return LedgerEntry(transaction, RowMetadata(run_id=row["run_id"], imported_at=imported_at), bool(row["edited"]))
+
+
+def find_edits(
+    transactions: Mapping[str, SimpleFinTransaction],
+    rows: Iterable[list[str]],
+    layout: SheetLayout,
+    redaction: Redaction | None = None,
+) -> list[SheetEdit]:
+    """
+    Finds the rows whose payee or category differs from what the ledger says was written.
+
+    With a redaction, a redacted payee left as written isn't an edit, its edit keeps the ledger's payee.
+    """
+    group_column = layout.columns().index("category_group") if layout.category_groups else None
+    edits: list[SheetEdit] = []
+    for row in rows:
+        sheet_row = SheetTransaction.from_row(row)
+        transaction = transactions.get(sheet_row.id) if sheet_row else None
+        if not sheet_row or not transaction:
+            continue
+        group = row[group_column] if group_column is not None and len(row) > group_column else ""
+        written = split_category(transaction.category) if layout.category_groups else ("", transaction.category or "")
+        payee = redaction.apply(transaction).payee if redaction else transaction.payee
+        if sheet_row.payee == payee and (group, sheet_row.category) == written:
+            continue
+        category = f"{group}{CATEGORY_SEPARATOR}{sheet_row.category}" if group else sheet_row.category
+        edits.append(
+            SheetEdit(
+                row_id=sheet_row.id,
+                payee=transaction.payee if sheet_row.payee == payee else sheet_row.payee,
+                category=category or None,
+                source_payee=transaction.original_payee or transaction.payee,
+            )
+        )
+    return edits
+
+
+def edit_rules(edits: Iterable[SheetEdit]) -> dict[str, Category]:
+    """Mapping rules that categorize the next transactions of an edited payee the way it was edited."""
+    return {
+        edit.source_payee: Category(
+            category=edit.category, name=edit.payee if edit.payee != edit.source_payee else None
+        )
+        for edit in edits
+    }
+
+
+def update_clause(column: str) -> str:
+    if column in EDITED_COLUMNS:
+        return f"{column} = CASE WHEN edited THEN {column} ELSE excluded.{column} END"
+    if column in ENRICHED_COLUMNS:
+        return f"{column} = COALESCE(excluded.{column}, {column})"
+    return f"{column} = excluded.{column}"
+
+
+class Ledger:
+    """
+    The transactions the importer wrote, keyed by the ID written to the sheet.
+
+    Sample usage:
+    ```python
+    with Ledger(path) as ledger:
+        ledger.record(transactions, RowMetadata(run_id=run_id, imported_at=now))
+        entries = ledger.entries()
+    ```
+    """
+
+    conn: sqlite3.Connection
+
+    def __init__(self, path: str) -> None:
+        self.conn = sqlite3.connect(path)
+        self.conn.row_factory = sqlite3.Row
+        version = self.conn.execute("PRAGMA user_version").fetchone()[0]
+        if version > SCHEMA_VERSION:
+            self.conn.close()
+            msg = f"Ledger {path} was written by a newer release (schema {version})"
+            raise ValueError(msg)
+        exists = self.conn.execute("SELECT 1 FROM sqlite_master WHERE name = 'transactions'").fetchone()
+        if exists:
+            for migration_version, migration in MIGRATIONS.items():
+                if version < migration_version:
+                    logger.info("Migrating ledger %s to schema %d", path, migration_version)
+                    _ = self.conn.execute(migration)
+        _ = self.conn.executescript(SCHEMA)
+        _ = self.conn.execute(f"PRAGMA user_version = {SCHEMA_VERSION}")
+
+    def __enter__(self) -> Self:
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_val, exc_tb
+        if exc_type:
+            self.conn.rollback()
+        else:
+            self.conn.commit()
+        self.conn.close()
+
+    def record(self, transactions: Iterable[SimpleFinTransaction], metadata: RowMetadata) -> None:
+        """
+        Adds or updates transactions, those already recorded keep the run that first imported them.
+
+        Transactions edited in the sheet keep the edited payee and category.
+        """
+        updates = ", ".join(update_clause(column) for column in COLUMNS if column not in KEPT_COLUMNS)
+        _ = self.conn.executemany(
+            f"INSERT INTO transactions ({', '.join(COLUMNS)}) VALUES ({', '.join('?' for _ in COLUMNS)})"  # noqa: S608
+            f" ON CONFLICT (row_id) DO UPDATE SET {updates}",
+            [to_record(transaction, metadata) for transaction in transactions],
+        )
+
+    def entries(self) -> list[LedgerEntry]:
+        """Every recorded transaction with the metadata of the run that imported it, newest first."""
+        rows = self.conn.execute("SELECT * FROM transactions ORDER BY transacted_at DESC, id DESC")
+        return [from_record(row) for row in rows]
+
+    def apply_edits(self, edits: Iterable[SheetEdit]) -> int:
+        """
+        Stores payees and categories edited in the sheet, returning how many transactions changed.
+
+        The payee the transaction had first is kept as the original one, which mapping rules are keyed by.
+        """
+        return self.conn.executemany(
+            "UPDATE transactions SET"
+            " original_payee = CASE WHEN payee = :payee THEN original_payee ELSE COALESCE(original_payee, payee) END,"
+            " payee = :payee, category = :category, mapped = 0, edited = 1 WHERE row_id = :row_id",
+            [edit._asdict() for edit in edits],
+        ).rowcount
+
+    def rename_category(self, old: str, new: str) -> int:
+        """Moves the transactions of a category to another, returning how many moved."""
+        return self.conn.execute("UPDATE transactions SET category = ? WHERE category = ?", (new, old)).rowcount
+
+    def delete_transactions(self, row_ids: Iterable[str]) -> int:
+        """Removes transactions by their row ID, returning how many were removed."""
+        return self.conn.executemany(
+            "DELETE FROM transactions WHERE row_id = ?", [(row_id,) for row_id in row_ids]
+        ).rowcount
+
+    def delete_run(self, run_id: str) -> int:
+        """Removes the transactions a run imported, returning how many were removed."""
+        return self.conn.execute("DELETE FROM transactions WHERE run_id = ?", (run_id,)).rowcount
+
+
+def pull_edits(
+    path: str, rows: Iterable[list[str]], layout: SheetLayout, redaction: Redaction | None = None
+) -> list[SheetEdit]:
+    """Stores the edits made to the rows of recorded transactions in the ledger, returning them."""
+    with Ledger(path) as ledger:
+        transactions = {entry.transaction.row_id: entry.transaction for entry in ledger.entries()}
+        edits = find_edits(transactions, rows, layout, redaction)
+        _ = ledger.apply_edits(edits)
+    if edits:
+        logger.info("Pulled %d edits from the sheet into the ledger", len(edits))
+    return edits
+
+
+def record_run(
+    path: str,
+    new_transactions: Sequence[SimpleFinTransaction],
+    existing: Sequence[SimpleFinTransaction],
+    metadata: RowMetadata,
+) -> None:
+    """Records a run's new transactions and the fetched ones the sheet already had, which keep their run."""
+    with Ledger(path) as ledger:
+        ledger.record(existing, RowMetadata())
+        ledger.record(new_transactions, metadata)
+    logger.info("Recorded %d transactions in the ledger", len(new_transactions) + len(existing))
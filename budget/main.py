@@ -1,16 +1,114 @@
 import logging
-from dataclasses import dataclass
-from datetime import UTC, datetime, timedelta
-from functools import cached_property
+from collections import Counter
+from collections.abc import Callable, Iterable, Mapping, Sequence
+from concurrent.futures import ProcessPoolExecutor
+from contextlib import nullcontext
+from dataclasses import dataclass, field
+from datetime import UTC, date, datetime, timedelta
+from decimal import Decimal
+from functools import cache, partial
+from multiprocessing import get_context
+from typing import TYPE_CHECKING, Final
 
+from budget import shutdown
+from budget.accounts import AccountAlias, apply_aliases, validate_aliases
+from budget.alerts import send_alert
+from budget.amortization import add_months, amortize, parse_amortization
+from budget.artifacts import ArtifactEntry, ArtifactFormat, Decision, write_artifact
+from budget.balances import balance_rows
+from budget.budgets import HEADER, budget_status, parse_budgets
+from budget.checkpoint import WriteCheckpoint, settle_writes
+from budget.checksum import CONFLICTS_RANGE_NAME, ChecksumPolicy, Conflict, find_updates, validate_conflict_fields
+from budget.circuit import breaker
+from budget.clients.basiq import BasiqClient, BasiqSource
+from budget.clients.bucket import BucketSource, fetch_bucket
+from budget.clients.fx import FxClient, convert_transactions
 from budget.clients.google import GoogleClient
+from budget.clients.imap import ImapSource, fetch_imap, mark_imported
+from budget.clients.merchants import MerchantApiClient
 from budget.clients.paperless import PaperlessClient
-from budget.clients.simplefin import SimpleFinClient
+from budget.clients.saltedge import SaltEdgeClient, SaltEdgeSource
+from budget.clients.sftp import SftpSource, fetch_sftp, mark_processed
+from budget.clients.simplefin import (
+    WINDOW_DAYS,
+    SimpleFinAccessRevokedError,
+    SimpleFinClaim,
+    SimpleFinClient,
+    SimpleFinFetch,
+    StrictMode,
+    attach_receipts,
+    categorize_transactions,
+    split_access_url,
+)
+from budget.clients.transport import Transport
+from budget.clients.truelayer import TrueLayerSource, fetch_truelayer
+from budget.clients.wise import WiseClient, WiseSource
+from budget.csv_source import CsvSource, fetch_csv_source
+from budget.dedup import DedupKey, assign_keys, cross_source_duplicates
+from budget.drive_source import DriveSource, fetch_drive_source
+from budget.exclusions import ExclusionRule, aggregate_updates, apply_exclusions, apply_min_amount
+from budget.funds import SinkingFund, fund_rows, validate_funds
+from budget.fuzzy import PayeeMatcher
+from budget.holdings import Position, holding_changes, positions
+from budget.household import SimpleFinMember, assign_owner, drop_shared
+from budget.layout import stamp_layout, upgrade_layout
+from budget.learning import LEARN_THRESHOLD, suggest_rules
+from budget.ledger import Ledger, edit_rules, pull_edits, record_run
+from budget.merchants import MerchantDataset, MerchantProvider, enrich_merchants
+from budget.metrics import PUSHGATEWAY_JOB, STATSD_PREFIX, MetricsSettings, export_metrics, validate_statsd_address
+from budget.models.google import (
+    EXTRA_PREFIX,
+    Category,
+    DateField,
+    DateFormat,
+    RowMetadata,
+    SheetLayout,
+    SheetTransaction,
+)
+from budget.models.paperless import Document
+from budget.models.simplefin import AccountPurpose, SimpleFinAccount, SimpleFinTransaction
+from budget.periods import BudgetPeriod, Periods, validate_anchor
+from budget.plugins import Destination, DestinationPlugin, PluginConfig, Source, SourcePlugin
+from budget.protection import IdProtection, protect_ids
+from budget.redaction import REDACT_LENGTH, Redaction, RedactionMode, redact
+from budget.refunds import RefundLink, link_refunds
+from budget.review import ReviewAbortedError, review_transactions
+from budget.rollback import SheetWrite, all_or_nothing, verify_write
+from budget.routing import (
+    MAX_DATE_SHIFT_DAYS,
+    TabRotation,
+    follow_written,
+    is_routed_tab,
+    rotated_tab,
+    rotation_end,
+    route_transactions,
+    validate_template,
+)
+from budget.runs import ProgressCallback, Run, RunStage, RunStatus, RunTrigger, new_run_id, notify, report
+from budget.sentry import capture_error
+from budget.sheet_source import SheetSource, fetch_sheet_source
+from budget.state import ImportState
+from budget.styles import CategoryStyle, apply_styles, category_emoji, validate_styles
+from budget.summary import refresh_charts, refresh_summary
+from budget.transfers import TRANSFER_CATEGORY, TRANSFER_WINDOW_DAYS, CardPayment, categorize_transfers
+from budget.watch import WatchFolder, fetch_watch_folder, move_processed
+
+if TYPE_CHECKING:
+    from budget.wasm import WasmRules
 
 logging.basicConfig(level=logging.INFO, format="%(asctime)s - %(message)s")
 logger = logging.getLogger(__name__)
 logger.setLevel(logging.INFO)
 
+LOOKBACK_DAYS: Final = 2
+WORKERS: Final = 4
+# fewer transactions are categorized in place sooner than worker processes start
+PARALLEL_MIN_TRANSACTIONS: Final = 1000
+
+
+def utc_now() -> datetime:
+    return datetime.now(UTC)
+
 
 @dataclass()
 class Args:
@@ -25,37 +123,1060 @@ class Args:
     sheets_spreadsheet_id: str
     sheets_range_name: str
     mapping_range_name: str
+    # a spreadsheet of its own for the mapping, shared by several budgets
+    mapping_spreadsheet_id: str | None = None
+    interactive: bool = False
+    source_plugins: list[PluginConfig] = field(default_factory=list)
+    destination_plugins: list[PluginConfig] = field(default_factory=list)
+    # sources and destinations of a program embedding the importer, written to like plugins
+    sources: list[Source] = field(default_factory=list)
+    destinations: list[Destination] = field(default_factory=list)
+    # rules used instead of the lookup tab's, which is then not read
+    rules: dict[str, Category] | None = None
+    # the time the run happens at, for the lookback, the tabs and the import metadata
+    clock: Callable[[], datetime] = utc_now
+    # the range a backfill window imports instead of the lookback, later transactions are left for the next,
+    # the last window is open ended
+    window: tuple[datetime, datetime | None] | None = None
+    wasm_rules: str | None = None
+    workers: int = WORKERS
+    lookback_days: int = LOOKBACK_DAYS
+    simplefin_rate_limit: float | None = None
+    simplefin_window_days: int = WINDOW_DAYS
+    simplefin_strict: StrictMode = StrictMode.OFF
+    simplefin_include_pending: bool = True
+    simplefin_setup_token: str | None = None
+    simplefin_claim_file: str | None = None
+    # the household member the main connection belongs to
+    simplefin_owner: str | None = None
+    simplefin_members: list[SimpleFinMember] = field(default_factory=list)
+    simplefin_transport: Transport = Transport()
+    paperless_transport: Transport = Transport()
+    status_column: bool = False
+    alert_webhook_url: str | None = None
+    currency_column: bool = False
+    currency_symbol: str = "$"
+    currency_symbol_after: bool = False
+    fx_base_currency: str | None = None
+    fx_rates: dict[str, Decimal] = field(default_factory=dict)
+    date_format: str = DateFormat.US
+    date_field: str = DateField.TRANSACTED_AT
+    dedup_key: str = DedupKey.ID
+    dedup_cross_source: bool = False
+    checksum_policy: str = ChecksumPolicy.OFF
+    conflict_fields: dict[str, str] = field(default_factory=dict)
+    conflicts_range_name: str = CONFLICTS_RANGE_NAME
+    run_id_column: bool = False
+    import_metadata: bool = False
+    transactional: bool = False
+    tab_rotation: str = TabRotation.NONE
+    account_tab_template: str | None = None
+    unmapped_range_name: str | None = None
+    fuzzy_threshold: float | None = None
+    category_groups: bool = False
+    budget_range_name: str | None = None
+    balances_range_name: str | None = None
+    funds_range_name: str | None = None
+    holdings_range_name: str | None = None
+    summary_range_name: str | None = None
+    summary_charts: bool = False
+    # carry each row's transaction ID as developer metadata, which survives edits to the ID column
+    row_metadata: bool = False
+    id_protection: str = IdProtection.OFF
+    budget_rollover: bool = False
+    budget_period: str = BudgetPeriod.MONTHLY
+    # an ISO date, a payday weekly and biweekly periods are counted from
+    budget_period_anchor: str | None = None
+    exclusions: list[ExclusionRule] = field(default_factory=list)
+    card_payments: list[CardPayment] = field(default_factory=list)
+    transfer_category: str = TRANSFER_CATEGORY
+    transfer_window_days: int = TRANSFER_WINDOW_DAYS
+    accounts: list[AccountAlias] = field(default_factory=list)
+    sinking_funds: list[SinkingFund] = field(default_factory=list)
+    sheet_sources: list[SheetSource] = field(default_factory=list)
+    csv_sources: list[CsvSource] = field(default_factory=list)
+    imap_sources: list[ImapSource] = field(default_factory=list)
+    sftp_sources: list[SftpSource] = field(default_factory=list)
+    bucket_sources: list[BucketSource] = field(default_factory=list)
+    drive_sources: list[DriveSource] = field(default_factory=list)
+    state_file: str | None = None
+    watch_folder: WatchFolder | None = None
+    ledger_file: str | None = None
+    sync_rules: bool = False
+    learn_rules: bool = False
+    learn_threshold: int = LEARN_THRESHOLD
+    category_styles: dict[str, CategoryStyle] = field(default_factory=dict)
+    basiq_sources: list[BasiqSource] = field(default_factory=list)
+    truelayer_sources: list[TrueLayerSource] = field(default_factory=list)
+    saltedge_sources: list[SaltEdgeSource] = field(default_factory=list)
+    wise_sources: list[WiseSource] = field(default_factory=list)
+    min_amount: Decimal | None = None
+    aggregate_small: bool = False
+    # days a refund is looked back for its purchase, off when unset
+    refund_window_days: int | None = None
+    refund_link: str = RefundLink.CATEGORY
+    merchant_columns: bool = False
+    trade_columns: bool = False
+    # categories whose transactions the sheet shows without their details
+    redact_categories: list[str] = field(default_factory=list)
+    redact_mode: str = RedactionMode.HASH
+    redact_length: int = REDACT_LENGTH
+    extra_columns: list[str] = field(default_factory=list)
+    merchants_dataset: str | None = None
+    merchants_api_url: str | None = None
+    merchants_api_token: str | None = None
+    artifacts_dir: str | None = None
+    artifacts_format: str = ArtifactFormat.JSON
+    metrics_file: str | None = None
+    metrics_pushgateway_url: str | None = None
+    metrics_pushgateway_job: str = PUSHGATEWAY_JOB
+    metrics_statsd_address: str | None = None
+    metrics_statsd_prefix: str = STATSD_PREFIX
+
+    @property
+    def metrics(self) -> MetricsSettings:
+        return MetricsSettings(
+            file=self.metrics_file,
+            pushgateway_url=self.metrics_pushgateway_url,
+            pushgateway_job=self.metrics_pushgateway_job,
+            statsd_address=self.metrics_statsd_address,
+            statsd_prefix=self.metrics_statsd_prefix,
+        )
 
-    @cached_property
+    @property
     def start_date(self) -> datetime:
-        return datetime.now(UTC) - timedelta(days=2)
+        if self.window:
+            return self.window[0]
+        return self.clock() - timedelta(days=self.lookback_days)
+
+    @property
+    def end_date(self) -> datetime | None:
+        return self.window[1] if self.window else None
+
+    @property
+    def current_tab(self) -> str:
+        """The tab today's transactions go to."""
+        return rotated_tab(self.sheets_range_name, TabRotation(self.tab_rotation), self.clock().date())
+
+    @property
+    def auxiliary_tabs(self) -> set[str]:
+        """The tabs of the other ranges the import reads and writes, which never hold transactions."""
+        return {
+            name
+            for name in (
+                self.mapping_range_name,
+                self.conflicts_range_name,
+                self.unmapped_range_name,
+                self.budget_range_name,
+                self.balances_range_name,
+                self.funds_range_name,
+                self.holdings_range_name,
+                self.summary_range_name,
+            )
+            if name
+        }
+
+    def transaction_tabs(self, titles: Iterable[str]) -> list[str]:
+        """The tabs among the titles that routing writes transactions to, rotated and per-account tabs included."""
+        return [
+            title
+            for title in titles
+            if is_routed_tab(
+                title,
+                self.sheets_range_name,
+                TabRotation(self.tab_rotation),
+                self.account_tab_template,
+                self.auxiliary_tabs,
+            )
+        ]
+
+    @property
+    def periods(self) -> Periods:
+        anchor = date.fromisoformat(self.budget_period_anchor) if self.budget_period_anchor else None
+        return Periods(BudgetPeriod(self.budget_period), anchor)
+
+    @property
+    def mapping_spreadsheet(self) -> str:
+        """The spreadsheet the mapping tab is in, the transactions spreadsheet unless a shared one is set."""
+        return self.mapping_spreadsheet_id or self.sheets_spreadsheet_id
+
+    def route(self, transactions: Sequence[SimpleFinTransaction]) -> dict[str, list[SimpleFinTransaction]]:
+        return route_transactions(
+            transactions,
+            self.sheets_range_name,
+            TabRotation(self.tab_rotation),
+            DateField(self.date_field),
+            self.account_tab_template,
+        )
+
+    @property
+    def redaction(self) -> Redaction | None:
+        if not self.redact_categories:
+            return None
+        return Redaction(frozenset(self.redact_categories), RedactionMode(self.redact_mode), self.redact_length)
+
+    @property
+    def layout(self) -> SheetLayout:
+        return SheetLayout(
+            currency=self.currency_column,
+            currency_symbol=self.currency_symbol,
+            currency_symbol_after=self.currency_symbol_after,
+            original_amount=bool(self.fx_base_currency),
+            date_format=DateFormat(self.date_format),
+            date_field=DateField(self.date_field),
+            checksum=self.checksum_policy != ChecksumPolicy.OFF,
+            run_id=self.run_id_column,
+            metadata=self.import_metadata,
+            category_groups=self.category_groups,
+            status=self.status_column,
+            owner=bool(self.simplefin_owner or self.simplefin_members or any(alias.owner for alias in self.accounts)),
+            purpose=any(alias.purpose != AccountPurpose.PERSONAL for alias in self.accounts),
+            merchant=self.merchant_columns,
+            trades=self.trade_columns,
+            refund_of=bool(self.refund_window_days) and self.refund_link == RefundLink.COLUMN,
+            extra=tuple(column.removeprefix(EXTRA_PREFIX) for column in self.extra_columns),
+            category_emoji=category_emoji(self.category_styles),
+        )
 
     def __post_init__(self) -> None:
         errors: list[str] = []
         if not any((self.simplefin_username, self.simplefin_password, self.simplefin_access_url)):
             errors.append("SimpleFin credentials are required")
+        if self.simplefin_setup_token and not self.simplefin_claim_file:
+            errors.append("A SimpleFin setup token requires a claim file to store the claimed access URL")
+        if (self.bucket_sources or self.drive_sources) and not self.state_file:
+            errors.append("Bucket and Drive sources require a state file to remember the imported files")
+        if error := validate_conflict_fields(self.conflict_fields):
+            errors.append(error)
+        if self.sync_rules and not self.ledger_file:
+            errors.append("Syncing sheet edits into the rules requires a ledger file")
+        invalid = [
+            column
+            for column in self.extra_columns
+            if not column.startswith(EXTRA_PREFIX) or not column.removeprefix(EXTRA_PREFIX)
+        ]
+        if invalid:
+            errors.append(f"Extra columns must name a key as {EXTRA_PREFIX}<key>, got {', '.join(invalid)}")
+        if self.merchant_columns and not (self.merchants_dataset or self.merchants_api_url):
+            errors.append("Merchant columns require a merchant dataset or lookup API")
+        if self.learn_rules and not self.ledger_file:
+            errors.append("Learning rules from sheet edits requires a ledger file")
+        if self.lookback_days < 1:
+            errors.append(f"Lookback days must be at least 1, got {self.lookback_days}")
+        if self.simplefin_window_days < 1:
+            errors.append(f"SimpleFin window days must be at least 1, got {self.simplefin_window_days}")
+        if error := self.simplefin_transport.validate("SimpleFin"):
+            errors.append(error)
+        if error := self.paperless_transport.validate("Paperless"):
+            errors.append(error)
+        if self.artifacts_format not in set(ArtifactFormat):
+            errors.append(f"Artifacts format must be one of {', '.join(ArtifactFormat)}")
+        if error := validate_statsd_address(self.metrics_statsd_address):
+            errors.append(error)
+        if self.learn_threshold < 1:
+            errors.append(f"Learn threshold must be at least 1, got {self.learn_threshold}")
         if not any((self.paperless_url, self.paperless_token)):
             errors.append("Paperless credentials are required")
         if not any((self.google_credentials, self.sheets_spreadsheet_id)):
             errors.append("Google credentials are required")
 
+        if self.workers < 1:
+            errors.append("Workers must be at least 1")
+        if self.date_format not in set(DateFormat):
+            errors.append(f"Date format must be one of {', '.join(DateFormat)}")
+        if self.date_field not in set(DateField):
+            errors.append(f"Date field must be one of {', '.join(DateField)}")
+        if self.dedup_key not in set(DedupKey):
+            errors.append(f"Dedup key must be one of {', '.join(DedupKey)}")
+        if self.fuzzy_threshold is not None and not 0 < self.fuzzy_threshold <= 1:
+            errors.append(f"Fuzzy threshold must be between 0 and 1, got {self.fuzzy_threshold}")
+        if self.min_amount is not None and self.min_amount < 0:
+            errors.append(f"Minimum amount must not be negative, got {self.min_amount}")
+        if self.transfer_window_days < 0:
+            errors.append(f"Transfer window days must not be negative, got {self.transfer_window_days}")
+        if not self.transfer_category.strip():
+            errors.append("The transfer category of card payments and tagged transfers must not be empty")
+        if self.refund_window_days is not None and self.refund_window_days < 1:
+            errors.append(f"Refund window days must be at least 1, got {self.refund_window_days}")
+        if self.refund_link not in set(RefundLink):
+            errors.append(f"Refund link must be one of {', '.join(RefundLink)}")
+        if self.redact_mode not in set(RedactionMode):
+            errors.append(f"Redact mode must be one of {', '.join(RedactionMode)}")
+        if self.redact_length < 1:
+            errors.append(f"Redact length must be at least 1, got {self.redact_length}")
+        if self.tab_rotation not in set(TabRotation):
+            errors.append(f"Tab rotation must be one of {', '.join(TabRotation)}")
+        if self.account_tab_template and (error := validate_template(self.account_tab_template)):
+            errors.append(error)
+        if self.summary_range_name and (self.tab_rotation != TabRotation.NONE or self.account_tab_template):
+            errors.append("The summary tab reads a single transactions tab, it can't be used with rotated tabs")
+        if self.summary_charts and not self.summary_range_name:
+            errors.append("Summary charts require a summary tab to be placed on")
+        if error := validate_aliases(self.accounts):
+            errors.append(error)
+        if error := validate_funds(self.sinking_funds):
+            errors.append(error)
+        if self.transactional and not (self.run_id_column or self.import_metadata):
+            errors.append("Transactional imports find their rows by the run ID column, enable --run-id-column")
+        if self.holdings_range_name and not self.state_file:
+            errors.append("Tracking holdings requires a state file to keep the last run's snapshot")
+        if self.sinking_funds and not self.funds_range_name:
+            errors.append("Sinking funds require a funds tab to track their balances on")
+        if error := validate_styles(self.category_styles):
+            errors.append(error)
+        if self.budget_period not in set(BudgetPeriod):
+            errors.append(f"Budget period must be one of {', '.join(BudgetPeriod)}")
+        if error := validate_anchor(self.budget_period_anchor):
+            errors.append(error)
+        elif self.budget_period == BudgetPeriod.BIWEEKLY and not self.budget_period_anchor:
+            errors.append("Biweekly budget periods require an anchor, a payday to count the periods from")
+        if self.id_protection not in set(IdProtection):
+            errors.append(f"ID protection must be one of {', '.join(IdProtection)}")
+        if self.checksum_policy not in set(ChecksumPolicy):
+            errors.append(f"Checksum policy must be one of {', '.join(ChecksumPolicy)}")
+        errors.extend(
+            f"Exchange rate for {currency} must be positive" for currency, rate in self.fx_rates.items() if rate <= 0
+        )
+
         if errors:
             msg = f"Missing CLI Args \n{'\n'.join(errors)}"
             raise Args.Error(msg)
 
 
-def main(args: Args) -> None:
+class CurrencyError(Exception): ...
+
+
+def sheet_transactions(args: Args, google: GoogleClient, since: date | None = None) -> list[SheetTransaction]:
+    """
+    The transactions of every tab routing writes to, the rotated and per-account ones included.
+
+    With a start, the rotated tabs of periods that ended before it are skipped, tabs without a rotation
+    suffix are always read.
+    """
+    rotation = TabRotation(args.tab_rotation)
+    tabs = [
+        tab
+        for tab in args.transaction_tabs(google.worksheet_titles(args.sheets_spreadsheet_id))
+        if since is None or (end := rotation_end(tab, rotation)) is None or end >= since
+    ]
+    return [transaction for tab in tabs for transaction in google.get_transactions(args.sheets_spreadsheet_id, tab)]
+
+
+def update_budget_status(args: Args, google: GoogleClient) -> None:
+    """Writes what is left of each category's budget this period to the budget status tab."""
+    if not args.budget_range_name:
+        return
+    with breaker("google").guard():
+        rules = google.get_rows(args.mapping_spreadsheet, args.mapping_range_name)
+        budgets = parse_budgets(rules, groups=args.category_groups)
+        if not budgets:
+            logger.info("No budgets in the lookup sheet, skipping the budget status")
+            return
+        today = args.clock().date()
+        months = parse_amortization(rules)
+        # rollover carries every earlier period of the year, amortized bills reach back as many months as they span
+        start = args.periods.start(date(today.year, 1, 1) if args.budget_rollover else today)
+        since = add_months(start, 1 - max(months.values(), default=1))
+        transactions = amortize(sheet_transactions(args, google, since), months)
+        statuses = budget_status(budgets, transactions, today, rollover=args.budget_rollover, periods=args.periods)
+        google.replace_rows(
+            args.sheets_spreadsheet_id, args.budget_range_name, [HEADER, *(status.to_row() for status in statuses)]
+        )
+
+
+def update_funds(args: Args, google: GoogleClient) -> None:
+    """Writes what each sinking fund accrued, spent and has left to the funds tab."""
+    if not args.sinking_funds or not args.funds_range_name:
+        return
+    with breaker("google").guard():
+        transactions = sheet_transactions(args, google)
+        rows = fund_rows(args.sinking_funds, transactions, args.clock().date(), groups=args.category_groups)
+        google.replace_rows(args.sheets_spreadsheet_id, args.funds_range_name, rows)
+    logger.info("Updated the balances of %d sinking funds", len(args.sinking_funds))
+
+
+def track_holdings(args: Args, google: GoogleClient, accounts: Sequence[SimpleFinAccount]) -> None:
+    """Appends what changed in the holdings since the last run to the activity tab and keeps the new snapshot."""
+    if not args.holdings_range_name or not args.state_file:
+        return
+    state = ImportState(args.state_file)
+    current = positions(accounts)
+    if state.holdings:
+        previous = {key: Position.from_dict(position) for key, position in state.holdings.items()}
+        day = args.clock().date().isoformat()
+        if rows := [change.to_row(day) for change in holding_changes(previous, current)]:
+            with breaker("google").guard():
+                google.append_rows(args.sheets_spreadsheet_id, args.holdings_range_name, rows)
+            logger.info("Recorded %d holding changes to %s", len(rows), args.holdings_range_name)
+    state.record_holdings({key: position.to_dict() for key, position in current.items()})
+
+
+def save_mapping_rule(args: Args, google: GoogleClient, payee: str, rule: Category) -> None:
+    with breaker("google").guard():
+        google.update_category_mapping(args.mapping_spreadsheet, args.mapping_range_name, {payee: rule})
+
+
+def pull_sheet_edits(args: Args, google: GoogleClient, rows: Iterable[list[str]]) -> int:
+    """Pulls payees and categories edited in the sheet into the ledger, and the mapping with sync_rules."""
+    edits = pull_edits(args.ledger_file or "", rows, args.layout, args.redaction)
+    if args.sync_rules and edits:
+        with breaker("google").guard():
+            google.update_category_mapping(args.mapping_spreadsheet, args.mapping_range_name, edit_rules(edits))
+    elif edits:
+        learn_rules(args, google)
+    return len(edits)
+
+
+def learn_rules(args: Args, google: GoogleClient) -> None:
+    """Suggests rules for payees repeatedly recategorized in the sheet, saving them with learn_rules."""
+    with Ledger(args.ledger_file or "") as ledger:
+        entries = ledger.entries()
+    with breaker("google").guard():
+        _, mapping = google.get_category_mapping(args.mapping_spreadsheet, args.mapping_range_name)
+    suggestions = suggest_rules(entries, mapping, args.learn_threshold)
+    for suggestion in suggestions:
+        logger.info(
+            "%s was recategorized to %s %d times, %s a mapping rule",
+            suggestion.payee,
+            suggestion.rule.category,
+            suggestion.count,
+            "adding" if args.learn_rules else "consider adding",
+        )
+    if args.learn_rules and suggestions:
+        rules = {suggestion.payee: suggestion.rule for suggestion in suggestions}
+        with breaker("google").guard():
+            google.update_category_mapping(args.mapping_spreadsheet, args.mapping_range_name, rules)
+
+
+def record_conflicts(args: Args, google: GoogleClient, conflicts: Mapping[str, Sequence[Conflict]]) -> None:
+    """
+    Lists the fields of rows edited in the sheet that changed upstream too in the conflicts tab.
+
+    Each field is listed once, the row is flagged on every run until it's edited to match the source.
+    """
+    with breaker("google").guard():
+        listed = {
+            tuple(row[1:4])
+            for row in google.get_rows(args.sheets_spreadsheet_id, args.conflicts_range_name, missing_ok=True)
+        }
+    detected = args.clock().date().isoformat()
+    new_rows = [
+        [detected, tab, conflict.row_id, conflict.field, conflict.sheet, conflict.source]
+        for tab, tab_conflicts in conflicts.items()
+        for conflict in tab_conflicts
+    ]
+    new_rows = [row for row in new_rows if tuple(row[1:4]) not in listed]
+    if new_rows:
+        logger.warning("Flagged %d conflicting fields for review in %s", len(new_rows), args.conflicts_range_name)
+        with breaker("google").guard():
+            google.append_rows(args.sheets_spreadsheet_id, args.conflicts_range_name, new_rows)
+
+
+def tag_source(accounts: Sequence[SimpleFinAccount], source: str) -> None:
+    for account in accounts:
+        for transaction in account.transactions:
+            transaction.source = source
+
+
+def convert_currencies(args: Args, transactions: Sequence[SimpleFinTransaction]) -> None:
+    """Converts transactions into the base currency, rates not set in the config are looked up."""
+    if not args.fx_base_currency:
+        return
+    rates = dict(args.fx_rates)
+    missing = {t.currency for t in transactions if t.currency and t.currency not in {args.fx_base_currency, *rates}}
+    if missing:
+        with breaker("fx").guard(), FxClient() as fx:
+            rates |= fx.latest_rates(args.fx_base_currency, missing)
+    convert_transactions(transactions, args.fx_base_currency, rates)
+
+
+def enrich_transactions(args: Args, transactions: Sequence[SimpleFinTransaction]) -> None:
+    """Resolves the merchants of transactions for the merchant columns, the local dataset before the API."""
+    if not args.merchant_columns or not transactions:
+        return
+    providers: list[MerchantProvider] = []
+    if args.merchants_dataset:
+        providers.append(MerchantDataset.from_file(args.merchants_dataset))
+    if not args.merchants_api_url:
+        _ = enrich_merchants(transactions, providers)
+        return
+    with breaker("merchants").guard(), MerchantApiClient(args.merchants_api_url, args.merchants_api_token) as api:
+        _ = enrich_merchants(transactions, [*providers, api])
+
+
+def check_currencies(args: Args, transactions: Sequence[SimpleFinTransaction]) -> None:
+    """Rejects mixing currencies in one sheet unless the currency column tells them apart."""
+    currencies = sorted({transaction.currency for transaction in transactions if transaction.currency})
+    if len(currencies) > 1 and not args.layout.currency:
+        msg = f"Transactions are in several currencies ({', '.join(currencies)}), enable the currency column"
+        raise CurrencyError(msg)
+
+
+def simplefin_client(args: Args) -> SimpleFinClient:
+    """
+    A client for the configured access URL, or for the one claimed from the setup token in an earlier run.
+
+    The setup token is only passed on while it hasn't been claimed, a token can't be claimed twice. A claimed
+    access URL is stored as soon as the bridge returns it, so a run failing after the claim doesn't lose it.
+    """
+    access_url, username, password = args.simplefin_access_url, args.simplefin_username, args.simplefin_password
+    setup_token = args.simplefin_setup_token
+    if args.simplefin_claim_file and (claim := SimpleFinClaim.load(args.simplefin_claim_file)):
+        access_url, username, password = split_access_url(claim.access_url)
+        if setup_token and SimpleFinClaim.digest(setup_token) == claim.token_digest:
+            setup_token = None
+    return SimpleFinClient(
+        access_url,
+        username,
+        password,
+        args.simplefin_rate_limit,
+        args.simplefin_strict,
+        include_pending=args.simplefin_include_pending,
+        setup_token=setup_token,
+        window_days=args.simplefin_window_days,
+        transport=args.simplefin_transport,
+        on_claim=partial(store_claim, args) if args.simplefin_claim_file and setup_token else None,
+    )
+
+
+def store_claim(args: Args, access_url: str) -> None:
+    """Stores the access URL claimed from the setup token and alerts, the next runs use it."""
+    if args.simplefin_claim_file and args.simplefin_setup_token:
+        digest = SimpleFinClaim.digest(args.simplefin_setup_token)
+        SimpleFinClaim(access_url, digest).save(args.simplefin_claim_file)
+    send_alert("SimpleFin access was revoked, a new access URL was claimed from the setup token")
+
+
+def drop_after(accounts: Sequence[SimpleFinAccount], end_date: datetime) -> None:
+    """Drops the transactions posted on or after the end date, sources other than SimpleFin fetch up to now."""
+    for account in accounts:
+        account.transactions = [transaction for transaction in account.transactions if transaction.posted < end_date]
+
+
+def fetch_simplefin(args: Args, simplefin: SimpleFinClient) -> SimpleFinFetch:
+    """
+    Fetches the SimpleFin accounts, alerting once when the access was revoked and couldn't be claimed again.
+
+    The validators of the last fetch are read from the state file, the run saves the new ones once it's done.
+    """
+    validators = ImportState(args.state_file).validators if args.state_file else None
+    try:
+        with breaker("simplefin").guard():
+            return simplefin.fetch_data(args.start_date, args.end_date, validators=validators)
+    except SimpleFinAccessRevokedError as e:
+        send_alert(str(e), once=True)
+        raise
+
+
+def fetch_members(args: Args, seen: set[str]) -> list[SimpleFinAccount]:
+    """Fetches the accounts of the household members' SimpleFin connections, skipping those already fetched."""
+    accounts: list[SimpleFinAccount] = []
+    for member in args.simplefin_members:
+        url, username, password = split_access_url(member.access_url)
+        with (
+            SimpleFinClient(
+                url,
+                username,
+                password,
+                args.simplefin_rate_limit,
+                args.simplefin_strict,
+                include_pending=args.simplefin_include_pending,
+                window_days=args.simplefin_window_days,
+                transport=args.simplefin_transport,
+            ) as client,
+            breaker("simplefin").guard(),
+        ):
+            fetched = client.fetch_data(args.start_date, args.end_date)
+        member_accounts = drop_shared(fetched.accounts, seen)
+        for notice in fetched.notices:
+            send_alert(f"SimpleFin ({member.owner}): {notice}", once=True)
+        assign_owner(member_accounts, member.owner)
+        accounts.extend(member_accounts)
+    return accounts
+
+
+def fetch_bank_sources(args: Args, progress: ProgressCallback | None) -> list[SimpleFinAccount]:
+    """Fetches the accounts of the bank API sources, their notices are reported like SimpleFin's."""
+    accounts: list[SimpleFinAccount] = []
+    notices: list[str] = []
+    for source in args.basiq_sources:
+        with breaker("basiq").guard(), BasiqClient(source.api_key) as basiq:
+            source_accounts = basiq.fetch_data(source, args.start_date)
+        notices.extend(basiq.notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+    for source in args.truelayer_sources:
+        with breaker("truelayer").guard():
+            source_accounts = fetch_truelayer(source, args.start_date, notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+    for source in args.saltedge_sources:
+        with breaker("saltedge").guard(), SaltEdgeClient(source.app_id, source.secret) as saltedge:
+            source_accounts = saltedge.fetch_data(source, args.start_date)
+        notices.extend(saltedge.notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+    for source in args.wise_sources:
+        with breaker("wise").guard(), WiseClient(source.api_token, source.url) as wise:
+            source_accounts = wise.fetch_data(source, args.start_date)
+        notices.extend(wise.notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+    report_notices(progress, notices)
+    return accounts
+
+
+def fetch_statement_sources(
+    args: Args, google: GoogleClient, progress: ProgressCallback | None, *, dry_run: bool = False
+) -> tuple[list[SimpleFinAccount], list[Callable[[], None]]]:
+    """
+    Fetches the accounts of the statement file sources, with the steps that mark their files as imported.
+
+    The steps run once the transactions are written, so a failed run reads the same files again. A dry
+    run leaves every file where it is.
+    Statements are imported whole, their transactions aren't limited to the start date.
+    """
+    accounts: list[SimpleFinAccount] = []
+    commits: list[Callable[[], None]] = []
+    notices: list[str] = []
+    for source in args.imap_sources:
+        with breaker("imap").guard():
+            source_accounts, uids = fetch_imap(source, notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+        commits.append(partial(mark_imported, source, uids))
+    for source in args.sftp_sources:
+        with breaker("sftp").guard():
+            source_accounts, paths = fetch_sftp(source, notices)
+        tag_source(source_accounts, source.name)
+        accounts.extend(source_accounts)
+        commits.append(partial(mark_processed, source, paths))
+    if args.state_file:
+        state = ImportState(args.state_file)
+        for source in args.bucket_sources:
+            with breaker("bucket").guard():
+                source_accounts, keys = fetch_bucket(source, state, notices)
+            tag_source(source_accounts, source.name)
+            accounts.extend(source_accounts)
+            commits.append(partial(state.record, source.state_key, keys))
+        for source in args.drive_sources:
+            with breaker("google").guard():
+                source_accounts, file_ids = fetch_drive_source(google, source, state, notices)
+            tag_source(source_accounts, source.name)
+            accounts.extend(source_accounts)
+            commits.append(partial(state.record, source.state_key, file_ids))
+    if args.watch_folder:
+        folder_accounts, files = fetch_watch_folder(args.watch_folder, notices, dry_run=dry_run)
+        tag_source(folder_accounts, args.watch_folder.name)
+        accounts.extend(folder_accounts)
+        commits.append(partial(move_processed, args.watch_folder, files))
+    report_notices(progress, notices)
+    return accounts, commits
+
+
+def neighbor_tabs(args: Args, google: GoogleClient, tabs: Iterable[str]) -> list[str]:
+    """
+    The other rotated tabs a transaction of the lookback may already be in, its date moved across a boundary.
+
+    Reads the tabs of the periods reaching into the lookback, or a little before it, for dates moved forward.
+    """
+    rotation = TabRotation(args.tab_rotation)
+    if rotation == TabRotation.NONE:
+        return []
+    since = args.start_date.date() - timedelta(days=MAX_DATE_SHIFT_DAYS)
+    titles = args.transaction_tabs(google.worksheet_titles(args.sheets_spreadsheet_id))
+    return [
+        title
+        for title in titles
+        if title not in tabs and (end := rotation_end(title, rotation)) is not None and end >= since
+    ]
+
+
+def restore_ids(rows: list[list[str]], ids: Mapping[int, str]) -> list[list[str]]:
+    """The rows with the IDs their developer metadata carries, in place of ID cells edited or cleared by hand."""
+    return [
+        [ids[index], *row[1:]] if index in ids and row[:1] != [ids[index]] else row
+        for index, row in enumerate(rows, start=1)
+    ]
+
+
+def drop_cross_source_duplicates(
+    args: Args,
+    accounts: Sequence[SimpleFinAccount],
+    tabs: dict[str, list[SimpleFinTransaction]],
+    rows: dict[str, list[list[str]]],
+    new_transactions: list[SimpleFinTransaction],
+) -> list[SimpleFinTransaction]:
+    """Drops the new transactions another source already brought, sources fetched first win."""
+    sources = list(dict.fromkeys(t.source or "" for account in accounts for t in account.transactions))
+    new_ids = {transaction.row_id for transaction in new_transactions}
+    duplicates: set[int] = set()
+    for tab, tab_transactions in tabs.items():
+        sheet_rows = [sheet_row for row in rows[tab] if (sheet_row := SheetTransaction.from_row(row))]
+        duplicates.update(
+            id(transaction)
+            for transaction in cross_source_duplicates(
+                tab_transactions, sheet_rows, new_ids, sources, DateField(args.date_field)
+            )
+        )
+    return [transaction for transaction in new_transactions if id(transaction) not in duplicates]
+
+
+def report_notices(progress: ProgressCallback | None, notices: Sequence[str]) -> None:
+    for notice in notices:
+        logger.warning(notice)
+        notify(progress, notice)
+        send_alert(notice, once=True)
+
+
+@cache
+def load_wasm_rules(path: str) -> "WasmRules":
+    """The compiled rules, compiled once per process rather than for each account."""
+    from budget.wasm import WasmRules  # noqa: PLC0415 - optional dependency
+
+    return WasmRules(path)
+
+
+def process_account(
+    account: SimpleFinAccount,
+    documents: Sequence[Document],
+    mapping: dict[str, Category],
+    fuzzy_threshold: float | None,
+    wasm_rules: str | None,
+) -> list[SimpleFinTransaction]:
+    """Attaches receipts and categorizes the transactions of an account, run in the worker processes."""
+    transactions = attach_receipts([account], documents)
+    matcher = PayeeMatcher(mapping, fuzzy_threshold) if fuzzy_threshold else None
+    categorize_transactions(transactions, mapping, matcher)
+    if wasm_rules:
+        load_wasm_rules(wasm_rules).apply(transactions)
+    return transactions
+
+
+def process_accounts(
+    args: Args,
+    accounts: Sequence[SimpleFinAccount],
+    documents: Sequence[Document],
+    mapping: dict[str, Category],
+) -> list[SimpleFinTransaction]:
+    """
+    Attaches receipts and categorizes each account's transactions on a bounded pool of worker processes.
+
+    Matching payees and running the rules is CPU bound and holds the GIL, so the accounts are spread over
+    processes rather than threads. The workers are spawned, a forked worker would inherit the locks held by
+    the daemon's threads, and they return categorized copies of the transactions. A daily run's few dozen
+    transactions, like a single account or worker, are processed in place, starting the processes would cost
+    more than it saves. The merged result is sorted newest first with the ID as a tie breaker, so the output
+    doesn't depend on which account finished first.
+    """
+    process = partial(
+        process_account,
+        documents=documents,
+        mapping=mapping,
+        fuzzy_threshold=args.fuzzy_threshold,
+        wasm_rules=args.wasm_rules,
+    )
+    # the rules may have been edited since the daemon's last run, the worker processes are new each run
+    load_wasm_rules.cache_clear()
+    workers = min(args.workers, len(accounts))
+    if workers > 1 and sum(len(account.transactions) for account in accounts) >= PARALLEL_MIN_TRANSACTIONS:
+        with ProcessPoolExecutor(max_workers=workers, mp_context=get_context("spawn")) as executor:
+            transactions = [transaction for result in executor.map(process, accounts) for transaction in result]
+    else:
+        transactions = [transaction for account in accounts for transaction in process(account)]
+
+    transactions.sort(key=lambda t: (t.transacted_at, t.id), reverse=True)
+    logger.info("Processed %d transactions across %d accounts", len(transactions), len(accounts))
+    return transactions
+
+
+def record_artifact(
+    args: Args,
+    run_id: str,
+    tabs: Mapping[str, Sequence[SimpleFinTransaction]],
+    existing_ids: Mapping[str, set[str]],
+    deduplicated: Sequence[SimpleFinTransaction],
+    new_transactions: Sequence[SimpleFinTransaction],
+    updated_ids: Mapping[str, set[str]] | None = None,
+) -> None:
+    """
+    Writes the run's artifact when an artifacts folder is set, a failure to write it is logged.
+
+    Deduplicated are the transactions left after deduplication, those missing from the new
+    transactions were skipped in the review.
+    """
+    if not args.artifacts_dir:
+        return
+    new, kept = {id(transaction) for transaction in new_transactions}, {id(transaction) for transaction in deduplicated}
+    entries: list[ArtifactEntry] = []
+    for tab, tab_transactions in tabs.items():
+        for transaction in tab_transactions:
+            if transaction.row_id in existing_ids[tab]:
+                updated = transaction.row_id in (updated_ids or {}).get(tab, set())
+                decision = Decision.UPDATED if updated else Decision.EXISTING
+            elif id(transaction) in new:
+                decision = Decision.NEW
+            elif id(transaction) in kept:
+                decision = Decision.SKIPPED
+            else:
+                decision = Decision.DUPLICATE
+            entries.append(ArtifactEntry(tab, transaction, decision))
+    try:
+        _ = write_artifact(args.artifacts_dir, run_id, entries, args.layout, ArtifactFormat(args.artifacts_format))
+    except OSError:
+        logger.exception("Failed to write the artifact of run %s", run_id)
+
+
+def main(
+    args: Args,
+    progress: ProgressCallback | None = None,
+    *,
+    dry_run: bool = False,
+    run_id: str | None = None,
+    simplefin: SimpleFinClient | None = None,
+) -> list[SimpleFinTransaction]:
+    """
+    Imports new transactions into the Google Sheet and returns them.
+
+    A long-running caller passes the SimpleFin client it keeps, otherwise the run makes one.
+    Progress events are reported to the optional callback as each stage completes.
+    With dry_run the new transactions are fetched and categorized but not written.
+    Rows are tagged with the run ID when the run ID column is enabled, so the run can be undone.
+    Transactional runs verify their rows and roll the sheet back when writing to it fails.
+    """
+    run_id = run_id or new_run_id()
     with (
-        PaperlessClient(args.paperless_url, args.paperless_token) as paperless,
-        SimpleFinClient(args.simplefin_access_url, args.simplefin_username, args.simplefin_password) as simplefin,
+        PaperlessClient(args.paperless_url, args.paperless_token, args.paperless_transport) as paperless,
+        simplefin or simplefin_client(args) as simplefin,
         GoogleClient(args.google_credentials) as google,
     ):
-        _, mapping = google.get_category_mapping(args.sheets_spreadsheet_id, args.mapping_range_name)
+        if args.rules is not None:
+            mapping = dict(args.rules)
+            categories = {rule.category for rule in mapping.values() if rule.category}
+        else:
+            with breaker("google").guard():
+                categories, mapping = google.get_category_mapping(args.mapping_spreadsheet, args.mapping_range_name)
+
+        with breaker("paperless").guard():
+            documents = paperless.fetch_documents()
+        report(progress, RunStage.FETCHED_DOCUMENTS, len(documents))
+        shutdown.check()
+        fetched = fetch_simplefin(args, simplefin)
+        accounts = fetched.accounts
+        assign_owner(accounts, args.simplefin_owner)
+        accounts.extend(fetch_members(args, {account.id for account in accounts}))
+        tag_source(accounts, "simplefin")
+        for notice in fetched.notices:
+            notify(progress, notice)
+            send_alert(f"SimpleFin: {notice}", once=True)
+        for source in [*map(SourcePlugin, args.source_plugins), *args.sources]:
+            source_accounts = source.fetch_data(args.start_date)
+            tag_source(source_accounts, source.name)
+            accounts.extend(source_accounts)
+        accounts.extend(fetch_bank_sources(args, progress))
+        for source in args.sheet_sources:
+            with breaker("google").guard():
+                sheet_account = fetch_sheet_source(google, source, args.start_date)
+            tag_source([sheet_account], f"sheet:{source.name}")
+            accounts.append(sheet_account)
+        for source in args.csv_sources:
+            csv_account = fetch_csv_source(source, args.start_date)
+            tag_source([csv_account], f"csv:{source.name}")
+            accounts.append(csv_account)
+        if args.end_date:
+            drop_after(accounts, args.end_date)
+        statement_accounts, commits = fetch_statement_sources(args, google, progress, dry_run=dry_run)
+        accounts.extend(statement_accounts)
+        shutdown.check()
+        report(progress, RunStage.FETCHED_ACCOUNTS, len(accounts))
+        if fetched.not_modified and not accounts:
+            logger.info("Run %s found nothing new, SimpleFin data is unchanged and no other source has any", run_id)
+            return []
+        apply_aliases(accounts, args.accounts)
+        apply_exclusions(accounts, args.exclusions)
+        apply_min_amount(accounts, args.min_amount, aggregate=args.aggregate_small)
+        assign_keys(accounts, DedupKey(args.dedup_key))
+
+        transactions = process_accounts(args, accounts, documents, mapping)
+        _ = categorize_transfers(transactions, args.card_payments, args.transfer_category, args.transfer_window_days)
+        report(progress, RunStage.CATEGORIZED, len(transactions))
+        shutdown.check()
+        convert_currencies(args, transactions)
+        check_currencies(args, transactions)
+
+        # each tab is deduplicated on its own, rotated tabs that don't exist yet have no rows, and transactions
+        # already in the tab of a neighboring period stay there
+        tabs = args.route(transactions)
+        with breaker("google").guard():
+            rows = {
+                tab: google.get_rows(args.sheets_spreadsheet_id, tab, missing_ok=tab != args.sheets_range_name)
+                for tab in [*tabs, *neighbor_tabs(args, google, tabs)]
+            }
+            # tabs written with other columns are migrated before their rows are compared or appended to
+            rows = {
+                tab: upgrade_layout(google, args.sheets_spreadsheet_id, tab, tab_rows, args.layout, dry_run=dry_run)
+                for tab, tab_rows in rows.items()
+            }
+            # tabs without rows might not exist yet, there is no metadata to read then
+            for tab, tab_rows in rows.items() if args.row_metadata else ():
+                if tab_rows:
+                    rows[tab] = restore_ids(tab_rows, google.row_ids(args.sheets_spreadsheet_id, tab))
+        if args.refund_window_days:
+            all_rows = (row for tab_rows in rows.values() for row in tab_rows)
+            _ = link_refunds(transactions, all_rows, args.layout, args.refund_window_days, RefundLink(args.refund_link))
+        existing_ids = {tab: {row[0] for row in tab_rows if row} for tab, tab_rows in rows.items()}
+        # what an interrupted run appended counts as in the sheet, whether or not reading it back found it
+        for tab, landed in settle_writes(args.state_file, rows, args.layout).items():
+            existing_ids.setdefault(tab, set()).update(landed)
+        tabs = follow_written(tabs, {tab: existing_ids[tab] for tab in rows})
+        new_transactions = [
+            transaction
+            for tab, tab_transactions in tabs.items()
+            for transaction in tab_transactions
+            if transaction.row_id not in existing_ids[tab]
+        ]
+        if args.dedup_cross_source:
+            new_transactions = drop_cross_source_duplicates(args, accounts, tabs, rows, new_transactions)
+        enrich_transactions(args, new_transactions)
+        report(progress, RunStage.DEDUPLICATED, len(new_transactions))
+        deduplicated = new_transactions
+        if dry_run:
+            record_artifact(args, run_id, tabs, existing_ids, deduplicated, new_transactions)
+            return new_transactions
+        if args.interactive:
+            save_rule = partial(save_mapping_rule, args, google)
+            new_transactions = review_transactions(new_transactions, categories, save_rule=save_rule)
+        # the last chance to stop cleanly, the writes below run to the end once started
+        shutdown.check()
+
+        policy = ChecksumPolicy(args.checksum_policy)
+        conflicts: dict[str, list[Conflict]] = {tab: [] for tab in tabs}
+        updates = {
+            tab: find_updates(
+                rows[tab], redact(tabs[tab], args.redaction), args.layout, policy, args.conflict_fields, conflicts[tab]
+            )
+            for tab in tabs
+        }
+        if args.aggregate_small:
+            for tab in tabs:
+                updates[tab] |= aggregate_updates(rows[tab], redact(tabs[tab], args.redaction), args.layout)
+        metadata = RowMetadata(run_id=run_id, imported_at=args.clock())
+        checkpoint = WriteCheckpoint(args.state_file, run_id)
+        write = SheetWrite(args.sheets_spreadsheet_id, run_id, args.layout)
+        with all_or_nothing(google, write, checkpoint) if args.transactional else nullcontext():
+            if any(updates.values()):
+                with breaker("google").guard():
+                    for tab, tab_updates in updates.items():
+                        write.update(google, tab, tab_updates)
+                        google.update_rows(args.sheets_spreadsheet_id, tab, tab_updates, args.layout)
+                report(progress, RunStage.UPDATED, sum(len(tab_updates) for tab_updates in updates.values()))
+            if any(conflicts.values()):
+                record_conflicts(args, google, conflicts)
+
+            with breaker("google").guard():
+                # the sheet may be shared, the ledger, artifacts and plugins below keep the full detail
+                for tab, tab_transactions in args.route(redact(new_transactions, args.redaction)).items():
+                    ids = [transaction.row_id for transaction in tab_transactions]
+                    checkpoint.begin(tab, ids)
+                    write.append(tab, ids)
+                    google.insert_records_to_google_sheet(
+                        args.sheets_spreadsheet_id,
+                        tab,
+                        tab_transactions,
+                        args.layout,
+                        metadata,
+                        identify=args.row_metadata,
+                    )
+                    checkpoint.commit()
+                    if not rows[tab]:
+                        stamp_layout(google, args.sheets_spreadsheet_id, tab, args.layout)
+                    if args.category_styles:
+                        apply_styles(google, args.sheets_spreadsheet_id, tab, args.category_styles, args.layout)
+                    if args.id_protection != IdProtection.OFF:
+                        protect_ids(google, args.sheets_spreadsheet_id, tab, IdProtection(args.id_protection))
+                if args.transactional:
+                    verify_write(google, write)
+        for destination in [*map(DestinationPlugin, args.destination_plugins), *args.destinations]:
+            _ = destination.write_transactions(new_transactions)
+        if args.ledger_file:
+            existing = [
+                transaction
+                for tab, tab_transactions in tabs.items()
+                for transaction in tab_transactions
+                if transaction.row_id in existing_ids[tab]
+            ]
+            _ = pull_sheet_edits(args, google, (row for tab_rows in rows.values() for row in tab_rows))
+            record_run(args.ledger_file, new_transactions, existing, metadata)
+        for commit in commits:
+            commit()
+        if args.state_file and fetched.validators is not None:
+            ImportState(args.state_file).record_validators(fetched.validators)
+        checkpoint.finish()
+
+        update_budget_status(args, google)
+        update_funds(args, google)
+        # without SimpleFin's accounts the balances would be incomplete
+        if args.balances_range_name and not fetched.not_modified:
+            with breaker("google").guard():
+                google.replace_rows(args.sheets_spreadsheet_id, args.balances_range_name, balance_rows(accounts))
+        if not fetched.not_modified:
+            track_holdings(args, google, accounts)
+        if args.summary_range_name:
+            with breaker("google").guard():
+                amortization = parse_amortization(google.get_rows(args.mapping_spreadsheet, args.mapping_range_name))
+                refresh_summary(
+                    google,
+                    args.sheets_spreadsheet_id,
+                    args.sheets_range_name,
+                    args.summary_range_name,
+                    args.layout,
+                    args.periods,
+                    amortization,
+                )
+                if args.summary_charts:
+                    refresh_charts(
+                        google,
+                        args.sheets_spreadsheet_id,
+                        args.sheets_range_name,
+                        args.summary_range_name,
+                        args.periods,
+                        amortization,
+                    )
 
-        documents = paperless.fetch_documents()
-        accounts = simplefin.fetch_data(args.start_date)
+        unmapped = Counter(transaction.payee for transaction in new_transactions if not transaction.mapped)
+        if args.unmapped_range_name and unmapped:
+            with breaker("google").guard():
+                google.add_unmapped_payees(
+                    args.sheets_spreadsheet_id,
+                    args.unmapped_range_name,
+                    unmapped,
+                    args.clock().date().isoformat(),
+                )
+        updated_ids = {tab: {rows[tab][index - 1][0] for index in tab_updates} for tab, tab_updates in updates.items()}
+        record_artifact(args, run_id, tabs, existing_ids, deduplicated, new_transactions, updated_ids)
+        report(progress, RunStage.INSERTED, len(new_transactions))
+        logger.info("Run %s imported %d transactions", run_id, len(new_transactions))
+        return new_transactions
 
-        transactions = simplefin.attach_receipts(accounts, documents)
-        simplefin.categorize_transactions(transactions, mapping)
 
-        google.insert_records_to_google_sheet(args.sheets_spreadsheet_id, args.sheets_range_name, transactions)
+def run_once(args: Args) -> list[SimpleFinTransaction]:
+    """Runs a single import from the command line, as cron does, and exports the run's metrics."""
+    run = Run(id=new_run_id(), trigger=RunTrigger.MANUAL, started_at=datetime.now(UTC))
+    try:
+        with shutdown.graceful():
+            run.transactions = main(args, run.events.append, run_id=run.id)
+        run.status = RunStatus.SUCCEEDED
+    except shutdown.ShutdownRequestedError as e:
+        run.status = RunStatus.SKIPPED
+        run.error = str(e)
+        raise
+    except BaseException as e:
+        run.status = RunStatus.FAILED
+        run.error = str(e) or type(e).__name__
+        if isinstance(e, Exception) and not isinstance(e, ReviewAbortedError):
+            capture_error(e, run)
+        raise
+    finally:
+        run.finished_at = datetime.now(UTC)
+        export_metrics(args.metrics, run)
+    return run.transactions
@@ -0,0 +1,137 @@
+"""
+Round-trips the lookup sheet through a local YAML file, to keep the mapping in version control, review changes
+to it and restore a lookup sheet that was trashed or overwritten.
+
+Each row of the lookup sheet is a rule with its payee, category, name, monthly budget and the months its
+transactions are amortized over, the columns past those are kept as `extra`. Importing replaces the whole
+lookup sheet with the file's rules, in the file's order.
+
+Sample usage:
+```sh
+budget-import mappings export rules.yaml
+budget-import mappings import rules.yaml
+```
+
+Sample file:
+```yaml
+rules:
+  - payee: AMZN MKTP US
+    category: Shopping
+    name: Amazon
+  - payee: RENT PMT
+    category: Housing
+    budget: "1,500.00"
+  - payee: STATE FARM INS
+    category: Insurance
+    amortize: "12"
+```
+"""
+
+import logging
+from collections.abc import Sequence
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Any, Final
+
+import yaml
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.config import ConfigError
+from budget.main import Args
+from budget.models.google import GoogleSheetRow, parse_amount
+
+logger = logging.getLogger(__name__)
+
+COLUMNS: Final = ("payee", "category", "name", "budget", "amortize")
+
+
+@dataclass()
+class ExportMappingsArgs:
+    args: Args
+    path: str
+
+
+@dataclass()
+class ImportMappingsArgs:
+    args: Args
+    path: str
+
+
+def rules_from_rows(rows: Sequence[list[str]]) -> list[dict[str, Any]]:
+    """The lookup sheet's rows as rules, blank rows between rules are kept as empty rules."""
+    rules: list[dict[str, Any]] = []
+    for row in rows:
+        rule: dict[str, Any] = {column: value for column, value in zip(COLUMNS, row, strict=False) if value}
+        extra = list(row[len(COLUMNS) :])
+        while extra and not extra[-1]:
+            _ = extra.pop()
+        if extra:
+            rule["extra"] = extra
+        rules.append(rule)
+    while rules and not rules[-1]:
+        _ = rules.pop()
+    return rules
+
+
+def rows_from_rules(rules: Sequence[dict[str, Any]]) -> list[GoogleSheetRow]:
+    """The lookup sheet's rows for the rules, budgets that are amounts are written as numbers."""
+    rows: list[GoogleSheetRow] = []
+    for rule in rules:
+        row: GoogleSheetRow = [str(rule.get(column) or "") for column in COLUMNS]
+        if (budget := parse_amount(str(row[3]))) is not None:
+            row[3] = float(budget)
+        row.extend(str(value) for value in rule.get("extra") or [])
+        while row and row[-1] == "":
+            _ = row.pop()
+        rows.append(row)
+    return rows
+
+
+def load_rules(path: str) -> list[dict[str, Any]]:
+    try:
+        data = yaml.safe_load(Path(path).read_text(encoding="utf-8")) or {}
+    except (OSError, yaml.YAMLError) as e:
+        msg = f"Unable to read mappings from {path}: {e}"
+        raise ConfigError(msg) from e
+    rules = data.get("rules") if isinstance(data, dict) else None
+    if not isinstance(rules, list) or not all(isinstance(rule, dict) for rule in rules):
+        msg = f"{path} must hold a list of rules under `rules`"
+        raise ConfigError(msg)
+    errors: list[str] = []
+    for index, rule in enumerate(rules, start=1):
+        if unknown := set(rule) - {*COLUMNS, "extra"}:
+            errors.append(f"Rule {index} has unknown fields {', '.join(sorted(unknown))}")
+        if rule and not rule.get("payee"):
+            errors.append(f"Rule {index} has no payee")
+    if errors:
+        msg = f"Invalid mappings in {path}\n{'\n'.join(errors)}"
+        raise ConfigError(msg)
+    return rules
+
+
+def export_mappings(args: ExportMappingsArgs) -> int:
+    """Writes the lookup sheet's rules to the file, returning how many."""
+    base = args.args
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        rows = google.get_rows(base.mapping_spreadsheet, base.mapping_range_name)
+    rules = rules_from_rows(rows)
+    text = yaml.safe_dump({"rules": rules}, sort_keys=False, allow_unicode=True)
+    _ = Path(args.path).write_text(text, encoding="utf-8")
+    logger.info("Exported %d rules from %s to %s", len(rules), base.mapping_range_name, args.path)
+    return len(rules)
+
+
+def import_mappings(args: ImportMappingsArgs) -> int:
+    """Replaces the lookup sheet with the file's rules, returning how many."""
+    base = args.args
+    rules = load_rules(args.path)
+    payees = {str(rule["payee"]) for rule in rules if rule}
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        # a trashed lookup sheet is created again
+        rows = google.get_rows(base.mapping_spreadsheet, base.mapping_range_name, missing_ok=True)
+        current = {row[0] for row in rows if row and row[0]}
+        google.replace_rows(base.mapping_spreadsheet, base.mapping_range_name, rows_from_rules(rules))
+    added, removed = len(payees - current), len(current - payees)
+    logger.info("Imported %d rules from %s, adding %d payees and removing %d", len(rules), args.path, added, removed)
+    return len(rules)
@@ -0,0 +1,92 @@
+"""
+Merchant enrichment, resolving raw descriptors like `SQ *BLUE BOTTLE 0123` to a clean merchant name,
+website and logo, written to the optional merchant and logo columns.
+
+The provider is a local dataset, a CSV file with `match`, `name`, `website` and `logo` columns where
+`match` is a case-insensitive regular expression searched in the descriptor, or a lookup API (see
+`MerchantApiClient`). The dataset is tried first when both are set.
+
+Sample config:
+```yaml
+pipeline:
+  enrichment:
+    merchants:
+      dataset: /data/merchants.csv
+      api_url: https://merchants.example.com/lookup
+      api_token: secret
+```
+"""
+
+import csv
+import logging
+import re
+from collections.abc import Sequence
+from pathlib import Path
+from typing import NamedTuple, Protocol, Self
+
+from budget.config import ConfigError
+from budget.models.simplefin import Merchant, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+class MerchantProvider(Protocol):
+    def lookup(self, descriptor: str) -> Merchant | None: ...
+
+
+class MerchantPattern(NamedTuple):
+    pattern: re.Pattern[str]
+    merchant: Merchant
+
+
+class MerchantDataset:
+    """Merchants of a local CSV file, the first row whose pattern matches wins."""
+
+    patterns: list[MerchantPattern]
+
+    def __init__(self, patterns: Sequence[MerchantPattern]) -> None:
+        self.patterns = list(patterns)
+
+    @classmethod
+    def from_file(cls, path: str) -> Self:
+        try:
+            with Path(path).open(encoding="utf-8", newline="") as file:
+                rows = list(csv.DictReader(file))
+        except OSError as e:
+            msg = f"Unable to read the merchant dataset {path}: {e}"
+            raise ConfigError(msg) from e
+        patterns: list[MerchantPattern] = []
+        for line, row in enumerate(rows, start=2):
+            if not row.get("match") or not row.get("name"):
+                msg = f"Invalid merchant dataset {path} line {line}, match and name are required"
+                raise ConfigError(msg)
+            try:
+                pattern = re.compile(row["match"], re.IGNORECASE)
+            except re.error as e:
+                msg = f"Invalid merchant dataset {path} line {line}, {row['match']!r} is not a valid pattern: {e}"
+                raise ConfigError(msg) from e
+            merchant = Merchant(name=row["name"], website=row.get("website") or None, logo=row.get("logo") or None)
+            patterns.append(MerchantPattern(pattern, merchant))
+        logger.info("Loaded %d merchants from %s", len(patterns), path)
+        return cls(patterns)
+
+    def lookup(self, descriptor: str) -> Merchant | None:
+        return next((entry.merchant for entry in self.patterns if entry.pattern.search(descriptor)), None)
+
+
+def descriptor(transaction: SimpleFinTransaction) -> str:
+    """The raw text the bank gave the transaction, before the mapping renamed the payee."""
+    return transaction.description or transaction.original_payee or transaction.payee
+
+
+def enrich_merchants(transactions: Sequence[SimpleFinTransaction], providers: Sequence[MerchantProvider]) -> int:
+    """Sets the merchant of transactions a provider knows, returning how many were resolved."""
+    resolved: dict[str, Merchant | None] = {}
+    for transaction in transactions:
+        text = descriptor(transaction)
+        if text not in resolved:
+            resolved[text] = next((merchant for provider in providers if (merchant := provider.lookup(text))), None)
+        transaction.merchant = resolved[text] or transaction.merchant
+    count = sum(1 for transaction in transactions if transaction.merchant)
+    logger.info("Resolved the merchant of %d of %d transactions", count, len(transactions))
+    return count
@@ -0,0 +1,241 @@
+"""
+Run metrics for imports run from cron, where no daemon is around to report how they went.
+
+After each run, from the command line or the daemon, the metrics file is rewritten in the node_exporter
+textfile collector format, or as JSON when its name ends in `.json`, so Prometheus can alert on the time
+of the last successful run and graph the row counts. A failed run keeps the time of the last success the
+previous file recorded.
+
+The metrics can also be pushed to a Prometheus Pushgateway, grouped under the job. They are pushed with
+POST, which only replaces the metrics pushed again, so the time of the last success stays on the gateway
+when a run fails.
+
+For Datadog or Telegraf the metrics can be sent to a StatsD server instead, over UDP as `<prefix>.runs.<status>`
+counters, `<prefix>.transactions.<stage>` counters, a `<prefix>.run.duration` timer and gauges of the last run.
+
+Sample config:
+```yaml
+metrics:
+  file: /var/lib/node_exporter/textfile_collector/budget.prom
+  pushgateway_url: http://pushgateway:9091
+  pushgateway_job: budget_import
+  statsd_address: localhost:8125
+  statsd_prefix: budget_import
+```
+"""
+
+import json
+import logging
+import re
+import socket
+import urllib.request
+from collections.abc import Sequence
+from datetime import datetime
+from pathlib import Path
+from typing import Any, Final, NamedTuple
+from urllib.parse import quote
+
+from budget.runs import Run, RunStage, RunStatus
+
+logger = logging.getLogger(__name__)
+
+METRIC_PREFIX: Final = "budget_import"
+PUSHGATEWAY_JOB: Final = "budget_import"
+PUSHGATEWAY_TIMEOUT: Final = 10
+TEXT_FORMAT: Final = "text/plain; version=0.0.4; charset=utf-8"
+STATSD_PREFIX: Final = "budget_import"
+MAX_PORT: Final = 65535
+LAST_SUCCESS: Final = f"{METRIC_PREFIX}_last_success_timestamp_seconds"
+TRANSACTIONS: Final = f"{METRIC_PREFIX}_last_run_transactions"
+LAST_SUCCESS_LINE: Final = re.compile(rf"^{LAST_SUCCESS}\s+(\S+)\s*$", re.MULTILINE)
+
+
+class Sample(NamedTuple):
+    name: str
+    help: str
+    value: float
+    labels: dict[str, str] | None = None
+
+
+class MetricsSettings(NamedTuple):
+    file: str | None = None
+    pushgateway_url: str | None = None
+    pushgateway_job: str = PUSHGATEWAY_JOB
+    statsd_address: str | None = None
+    statsd_prefix: str = STATSD_PREFIX
+
+
+def stage_counts(run: Run) -> dict[str, int]:
+    """The count each stage of the run last reported, notices are counted on their own."""
+    counts = {stage.value: 0 for stage in RunStage if stage != RunStage.NOTICE}
+    for event in run.events:
+        if event.stage != RunStage.NOTICE:
+            counts[event.stage.value] = event.count
+    return counts
+
+
+def last_success(run: Run, previous: float | None) -> float | None:
+    if run.status == RunStatus.SUCCEEDED and run.finished_at:
+        return run.finished_at.timestamp()
+    return previous
+
+
+def run_samples(run: Run, previous_success: float | None = None) -> list[Sample]:
+    finished_at = run.finished_at or run.started_at
+    samples = [
+        Sample(f"{METRIC_PREFIX}_last_run_timestamp_seconds", "When the last run finished", finished_at.timestamp()),
+        Sample(
+            f"{METRIC_PREFIX}_last_run_success",
+            "Whether the last run succeeded",
+            1 if run.status == RunStatus.SUCCEEDED else 0,
+        ),
+        Sample(
+            f"{METRIC_PREFIX}_last_run_duration_seconds",
+            "How long the last run took",
+            (finished_at - run.started_at).total_seconds(),
+        ),
+        Sample(f"{METRIC_PREFIX}_last_run_notices", "Notices upstream APIs sent in the last run", len(run.notices)),
+    ]
+    if (success := last_success(run, previous_success)) is not None:
+        samples.append(Sample(LAST_SUCCESS, "When the last successful run finished", success))
+    samples.extend(
+        Sample(TRANSACTIONS, "Transactions at each stage of the last run", count, {"stage": stage})
+        for stage, count in stage_counts(run).items()
+    )
+    return samples
+
+
+def format_labels(labels: dict[str, str] | None) -> str:
+    if not labels:
+        return ""
+    escaped = (value.replace("\\", "\\\\").replace('"', '\\"').replace("\n", "\\n") for value in labels.values())
+    return "{" + ",".join(f'{name}="{value}"' for name, value in zip(labels, escaped, strict=True)) + "}"
+
+
+def format_textfile(samples: Sequence[Sample]) -> str:
+    """Formats the samples in the Prometheus text exposition format, with a HELP and TYPE line per metric."""
+    lines: list[str] = []
+    described: set[str] = set()
+    for sample in samples:
+        if sample.name not in described:
+            described.add(sample.name)
+            lines.extend((f"# HELP {sample.name} {sample.help}", f"# TYPE {sample.name} gauge"))
+        lines.append(f"{sample.name}{format_labels(sample.labels)} {sample.value}")
+    return "\n".join(lines) + "\n"
+
+
+def format_json(run: Run, previous_success: float | None) -> str:
+    finished_at = run.finished_at or run.started_at
+    success = last_success(run, previous_success)
+    data: dict[str, Any] = {
+        "run_id": run.id,
+        "trigger": run.trigger.value,
+        "status": run.status.value,
+        "error": run.error,
+        "started_at": run.started_at.isoformat(),
+        "finished_at": finished_at.isoformat(),
+        "duration_seconds": (finished_at - run.started_at).total_seconds(),
+        "last_success_at": datetime.fromtimestamp(success, finished_at.tzinfo).isoformat() if success else None,
+        "notices": run.notices,
+        "transactions": stage_counts(run),
+    }
+    return json.dumps(data, indent=2) + "\n"
+
+
+def previous_success(path: Path) -> float | None:
+    """The time of the last success recorded by the previous metrics file, None when there isn't one."""
+    try:
+        text = path.read_text(encoding="utf-8")
+    except FileNotFoundError:
+        return None
+    if path.suffix == ".json":
+        try:
+            value = json.loads(text).get("last_success_at")
+            return datetime.fromisoformat(value).timestamp() if value else None
+        except (ValueError, AttributeError, TypeError):
+            return None
+    match = LAST_SUCCESS_LINE.search(text)
+    try:
+        return float(match.group(1)) if match else None
+    except ValueError:
+        return None
+
+
+def write_metrics_file(path: str, run: Run) -> None:
+    """Rewrites the metrics file atomically, the collector never reads a half written file."""
+    file = Path(path)
+    previous = previous_success(file)
+    text = format_json(run, previous) if file.suffix == ".json" else format_textfile(run_samples(run, previous))
+    temporary = file.with_name(f".{file.name}.tmp")
+    _ = temporary.write_text(text, encoding="utf-8")
+    _ = temporary.replace(file)
+    logger.debug("Wrote the metrics of run %s to %s", run.id, path)
+
+
+def push_metrics(url: str, job: str, run: Run) -> None:
+    """Pushes the run's metrics to the Pushgateway, replacing the job's metrics of the same names."""
+    request = urllib.request.Request(  # noqa: S310 - the URL comes from the user's config
+        f"{url.rstrip('/')}/metrics/job/{quote(job, safe='')}",
+        data=format_textfile(run_samples(run)).encode(),
+        headers={"Content-Type": TEXT_FORMAT},
+        method="POST",
+    )
+    with urllib.request.urlopen(request, timeout=PUSHGATEWAY_TIMEOUT):  # noqa: S310
+        pass
+    logger.debug("Pushed the metrics of run %s to %s", run.id, url)
+
+
+def parse_statsd_address(address: str) -> tuple[str, int] | None:
+    """Splits `host:port`, None when the address isn't one."""
+    host, separator, port = address.rpartition(":")
+    if not separator or not host or not port.isdigit() or not 0 < int(port) <= MAX_PORT:
+        return None
+    return host.strip("[]"), int(port)
+
+
+def validate_statsd_address(address: str | None) -> str | None:
+    if address and not parse_statsd_address(address):
+        return f"StatsD address must be host:port, got {address}"
+    return None
+
+
+def statsd_lines(run: Run, prefix: str) -> list[str]:
+    finished_at = run.finished_at or run.started_at
+    lines = [
+        f"{prefix}.runs.{run.status.value}:1|c",
+        f"{prefix}.run.duration:{round((finished_at - run.started_at).total_seconds() * 1000)}|ms",
+        f"{prefix}.last_run.success:{1 if run.status == RunStatus.SUCCEEDED else 0}|g",
+        f"{prefix}.last_run.notices:{len(run.notices)}|g",
+    ]
+    lines.extend(f"{prefix}.transactions.{stage}:{count}|c" for stage, count in stage_counts(run).items() if count)
+    return lines
+
+
+def send_statsd(address: str, prefix: str, run: Run) -> None:
+    """Sends the run's metrics to the StatsD server in one datagram, UDP never waits for the server."""
+    parsed = parse_statsd_address(address)
+    if not parsed:
+        msg = f"Invalid StatsD address {address}"
+        raise OSError(msg)
+    with socket.socket(socket.AF_INET6 if ":" in parsed[0] else socket.AF_INET, socket.SOCK_DGRAM) as sock:
+        _ = sock.sendto("\n".join(statsd_lines(run, prefix)).encode(), parsed)
+    logger.debug("Sent the metrics of run %s to %s", run.id, address)
+
+
+def export_metrics(settings: MetricsSettings, run: Run) -> None:
+    """Exports the metrics of a finished run, a failure to export is logged and doesn't fail the run."""
+    if settings.file:
+        try:
+            write_metrics_file(settings.file, run)
+        except OSError:
+            logger.exception("Failed to write the metrics file %s", settings.file)
+    if settings.pushgateway_url:
+        try:
+            push_metrics(settings.pushgateway_url, settings.pushgateway_job, run)
+        except OSError:
+            logger.exception("Failed to push the metrics to %s", settings.pushgateway_url)
+    if settings.statsd_address:
+        try:
+            send_statsd(settings.statsd_address, settings.statsd_prefix, run)
+        except OSError:
+            logger.exception("Failed to send the metrics to %s", settings.statsd_address)
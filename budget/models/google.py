@@ -1,4 +1,10 @@
-from typing import NamedTuple, Self
+import hashlib
+import re
+import unicodedata
+from datetime import date, datetime
+from decimal import Decimal, InvalidOperation
+from enum import StrEnum
+from typing import Final, NamedTuple, Self
 
 GoogleSheetRow = list[str | float | int]
 
@@ -11,3 +17,170 @@ class Category(NamedTuple):
     def from_row(cls, row: list[str]) -> Self:
         checked_row = [*row[1:], None, None]
         return cls(category=checked_row[0], name=checked_row[1])
+
+
+class DateFormat(StrEnum):
+    US = "us"
+    ISO = "iso"
+
+
+class DateField(StrEnum):
+    TRANSACTED_AT = "transacted_at"
+    POSTED = "posted"
+
+
+class SheetLayout(NamedTuple):
+    """Optional columns, written in this order after the id, payee, amount, date, category and receipt columns."""
+
+    currency: bool = False
+    original_amount: bool = False
+    date_format: DateFormat = DateFormat.US
+    date_field: DateField = DateField.TRANSACTED_AT
+    checksum: bool = False
+    run_id: bool = False
+    metadata: bool = False
+    category_groups: bool = False
+    status: bool = False
+    # the household member each transaction belongs to
+    owner: bool = False
+    # whether the transaction's account is personal or business
+    purpose: bool = False
+    merchant: bool = False
+    # the symbol, units, unit price, gross amount and fees of brokerage trades
+    trades: bool = False
+    # the ID of the purchase a refund returns
+    refund_of: bool = False
+    # keys of the transactions' extra data, each written to its own column
+    extra: tuple[str, ...] = ()
+    # category and emoji pairs, the emoji is written in front of the category
+    category_emoji: tuple[tuple[str, str], ...] = ()
+    # the symbol amounts are formatted with, none formats them as plain numbers
+    currency_symbol: str = "$"
+    currency_symbol_after: bool = False
+
+    def columns(self) -> list[str]:
+        """The column names in sheet order."""
+        columns = ["id", "payee", "amount", "date", "category", "receipt"]
+        if self.category_groups:
+            columns.append("category_group")
+        if self.status:
+            columns.append("status")
+        if self.owner:
+            columns.append("owner")
+        if self.purpose:
+            columns.append("purpose")
+        if self.currency:
+            columns.append("currency")
+        if self.original_amount:
+            columns.extend(("original_amount", "original_currency"))
+        if self.checksum:
+            columns.append("checksum")
+        if self.run_id or self.metadata:
+            columns.append("run_id")
+        if self.metadata:
+            columns.extend(("imported_at", "source"))
+        if self.merchant:
+            columns.extend(("merchant", "merchant_logo"))
+        if self.trades:
+            columns.extend(TRADE_COLUMNS)
+        if self.refund_of:
+            columns.append("refund_of")
+        columns.extend(f"{EXTRA_PREFIX}{key}" for key in self.extra)
+        return columns
+
+    def hidden_columns(self) -> list[int]:
+        """Indexes of the bookkeeping columns that are hidden from view."""
+        return [index for index, column in enumerate(self.columns()) if column in {"checksum", "run_id"}]
+
+
+def split_category(category: str | None) -> tuple[str, str]:
+    """Splits a two level "Food:Restaurants" category into its group and category, plain categories have no group."""
+    if not category:
+        return "", ""
+    group, separator, name = category.partition(CATEGORY_SEPARATOR)
+    if not separator:
+        return "", category.strip()
+    return group.strip(), name.strip()
+
+
+class RowMetadata(NamedTuple):
+    """Describes the import that appended a row."""
+
+    run_id: str = ""
+    imported_at: datetime | None = None
+
+
+class DriveFile(NamedTuple):
+    id: str
+    name: str
+
+
+METADATA_COLUMNS: Final = ("run_id", "imported_at", "source")
+TRADE_COLUMNS: Final = ("symbol", "units", "unit_price", "gross_amount", "fees")
+CATEGORY_SEPARATOR: Final = ":"
+EXTRA_PREFIX: Final = "extra."
+# an emoji or symbol the category cell starts with, written by the category styles
+DECORATION: Final = re.compile(r"^[^\w\s]+\s+")
+
+
+def parse_amount(value: str) -> Decimal | None:
+    """Parses a formatted sheet amount like "$1,234.56", "1,234.56 €" or "(12.00)"."""
+    # currency symbols are unicode's Sc category
+    cleaned = "".join(
+        char for char in value if char != "," and not char.isspace() and unicodedata.category(char) != "Sc"
+    )
+    negative = cleaned.startswith("(") and cleaned.endswith(")")
+    cleaned = cleaned.strip("()")
+    try:
+        amount = Decimal(cleaned)
+    except InvalidOperation:
+        return None
+    return -amount if negative else amount
+
+
+def strip_decoration(category: str) -> str:
+    """The category of a category cell, without the emoji in front of it."""
+    return DECORATION.sub("", category)
+
+
+def parse_date(value: str) -> date | None:
+    """Parses a sheet date written as m/d/Y or as an ISO 8601 date."""
+    for date_format in ("%m/%d/%Y", "%Y-%m-%d"):
+        try:
+            return datetime.strptime(value.strip(), date_format).date()  # noqa: DTZ007
+        except ValueError:
+            continue
+    return None
+
+
+class SheetTransaction(NamedTuple):
+    id: str
+    payee: str
+    amount: Decimal
+    date: date
+    category: str
+    receipt: str
+
+    @classmethod
+    def from_row(cls, row: list[str]) -> Self | None:
+        """Parses a transactions sheet row, returning None for headers or malformed rows."""
+        checked_row = [*row, "", "", "", "", "", ""]
+        amount = parse_amount(checked_row[2])
+        transacted_at = parse_date(checked_row[3])
+        if amount is None or transacted_at is None:
+            return None
+        return cls(
+            id=checked_row[0],
+            payee=checked_row[1],
+            amount=amount,
+            date=transacted_at,
+            category=strip_decoration(checked_row[4]),
+            receipt=checked_row[5],
+        )
+
+    @property
+    def checksum(self) -> str:
+        """A digest of the imported fields, stable across the sheet's display formatting."""
+        amount = str(self.amount.quantize(Decimal("0.01")))
+        fields = (self.payee, amount, self.date.isoformat(), self.category, self.receipt)
+        return hashlib.sha256("\x1f".join(fields).encode()).hexdigest()[:16]
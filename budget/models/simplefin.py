@@ -1,7 +1,8 @@
-from dataclasses import dataclass
+from dataclasses import dataclass, field
 from datetime import UTC, datetime
 from decimal import Decimal
-from typing import Any, Self, TypedDict, TypeGuard
+from enum import StrEnum
+from typing import Any, NamedTuple, NotRequired, Self, TypedDict, TypeGuard
 
 from budget.models.paperless import Document
 
@@ -66,7 +67,48 @@ class SimpleFinHolding:
         )
 
 
+class AccountPurpose(StrEnum):
+    PERSONAL = "personal"
+    BUSINESS = "business"
+
+
+class AccountRef(NamedTuple):
+    """The account a transaction belongs to, usable in tab templates as `{account.name}` or `{account.purpose}`."""
+
+    id: str
+    name: str
+    org: str
+    currency: str
+    # tagged in the accounts section of the config, accounts are personal unless tagged otherwise
+    purpose: AccountPurpose = AccountPurpose.PERSONAL
+
+
+class Merchant(NamedTuple):
+    """The clean name, website and logo a raw descriptor resolves to."""
+
+    name: str
+    website: str | None = None
+    logo: str | None = None
+
+
+class Trade(NamedTuple):
+    """
+    The buy or sell of a brokerage account's cash transaction, the transaction's amount is the net.
+
+    Gross is the cash value of the units, negative for buys like the net, fees are what the broker took on top,
+    so the net is the gross less the fees.
+    """
+
+    action: str
+    symbol: str
+    units: Decimal
+    unit_price: Decimal
+    gross: Decimal
+    fees: Decimal
+
+
 class SimpleFinTransactionDict(TypedDict):
+    pending: NotRequired[bool]
     id: str
     amount: str
     description: str
@@ -74,6 +116,7 @@ class SimpleFinTransactionDict(TypedDict):
     payee: str
     posted: int
     transacted_at: int
+    extra: NotRequired[dict[str, Any]]
 
 
 @dataclass
@@ -87,6 +130,32 @@ class SimpleFinTransaction:
     transacted_at: datetime
     category: str | None = None
     receipt: Document | None = None
+    tags: list[str] = field(default_factory=list)
+    currency: str | None = None
+    original_amount: Decimal | None = None
+    original_currency: str | None = None
+    # the payee as the source named it, before the mapping renamed it
+    original_payee: str | None = None
+    merchant: Merchant | None = None
+    trade: Trade | None = None
+    # bank specific data the bridge passed along
+    extra: dict[str, Any] = field(default_factory=dict)
+    key: str | None = None
+    source: str | None = None
+    account: AccountRef | None = None
+    # the household member whose connection brought the transaction
+    owner: str | None = None
+    mapped: bool = False
+    # the payee of the mapping rule that matched, which fuzzy matching may have picked
+    rule: str | None = None
+    pending: bool = False
+    # the ID of the purchase a refund returns
+    refund_of: str | None = None
+
+    @property
+    def row_id(self) -> str:
+        """The ID written to the sheet, the dedup key when one was assigned."""
+        return self.key or self.id
 
     @classmethod
     def from_dict(cls, transaction: SimpleFinTransactionDict) -> Self:
@@ -101,8 +170,33 @@ class SimpleFinTransaction:
             payee=transaction["payee"],
             posted=posted,
             transacted_at=transacted_at,
+            pending=bool(transaction.get("pending")) or not transaction["posted"],
+            extra=extra if isinstance(extra := transaction.get("extra"), dict) else {},
         )
 
+    def to_dict(self) -> dict[str, Any]:
+        return {
+            "id": self.id,
+            "payee": self.payee,
+            "amount": str(self.amount),
+            "description": self.description,
+            "memo": self.memo,
+            "transacted_at": self.transacted_at.isoformat(),
+            "posted": self.posted.isoformat(),
+            "category": self.category,
+            "receipt": str(self.receipt) if self.receipt else None,
+            "tags": self.tags,
+            "currency": self.currency,
+            "original_amount": str(self.original_amount) if self.original_amount is not None else None,
+            "original_currency": self.original_currency,
+            "source": self.source,
+            "pending": self.pending,
+            "refund_of": self.refund_of,
+            "owner": self.owner,
+            "purpose": self.account.purpose if self.account else None,
+            "extra": self.extra,
+        }
+
 
 class SimpleFinAccountDict(TypedDict("SimpleFinAccount", {"available-balance": str, "balance-date": int})):
     balance: str
@@ -131,6 +225,10 @@ class SimpleFinAccount:
         org = SimpleFinOrganization.from_dict(account["org"])
         holdings = [SimpleFinHolding.from_dict(holding) for holding in account["holdings"]]
         transactions = [SimpleFinTransaction.from_dict(transaction) for transaction in account["transactions"]]
+        account_ref = AccountRef(id=account["id"], name=account["name"], org=org.name, currency=account["currency"])
+        for transaction in transactions:
+            transaction.currency = account["currency"]
+            transaction.account = account_ref
         return cls(
             available_balance=account["available-balance"],
             balance=account["balance"],
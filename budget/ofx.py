@@ -0,0 +1,164 @@
+"""
+Reads OFX and QFX statements, the format most banks offer as "Quicken" or "Money" downloads.
+
+Both the SGML flavor of OFX 1.x, whose leaf elements have no closing tags, and the XML flavor of
+OFX 2.x are read by matching the elements the importer needs, so the header and any unknown
+aggregates are ignored. Each bank, card or brokerage statement in the file becomes one account.
+
+A brokerage statement's buys and sells become transactions of their net cash amount carrying the
+trade, its symbol from the file's security list, units, unit price, gross amount and the commission,
+fees, taxes and load summed as the fees. Its other cash movements, like dividends, are read as
+bank transactions.
+"""
+
+import logging
+import re
+from datetime import UTC, datetime
+from decimal import Decimal, InvalidOperation
+from typing import Final
+
+from budget.models.simplefin import AccountRef, SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction, Trade
+
+logger = logging.getLogger(__name__)
+
+# aggregates are closed in both flavors, only the leaf elements of OFX 1.x aren't
+STATEMENT: Final = re.compile(r"<(STMTRS|CCSTMTRS|INVSTMTRS)>(.*?)</\1>", re.DOTALL)
+TRANSACTION: Final = re.compile(r"<STMTTRN>(.*?)</STMTTRN>", re.DOTALL)
+TRADE: Final = re.compile(r"<(INVBUY|INVSELL)>(.*?)</\1>", re.DOTALL)
+SECURITY: Final = re.compile(r"<SECINFO>(.*?)</SECINFO>", re.DOTALL)
+FEE_ELEMENTS: Final = ("COMMISSION", "FEES", "TAXES", "LOAD")
+ORG_PATTERN: Final = re.compile(r"<FI>.*?<ORG>([^<\r\n]*)", re.DOTALL)
+
+
+def element(text: str, tag: str) -> str:
+    """The value of the first `<TAG>value` in the text, empty when it isn't there."""
+    match = re.search(rf"<{tag}>([^<\r\n]*)", text)
+    return match.group(1).strip() if match else ""
+
+
+def parse_ofx_date(value: str) -> datetime | None:
+    """Parses `20261015`, `20261015120000` or `20261015120000.000[-5:EST]`, the time zone is dropped."""
+    try:
+        return datetime.strptime(value[:8], "%Y%m%d").replace(tzinfo=UTC)
+    except ValueError:
+        return None
+
+
+def parse_decimal(value: str) -> Decimal | None:
+    try:
+        return Decimal(value.replace(",", ".")) if value else None
+    except InvalidOperation:
+        return None
+
+
+def tickers(text: str) -> dict[str, str]:
+    """The ticker of each security of the file's security list, by its CUSIP or other unique ID."""
+    return {
+        element(security, "UNIQUEID"): element(security, "TICKER") or element(security, "SECNAME")
+        for security in SECURITY.findall(text)
+    }
+
+
+def to_trade(kind: str, text: str, account: AccountRef, symbols: dict[str, str]) -> SimpleFinTransaction | None:
+    """A buy or sell as a transaction of its net amount, negative for buys."""
+    fit_id = element(text, "FITID")
+    traded = parse_ofx_date(element(text, "DTTRADE"))
+    settled = parse_ofx_date(element(text, "DTSETTLE")) or traded
+    units, unit_price = parse_decimal(element(text, "UNITS")), parse_decimal(element(text, "UNITPRICE"))
+    if not fit_id or not traded or not settled or units is None or unit_price is None:
+        logger.warning("Skipping OFX trade %s without an ID, date, units or unit price", fit_id)
+        return None
+    fees = sum((parse_decimal(element(text, tag)) or Decimal(0) for tag in FEE_ELEMENTS), Decimal(0))
+    action = "buy" if kind == "INVBUY" else "sell"
+    # buys take cash out, the units are positive for buys and negative for sells
+    gross = -abs(units * unit_price) if action == "buy" else abs(units * unit_price)
+    total = parse_decimal(element(text, "TOTAL"))
+    amount = total if total is not None else gross - fees
+    unique_id = element(text, "UNIQUEID")
+    symbol = symbols.get(unique_id) or unique_id
+    payee = f"{action.capitalize()} {symbol}"
+    return SimpleFinTransaction(
+        id=fit_id,
+        amount=amount,
+        description=element(text, "MEMO") or payee,
+        memo=element(text, "MEMO"),
+        payee=payee,
+        posted=settled,
+        transacted_at=traded,
+        currency=account.currency or None,
+        account=account,
+        trade=Trade(
+            action=action, symbol=symbol, units=abs(units), unit_price=unit_price, gross=amount + fees, fees=fees
+        ),
+    )
+
+
+def to_transaction(text: str, account: AccountRef) -> SimpleFinTransaction | None:
+    fit_id = element(text, "FITID")
+    posted = parse_ofx_date(element(text, "DTPOSTED"))
+    transacted_at = parse_ofx_date(element(text, "DTUSER")) or posted
+    try:
+        amount = Decimal(element(text, "TRNAMT").replace(",", "."))
+    except InvalidOperation:
+        logger.warning("Skipping OFX transaction %s without a valid amount", fit_id)
+        return None
+    if not posted or not transacted_at or not fit_id:
+        logger.warning("Skipping OFX transaction %s without a date or ID", fit_id)
+        return None
+    name = element(text, "NAME") or element(text, "PAYEE")
+    memo = element(text, "MEMO")
+    return SimpleFinTransaction(
+        id=fit_id,
+        amount=amount,
+        description=name or memo,
+        memo=memo,
+        payee=name or memo,
+        posted=posted,
+        transacted_at=transacted_at,
+        currency=account.currency or None,
+        account=account,
+    )
+
+
+def parse_ofx(text: str, name: str, currency: str = "") -> list[SimpleFinAccount]:
+    """
+    The accounts of an OFX file, named after the source with the account number's last digits.
+
+    The currency is used for statements that don't declare one.
+    """
+    org_match = ORG_PATTERN.search(text)
+    org = SimpleFinOrganization(domain="", name=org_match.group(1).strip() if org_match else name, sfin_url=None)
+    symbols = tickers(text)
+    accounts: list[SimpleFinAccount] = []
+    for _, statement in STATEMENT.findall(text):
+        account_id = element(statement, "ACCTID")
+        currency = element(statement, "CURDEF") or currency
+        account_name = f"{name} {account_id[-4:]}" if account_id else name
+        account_ref = AccountRef(id=f"ofx:{account_id or name}", name=account_name, org=org.name, currency=currency)
+        transactions = [
+            transaction
+            for transaction_text in TRANSACTION.findall(statement)
+            if (transaction := to_transaction(transaction_text, account_ref))
+        ]
+        transactions.extend(
+            transaction
+            for kind, trade_text in TRADE.findall(statement)
+            if (transaction := to_trade(kind, trade_text, account_ref, symbols))
+        )
+        balance_date = parse_ofx_date(element(statement, "DTASOF"))
+        accounts.append(
+            SimpleFinAccount(
+                available_balance=element(statement.partition("<AVAILBAL>")[2], "BALAMT"),
+                balance=element(statement.partition("<LEDGERBAL>")[2], "BALAMT"),
+                balance_date=int(balance_date.timestamp()) if balance_date else 0,
+                currency=currency,
+                holdings=[],
+                id=account_ref.id,
+                name=account_name,
+                org=org,
+                transactions=transactions,
+            )
+        )
+    if not accounts:
+        logger.warning("No bank or card statement found in the %s OFX file", name)
+    return accounts
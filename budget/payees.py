@@ -0,0 +1,113 @@
+import logging
+import sys
+from collections import Counter
+from collections.abc import Mapping
+from dataclasses import dataclass
+
+from budget.categories import row_cells
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.dedup import normalize_payee
+from budget.fuzzy import PayeeMatcher
+from budget.ledger import Ledger, LedgerEntry
+from budget.main import Args, pull_sheet_edits
+from budget.models.google import Category, RowMetadata, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+from budget.recategorize import is_edited, original_payee
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class NormalizePayeesArgs:
+    args: Args
+    apply: bool = False
+
+
+def payee_names(mapping: Mapping[str, Category]) -> dict[str, str]:
+    """The name overrides of the mapping, also keyed by the payee reduced to its letters."""
+    names = {payee: rule.name for payee, rule in mapping.items() if rule.name}
+    names.update({normalize_payee(payee): name for payee, name in names.items() if normalize_payee(payee)})
+    return names
+
+
+def canonical_payee(payee: str, names: Mapping[str, str], matcher: PayeeMatcher | None) -> str | None:
+    """
+    The name a payee is shown as, None when no override applies.
+
+    The payee is looked up as is, then reduced to its letters so "AMZN Mktp US*2K4" finds the "AMZN MKTP US"
+    override, then through the closest mapped payee when fuzzy matching is on.
+    """
+    if payee in names:
+        return names[payee]
+    if normalize_payee(payee) in names:
+        return names[normalize_payee(payee)]
+    if matcher and (closest := matcher.match(payee)):
+        return names.get(closest)
+    return None
+
+
+def render_diff(renames: Counter[tuple[str, str]]) -> str:
+    lines: list[str] = []
+    for (old, new), count in sorted(renames.items(), key=lambda item: (item[0][1], item[0][0])):
+        lines.extend((f"- {old}", f"+ {new}  ({count} rows)"))
+    return "\n".join(lines)
+
+
+def normalize_payees(args: NormalizePayeesArgs) -> int:
+    """
+    Renames the payees of the rows already in the transactions tabs by the mapping's name overrides.
+
+    The renames are printed as a diff and only written with apply, returning the rows renamed. Rows are
+    matched by the payee the source named, which the ledger remembers, and rows edited by hand are kept.
+    """
+    base = args.args
+    layout = base.layout
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        tabs = base.transaction_tabs(google.worksheet_titles(base.sheets_spreadsheet_id))
+        sheet_rows = {tab: google.get_rows(base.sheets_spreadsheet_id, tab) for tab in tabs}
+        entries: dict[str, LedgerEntry] = {}
+        if base.ledger_file:
+            if args.apply:
+                _ = pull_sheet_edits(base, google, (row for rows in sheet_rows.values() for row in rows))
+            with Ledger(base.ledger_file) as ledger:
+                entries = {entry.transaction.row_id: entry for entry in ledger.entries()}
+        _, mapping = google.get_category_mapping(base.mapping_spreadsheet, base.mapping_range_name)
+        names = payee_names(mapping)
+        matcher = PayeeMatcher(mapping, base.fuzzy_threshold) if base.fuzzy_threshold else None
+
+        renames: Counter[tuple[str, str]] = Counter()
+        changed: list[SimpleFinTransaction] = []
+        for tab, rows in sheet_rows.items():
+            cells: dict[tuple[int, int], str] = {}
+            for index, row in enumerate(rows, start=1):
+                sheet_row = SheetTransaction.from_row(row)
+                entry = entries.get(sheet_row.id) if sheet_row else None
+                if not sheet_row or (entry and entry.edited) or is_edited(row, layout):
+                    continue
+                source_payee = original_payee(entry) if entry else sheet_row.payee
+                name = canonical_payee(source_payee, names, matcher)
+                if not name or name == sheet_row.payee:
+                    continue
+                renames[sheet_row.payee, name] += 1
+                cells.update(row_cells(index, row, layout, payee=name))
+                if entry:
+                    entry.transaction.original_payee = source_payee
+                    entry.transaction.payee = name
+                    changed.append(entry.transaction)
+            if cells and args.apply:
+                google.update_cells(base.sheets_spreadsheet_id, tab, cells)
+
+    renamed = renames.total()
+    if not renamed:
+        logger.info("Every payee already has its name")
+        return 0
+    _ = sys.stdout.write(render_diff(renames) + "\n")
+    if not args.apply:
+        logger.info("Would rename %d rows, run again with --apply to write them", renamed)
+        return 0
+    if changed and base.ledger_file:
+        with Ledger(base.ledger_file) as ledger:
+            ledger.record(changed, RowMetadata())
+    logger.info("Renamed the payees of %d rows", renamed)
+    return renamed
@@ -0,0 +1,94 @@
+"""
+The periods budgets and summaries are bucketed by, for budgeting by paycheck rather than by calendar month.
+
+Weekly periods start on Mondays or on the anchor's weekday, biweekly ones every other week from the anchor, a
+payday, and semi-monthly ones on the 1st and the 16th. The budgets of the lookup sheet are amounts per period.
+
+Sample config:
+```yaml
+destinations:
+  - type: sheets
+    budget_range_name: budget
+    budget_period: biweekly
+    budget_period_anchor: "2024-01-05"
+```
+"""
+
+from calendar import monthrange
+from datetime import date, timedelta
+from enum import StrEnum
+from typing import Final, NamedTuple
+
+
+class BudgetPeriod(StrEnum):
+    MONTHLY = "monthly"
+    SEMI_MONTHLY = "semi-monthly"
+    BIWEEKLY = "biweekly"
+    WEEKLY = "weekly"
+
+
+def validate_anchor(anchor: str | None) -> str | None:
+    """Returns why the period anchor isn't a date, or None when it is valid or unset."""
+    if not anchor:
+        return None
+    try:
+        _ = date.fromisoformat(anchor)
+    except ValueError:
+        return f"Invalid budget period anchor {anchor!r}, expected YYYY-MM-DD"
+    return None
+
+
+class Periods(NamedTuple):
+    period: BudgetPeriod = BudgetPeriod.MONTHLY
+    # the day weekly and biweekly periods are counted from, a payday
+    anchor: date | None = None
+
+    def start(self, day: date) -> date:
+        """The first day of the period holding the day."""
+        match self.period:
+            case BudgetPeriod.SEMI_MONTHLY:
+                return day.replace(day=1 if day.day < 16 else 16)
+            case BudgetPeriod.BIWEEKLY:
+                anchor = self.anchor or date.min
+                return day - timedelta(days=(day - anchor).days % 14)
+            case BudgetPeriod.WEEKLY:
+                weekday = self.anchor.weekday() if self.anchor else 0
+                return day - timedelta(days=(day.weekday() - weekday) % 7)
+            case _:
+                return day.replace(day=1)
+
+    def next(self, start: date) -> date:
+        """The first day of the period after the one starting on start."""
+        match self.period:
+            case BudgetPeriod.SEMI_MONTHLY if start.day < 16:
+                return start.replace(day=16)
+            case BudgetPeriod.SEMI_MONTHLY:
+                return start.replace(day=monthrange(start.year, start.month)[1]) + timedelta(days=1)
+            case BudgetPeriod.BIWEEKLY:
+                return start + timedelta(days=14)
+            case BudgetPeriod.WEEKLY:
+                return start + timedelta(days=7)
+            case _:
+                return (start.replace(day=28) + timedelta(days=4)).replace(day=1)
+
+    def earlier_in_year(self, day: date) -> list[date]:
+        """The starts of the periods before the day's that begin in the same year."""
+        starts: list[date] = []
+        start = self.start(date(day.year, 1, 1))
+        current = self.start(day)
+        while start < current:
+            if start.year == day.year:
+                starts.append(start)
+            start = self.next(start)
+        return starts
+
+    def label(self, start: date) -> str:
+        """The period in a summary, its month when monthly and its first day otherwise."""
+        return f"{start:%Y-%m}" if self.period == BudgetPeriod.MONTHLY else start.isoformat()
+
+    @property
+    def title(self) -> str:
+        return self.period.value.capitalize()
+
+
+MONTHLY: Final = Periods()
@@ -0,0 +1,158 @@
+"""
+Exec based plugins for third party sources and destinations.
+
+A plugin is any executable declared in the config. For every call the importer starts the
+command, writes a single JSON request to its stdin and reads a single JSON response from stdout,
+stderr is passed through to the importer's log.
+
+Request:
+    {"protocol": 1, "method": "fetch" | "write", "params": {...}, "config": {...}}
+
+Source plugins implement `fetch`, params are `{"start_date": "<ISO 8601>"}` and the response is a
+SimpleFIN style `{"accounts": [...], "errors": [...]}` document.
+
+Destination plugins implement `write`, params are `{"transactions": [...]}` and the response is
+`{"written": <count>}`.
+
+Any response may instead be `{"error": "<message>"}` to fail the call.
+
+Programs embedding the importer can pass objects of their own instead, anything with a name and the
+`fetch_data` of a `Source` or the `write_transactions` of a `Destination`, see `budget.importer`.
+"""
+
+import json
+import logging
+import shlex
+import subprocess
+from collections.abc import Sequence
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Any, Final, Protocol, Self
+
+from budget.models.simplefin import (
+    SimpleFinAccount,
+    SimpleFinResponse,
+    SimpleFinTransaction,
+    is_simplefin_response,
+)
+
+logger = logging.getLogger(__name__)
+
+PROTOCOL_VERSION: Final = 1
+DEFAULT_TIMEOUT: Final = 300
+
+
+class PluginError(Exception): ...
+
+
+class Source(Protocol):
+    @property
+    def name(self) -> str: ...
+
+    def fetch_data(self, start_date: datetime) -> list[SimpleFinAccount]: ...
+
+
+class Destination(Protocol):
+    @property
+    def name(self) -> str: ...
+
+    def write_transactions(self, transactions: Sequence[SimpleFinTransaction]) -> int: ...
+
+
+@dataclass
+class PluginConfig:
+    name: str
+    command: list[str]
+    config: dict[str, Any] = field(default_factory=dict)
+    timeout: int = DEFAULT_TIMEOUT
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not data.get("name") or not data.get("command"):
+            msg = f"Invalid plugin declaration {data!r}, name and command are required"
+            raise PluginError(msg)
+        command = data["command"]
+        return cls(
+            name=str(data["name"]),
+            command=shlex.split(command) if isinstance(command, str) else [str(arg) for arg in command],
+            config=dict(data.get("config") or {}),
+            timeout=int(data.get("timeout", DEFAULT_TIMEOUT)),
+        )
+
+
+class Plugin:
+    plugin_config: Final[PluginConfig]
+
+    def __init__(self, plugin_config: PluginConfig) -> None:
+        self.plugin_config = plugin_config
+
+    @property
+    def name(self) -> str:
+        return self.plugin_config.name
+
+    def call(self, method: str, params: dict[str, Any]) -> dict[str, Any]:
+        request = {
+            "protocol": PROTOCOL_VERSION,
+            "method": method,
+            "params": params,
+            "config": self.plugin_config.config,
+        }
+        try:
+            result = subprocess.run(  # noqa: S603 - commands come from the user's own config
+                self.plugin_config.command,
+                input=json.dumps(request),
+                capture_output=True,
+                text=True,
+                timeout=self.plugin_config.timeout,
+                check=False,
+            )
+        except (OSError, subprocess.TimeoutExpired) as e:
+            msg = f"Plugin {self.name} failed to run: {e}"
+            raise PluginError(msg) from e
+
+        for line in result.stderr.splitlines():
+            logger.info("[%s] %s", self.name, line)
+        if result.returncode != 0:
+            msg = f"Plugin {self.name} exited with status {result.returncode}"
+            raise PluginError(msg)
+
+        try:
+            response = json.loads(result.stdout)
+        except json.JSONDecodeError as e:
+            msg = f"Plugin {self.name} returned invalid JSON: {e}"
+            raise PluginError(msg) from e
+        if not isinstance(response, dict):
+            msg = f"Plugin {self.name} returned {type(response).__name__}, expected an object"
+            raise PluginError(msg)
+        if response.get("error"):
+            msg = f"Plugin {self.name} reported an error: {response['error']}"
+            raise PluginError(msg)
+        return response
+
+
+class SourcePlugin(Plugin):
+    def fetch_data(self, start_date: datetime) -> list[SimpleFinAccount]:
+        """Fetches accounts and transactions from the plugin."""
+        data = self.call("fetch", {"start_date": start_date.isoformat()})
+        if not is_simplefin_response(data):
+            msg = f"Plugin {self.name} returned an invalid response, accounts are required"
+            raise PluginError(msg)
+
+        try:
+            resp = SimpleFinResponse.from_dict(data)
+        except (KeyError, TypeError, ValueError, ArithmeticError) as e:
+            msg = f"Plugin {self.name} returned an invalid account or transaction: {e!r}"
+            raise PluginError(msg) from e
+        for error in resp.errors or []:
+            logger.warning("[%s] %s", self.name, error)
+        logger.info("Fetched %d accounts from plugin %s", len(resp.accounts), self.name)
+        return resp.accounts
+
+
+class DestinationPlugin(Plugin):
+    def write_transactions(self, transactions: Sequence[SimpleFinTransaction]) -> int:
+        """Sends the new transactions to the plugin, returning the number it wrote."""
+        data = self.call("write", {"transactions": [transaction.to_dict() for transaction in transactions]})
+        written = int(data.get("written", len(transactions)))
+        logger.info("Wrote %d transactions to plugin %s", written, self.name)
+        return written
@@ -0,0 +1,64 @@
+"""
+Protects the ID column of the transactions tabs, the key rows are deduplicated and updated by.
+
+An ID edited or cleared by hand makes the next import append the transaction again. With `warn` editing a
+cell of the column asks the editor to confirm first, with `enforce` only the spreadsheet's owner and the
+account the importer runs as can edit it. The protection is installed after an import wrote to a tab and
+replaced when the mode changes, turning it off leaves it in place to remove from the sheet's protected
+ranges. Protections added by hand are left alone.
+
+Sample config:
+```yaml
+destinations:
+  - type: sheets
+    id_protection: warn
+```
+"""
+
+import logging
+from enum import StrEnum
+from typing import Any, Final
+
+from budget.clients.google import GoogleClient
+
+logger = logging.getLogger(__name__)
+
+DESCRIPTION: Final = "Transaction IDs, written by budget-import"
+
+
+class IdProtection(StrEnum):
+    OFF = "off"
+    WARN = "warn"
+    ENFORCE = "enforce"
+
+
+def protection_requests(sheet_id: int, ranges: list[dict[str, Any]], mode: IdProtection) -> list[dict[str, Any]]:
+    """The requests replacing the tab's ID protection with one in the mode, none when it is already in place."""
+    existing = [protected for protected in ranges if protected.get("description") == DESCRIPTION]
+    warning_only = mode == IdProtection.WARN
+    if [bool(protected.get("warningOnly")) for protected in existing] == [warning_only]:
+        return []
+    requests: list[dict[str, Any]] = [
+        {"deleteProtectedRange": {"protectedRangeId": protected["protectedRangeId"]}} for protected in existing
+    ]
+    requests.append(
+        {
+            "addProtectedRange": {
+                "protectedRange": {
+                    "range": {"sheetId": sheet_id, "startColumnIndex": 0, "endColumnIndex": 1},
+                    "description": DESCRIPTION,
+                    # an enforced range can be edited by the owner and whoever added it, the importer
+                    "warningOnly": warning_only,
+                }
+            }
+        }
+    )
+    return requests
+
+
+def protect_ids(google: GoogleClient, spreadsheet_id: str, sheet_name: str, mode: IdProtection) -> None:
+    """Installs the protection of the tab's ID column in the mode, warn or enforce."""
+    sheet_id, ranges = google.protected_ranges(spreadsheet_id, sheet_name)
+    if requests := protection_requests(sheet_id, ranges, mode):
+        google.batch_update(spreadsheet_id, requests)
+        logger.info("Set the protection of the ID column of %s to %s", sheet_name, mode)
@@ -0,0 +1,109 @@
+"""
+Reads QIF statements, the plain text format older finance software and some banks still export.
+
+Only bank, cash and card sections are read, investment and list sections are skipped. QIF has no
+transaction IDs, transactions are keyed like CSV rows by a digest of their date, amount, payee and
+position among identical transactions. Categories are pre-filled, transfers (`[Account]`) aren't.
+"""
+
+import hashlib
+import logging
+from collections import Counter
+from datetime import UTC, date, datetime, time
+from decimal import Decimal
+from typing import Final
+
+from budget.csv_source import parse_amount
+from budget.models.simplefin import AccountRef, SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+ORGANIZATION: Final = SimpleFinOrganization(domain="", name="QIF", sfin_url=None)
+ACCOUNT_TYPES: Final = {"!type:bank", "!type:cash", "!type:ccard", "!type:oth a", "!type:oth l"}
+# US dates, QIF writers use slashes, dashes or an apostrophe before two digit years
+DATE_FORMATS: Final = ("%m/%d/%Y", "%m/%d/%y", "%m-%d-%Y", "%m-%d-%y", "%Y-%m-%d")
+
+
+def parse_qif_date(value: str) -> date | None:
+    text = value.strip().replace("'", "/").replace(" ", "0")
+    for date_format in DATE_FORMATS:
+        try:
+            return datetime.strptime(text, date_format).date()  # noqa: DTZ007
+        except ValueError:
+            continue
+    return None
+
+
+def records(text: str) -> list[tuple[str, dict[str, str]]]:
+    """The records of the file with the section header they are in, each as its first value per field."""
+    section = ""
+    result: list[tuple[str, dict[str, str]]] = []
+    record: dict[str, str] = {}
+    for raw_line in text.splitlines():
+        line = raw_line.strip()
+        if not line:
+            continue
+        if line.startswith("!"):
+            section = line.lower()
+        elif line == "^":
+            if record:
+                result.append((section, record))
+            record = {}
+        else:
+            # split lines (S, E, $) repeat, the transaction's own fields come first
+            _ = record.setdefault(line[0], line[1:].strip())
+    if record:
+        result.append((section, record))
+    return result
+
+
+def to_transaction(
+    record: dict[str, str], account: AccountRef, occurrences: Counter[str]
+) -> SimpleFinTransaction | None:
+    transacted = parse_qif_date(record.get("D", ""))
+    amount = parse_amount(record.get("T") or record.get("U") or "")
+    if not transacted or amount is None:
+        logger.warning("Skipping QIF transaction without a valid date or amount: %r", record)
+        return None
+    payee = record.get("P") or record.get("M") or ""
+    fingerprint = f"{transacted.isoformat()}|{amount}|{payee}"
+    occurrences[fingerprint] += 1
+    digest = hashlib.sha256(f"{fingerprint}|{occurrences[fingerprint]}".encode()).hexdigest()[:16]
+    category = record.get("L", "")
+    timestamp = datetime.combine(transacted, time(), tzinfo=UTC)
+    return SimpleFinTransaction(
+        id=f"qif-{digest}",
+        amount=Decimal(amount),
+        description=payee,
+        memo=record.get("M", ""),
+        payee=payee,
+        posted=timestamp,
+        transacted_at=timestamp,
+        category=category if category and not category.startswith("[") else None,
+        currency=account.currency or None,
+        account=account,
+    )
+
+
+def parse_qif(text: str, name: str, currency: str = "") -> list[SimpleFinAccount]:
+    """The transactions of a QIF file as one account named after the source."""
+    account = AccountRef(id=f"qif:{name}", name=name, org=ORGANIZATION.name, currency=currency)
+    occurrences: Counter[str] = Counter()
+    transactions = [
+        transaction
+        for section, record in records(text)
+        if section in ACCOUNT_TYPES and (transaction := to_transaction(record, account, occurrences))
+    ]
+    return [
+        SimpleFinAccount(
+            available_balance="",
+            balance="",
+            balance_date=0,
+            currency=currency,
+            holdings=[],
+            id=account.id,
+            name=name,
+            org=ORGANIZATION,
+            transactions=transactions,
+        )
+    ]
@@ -0,0 +1,59 @@
+import logging
+import threading
+import time
+from collections.abc import Callable
+from typing import Final
+
+logger = logging.getLogger(__name__)
+
+_limiters: dict[str, "RateLimiter"] = {}
+_limiters_lock = threading.Lock()
+
+
+class RateLimiter:
+    """
+    A thread-safe token bucket allowing `per_minute` requests per minute.
+
+    The bucket starts full so a burst of up to `per_minute` requests goes through immediately,
+    after that callers block until a token is refilled.
+    """
+
+    per_minute: Final[float]
+
+    def __init__(
+        self,
+        per_minute: float,
+        clock: Callable[[], float] = time.monotonic,
+        sleep: Callable[[float], None] = time.sleep,
+    ) -> None:
+        if per_minute <= 0:
+            msg = f"Rate limit must be positive, got {per_minute}"
+            raise ValueError(msg)
+        self.per_minute = per_minute
+        self._clock = clock
+        self._sleep = sleep
+        self._tokens = per_minute
+        self._updated = clock()
+        self._lock = threading.Lock()
+
+    def acquire(self) -> None:
+        while True:
+            with self._lock:
+                now = self._clock()
+                self._tokens = min(self.per_minute, self._tokens + (now - self._updated) * self.per_minute / 60)
+                self._updated = now
+                if self._tokens >= 1:
+                    self._tokens -= 1
+                    return
+                wait = (1 - self._tokens) * 60 / self.per_minute
+            logger.debug("Rate limited, waiting %.1fs", wait)
+            self._sleep(wait)
+
+
+def shared_limiter(key: str, per_minute: float) -> RateLimiter:
+    """Returns the limiter shared by every client talking to `key`, so all of them draw from one budget."""
+    with _limiters_lock:
+        limiter = _limiters.get(key)
+        if limiter is None or limiter.per_minute != per_minute:
+            limiter = _limiters[key] = RateLimiter(per_minute)
+        return limiter
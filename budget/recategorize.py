@@ -0,0 +1,126 @@
+import logging
+from dataclasses import dataclass
+from datetime import date
+
+from budget.categories import row_category, row_cells
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.fuzzy import PayeeMatcher
+from budget.ledger import Ledger, LedgerEntry
+from budget.main import Args, pull_sheet_edits
+from budget.models.google import Category, RowMetadata, SheetLayout, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class RecategorizeArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    since: str | None = None
+    only_uncategorized: bool = False
+
+    @property
+    def since_date(self) -> date | None:
+        return date.fromisoformat(self.since) if self.since else None
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        try:
+            _ = self.since_date
+        except ValueError:
+            errors.append(f"Invalid date {self.since!r}, expected YYYY-MM-DD")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise RecategorizeArgs.Error(msg)
+
+
+def is_edited(row: list[str], layout: SheetLayout) -> bool:
+    """Whether the row was edited by hand since its import, rows without a checksum count as unedited."""
+    if not layout.checksum:
+        return False
+    checksum_column = layout.columns().index("checksum")
+    stored = row[checksum_column] if len(row) > checksum_column else ""
+    sheet_row = SheetTransaction.from_row(row)
+    return bool(stored) and bool(sheet_row) and stored != sheet_row.checksum
+
+
+def match_rule(payee: str, mapping: dict[str, Category], matcher: PayeeMatcher | None) -> tuple[str, Category] | None:
+    """The payee of the rule matching a payee and the rule, as the import matches them."""
+    if payee not in mapping and matcher:
+        payee = matcher.match(payee) or payee
+    return (payee, mapping[payee]) if payee in mapping else None
+
+
+def original_payee(entry: LedgerEntry) -> str:
+    """The payee as the source named it, the one mapping rules are keyed by."""
+    return entry.transaction.original_payee or entry.transaction.payee
+
+
+def recategorize(args: RecategorizeArgs) -> int:
+    """
+    Applies the current mapping rules to the rows already in the transactions tabs, returning the rows changed.
+
+    Rows are matched by the payee the source named, which the ledger remembers for rows the mapping renamed.
+    Rows edited in the sheet are left alone, those the ledger marks as edited and, with the checksum column,
+    those that no longer match their checksum. Rows no rule matches keep their payee and category.
+    """
+    base = args.args
+    layout = base.layout
+    since = args.since_date
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        tabs = base.transaction_tabs(google.worksheet_titles(base.sheets_spreadsheet_id))
+        sheet_rows = {tab: google.get_rows(base.sheets_spreadsheet_id, tab) for tab in tabs}
+        entries: dict[str, LedgerEntry] = {}
+        if base.ledger_file:
+            _ = pull_sheet_edits(base, google, (row for rows in sheet_rows.values() for row in rows))
+            with Ledger(base.ledger_file) as ledger:
+                entries = {entry.transaction.row_id: entry for entry in ledger.entries()}
+        _, mapping = google.get_category_mapping(base.mapping_spreadsheet, base.mapping_range_name)
+        matcher = PayeeMatcher(mapping, base.fuzzy_threshold) if base.fuzzy_threshold else None
+
+        changed: list[SimpleFinTransaction] = []
+        recategorized = 0
+        for tab, rows in sheet_rows.items():
+            cells: dict[tuple[int, int], str] = {}
+            for index, row in enumerate(rows, start=1):
+                sheet_row = SheetTransaction.from_row(row)
+                if not sheet_row or (since and sheet_row.date < since):
+                    continue
+                if args.only_uncategorized and sheet_row.category:
+                    continue
+                entry = entries.get(sheet_row.id)
+                if (entry and entry.edited) or is_edited(row, layout):
+                    logger.debug("Keeping %s, it was edited in the sheet", sheet_row.id)
+                    continue
+                source_payee = original_payee(entry) if entry else sheet_row.payee
+                if not (matched := match_rule(source_payee, mapping, matcher)):
+                    continue
+                rule, (category, name) = matched
+                payee = name or source_payee
+                category = category or row_category(row, layout)
+                if (payee, category) == (sheet_row.payee, row_category(row, layout)):
+                    continue
+                cells.update(row_cells(index, row, layout, payee=payee, category=category))
+                recategorized += 1
+                if entry:
+                    transaction = entry.transaction
+                    transaction.original_payee = source_payee if payee != source_payee else None
+                    transaction.payee = payee
+                    transaction.category = category or None
+                    transaction.mapped = True
+                    transaction.rule = rule
+                    changed.append(transaction)
+            if cells:
+                google.update_cells(base.sheets_spreadsheet_id, tab, cells)
+                logger.info("Recategorized %d rows of %s", len({row for row, _ in cells}), tab)
+
+    if changed and base.ledger_file:
+        # the rows keep their run, the ledger now matches what the sheet shows
+        with Ledger(base.ledger_file) as ledger:
+            ledger.record(changed, RowMetadata())
+    logger.info("Recategorized %d rows", recategorized)
+    return recategorized
@@ -0,0 +1,80 @@
+"""
+Redacts sensitive transactions, like medical ones, in a spreadsheet shared with others.
+
+Transactions in a redacted category, or in a category of a redacted group, are written to the sheet with
+their payee hashed or truncated, and without their memo, description, merchant, receipt or extra data. The
+amount, date and category are kept so the budget still adds up. Only the sheet is redacted, the ledger,
+artifacts and destination plugins keep the full detail. A hashed payee is the same for every transaction of
+the payee, so they can still be counted, but common payees can be guessed from it by hashing their names.
+
+Sample config:
+```yaml
+destinations:
+  - type: sheets
+    redact_categories: [Medical, "Personal:Therapy"]
+    redact_mode: truncate
+    redact_length: 3
+```
+"""
+
+import hashlib
+import logging
+from collections.abc import Sequence
+from dataclasses import dataclass, replace
+from enum import StrEnum
+from typing import Final
+
+from budget.models.google import CATEGORY_SEPARATOR
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+REDACT_LENGTH: Final = 3
+HASH_LENGTH: Final = 10
+
+
+class RedactionMode(StrEnum):
+    HASH = "hash"
+    TRUNCATE = "truncate"
+
+
+@dataclass(frozen=True)
+class Redaction:
+    categories: frozenset[str]
+    mode: RedactionMode = RedactionMode.HASH
+    # the characters of the payee kept when truncating
+    length: int = REDACT_LENGTH
+
+    def applies(self, transaction: SimpleFinTransaction) -> bool:
+        category = transaction.category or ""
+        group = category.split(CATEGORY_SEPARATOR, 1)[0]
+        return category in self.categories or (group != category and group in self.categories)
+
+    def payee(self, payee: str) -> str:
+        if self.mode == RedactionMode.TRUNCATE:
+            return payee if len(payee) <= self.length else f"{payee[: self.length]}…"
+        return f"#{hashlib.sha256(payee.encode()).hexdigest()[:HASH_LENGTH]}"
+
+    def apply(self, transaction: SimpleFinTransaction) -> SimpleFinTransaction:
+        """The transaction as the sheet shows it, a redacted copy when its category is redacted."""
+        if not self.applies(transaction):
+            return transaction
+        return replace(
+            transaction,
+            payee=self.payee(transaction.payee),
+            description="",
+            memo="",
+            merchant=None,
+            receipt=None,
+            extra={},
+        )
+
+
+def redact(transactions: Sequence[SimpleFinTransaction], redaction: Redaction | None) -> list[SimpleFinTransaction]:
+    """The transactions with those in redacted categories replaced by their redacted copies."""
+    if not redaction:
+        return list(transactions)
+    redacted = [redaction.apply(transaction) for transaction in transactions]
+    if count := sum(1 for before, after in zip(transactions, redacted, strict=True) if before is not after):
+        logger.info("Redacted %d transactions", count)
+    return redacted
@@ -0,0 +1,137 @@
+"""
+Links refunds to the purchases they return, so refunds don't show up as income.
+
+A refund is a positive amount from the payee of a purchase made within the window before it, the purchase
+being at least as large. Purchases are looked up in the transactions tabs and in the fetched transactions,
+an exact amount wins over a partial one and a later purchase over an earlier one. A purchase refunded in
+parts takes each part until the parts add up to it.
+
+The link either gives the refund the purchase's category, so the two net out in the budgets and the summary,
+or writes the purchase's ID to a `refund_of` column for formulas to look it up.
+
+Sample config:
+```yaml
+pipeline:
+  refunds:
+    window_days: 30
+    link: column
+```
+"""
+
+import logging
+from collections import defaultdict
+from collections.abc import Iterable, Sequence
+from datetime import date, timedelta
+from decimal import Decimal
+from enum import StrEnum
+from typing import NamedTuple
+
+from budget.clients.google import transaction_date
+from budget.dedup import normalize_payee
+from budget.models.google import CATEGORY_SEPARATOR, DateField, SheetLayout, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+
+class RefundLink(StrEnum):
+    CATEGORY = "category"
+    COLUMN = "column"
+
+
+class Purchase(NamedTuple):
+    id: str
+    payee: str
+    # negative, as the sheet has it
+    amount: Decimal
+    day: date
+    category: str
+
+
+def sheet_purchases(rows: Iterable[list[str]], layout: SheetLayout) -> list[Purchase]:
+    """The purchases of a transactions tab, with the category's group when the group has a column of its own."""
+    group_column = layout.columns().index("category_group") if layout.category_groups else None
+    purchases: list[Purchase] = []
+    for row in rows:
+        sheet_row = SheetTransaction.from_row(row)
+        if not sheet_row or not sheet_row.id or sheet_row.amount >= 0:
+            continue
+        group = row[group_column] if group_column is not None and len(row) > group_column else ""
+        category = f"{group}{CATEGORY_SEPARATOR}{sheet_row.category}" if group else sheet_row.category
+        purchases.append(Purchase(sheet_row.id, sheet_row.payee, sheet_row.amount, sheet_row.date, category))
+    return purchases
+
+
+def find_refunds(
+    transactions: Sequence[SimpleFinTransaction],
+    purchases: Sequence[Purchase],
+    window_days: int,
+    date_field: DateField,
+) -> dict[int, Purchase]:
+    """The purchase each refund returns, keyed by the refund's id(), refunds are matched oldest first."""
+    by_payee: defaultdict[str, list[Purchase]] = defaultdict(list)
+    for purchase in purchases:
+        if payee := normalize_payee(purchase.payee):
+            by_payee[payee].append(purchase)
+    # what is left to refund of each purchase
+    remaining = {purchase.id: -purchase.amount for purchase in purchases}
+    window = timedelta(days=window_days)
+
+    refunds = sorted(
+        (transaction for transaction in transactions if transaction.amount > 0),
+        key=lambda transaction: (transaction_date(transaction, date_field), transaction.row_id),
+    )
+    links: dict[int, Purchase] = {}
+    for refund in refunds:
+        day = transaction_date(refund, date_field).date()
+        candidates = [
+            purchase
+            for purchase in by_payee.get(normalize_payee(refund.payee), [])
+            if day - window <= purchase.day <= day and remaining[purchase.id] >= refund.amount
+        ]
+        if not candidates:
+            continue
+        purchase = max(candidates, key=lambda purchase: (-purchase.amount == refund.amount, purchase.day))
+        remaining[purchase.id] -= refund.amount
+        links[id(refund)] = purchase
+    return links
+
+
+def link_refunds(
+    transactions: Sequence[SimpleFinTransaction],
+    rows: Iterable[list[str]],
+    layout: SheetLayout,
+    window_days: int,
+    link: RefundLink,
+) -> int:
+    """
+    Links the refunds among the transactions to their purchases, returning how many were linked.
+
+    Every fetched transaction is linked, not only the new ones, so rows already in the sheet keep the
+    link when the checksum policy compares them to the source. The sheet's rows win over the fetched
+    transactions, their category may have been edited by hand.
+    """
+    purchases = {
+        transaction.row_id: Purchase(
+            transaction.row_id,
+            transaction.payee,
+            transaction.amount,
+            transaction_date(transaction, layout.date_field).date(),
+            transaction.category or "",
+        )
+        for transaction in transactions
+        if transaction.amount < 0
+    }
+    purchases.update((purchase.id, purchase) for purchase in sheet_purchases(rows, layout))
+
+    links = find_refunds(transactions, list(purchases.values()), window_days, layout.date_field)
+    for transaction in transactions:
+        if not (purchase := links.get(id(transaction))):
+            continue
+        if link == RefundLink.COLUMN:
+            transaction.refund_of = purchase.id
+        elif purchase.category:
+            transaction.category = purchase.category
+    if links:
+        logger.info("Linked %d refunds to their purchases", len(links))
+    return len(links)
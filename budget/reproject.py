@@ -0,0 +1,119 @@
+import logging
+from dataclasses import dataclass
+from datetime import date
+
+from gspread.utils import ValueInputOption
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient, convert_to_row, transaction_date
+from budget.clients.simplefin import categorize_transactions
+from budget.fuzzy import PayeeMatcher
+from budget.layout import upgrade_layout
+from budget.ledger import Ledger
+from budget.main import Args, pull_sheet_edits, restore_ids
+from budget.models.google import DateField, GoogleSheetRow, RowMetadata, SheetTransaction
+from budget.models.simplefin import SimpleFinTransaction
+from budget.redaction import redact
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class ReprojectArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+
+    @property
+    def ledger_file(self) -> str:
+        return self.args.ledger_file or ""
+
+    def __post_init__(self) -> None:
+        if not self.args.ledger_file:
+            msg = "Invalid CLI Args \nReproject needs the ledger, set --ledger-file"
+            raise ReprojectArgs.Error(msg)
+
+
+def reset_mapping(transaction: SimpleFinTransaction) -> None:
+    """Undoes what the mapping set, so the current mapping can be applied from scratch."""
+    if transaction.original_payee:
+        transaction.payee = transaction.original_payee
+        transaction.original_payee = None
+    if transaction.mapped:
+        # the category came from the mapping unless the source or a receipt set one
+        transaction.category = transaction.receipt.category if transaction.receipt else None
+        transaction.mapped = False
+        transaction.rule = None
+
+
+def reproject(args: ReprojectArgs) -> int:
+    """
+    Rebuilds every transactions tab from the ledger with the current mapping, returning the rows written.
+
+    Payees and categories edited in the sheet are pulled into the ledger first and kept, the other rows of
+    transactions in the ledger are rewritten with the current mapping. Rows the ledger doesn't know, like
+    those imported before it existed, are kept and rows that aren't transactions, like a header, stay on top.
+    Tabs with no transactions in the ledger are left alone.
+    """
+    base = args.args
+    with Ledger(args.ledger_file) as ledger:
+        recorded = [entry.transaction for entry in ledger.entries()]
+
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        # routing doesn't depend on the payee or category, so the tabs don't change with the edits
+        sheet_rows = {
+            tab: google.get_rows(base.sheets_spreadsheet_id, tab, missing_ok=True) for tab in base.route(recorded)
+        }
+        # the rows the ledger doesn't know are kept as they are, in the tab's current columns
+        sheet_rows = {
+            tab: upgrade_layout(google, base.sheets_spreadsheet_id, tab, rows, base.layout)
+            for tab, rows in sheet_rows.items()
+        }
+        if base.row_metadata:
+            sheet_rows = {
+                tab: restore_ids(rows, google.row_ids(base.sheets_spreadsheet_id, tab)) if rows else rows
+                for tab, rows in sheet_rows.items()
+            }
+        _ = pull_sheet_edits(base, google, (row for rows in sheet_rows.values() for row in rows))
+        with Ledger(args.ledger_file) as ledger:
+            entries = ledger.entries()
+        metadata = {entry.transaction.row_id: entry.metadata for entry in entries}
+        transactions = [entry.transaction for entry in entries]
+        unedited = [entry.transaction for entry in entries if not entry.edited]
+        for transaction in unedited:
+            reset_mapping(transaction)
+
+        _, mapping = google.get_category_mapping(base.mapping_spreadsheet, base.mapping_range_name)
+        matcher = PayeeMatcher(mapping, base.fuzzy_threshold) if base.fuzzy_threshold else None
+        categorize_transactions(unedited, mapping, matcher)
+        written = 0
+        for tab, tab_transactions in base.route(transactions).items():
+            dated: list[tuple[date, GoogleSheetRow]] = [
+                (
+                    transaction_date(transaction, DateField(base.date_field)).date(),
+                    convert_to_row(transaction, base.layout, metadata[transaction.row_id]),
+                )
+                for transaction in redact(tab_transactions, base.redaction)
+            ]
+            headers: list[GoogleSheetRow] = []
+            for row in sheet_rows[tab]:
+                sheet_row = SheetTransaction.from_row(row)
+                if not sheet_row:
+                    if any(row):
+                        headers.append(list(row))
+                elif sheet_row.id not in metadata:
+                    dated.append((sheet_row.date, list(row)))
+            dated.sort(key=lambda item: item[0], reverse=True)
+            rows = [*headers, *(row for _, row in dated)]
+            google.replace_rows(base.sheets_spreadsheet_id, tab, rows, ValueInputOption.user_entered)
+            if base.row_metadata:
+                # the rows were rewritten in a new order, the IDs are attached again to where they are now
+                ids = {index: str(row[0]) for index, row in enumerate(rows, start=1) if index > len(headers)}
+                google.identify_rows(base.sheets_spreadsheet_id, tab, ids)
+            written += len(tab_transactions)
+            logger.info("Reprojected %d rows to %s", len(tab_transactions), tab)
+
+    # recorded transactions keep their run and their edits, only the mapped payee and category change
+    with Ledger(args.ledger_file) as ledger:
+        ledger.record(transactions, RowMetadata())
+    return written
@@ -0,0 +1,146 @@
+import difflib
+import logging
+import sys
+from collections.abc import Callable, Sequence
+from typing import Final
+
+from budget.models.google import Category
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+HELP: Final = (
+    "  [a]pprove  [s]kip  [c]ategory  [p]ayee  approve [A]ll remaining  skip [r]est  [q]uit without importing\n"
+)
+MAX_MATCHES: Final = 9
+
+SaveRule = Callable[[str, Category], None]
+
+
+class ReviewAbortedError(Exception): ...
+
+
+def write(text: str) -> None:
+    _ = sys.stdout.write(text)
+    _ = sys.stdout.flush()
+
+
+def describe(index: int, total: int, transaction: SimpleFinTransaction) -> str:
+    return (
+        f"\n[{index}/{total}] {transaction.transacted_at:%Y-%m-%d}  {transaction.payee}  {transaction.amount:,.2f}\n"
+        f"  category: {transaction.category or '-'}"
+        f"{f'  receipt: {transaction.receipt}' if transaction.receipt else ''}\n"
+    )
+
+
+def review_transactions(
+    transactions: Sequence[SimpleFinTransaction],
+    categories: set[str],
+    prompt: Callable[[str], str] = input,
+    save_rule: SaveRule | None = None,
+) -> list[SimpleFinTransaction]:
+    """
+    Interactively review pending transactions before they are imported.
+
+    Each transaction is shown with its proposed category and payee, the user can edit either
+    value and approve or skip the row. Only the approved transactions are returned.
+    The first time a payee without a mapping rule comes up the user is offered to map it, the
+    category applies to the payee's remaining transactions and can be saved as a new rule.
+    """
+    if not transactions:
+        return []
+
+    write(f"{len(transactions)} new transactions pending review\n{HELP}")
+    approved: list[SimpleFinTransaction] = []
+    offered: set[str] = set()
+    for index, transaction in enumerate(transactions, start=1):
+        if not transaction.mapped and transaction.payee not in offered:
+            offered.add(transaction.payee)
+            map_payee(transaction.payee, transactions[index - 1 :], categories, prompt, save_rule)
+        write(describe(index, len(transactions), transaction))
+        if not review_transaction(index, transactions, approved, categories, prompt):
+            break
+
+    logger.info("Approved %d of %d transactions", len(approved), len(transactions))
+    return approved
+
+
+def review_transaction(
+    index: int,
+    transactions: Sequence[SimpleFinTransaction],
+    approved: list[SimpleFinTransaction],
+    categories: set[str],
+    prompt: Callable[[str], str],
+) -> bool:
+    """Prompts for a single transaction, returns False when the review should stop."""
+    transaction = transactions[index - 1]
+    while True:
+        answer = prompt("> ").strip()
+        match answer:
+            case "a" | "":
+                approved.append(transaction)
+                return True
+            case "s":
+                return True
+            case "c":
+                transaction.category = prompt_category(categories, prompt)
+                write(describe(index, len(transactions), transaction))
+            case "p":
+                transaction.payee = prompt("payee: ").strip() or transaction.payee
+                write(describe(index, len(transactions), transaction))
+            case "A":
+                approved.extend(transactions[index - 1 :])
+                return False
+            case "r":
+                return False
+            case "q":
+                msg = "Review aborted, nothing was imported"
+                raise ReviewAbortedError(msg)
+            case _:
+                write(HELP)
+
+
+def map_payee(
+    payee: str,
+    transactions: Sequence[SimpleFinTransaction],
+    categories: set[str],
+    prompt: Callable[[str], str],
+    save_rule: SaveRule | None,
+) -> None:
+    """Offers to categorize an unmapped payee, optionally saving the rule to the lookup sheet."""
+    write(f"\nNo mapping rule for {payee!r}\n")
+    category = prompt_category(categories, prompt, blank="skip")
+    if not category:
+        return
+    categories.add(category)
+    for transaction in transactions:
+        if transaction.payee == payee:
+            transaction.category = category
+            transaction.mapped = True
+    if save_rule and prompt("save as a mapping rule? [y/N] ").strip().lower() == "y":
+        save_rule(payee, Category(category=category, name=None))
+        write(f"  saved {payee!r} -> {category!r}\n")
+
+
+def search_categories(query: str, categories: set[str]) -> list[str]:
+    """Categories containing the query first, then close spellings, best matches first."""
+    lowered = query.lower()
+    contains = sorted(category for category in categories if lowered in category.lower())
+    close = difflib.get_close_matches(query, sorted(categories), n=MAX_MATCHES, cutoff=0.5)
+    return list(dict.fromkeys([*contains, *close]))[:MAX_MATCHES]
+
+
+def prompt_category(categories: set[str], prompt: Callable[[str], str], blank: str = "clear") -> str | None:
+    """Prompts for a category, searching the known categories when the answer isn't an exact match."""
+    query = prompt(f"category (search, blank to {blank}): ").strip()
+    if not query or query in categories:
+        return query or None
+
+    matches = search_categories(query, categories)
+    for number, match in enumerate(matches, start=1):
+        write(f"  {number}. {match}\n")
+    answer = prompt(f"pick a number, or enter to use {query!r} as a new category: ").strip() if matches else ""
+    if answer.isdigit() and 1 <= int(answer) <= len(matches):
+        return matches[int(answer) - 1]
+    write(f"  note: {query!r} is a new category\n")
+    return query
@@ -0,0 +1,110 @@
+"""
+All-or-nothing imports, a run either writes all its rows to the sheet or leaves the sheet as it was.
+
+In transactional mode the run's appended rows are tagged with the run ID, and once appended they are read
+back and checked: each tab needs exactly one row per appended transaction, tagged with the run, and no
+other. When the check fails, or any write to the sheet before it does, like the sort after an append or
+updating changed rows, the run deletes the rows tagged with its ID and writes back the rows it updated as
+they were, then fails as it would have. Destination plugins, the ledger and summaries are only written
+once the sheet passed the check. A run interrupted at once by a second signal can't roll back, the write
+checkpoint tells the next run which rows it left behind, and the undo command deletes them.
+
+Sample config:
+```yaml
+destinations:
+  - type: sheets
+    run_id_column: true
+    transactional: true
+```
+"""
+
+import logging
+from collections import Counter
+from collections.abc import Generator, Mapping, Sequence
+from contextlib import contextmanager
+from dataclasses import dataclass, field
+
+from budget.checkpoint import WriteCheckpoint
+from budget.clients.google import GoogleClient
+from budget.models.google import GoogleSheetRow, SheetLayout
+
+logger = logging.getLogger(__name__)
+
+
+class VerificationError(Exception): ...
+
+
+@dataclass
+class SheetWrite:
+    """What a run wrote to the sheet, by tab: the IDs of the rows it appended and the rows it updated as they were."""
+
+    spreadsheet_id: str
+    run_id: str
+    layout: SheetLayout
+    appended: dict[str, list[str]] = field(default_factory=dict)
+    replaced: dict[str, dict[str, GoogleSheetRow]] = field(default_factory=dict)
+
+    def append(self, tab: str, ids: Sequence[str]) -> None:
+        self.appended.setdefault(tab, []).extend(ids)
+
+    def update(self, google: GoogleClient, tab: str, updates: Mapping[int, GoogleSheetRow]) -> None:
+        """
+        Keeps the rows about to be overwritten, keyed by their ID as sorting moves them.
+
+        The rows are read as stored, with their formulas and typed values, the displayed text of a formatted
+        amount or date would come back as text.
+        """
+        previous = self.replaced.setdefault(tab, {})
+        values = google.get_values(self.spreadsheet_id, tab, formulas=True)
+        for index in updates:
+            row = values[index - 1]
+            _ = previous.setdefault(str(row[0]), list(row))
+
+
+def verify_write(google: GoogleClient, write: SheetWrite) -> None:
+    """Reads the tabs back and checks the run's rows are those it appended, each exactly once."""
+    column = write.layout.columns().index("run_id")
+    for tab, ids in write.appended.items():
+        rows = google.get_rows(write.spreadsheet_id, tab)
+        found = Counter(row[0] for row in rows if len(row) > column and row[column] == write.run_id)
+        if found != Counter(ids):
+            missing = len(set(ids) - set(found))
+            duplicated = sum(count - 1 for count in found.values() if count > 1)
+            msg = (
+                f"The rows run {write.run_id} appended to {tab} don't match what it wrote, "
+                f"{len(ids)} expected, {sum(found.values())} found, {missing} missing and {duplicated} duplicated"
+            )
+            raise VerificationError(msg)
+    if count := sum(map(len, write.appended.values())):
+        logger.info("Verified the %d rows run %s appended", count, write.run_id)
+
+
+def rollback(google: GoogleClient, write: SheetWrite) -> None:
+    """Deletes the rows the run appended and writes back the rows it updated as they were."""
+    column = write.layout.columns().index("run_id")
+    for tab in write.appended:
+        deleted = google.delete_rows_where(write.spreadsheet_id, tab, column, write.run_id)
+        logger.info("Deleted the %d rows run %s appended to %s", deleted, write.run_id, tab)
+    for tab, previous in write.replaced.items():
+        rows = google.get_rows(write.spreadsheet_id, tab)
+        restored: dict[int, GoogleSheetRow] = {
+            index: list(previous[row[0]]) for index, row in enumerate(rows, start=1) if row and row[0] in previous
+        }
+        google.restore_rows(write.spreadsheet_id, tab, restored)
+        logger.info("Restored the %d rows run %s updated in %s", len(restored), write.run_id, tab)
+
+
+@contextmanager
+def all_or_nothing(google: GoogleClient, write: SheetWrite, checkpoint: WriteCheckpoint) -> Generator[None, None, None]:
+    """Rolls the run's writes back when the block fails, clearing the checkpoint once nothing is left behind."""
+    try:
+        yield
+    except Exception:
+        logger.warning("Run %s failed while writing to the sheet, rolling it back", write.run_id)
+        try:
+            rollback(google, write)
+        except Exception:
+            logger.exception("Rolling back run %s failed, the undo command deletes its rows", write.run_id)
+        else:
+            checkpoint.finish()
+        raise
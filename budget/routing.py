@@ -0,0 +1,147 @@
+import re
+import string
+from collections import defaultdict
+from collections.abc import Collection, Mapping, Sequence
+from datetime import date, timedelta
+from enum import StrEnum
+from typing import Final
+
+from budget.clients.google import transaction_date
+from budget.models.google import DateField
+from budget.models.simplefin import AccountRef, SimpleFinTransaction
+
+MAX_TAB_NAME: Final = 100
+# how far a source may move a transaction's date between runs, like a pending charge posting days later
+MAX_DATE_SHIFT_DAYS: Final = 14
+ARCHIVE_SUFFIX: Final = "archive"
+SAMPLE_ACCOUNT: Final = AccountRef(id="ACT-1", name="Checking", org="Bank", currency="USD")
+
+
+class TabRotation(StrEnum):
+    NONE = "none"
+    YEARLY = "yearly"
+    MONTHLY = "monthly"
+
+
+def rotated_tab(base: str, rotation: TabRotation, day: date) -> str:
+    """The tab holding transactions from `day`, e.g. `transactions-2024` or `transactions-2024-03`."""
+    match rotation:
+        case TabRotation.YEARLY:
+            return f"{base}-{day:%Y}"
+        case TabRotation.MONTHLY:
+            return f"{base}-{day:%Y-%m}"
+        case _:
+            return base
+
+
+def rotation_end(title: str, rotation: TabRotation) -> date | None:
+    """The last day a rotated tab holds transactions of, None for a tab without the rotation's suffix."""
+    match rotation:
+        case TabRotation.YEARLY if suffix := re.search(r"-(\d{4})$", title):
+            return date(int(suffix[1]), 12, 31)
+        case TabRotation.MONTHLY if (suffix := re.search(r"-(\d{4})-(\d{2})$", title)) and 1 <= int(suffix[2]) <= 12:
+            year, month = int(suffix[1]), int(suffix[2])
+            return date(year + month // 12, month % 12 + 1, 1) - timedelta(days=1)
+        case _:
+            return None
+
+
+def tab_name(name: str) -> str:
+    """The name with the characters Sheets rejects in tab names replaced."""
+    return re.sub(r"[\[\]*?/\\:']", "-", name)
+
+
+def account_tab(template: str, account: AccountRef) -> str:
+    """Formats a per-account tab name like `txns-{account.name}`, dropping characters Sheets rejects."""
+    return tab_name(template.format(account=account)).strip()[:MAX_TAB_NAME]
+
+
+def template_prefix(template: str) -> str:
+    """The text every tab of the template starts with, the part before its first placeholder."""
+    return tab_name(template.split("{", 1)[0]).strip()
+
+
+def validate_template(template: str) -> str | None:
+    """Returns why a tab template can't be formatted, or None when it is valid."""
+    try:
+        _ = account_tab(template, SAMPLE_ACCOUNT)
+    except (KeyError, AttributeError, IndexError, ValueError) as e:
+        return f"Invalid account tab template {template!r}: {e}"
+    # without a prefix of its own any tab would look like one of the template's
+    if not template_prefix(template):
+        return f"Invalid account tab template {template!r}: it must start with text, like txns-{{account.name}}"
+    return None
+
+
+def template_pattern(template: str) -> str:
+    """A regular expression matching the tab names the template formats, each placeholder any text."""
+    parts = [
+        f"{re.escape(tab_name(literal))}{'.+' if field is not None else ''}"
+        for literal, field, _, _ in string.Formatter().parse(template.strip())
+    ]
+    return "".join(parts)
+
+
+def is_routed_tab(
+    title: str,
+    base: str,
+    rotation: TabRotation = TabRotation.NONE,
+    template: str | None = None,
+    excluded: Collection[str] = (),
+) -> bool:
+    """
+    Whether a tab could hold imported transactions, used to find rows across rotated and per-account tabs.
+
+    Only the names routing gives tabs match, the base or a per-account tab with the rotation's suffix, and the
+    base tab itself, which holds the rows imported before rotating. The excluded tabs, those of the other
+    ranges the import writes, and the archive tab never match.
+    """
+    if title in excluded or title == f"{base}-{ARCHIVE_SUFFIX}":
+        return False
+    if title == base:
+        return True
+    names = [re.escape(base), *([template_pattern(template)] if template else [])]
+    match rotation:
+        case TabRotation.YEARLY:
+            suffix = r"-\d{4}"
+        case TabRotation.MONTHLY:
+            suffix = r"-\d{4}-\d{2}"
+        case _:
+            suffix = ""
+    return re.fullmatch(f"(?:{'|'.join(names)}){suffix}", title) is not None
+
+
+def follow_written(
+    tabs: Mapping[str, Sequence[SimpleFinTransaction]], written: Mapping[str, set[str]]
+) -> dict[str, list[SimpleFinTransaction]]:
+    """
+    Moves the transactions already written to another tab back to it, keeping their order within each tab.
+
+    A date that moved across a rotation boundary routes a transaction to the next period's tab, the tab
+    holding its row keeps it there so it is deduplicated and updated instead of imported again.
+    """
+    found = {row_id: tab for tab, ids in written.items() for row_id in ids}
+    followed: defaultdict[str, list[SimpleFinTransaction]] = defaultdict(list)
+    for tab, transactions in tabs.items():
+        for transaction in transactions:
+            followed[found.get(transaction.row_id, tab)].append(transaction)
+    return dict(followed)
+
+
+def route_transactions(
+    transactions: Sequence[SimpleFinTransaction],
+    base: str,
+    rotation: TabRotation,
+    date_field: DateField,
+    template: str | None = None,
+) -> dict[str, list[SimpleFinTransaction]]:
+    """
+    Groups transactions by destination tab, keeping their order within each tab.
+
+    With a template each account gets its own tab, which is rotated like the base tab would be.
+    """
+    tabs: defaultdict[str, list[SimpleFinTransaction]] = defaultdict(list)
+    for transaction in transactions:
+        tab = account_tab(template, transaction.account) if template and transaction.account else base
+        tabs[rotated_tab(tab, rotation, transaction_date(transaction, date_field).date())].append(transaction)
+    return dict(tabs)
@@ -0,0 +1,184 @@
+"""
+gRPC interface mirroring the REST API, requires the optional `grpc` dependencies.
+
+The service is defined in `budget/proto/budget.proto` and loaded at runtime, so no generated code is checked in.
+"""
+
+import hmac
+import logging
+import time
+from collections.abc import Callable, Generator
+from concurrent.futures import ThreadPoolExecutor
+from typing import TYPE_CHECKING, Any, Final, override
+
+import grpc
+
+from budget.clients.google import GoogleClient
+from budget.models.google import Category
+from budget.models.simplefin import SimpleFinTransaction
+from budget.runs import ProgressEvent, Run, RunTrigger
+
+if TYPE_CHECKING:
+    from budget.daemon import Daemon
+
+logger = logging.getLogger(__name__)
+
+POLL_INTERVAL: Final = 0.25
+TRANSACTION_FIELDS: Final = frozenset(
+    (
+        "id",
+        "payee",
+        "amount",
+        "description",
+        "transacted_at",
+        "posted",
+        "category",
+        "receipt",
+        "currency",
+        "original_amount",
+        "original_currency",
+    )
+)
+MAX_WORKERS: Final = 4
+
+protos, services = grpc.protos_and_services("budget/proto/budget.proto")
+
+
+def transaction_message(transaction: SimpleFinTransaction) -> Any:
+    data = transaction.to_dict()
+    return protos.Transaction(**{key: data[key] or "" for key in TRANSACTION_FIELDS}, tags=transaction.tags)
+
+
+def event_message(event: ProgressEvent) -> Any:
+    return protos.ProgressEvent(
+        stage=event.stage, count=event.count, at=event.at.isoformat(), message=event.message or ""
+    )
+
+
+def run_message(run: Run) -> Any:
+    return protos.Run(
+        id=run.id,
+        trigger=run.trigger,
+        status=run.status,
+        started_at=run.started_at.isoformat(),
+        finished_at=run.finished_at.isoformat() if run.finished_at else "",
+        error=run.error or "",
+        transactions=[transaction_message(transaction) for transaction in run.transactions],
+        events=[event_message(event) for event in run.events],
+        notices=run.notices,
+    )
+
+
+def mappings_message(mapping: dict[str, Category]) -> Any:
+    return protos.ListMappingsResponse(
+        mappings=[
+            protos.Mapping(payee=payee, category=rule.category or "", name=rule.name or "")
+            for payee, rule in mapping.items()
+        ]
+    )
+
+
+class BudgetImporterServicer(services.BudgetImporterServicer):  # type: ignore[misc]
+    scheduler: Final["Daemon"]
+
+    def __init__(self, scheduler: "Daemon") -> None:
+        self.scheduler = scheduler
+
+    def Import(self, request: Any, context: grpc.ServicerContext) -> Generator[Any, None, None]:  # noqa: N802
+        del request
+        run = self.scheduler.start_import(RunTrigger.API)
+        if run is None:
+            context.abort(grpc.StatusCode.FAILED_PRECONDITION, "An import is already in progress")
+            return
+
+        sent = 0
+        while True:
+            finished = run.finished_at is not None
+            events = run.events[sent:]
+            for event in events:
+                yield protos.ImportEvent(run_id=run.id, progress=event_message(event))
+            sent += len(events)
+            if finished or not context.is_active():
+                break
+            time.sleep(POLL_INTERVAL)
+        yield protos.ImportEvent(run_id=run.id, run=run_message(run))
+
+    def ListRuns(self, request: Any, context: grpc.ServicerContext) -> Any:  # noqa: N802
+        del request, context
+        return protos.ListRunsResponse(runs=[run_message(run) for run in reversed(self.scheduler.history)])
+
+    def GetRun(self, request: Any, context: grpc.ServicerContext) -> Any:  # noqa: N802
+        run = self.scheduler.get_run(request.id)
+        if run is None:
+            context.abort(grpc.StatusCode.NOT_FOUND, "Run not found")
+        return run_message(run)
+
+    def PendingReview(self, request: Any, context: grpc.ServicerContext) -> Any:  # noqa: N802
+        del request
+        try:
+            pending = self.scheduler.pending_review()
+        except Exception as e:
+            logger.exception("PendingReview failed")
+            context.abort(grpc.StatusCode.UNAVAILABLE, str(e) or type(e).__name__)
+        if pending is None:
+            context.abort(grpc.StatusCode.FAILED_PRECONDITION, "An import is in progress")
+        return protos.PendingReviewResponse(transactions=[transaction_message(t) for t in pending])
+
+    def ListMappings(self, request: Any, context: grpc.ServicerContext) -> Any:  # noqa: N802
+        del request
+        args = self.scheduler.args
+        try:
+            with GoogleClient(args.google_credentials) as google:
+                _, mapping = google.get_category_mapping(args.mapping_spreadsheet, args.mapping_range_name)
+        except Exception as e:
+            logger.exception("ListMappings failed")
+            context.abort(grpc.StatusCode.UNAVAILABLE, str(e) or type(e).__name__)
+        return mappings_message(mapping)
+
+    def PutMappings(self, request: Any, context: grpc.ServicerContext) -> Any:  # noqa: N802
+        args = self.scheduler.args
+        mapping = {
+            rule.payee: Category(category=rule.category or None, name=rule.name or None) for rule in request.mappings
+        }
+        try:
+            with GoogleClient(args.google_credentials) as google:
+                google.update_category_mapping(args.mapping_spreadsheet, args.mapping_range_name, mapping)
+        except Exception as e:
+            logger.exception("PutMappings failed")
+            context.abort(grpc.StatusCode.UNAVAILABLE, str(e) or type(e).__name__)
+        return mappings_message(mapping)
+
+
+class TokenInterceptor(grpc.ServerInterceptor):  # type: ignore[misc]
+    """Rejects calls without an `authorization: Bearer <token>` metadata entry."""
+
+    token: Final[str]
+
+    def __init__(self, token: str) -> None:
+        self.token = token
+
+        def abort(request: Any, context: grpc.ServicerContext) -> None:
+            del request
+            context.abort(grpc.StatusCode.UNAUTHENTICATED, "Unauthorized")
+
+        self._abort_handler = grpc.unary_unary_rpc_method_handler(abort)
+
+    @override
+    def intercept_service(
+        self, continuation: Callable[[Any], Any], handler_call_details: grpc.HandlerCallDetails
+    ) -> Any:
+        metadata = dict(handler_call_details.invocation_metadata)
+        if hmac.compare_digest(str(metadata.get("authorization", "")).encode(), f"Bearer {self.token}".encode()):
+            return continuation(handler_call_details)
+        return self._abort_handler
+
+
+def serve_grpc(scheduler: "Daemon", host: str, port: int, api_token: str | None = None) -> grpc.Server:
+    """Starts the gRPC server on its own worker threads."""
+    interceptors = [TokenInterceptor(api_token)] if api_token else []
+    server = grpc.server(ThreadPoolExecutor(max_workers=MAX_WORKERS), interceptors=interceptors)
+    services.add_BudgetImporterServicer_to_server(BudgetImporterServicer(scheduler), server)
+    _ = server.add_insecure_port(f"{host}:{port}")
+    server.start()
+    logger.info("Serving gRPC on %s:%d", host, port)
+    return server
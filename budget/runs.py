@@ -0,0 +1,73 @@
+from collections.abc import Callable
+from dataclasses import dataclass, field
+from datetime import UTC, datetime
+from enum import StrEnum
+from uuid import uuid4
+
+from budget.models.simplefin import SimpleFinTransaction
+
+
+class RunStatus(StrEnum):
+    RUNNING = "running"
+    SUCCEEDED = "succeeded"
+    FAILED = "failed"
+    SKIPPED = "skipped"
+
+
+class RunTrigger(StrEnum):
+    SCHEDULE = "schedule"
+    MANUAL = "manual"
+    API = "api"
+    WATCH = "watch"
+
+
+class RunStage(StrEnum):
+    FETCHED_DOCUMENTS = "fetched_documents"
+    FETCHED_ACCOUNTS = "fetched_accounts"
+    CATEGORIZED = "categorized"
+    DEDUPLICATED = "deduplicated"
+    INSERTED = "inserted"
+    UPDATED = "updated"
+    NOTICE = "notice"
+
+
+def new_run_id() -> str:
+    return uuid4().hex[:12]
+
+
+@dataclass(frozen=True)
+class ProgressEvent:
+    stage: RunStage
+    count: int
+    at: datetime = field(default_factory=lambda: datetime.now(UTC))
+    message: str | None = None
+
+
+ProgressCallback = Callable[[ProgressEvent], None]
+
+
+def report(progress: ProgressCallback | None, stage: RunStage, count: int) -> None:
+    if progress:
+        progress(ProgressEvent(stage=stage, count=count))
+
+
+def notify(progress: ProgressCallback | None, message: str) -> None:
+    """Reports a notice from an upstream API, e.g. a bank that needs to be re-linked."""
+    if progress:
+        progress(ProgressEvent(stage=RunStage.NOTICE, count=1, message=message))
+
+
+@dataclass
+class Run:
+    id: str
+    trigger: RunTrigger
+    started_at: datetime
+    status: RunStatus = RunStatus.RUNNING
+    finished_at: datetime | None = None
+    transactions: list[SimpleFinTransaction] = field(default_factory=list)
+    events: list[ProgressEvent] = field(default_factory=list)
+    error: str | None = None
+
+    @property
+    def notices(self) -> list[str]:
+        return [event.message for event in self.events if event.stage == RunStage.NOTICE and event.message]
@@ -0,0 +1,452 @@
+"""
+JSON Schema of the version 2 config and a validator for the subset of it used here.
+
+The validator understands type, enum, const, minimum, properties, required, additionalProperties,
+items and oneOf, where oneOf picks the branch whose `type` property matches, like the entries of the
+sources and destinations lists. `budget-import config-schema` prints the schema for editor integration.
+"""
+
+import difflib
+import json
+import re
+import sys
+from collections.abc import Mapping, Sequence
+from dataclasses import dataclass
+from typing import Any, Final
+
+import yaml
+
+from budget.artifacts import ArtifactFormat
+from budget.checksum import CONFLICT_FIELDS, ChecksumPolicy, ConflictSide
+from budget.clients.simplefin import StrictMode
+from budget.dedup import DedupKey
+from budget.models.google import DateField, DateFormat
+from budget.models.simplefin import AccountPurpose
+from budget.periods import BudgetPeriod
+from budget.protection import IdProtection
+from budget.redaction import RedactionMode
+from budget.refunds import RefundLink
+from budget.routing import TabRotation
+
+Path = tuple[str | int, ...]
+
+STRING: Final = {"type": "string"}
+STRINGS: Final = {"type": ["string", "array"], "items": STRING}
+BOOLEAN: Final = {"type": "boolean"}
+INTEGER: Final = {"type": "integer", "minimum": 0}
+NUMBER: Final = {"type": "number"}
+AMOUNT: Final = {"type": ["number", "string"]}
+TYPES: Final = {
+    "string": (str,),
+    "integer": (int,),
+    "number": (int, float),
+    "boolean": (bool,),
+    "object": (Mapping,),
+    "array": (list,),
+    "null": (type(None),),
+}
+
+
+def section(properties: dict[str, Any], *required: str) -> dict[str, Any]:
+    return {"type": "object", "properties": properties, "required": list(required), "additionalProperties": False}
+
+
+def entry(kind: str, properties: dict[str, Any], *required: str) -> dict[str, Any]:
+    """A sources or destinations list entry, told apart by its type."""
+    return section({"type": {"const": kind}} | properties, "type", *required)
+
+
+def enum(values: Sequence[str]) -> dict[str, Any]:
+    return {"type": "string", "enum": list(values)}
+
+
+PLUGIN: Final = {
+    "name": STRING,
+    "command": STRINGS,
+    "config": {"type": "object"},
+    "timeout": INTEGER,
+}
+# how the SimpleFin and Paperless clients connect
+TRANSPORT: Final = {
+    "timeout": NUMBER,
+    "tls_handshake_timeout": NUMBER,
+    "keep_alive": BOOLEAN,
+    "max_idle_connections": INTEGER,
+}
+# how the CSV files of the csv source and the statement file sources are read
+STATEMENT: Final = {
+    "profile": STRING,
+    "currency": STRING,
+    "columns": {"type": "object", "additionalProperties": {"type": ["string", "integer"]}},
+    "date_format": STRING,
+    "negate": BOOLEAN,
+    "delimiter": STRING,
+    "header": BOOLEAN,
+    "encoding": STRING,
+}
+SOURCES: Final = [
+    entry(
+        "simplefin",
+        {
+            "access_url": STRING,
+            "username": STRING,
+            "password": STRING,
+            "rate_limit": NUMBER,
+            "window_days": INTEGER,
+            "strict": {"type": ["string", "boolean"], "enum": [*StrictMode, True, False]},
+            "include_pending": BOOLEAN,
+            "setup_token": STRING,
+            "claim_file": STRING,
+            "owner": STRING,
+            **TRANSPORT,
+        },
+    ),
+    entry("paperless", {"url": STRING, "token": STRING, **TRANSPORT}),
+    entry(
+        "sheet",
+        {"name": STRING, "spreadsheet_id": STRING, "range_name": STRING, "currency": STRING},
+        "name",
+        "spreadsheet_id",
+        "range_name",
+    ),
+    entry("plugin", PLUGIN, "name", "command"),
+    entry("basiq", {"name": STRING, "user_id": STRING, "api_key": STRING, "refresh": BOOLEAN}, "user_id"),
+    entry(
+        "truelayer",
+        {
+            "name": STRING,
+            "client_id": STRING,
+            "client_secret": STRING,
+            "token_file": STRING,
+            "sandbox": BOOLEAN,
+            "connections": {
+                "type": "array",
+                "items": section({"name": STRING, "refresh_token": STRING}, "name"),
+            },
+        },
+        "client_id",
+        "token_file",
+    ),
+    entry(
+        "saltedge",
+        {
+            "name": STRING,
+            "app_id": STRING,
+            "secret": STRING,
+            "customer_id": STRING,
+            "connection_ids": {"type": "array", "items": STRING},
+            "categories": {"type": ["boolean", "object"], "additionalProperties": STRING},
+        },
+        "app_id",
+    ),
+    entry(
+        "wise",
+        {"name": STRING, "api_token": STRING, "profile_id": {"type": ["string", "integer"]}, "sandbox": BOOLEAN},
+        "profile_id",
+    ),
+    entry(
+        "csv",
+        {"name": STRING, "path": STRING, **STATEMENT},
+        "name",
+        "path",
+    ),
+    entry(
+        "imap",
+        {"name": STRING, "url": STRING, "senders": STRINGS, "since_days": INTEGER, **STATEMENT},
+        "name",
+        "url",
+        "senders",
+    ),
+    entry(
+        "sftp",
+        {
+            "name": STRING,
+            "host": STRING,
+            "port": INTEGER,
+            "username": STRING,
+            "key_file": STRING,
+            "key_passphrase": STRING,
+            "known_hosts": STRING,
+            "path": STRING,
+            "processed_dir": STRING,
+            **STATEMENT,
+        },
+        "name",
+        "host",
+        "username",
+        "key_file",
+        "path",
+    ),
+    entry(
+        "bucket",
+        {
+            "name": STRING,
+            "url": STRING,
+            "endpoint": STRING,
+            "region": STRING,
+            "access_key_id": STRING,
+            "secret_access_key": STRING,
+            "session_token": STRING,
+            **STATEMENT,
+        },
+        "name",
+        "url",
+    ),
+    entry("drive", {"name": STRING, "folder_id": STRING, **STATEMENT}, "name", "folder_id"),
+]
+DESTINATIONS: Final = [
+    entry(
+        "sheets",
+        {
+            "credentials": STRING,
+            "spreadsheet_id": STRING,
+            "range_name": STRING,
+            "mapping_spreadsheet_id": STRING,
+            "mapping_range_name": STRING,
+            "unmapped_range_name": STRING,
+            "budget_range_name": STRING,
+            "budget_rollover": BOOLEAN,
+            "budget_period": enum(list(BudgetPeriod)),
+            "budget_period_anchor": STRING,
+            "balances_range_name": STRING,
+            "funds_range_name": STRING,
+            "holdings_range_name": STRING,
+            "date_format": enum(list(DateFormat)),
+            "date_field": enum(list(DateField)),
+            "checksum_policy": enum(list(ChecksumPolicy)),
+            "conflict_fields": section({name: enum(list(ConflictSide)) for name in CONFLICT_FIELDS}),
+            "conflicts_range_name": STRING,
+            "summary_range_name": STRING,
+            "summary_charts": BOOLEAN,
+            "row_metadata": BOOLEAN,
+            "id_protection": enum(list(IdProtection)),
+            "tab_rotation": enum(list(TabRotation)),
+            "account_tab_template": STRING,
+            "category_groups": BOOLEAN,
+            "status_column": BOOLEAN,
+            "merchant_columns": BOOLEAN,
+            "trade_columns": BOOLEAN,
+            "redact_categories": STRINGS,
+            "redact_mode": enum(list(RedactionMode)),
+            "redact_length": INTEGER,
+            "extra_columns": STRINGS,
+            "currency_column": BOOLEAN,
+            "currency_symbol": STRING,
+            "currency_symbol_after": BOOLEAN,
+            "run_id_column": BOOLEAN,
+            "import_metadata": BOOLEAN,
+            "transactional": BOOLEAN,
+        },
+    ),
+    entry("plugin", PLUGIN, "name", "command"),
+]
+ACCOUNT: Final = section(
+    {"name": STRING, "ids": STRINGS, "owner": STRING, "purpose": enum(list(AccountPurpose))}, "name", "ids"
+)
+FUND: Final = section(
+    {"name": STRING, "monthly": AMOUNT, "categories": STRINGS, "start": STRING, "balance": AMOUNT},
+    "name",
+    "monthly",
+    "categories",
+    "start",
+)
+CATEGORY_STYLE: Final = section({"emoji": STRING, "color": {"type": "string", "pattern": "^#[0-9a-fA-F]{6}$"}})
+EXCLUSION: Final = section(
+    {
+        "payee": STRING,
+        "account": STRING,
+        "min_amount": AMOUNT,
+        "max_amount": AMOUNT,
+        "owner": STRING,
+        "purpose": enum(list(AccountPurpose)),
+    }
+)
+CARD_PAYMENT: Final = section({"bank": STRING, "card": STRING, "payee": STRING}, "bank", "card")
+PIPELINE: Final = section(
+    {
+        "rules": section(
+            {
+                "fuzzy_threshold": NUMBER,
+                "wasm": STRING,
+                "learn": BOOLEAN,
+                "learn_threshold": INTEGER,
+                "categories": {"type": "object", "additionalProperties": CATEGORY_STYLE},
+            }
+        ),
+        "filters": section(
+            {"exclusions": {"type": "array", "items": EXCLUSION}, "min_amount": AMOUNT, "aggregate_small": BOOLEAN}
+        ),
+        "enrichment": section(
+            {
+                "fx": section({"base_currency": STRING, "rates": {"type": "object", "additionalProperties": AMOUNT}}),
+                "merchants": section({"dataset": STRING, "api_url": STRING, "api_token": STRING}),
+            }
+        ),
+        "dedup": section({"key": enum(list(DedupKey)), "cross_source": BOOLEAN}),
+        "transfers": section(
+            {"category": STRING, "window_days": INTEGER, "card_payments": {"type": "array", "items": CARD_PAYMENT}}
+        ),
+        "refunds": section({"window_days": INTEGER, "link": enum(list(RefundLink))}),
+    }
+)
+# options outside of the pipeline, each can be written flat as `web_port` or nested as `web: {port: ...}`
+SETTINGS: Final = {
+    "interactive": BOOLEAN,
+    "workers": INTEGER,
+    "lookback_days": INTEGER,
+    "trace_http": BOOLEAN,
+    "artifacts_dir": STRING,
+    "artifacts_format": enum(list(ArtifactFormat)),
+    "metrics_file": STRING,
+    "metrics_pushgateway_url": STRING,
+    "metrics_pushgateway_job": STRING,
+    "metrics_statsd_address": STRING,
+    "metrics_statsd_prefix": STRING,
+    "sentry_dsn": STRING,
+    "sentry_environment": STRING,
+    "alert_webhook_url": STRING,
+    "web_host": STRING,
+    "web_port": INTEGER,
+    "api_token": STRING,
+    "grpc_port": INTEGER,
+    "daemon_interval": INTEGER,
+    "circuit_failure_threshold": INTEGER,
+    "circuit_cooldown": INTEGER,
+    "digest_to": STRINGS,
+    "digest_from": STRING,
+    "digest_day": STRING,
+    "digest_template": STRING,
+    "smtp_url": STRING,
+    "archive_months": INTEGER,
+    "archive_tab": STRING,
+    "backfill_window_days": INTEGER,
+    "csv_profiles_dir": STRING,
+    "state_file": STRING,
+    "ledger_file": STRING,
+    "sync_rules": BOOLEAN,
+    "watch_dir": STRING,
+    "watch_profile": STRING,
+    "watch_currency": STRING,
+    "watch_interval": INTEGER,
+}
+
+
+def settings_properties() -> dict[str, Any]:
+    properties: dict[str, Any] = dict(SETTINGS)
+    for name, schema in SETTINGS.items():
+        group, separator, key = name.partition("_")
+        if separator:
+            properties.setdefault(group, section({}))["properties"][key] = schema
+    return properties
+
+
+CONFIG_SCHEMA: Final = {
+    "$schema": "https://json-schema.org/draft/2020-12/schema",
+    "title": "budget-import config",
+    **section(
+        {
+            "version": {"type": "integer", "enum": [2]},
+            "sources": {"type": "array", "items": {"oneOf": SOURCES}},
+            "pipeline": PIPELINE,
+            "destinations": {"type": "array", "items": {"oneOf": DESTINATIONS}},
+            "accounts": {"type": "array", "items": ACCOUNT},
+            "funds": {"type": "array", "items": FUND},
+            **settings_properties(),
+        }
+    ),
+}
+
+
+def is_type(value: Any, kind: str) -> bool:
+    # bool is an int in Python but not a number in JSON
+    return isinstance(value, TYPES[kind]) and (kind == "boolean" or not isinstance(value, bool))
+
+
+def format_path(path: Path) -> str:
+    text = "".join(f"[{part}]" if isinstance(part, int) else f".{part}" for part in path).lstrip(".")
+    return text or "(top level)"
+
+
+def validate(value: Any, schema: Mapping[str, Any], path: Path = ()) -> list[tuple[Path, str]]:
+    """Returns the location and description of every violation."""
+    kinds = schema.get("type")
+    if kinds and not any(is_type(value, kind) for kind in ([kinds] if isinstance(kinds, str) else kinds)):
+        expected = kinds if isinstance(kinds, str) else " or ".join(kinds)
+        return [(path, f"expected {expected}, got {type(value).__name__}")]
+    if "const" in schema and value != schema["const"]:
+        return [(path, f"expected {schema['const']!r}")]
+    if "enum" in schema and value not in schema["enum"]:
+        return [(path, f"{value!r} is not one of {', '.join(str(option) for option in schema['enum'])}")]
+    if "minimum" in schema and value < schema["minimum"]:
+        return [(path, f"must be at least {schema['minimum']}")]
+    if "pattern" in schema and isinstance(value, str) and not re.search(schema["pattern"], value):
+        return [(path, f"{value!r} doesn't match {schema['pattern']}")]
+    if "oneOf" in schema:
+        return validate_branch(value, schema["oneOf"], path)
+    if isinstance(value, Mapping):
+        return validate_object(value, schema, path)
+    if isinstance(value, list) and "items" in schema:
+        return [error for index, item in enumerate(value) for error in validate(item, schema["items"], (*path, index))]
+    return []
+
+
+def validate_object(value: Mapping[str, Any], schema: Mapping[str, Any], path: Path) -> list[tuple[Path, str]]:
+    properties: Mapping[str, Any] = schema.get("properties", {})
+    additional = schema.get("additionalProperties", True)
+    errors = [(path, f"missing required key {key!r}") for key in schema.get("required", []) if key not in value]
+    for key, item in value.items():
+        if key in properties:
+            errors.extend(validate(item, properties[key], (*path, key)))
+        elif isinstance(additional, Mapping):
+            errors.extend(validate(item, additional, (*path, key)))
+        elif not additional:
+            close = difflib.get_close_matches(str(key), list(properties), n=1)
+            errors.append(((*path, key), f"unknown key{f', did you mean {close[0]!r}?' if close else ''}"))
+    return errors
+
+
+def validate_branch(value: Any, branches: Sequence[Mapping[str, Any]], path: Path) -> list[tuple[Path, str]]:
+    kinds = [branch["properties"]["type"]["const"] for branch in branches]
+    kind = value.get("type") if isinstance(value, Mapping) else None
+    if kind not in kinds:
+        return [((*path, "type"), f"{kind!r} is not one of {', '.join(kinds)}")]
+    return validate(value, branches[kinds.index(kind)], path)
+
+
+def line_numbers(text: str) -> dict[Path, int]:
+    """The 1-based line of every key and list item in a YAML document."""
+    lines: dict[Path, int] = {}
+
+    def walk(node: yaml.Node, path: Path) -> None:
+        _ = lines.setdefault(path, node.start_mark.line + 1)
+        if isinstance(node, yaml.MappingNode):
+            for key, child in node.value:
+                lines[(*path, key.value)] = key.start_mark.line + 1
+                walk(child, (*path, key.value))
+        elif isinstance(node, yaml.SequenceNode):
+            for index, child in enumerate(node.value):
+                walk(child, (*path, index))
+
+    if root := yaml.compose(text):
+        walk(root, ())
+    return lines
+
+
+def describe_errors(errors: Sequence[tuple[Path, str]], source: str, lines: Mapping[Path, int]) -> list[str]:
+    """Formats errors as `file:line: path: message`, using the closest known line for each path."""
+    described: list[str] = []
+    for path, message in errors:
+        line = next((lines[path[:end]] for end in range(len(path), -1, -1) if path[:end] in lines), None)
+        location = f"{source}:{line}" if line else source
+        described.append(f"{location}: {format_path(path)}: {message}")
+    return described
+
+
+@dataclass()
+class ConfigSchemaArgs: ...
+
+
+def print_schema(args: ConfigSchemaArgs) -> None:
+    del args
+    json.dump(CONFIG_SCHEMA, sys.stdout, indent=2)
+    _ = sys.stdout.write("\n")
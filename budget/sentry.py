@@ -0,0 +1,82 @@
+"""
+Error reporting to Sentry, or GlitchTip which speaks the same protocol, so failures of an importer
+running on a headless box are captured with their stack traces instead of scrolling out of the journal.
+
+Errors of an import are reported with the run attached, its ID, trigger and the counts of the stages
+it got through. Log records become breadcrumbs of the events, they aren't reported on their own.
+Requires the sentry extra, `pip install budget[sentry]`.
+
+Sample config:
+```yaml
+sentry:
+  dsn: https://key@glitchtip.example.com/1
+  environment: home-server
+```
+"""
+
+import logging
+from collections.abc import Generator
+from contextlib import contextmanager
+
+from budget.__about__ import __version__
+from budget.config import ConfigError
+from budget.metrics import stage_counts
+from budget.runs import Run
+
+logger = logging.getLogger(__name__)
+
+_enabled = False
+
+
+def configure(dsn: str | None, environment: str | None = None) -> None:
+    """Starts reporting errors to the DSN, nothing is reported without one."""
+    global _enabled  # noqa: PLW0603
+    if not dsn:
+        return
+    try:
+        import sentry_sdk  # noqa: PLC0415 - optional dependency
+        from sentry_sdk.integrations.logging import LoggingIntegration  # noqa: PLC0415
+    except ImportError as e:
+        msg = "Sentry reporting requires the sentry extra, install budget[sentry]"
+        raise ConfigError(msg) from e
+
+    _ = sentry_sdk.init(
+        dsn=dsn,
+        environment=environment,
+        release=f"budget@{__version__}",
+        integrations=[LoggingIntegration(level=logging.INFO, event_level=None)],
+        send_default_pii=False,
+    )
+    _enabled = True
+    logger.info("Reporting errors to Sentry")
+
+
+def capture_error(error: BaseException, run: Run | None = None) -> None:
+    """Reports the error, with the run it failed when there is one. The same error is only reported once."""
+    if not _enabled:
+        return
+    import sentry_sdk  # noqa: PLC0415 - optional dependency
+
+    with run_scope(run):
+        _ = sentry_sdk.capture_exception(error)
+
+
+@contextmanager
+def run_scope(run: Run | None) -> Generator[None, None, None]:
+    import sentry_sdk  # noqa: PLC0415 - optional dependency
+
+    with sentry_sdk.new_scope() as scope:
+        if run:
+            scope.set_tag("run_id", run.id)
+            scope.set_tag("trigger", run.trigger.value)
+            scope.set_context(
+                "run",
+                {
+                    "id": run.id,
+                    "trigger": run.trigger.value,
+                    "started_at": run.started_at.isoformat(),
+                    "transactions": stage_counts(run),
+                    "notices": run.notices,
+                },
+            )
+        yield
@@ -0,0 +1,100 @@
+"""
+Reads transactions from other Google Sheets, e.g. a partner's budget or a legacy spreadsheet.
+
+Each sheet becomes one account whose rows go through the same exclusion, categorization and dedup
+steps as the bank transactions, so several sheets can be consolidated into the destination sheet.
+Rows keep the ID from the source sheet, rows without one are keyed by their checksum, identical rows
+like two equal coffees on the same day are told apart by their order.
+
+Sample config:
+```yaml
+sources:
+  - type: sheet
+    name: partner
+    spreadsheet_id: 4d5e6f
+    range_name: transactions
+    currency: USD
+```
+"""
+
+import logging
+from collections import Counter
+from datetime import UTC, datetime, time
+from typing import Any, NamedTuple, Self
+
+from budget.clients.google import GoogleClient
+from budget.config import ConfigError
+from budget.models.google import SheetTransaction
+from budget.models.simplefin import AccountRef, SimpleFinAccount, SimpleFinOrganization, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+ORGANIZATION = SimpleFinOrganization(domain="docs.google.com", name="Google Sheets", sfin_url=None)
+
+
+class SheetSource(NamedTuple):
+    name: str
+    spreadsheet_id: str
+    range_name: str
+    currency: str = ""
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not all(data.get(key) for key in ("name", "spreadsheet_id", "range_name")):
+            msg = f"Invalid sheet source {data!r}, name, spreadsheet_id and range_name are required"
+            raise ConfigError(msg)
+        return cls(
+            name=str(data["name"]),
+            spreadsheet_id=str(data["spreadsheet_id"]),
+            range_name=str(data["range_name"]),
+            currency=str(data.get("currency") or "").upper(),
+        )
+
+
+def to_transaction(row: SheetTransaction, account: AccountRef, occurrence: int = 1) -> SimpleFinTransaction:
+    """The row as a transaction, the occurrence is how many identical rows without an ID came before, plus one."""
+    transacted_at = datetime.combine(row.date, time(), tzinfo=UTC)
+    fallback_id = f"sheet-{row.checksum}" if occurrence == 1 else f"sheet-{row.checksum}-{occurrence}"
+    return SimpleFinTransaction(
+        id=row.id or fallback_id,
+        amount=row.amount,
+        description=row.payee,
+        memo="",
+        payee=row.payee,
+        posted=transacted_at,
+        transacted_at=transacted_at,
+        category=row.category or None,
+        currency=account.currency or None,
+        account=account,
+    )
+
+
+def fetch_sheet_source(google: GoogleClient, source: SheetSource, start_date: datetime) -> SimpleFinAccount:
+    """Reads the source sheet's rows dated on or after the start date as a single account."""
+    account_ref = AccountRef(
+        id=f"sheet:{source.spreadsheet_id}:{source.range_name}",
+        name=source.name,
+        org=ORGANIZATION.name,
+        currency=source.currency,
+    )
+    rows = google.get_transactions(source.spreadsheet_id, source.range_name)
+    occurrences: Counter[str] = Counter()
+    transactions: list[SimpleFinTransaction] = []
+    for row in rows:
+        if row.date < start_date.date():
+            continue
+        if not row.id:
+            occurrences[row.checksum] += 1
+        transactions.append(to_transaction(row, account_ref, occurrences[row.checksum] or 1))
+    logger.info("Read %d transactions from sheet source %s", len(transactions), source.name)
+    return SimpleFinAccount(
+        available_balance="",
+        balance="",
+        balance_date=0,
+        currency=source.currency,
+        holdings=[],
+        id=account_ref.id,
+        name=source.name,
+        org=ORGANIZATION,
+        transactions=transactions,
+    )
@@ -0,0 +1,71 @@
+"""
+Graceful shutdown on SIGINT and SIGTERM, for Kubernetes evictions, `systemctl stop` and laptops going to sleep.
+
+A signal requests a shutdown instead of interrupting whatever runs. An import still fetching stops at the
+next check, before anything was written, while one already writing to the sheet finishes its writes and
+saves its state, so the next run starts from a consistent sheet. A second signal interrupts at once, with a
+state file the next run learns from the write checkpoint which of the appends landed.
+"""
+
+import logging
+import signal
+import threading
+from collections.abc import Callable, Generator
+from contextlib import contextmanager
+from types import FrameType
+from typing import Final
+
+logger = logging.getLogger(__name__)
+
+SIGNALS: Final = (signal.SIGINT, signal.SIGTERM)
+
+_requested = threading.Event()
+# set while a graceful block has its handlers installed
+_handling = threading.Event()
+
+
+class ShutdownRequestedError(Exception): ...
+
+
+def requested() -> bool:
+    return _requested.is_set()
+
+
+def check() -> None:
+    """Stops an import that hasn't written anything yet once a shutdown was requested."""
+    if _requested.is_set():
+        msg = "Shutdown requested, the import stopped before writing anything"
+        raise ShutdownRequestedError(msg)
+
+
+@contextmanager
+def graceful(on_shutdown: Callable[[], None] | None = None) -> Generator[None, None, None]:
+    """
+    Handles SIGINT and SIGTERM within the block by requesting a shutdown, then calling on_shutdown.
+
+    The handlers are only installed from the main thread, the only one Python delivers signals to. A block
+    nested in another keeps the outer handlers, the request lasts until the outermost block ends.
+    """
+    if threading.current_thread() is not threading.main_thread() or _handling.is_set():
+        yield
+        return
+
+    def handle(signum: int, frame: FrameType | None) -> None:
+        del frame
+        if _requested.is_set():
+            raise KeyboardInterrupt
+        name = signal.Signals(signum).name
+        logger.warning("Received %s, finishing the writes in progress, send it again to exit now", name)
+        _requested.set()
+        if on_shutdown:
+            on_shutdown()
+
+    previous = {signum: signal.signal(signum, handle) for signum in SIGNALS}
+    _handling.set()
+    try:
+        yield
+    finally:
+        for signum, handler in previous.items():
+            _ = signal.signal(signum, handler)
+        _handling.clear()
+        _requested.clear()
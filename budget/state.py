@@ -0,0 +1,99 @@
+"""
+Remembers what sources already imported, for sources whose files can't be moved or flagged.
+
+The state file is a JSON object keyed by source, each holding the keys of the items the source
+imported, like the object keys of a bucket source. It also holds the ETag and Last-Modified
+validators of the SimpleFin requests, sent back so unchanged data isn't downloaded again, and the
+snapshot of the investment accounts' holdings the next run's are compared with, the checkpoint of
+the sheet writes of a run in progress and how far a backfill got. It is written once the run
+succeeded, the checkpoint as each write starts and ends. Several instances of a run may be open at
+once, each saves only the parts it changed over what the file holds then.
+"""
+
+import logging
+from collections.abc import Iterable
+from typing import Any
+
+from budget.clients.api import load_json, save_private_json
+
+logger = logging.getLogger(__name__)
+
+
+class ImportState:
+    """
+    The items each source imported, read from and saved to the state file.
+
+    Sample usage:
+    ```python
+    state = ImportState(path)
+    new_keys = [key for key in keys if not state.contains("bucket:statements", key)]
+    state.record("bucket:statements", new_keys)
+    ```
+    """
+
+    path: str
+    sources: dict[str, set[str]]
+    validators: dict[str, dict[str, str]]
+    holdings: dict[str, dict[str, str]]
+    writes: dict[str, Any]
+    backfill: dict[str, str]
+    changed: set[str]
+
+    def __init__(self, path: str) -> None:
+        self.path = path
+        data: dict[str, Any] = load_json(path) or {}
+        self.sources = {source: set(keys) for source, keys in data.get("sources", {}).items()}
+        self.validators = dict(data.get("validators", {}))
+        self.holdings = dict(data.get("holdings", {}))
+        self.writes = dict(data.get("writes", {}))
+        self.backfill = dict(data.get("backfill", {}))
+        self.changed = set()
+
+    def contains(self, source: str, key: str) -> bool:
+        return key in self.sources.get(source, set())
+
+    def add(self, source: str, keys: Iterable[str]) -> None:
+        self.sources.setdefault(source, set()).update(keys)
+        self.changed.add("sources")
+
+    def record(self, source: str, keys: Iterable[str]) -> None:
+        """Adds the keys and saves right away, called once their transactions are in the sheet."""
+        self.add(source, keys)
+        self.save()
+
+    def record_validators(self, validators: dict[str, dict[str, str]]) -> None:
+        """Replaces the HTTP validators and saves right away, called once the data they validate was imported."""
+        self.validators = dict(validators)
+        self.changed.add("validators")
+        self.save()
+
+    def record_holdings(self, holdings: dict[str, dict[str, str]]) -> None:
+        """Replaces the holdings snapshot and saves right away, called once the changes since it are written."""
+        self.holdings = dict(holdings)
+        self.changed.add("holdings")
+        self.save()
+
+    def record_writes(self, writes: dict[str, Any]) -> None:
+        """Replaces the write checkpoint and saves right away, called before and after each write to the sheet."""
+        self.writes = dict(writes)
+        self.changed.add("writes")
+        self.save()
+
+    def record_backfill(self, backfill: dict[str, str]) -> None:
+        """Replaces the backfill progress and saves right away, called once each window is imported."""
+        self.backfill = dict(backfill)
+        self.changed.add("backfill")
+        self.save()
+
+    def save(self) -> None:
+        """Writes the parts changed since loading over the file as it is now, the keys of sources are merged."""
+        data: dict[str, Any] = load_json(self.path) or {}
+        if "sources" in self.changed:
+            saved = {source: set(keys) for source, keys in data.get("sources", {}).items()}
+            for source, keys in self.sources.items():
+                saved.setdefault(source, set()).update(keys)
+            data["sources"] = {source: sorted(keys) for source, keys in saved.items()}
+        for part in self.changed - {"sources"}:
+            data[part] = getattr(self, part)
+        save_private_json(self.path, data)
+        logger.debug("Saved the import state to %s", self.path)
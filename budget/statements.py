@@ -0,0 +1,69 @@
+"""
+Parses the statement files that sources fetch, like the attachments of statement emails.
+
+The parser is picked by the file's extension. OFX and QFX files describe their own accounts, CSV
+files are read with the source's CSV profile (see budget.csv_source) and QIF files as is, both as a
+single account named after the source. Files of other types are skipped.
+"""
+
+import logging
+from pathlib import PurePath
+from typing import Any, Final, NamedTuple, Self
+
+from budget.config import ConfigError
+from budget.csv_source import CsvProfile, csv_account, csv_account_ref, parse_csv, source_profile
+from budget.models.simplefin import SimpleFinAccount
+from budget.ofx import parse_ofx
+from budget.qif import parse_qif
+
+logger = logging.getLogger(__name__)
+
+OFX_SUFFIXES: Final = (".ofx", ".qfx")
+CSV_SUFFIX: Final = ".csv"
+QIF_SUFFIX: Final = ".qif"
+
+
+class StatementError(ValueError): ...
+
+
+class StatementOptions(NamedTuple):
+    """How a source's statement files are read."""
+
+    name: str
+    profile: CsvProfile | None = None
+    currency: str = ""
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any], profiles_dir: str | None = None) -> Self:
+        return cls(
+            name=str(data["name"]),
+            profile=source_profile(data, profiles_dir),
+            currency=str(data.get("currency") or "").upper(),
+        )
+
+
+def is_statement(filename: str) -> bool:
+    return PurePath(filename).suffix.lower() in (*OFX_SUFFIXES, CSV_SUFFIX, QIF_SUFFIX)
+
+
+def parse_statement(filename: str, content: bytes, options: StatementOptions) -> list[SimpleFinAccount]:
+    """The accounts in a statement file, empty for files that aren't statements."""
+    suffix = PurePath(filename).suffix.lower()
+    if suffix in OFX_SUFFIXES:
+        # OFX 1.x files are often Windows-1252, only the payees would suffer from a wrong guess
+        return parse_ofx(content.decode("utf-8", errors="replace"), options.name, options.currency)
+    if suffix == QIF_SUFFIX:
+        return parse_qif(content.decode("utf-8-sig", errors="replace"), options.name, options.currency)
+    if suffix == CSV_SUFFIX:
+        if not options.profile:
+            msg = f"Unable to read {filename}, the {options.name} source has no CSV profile"
+            raise StatementError(msg)
+        account_ref = csv_account_ref(options.name, options.currency)
+        text = content.decode(options.profile.encoding, errors="replace")
+        try:
+            return [csv_account(account_ref, parse_csv(text, options.profile, account_ref))]
+        except ConfigError as e:
+            msg = f"Unable to read {filename}: {e}"
+            raise StatementError(msg) from e
+    logger.info("Skipping %s, it isn't a CSV, OFX or QIF statement", filename)
+    return []
@@ -0,0 +1,132 @@
+import sys
+from collections import Counter, defaultdict
+from collections.abc import Sequence
+from dataclasses import dataclass
+from datetime import date
+from decimal import Decimal
+from typing import Final, Self
+
+from budget.clients.google import GoogleClient
+from budget.main import Args
+from budget.models.google import SheetTransaction
+from budget.routing import TabRotation, is_routed_tab, rotation_end
+
+TOP_PAYEES: Final = 10
+UNCATEGORIZED: Final = "(uncategorized)"
+
+
+@dataclass()
+class StatsArgs:
+    class Error(Args.Error): ...
+
+    google_credentials: str
+    sheets_spreadsheet_id: str
+    sheets_range_name: str
+    month: str
+    tab_rotation: str = TabRotation.NONE
+    account_tab_template: str | None = None
+    # the tabs of the other ranges, which never hold transactions
+    auxiliary_tabs: frozenset[str] = frozenset()
+
+    @property
+    def month_start(self) -> date:
+        return date.fromisoformat(f"{self.month}-01")
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if not all((self.google_credentials, self.sheets_spreadsheet_id)):
+            errors.append("Google credentials are required")
+        try:
+            _ = self.month_start
+        except ValueError:
+            errors.append(f"Invalid month {self.month!r}, expected YYYY-MM")
+        if self.tab_rotation not in set(TabRotation):
+            errors.append(f"Tab rotation must be one of {', '.join(TabRotation)}")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise StatsArgs.Error(msg)
+
+
+@dataclass
+class MonthStats:
+    month: date
+    income: Decimal
+    expenses: Decimal
+    by_category: dict[str, Decimal]
+    by_payee: dict[str, Decimal]
+    count: int
+
+    @property
+    def net(self) -> Decimal:
+        return self.income + self.expenses
+
+    @classmethod
+    def from_transactions(cls, month: date, transactions: Sequence[SheetTransaction]) -> Self:
+        income = Decimal(0)
+        expenses = Decimal(0)
+        by_category: defaultdict[str, Decimal] = defaultdict(Decimal)
+        by_payee: defaultdict[str, Decimal] = defaultdict(Decimal)
+        count = 0
+        for transaction in transactions:
+            if (transaction.date.year, transaction.date.month) != (month.year, month.month):
+                continue
+            count += 1
+            if transaction.amount >= 0:
+                income += transaction.amount
+                continue
+            expenses += transaction.amount
+            by_category[transaction.category or UNCATEGORIZED] += transaction.amount
+            by_payee[transaction.payee] += transaction.amount
+        return cls(
+            month=month,
+            income=income,
+            expenses=expenses,
+            by_category=dict(by_category),
+            by_payee=dict(by_payee),
+            count=count,
+        )
+
+    def render(self) -> str:
+        lines = [f"Stats for {self.month:%B %Y} ({self.count} transactions)", ""]
+        lines.append("Spend by category:")
+        for category, amount in sorted(self.by_category.items(), key=lambda item: item[1]):
+            lines.append(f"  {category:<30} {-amount:>12,.2f}")
+
+        lines.extend(["", f"Top {TOP_PAYEES} payees:"])
+        top_payees = Counter({payee: -amount for payee, amount in self.by_payee.items()}).most_common(TOP_PAYEES)
+        for payee, amount in top_payees:
+            lines.append(f"  {payee:<30} {amount:>12,.2f}")
+
+        lines.extend(
+            [
+                "",
+                f"  {'Income':<30} {self.income:>12,.2f}",
+                f"  {'Expenses':<30} {-self.expenses:>12,.2f}",
+                f"  {'Net':<30} {self.net:>12,.2f}",
+            ]
+        )
+        return "\n".join(lines)
+
+
+def stats(args: StatsArgs) -> None:
+    """
+    Prints the month's spend across every tab routing writes to.
+
+    The rows imported before rotating stay in the base tab and a transaction whose date moved may sit in a
+    neighboring period's tab, so the month is picked out of all of them, skipping the periods ended before it.
+    """
+    rotation = TabRotation(args.tab_rotation)
+    with GoogleClient(args.google_credentials) as google:
+        tabs = [
+            title
+            for title in google.worksheet_titles(args.sheets_spreadsheet_id)
+            if is_routed_tab(title, args.sheets_range_name, rotation, args.account_tab_template, args.auxiliary_tabs)
+            and ((end := rotation_end(title, rotation)) is None or end >= args.month_start)
+        ]
+        transactions = [
+            transaction for tab in tabs for transaction in google.get_transactions(args.sheets_spreadsheet_id, tab)
+        ]
+
+    month_stats = MonthStats.from_transactions(args.month_start, transactions)
+    _ = sys.stdout.write(month_stats.render() + "\n")
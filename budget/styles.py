@@ -0,0 +1,129 @@
+"""
+Category emoji and colors, to make the transactions sheet scannable at a glance.
+
+A category's emoji is written in front of it in the category cell, where it is ignored when the sheet is
+read back. Its color becomes the background of its category cells through a conditional formatting rule on
+the category column, which also colors rows added or recategorized by hand. The rules are replaced after
+each import that wrote to a tab, rules added in the sheet for other columns or conditions are kept.
+
+Sample config:
+```yaml
+pipeline:
+  rules:
+    categories:
+      Groceries: {emoji: "🛒", color: "#d9ead3"}
+      Food:Restaurants: {emoji: "🍔", color: "#fce5cd"}
+```
+"""
+
+import logging
+import re
+from collections.abc import Mapping
+from typing import Any, Final, NamedTuple, Self
+
+from budget.clients.google import GoogleClient
+from budget.config import ConfigError
+from budget.models.google import SheetLayout, split_category
+
+logger = logging.getLogger(__name__)
+
+COLOR: Final = re.compile(r"^#[0-9a-fA-F]{6}$")
+
+
+class CategoryStyle(NamedTuple):
+    emoji: str = ""
+    color: str = ""
+
+    @classmethod
+    def from_dict(cls, category: str, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict):
+            msg = f"Invalid style for category {category}, expected emoji and color"
+            raise ConfigError(msg)
+        return cls(emoji=str(data.get("emoji") or "").strip(), color=str(data.get("color") or ""))
+
+    def background(self) -> dict[str, float]:
+        """The color as the fractions of red, green and blue the Sheets API takes."""
+        red, green, blue = (int(self.color[index : index + 2], 16) / 255 for index in (1, 3, 5))
+        return {"red": red, "green": green, "blue": blue}
+
+
+def validate_styles(styles: Mapping[str, CategoryStyle]) -> str | None:
+    invalid = [category for category, style in styles.items() if style.color and not COLOR.match(style.color)]
+    if invalid:
+        return f"Category colors must be written as #rrggbb, check {', '.join(invalid)}"
+    return None
+
+
+def category_emoji(styles: Mapping[str, CategoryStyle]) -> tuple[tuple[str, str], ...]:
+    """The emoji per category, as the sheet layout holds them."""
+    return tuple((category, style.emoji) for category, style in styles.items() if style.emoji)
+
+
+def cell_text(category: str, emoji: str, *, groups: bool = False) -> str:
+    """What the category cell of a category holds, without its group when the group has a column of its own."""
+    name = split_category(category)[1] if groups else category
+    return f"{emoji} {name}" if emoji else name
+
+
+def is_style_rule(rule: Mapping[str, Any], column: int) -> bool:
+    """Whether a conditional formatting rule colors the category column by its text, as the ones added here do."""
+    ranges = rule.get("ranges", [])
+    condition = rule.get("booleanRule", {}).get("condition", {})
+    return (
+        len(ranges) == 1
+        and ranges[0].get("startColumnIndex") == column
+        and ranges[0].get("endColumnIndex") == column + 1
+        and condition.get("type") == "TEXT_EQ"
+    )
+
+
+def color_rule(sheet_id: int, column: int, text: str, style: CategoryStyle) -> dict[str, Any]:
+    """Colors the cells of the column below the header holding exactly the text."""
+    return {
+        "ranges": [{"sheetId": sheet_id, "startRowIndex": 1, "startColumnIndex": column, "endColumnIndex": column + 1}],
+        "booleanRule": {
+            "condition": {"type": "TEXT_EQ", "values": [{"userEnteredValue": text}]},
+            "format": {"backgroundColor": style.background()},
+        },
+    }
+
+
+def style_requests(
+    sheet_id: int, rules: list[dict[str, Any]], styles: Mapping[str, CategoryStyle], layout: SheetLayout
+) -> list[dict[str, Any]]:
+    """Replaces the tab's category color rules, the existing rules are given in the sheet's order."""
+    column = layout.columns().index("category")
+    requests: list[dict[str, Any]] = [
+        # deleted from the last so the indexes of the remaining rules stay valid
+        {"deleteConditionalFormatRule": {"sheetId": sheet_id, "index": index}}
+        for index in reversed(range(len(rules)))
+        if is_style_rule(rules[index], column)
+    ]
+    colored = [(category, style) for category, style in styles.items() if style.color]
+    requests.extend(
+        {
+            "addConditionalFormatRule": {
+                "index": index,
+                "rule": color_rule(
+                    sheet_id, column, cell_text(category, style.emoji, groups=layout.category_groups), style
+                ),
+            }
+        }
+        for index, (category, style) in enumerate(colored)
+    )
+    return requests
+
+
+def apply_styles(
+    google: GoogleClient,
+    spreadsheet_id: str,
+    sheet_name: str,
+    styles: Mapping[str, CategoryStyle],
+    layout: SheetLayout,
+) -> None:
+    """Colors the tab's category cells by their category."""
+    if not any(style.color for style in styles.values()):
+        return
+    sheet_id, rules = google.conditional_formats(spreadsheet_id, sheet_name)
+    google.batch_update(spreadsheet_id, style_requests(sheet_id, rules, styles, layout))
+    logger.info("Applied the category colors to %s", sheet_name)
@@ -0,0 +1,252 @@
+"""
+A summary tab analyzing the transactions, for users who want their spend by category without building it.
+
+When the summary tab is set, each import creates the tab and a pivot table on it, or refreshes the one it
+created before, with a row per month and a column per category summing the amounts. The pivot reads the
+transactions tab without an end row, so it keeps up as rows are appended. It reads the single transactions
+tab, rotated and per-account tabs aren't summarized. Pivot tables only group dates by month and can't split
+rows, with a budget period other than monthly or with amortized rules the summary is a table of the spend per
+period and category written by each import.
+
+With charts on, the income, expenses and spend per category of each period are also written to a hidden data
+tab next to it, which two charts on the summary tab plot: the spend stacked by category and a line of income
+against expenses. Both are recreated over the whole data table after each import.
+
+Sample config:
+```yaml
+destination:
+  type: sheets
+  summary_range_name: summary
+  summary_charts: true
+```
+"""
+
+import logging
+from collections import defaultdict
+from collections.abc import Mapping, Sequence
+from decimal import Decimal
+from typing import Any, Final
+
+from budget.amortization import amortize
+from budget.clients.google import GoogleClient
+from budget.models.google import GoogleSheetRow, SheetLayout, SheetTransaction
+from budget.periods import MONTHLY, BudgetPeriod, Periods
+
+logger = logging.getLogger(__name__)
+
+SUMMARY_COLUMNS: Final = 26
+UNCATEGORIZED: Final = "(uncategorized)"
+CHART_WIDTH: Final = 720
+CHART_HEIGHT: Final = 400
+# rows of the default 21px between the charts stacked on the summary tab
+CHART_ROWS: Final = 21
+
+
+def column_offset(layout: SheetLayout, column: str) -> int:
+    return layout.columns().index(column)
+
+
+def pivot_table(source_sheet_id: int, layout: SheetLayout) -> dict[str, Any]:
+    """Spend summed by month down and category across, over the whole transactions tab."""
+    return {
+        # no end row, the pivot grows with the tab
+        "source": {"sheetId": source_sheet_id, "startColumnIndex": 0, "endColumnIndex": len(layout.columns())},
+        "rows": [
+            {
+                "sourceColumnOffset": column_offset(layout, "date"),
+                "showTotals": True,
+                "sortOrder": "ASCENDING",
+                "groupRule": {"dateTimeRule": {"type": "YEAR_MONTH"}},
+            }
+        ],
+        "columns": [
+            {"sourceColumnOffset": column_offset(layout, "category"), "showTotals": True, "sortOrder": "ASCENDING"}
+        ],
+        "values": [
+            {"sourceColumnOffset": column_offset(layout, "amount"), "summarizeFunction": "SUM", "name": "Amount"}
+        ],
+        "valueLayout": "HORIZONTAL",
+    }
+
+
+def pivot_table_request(source_sheet_id: int, summary_sheet_id: int, layout: SheetLayout) -> dict[str, Any]:
+    """Writes the pivot table to the summary tab's top left cell, replacing the one already there."""
+    return {
+        "updateCells": {
+            "start": {"sheetId": summary_sheet_id, "rowIndex": 0, "columnIndex": 0},
+            "rows": [{"values": [{"pivotTable": pivot_table(source_sheet_id, layout)}]}],
+            "fields": "pivotTable",
+        }
+    }
+
+
+def period_table(transactions: Sequence[SheetTransaction], periods: Periods) -> list[GoogleSheetRow]:
+    """The amounts summed by period down and category across, like the pivot table sums them by month."""
+    sums: defaultdict[tuple[str, str], Decimal] = defaultdict(Decimal)
+    for transaction in transactions:
+        label = periods.label(periods.start(transaction.date))
+        sums[label, transaction.category or UNCATEGORIZED] += transaction.amount
+    labels = sorted({label for label, _ in sums})
+    categories = sorted({category for _, category in sums})
+    rows: list[GoogleSheetRow] = [["Period", *categories, "Total"]]
+    for label in labels:
+        amounts = [sums.get((label, category), Decimal(0)) for category in categories]
+        rows.append([label, *(float(amount) for amount in amounts), float(sum(amounts))])
+    return rows
+
+
+def refresh_summary(
+    google: GoogleClient,
+    spreadsheet_id: str,
+    source_name: str,
+    summary_name: str,
+    layout: SheetLayout,
+    periods: Periods = MONTHLY,
+    amortization: Mapping[str, int] | None = None,
+) -> None:
+    """
+    Creates the summary tab and its pivot table over the transactions tab, or refreshes them.
+
+    Amortization holds the months each payee's transactions are spread over.
+    """
+    source_sheet_id = google.worksheet_id(spreadsheet_id, source_name)
+    summary_sheet_id = google.worksheet_id(spreadsheet_id, summary_name, cols=SUMMARY_COLUMNS)
+    if periods.period == BudgetPeriod.MONTHLY and not amortization:
+        google.batch_update(spreadsheet_id, [pivot_table_request(source_sheet_id, summary_sheet_id, layout)])
+        logger.info("Refreshed the pivot table on %s over %s", summary_name, source_name)
+        return
+    # a pivot table written before would sit over the table
+    no_pivot = {
+        "updateCells": {
+            "range": {"sheetId": summary_sheet_id, "startRowIndex": 0, "endRowIndex": 1},
+            "fields": "pivotTable",
+        }
+    }
+    google.batch_update(spreadsheet_id, [no_pivot])
+    transactions = amortize(google.get_transactions(spreadsheet_id, source_name), amortization or {})
+    table = period_table(transactions, periods)
+    google.replace_rows(spreadsheet_id, summary_name, table)
+    logger.info("Refreshed the %s summary on %s over %d periods", periods.period, summary_name, len(table) - 1)
+
+
+def data_tab(summary_name: str) -> str:
+    return f"{summary_name}-data"
+
+
+def chart_table(transactions: Sequence[SheetTransaction], periods: Periods = MONTHLY) -> list[GoogleSheetRow]:
+    """Period, income, expenses, then the spend per category, a row per period with expenses as positive amounts."""
+    income: defaultdict[str, Decimal] = defaultdict(Decimal)
+    expenses: defaultdict[str, Decimal] = defaultdict(Decimal)
+    spend: defaultdict[tuple[str, str], Decimal] = defaultdict(Decimal)
+    for transaction in transactions:
+        label = periods.label(periods.start(transaction.date))
+        if transaction.amount >= 0:
+            income[label] += transaction.amount
+            continue
+        expenses[label] -= transaction.amount
+        spend[label, transaction.category or UNCATEGORIZED] -= transaction.amount
+    labels = sorted({*income, *expenses})
+    categories = sorted({category for _, category in spend})
+    header = "Month" if periods.period == BudgetPeriod.MONTHLY else "Period"
+    rows: list[GoogleSheetRow] = [[header, "Income", "Expenses", *categories]]
+    rows.extend(
+        [
+            label,
+            float(income[label]),
+            float(expenses[label]),
+            *(float(spend.get((label, category), 0)) for category in categories),
+        ]
+        for label in labels
+    )
+    return rows
+
+
+def column_range(sheet_id: int, column: int, rows: int) -> dict[str, Any]:
+    return {
+        "sourceRange": {
+            "sources": [
+                {
+                    "sheetId": sheet_id,
+                    "startRowIndex": 0,
+                    "endRowIndex": rows,
+                    "startColumnIndex": column,
+                    "endColumnIndex": column + 1,
+                }
+            ]
+        }
+    }
+
+
+def chart_request(title: str, spec: dict[str, Any], summary_sheet_id: int, row: int, column: int) -> dict[str, Any]:
+    return {
+        "addChart": {
+            "chart": {
+                "spec": {"title": title, "basicChart": spec},
+                "position": {
+                    "overlayPosition": {
+                        "anchorCell": {"sheetId": summary_sheet_id, "rowIndex": row, "columnIndex": column},
+                        "widthPixels": CHART_WIDTH,
+                        "heightPixels": CHART_HEIGHT,
+                    }
+                },
+            }
+        }
+    }
+
+
+def chart_requests(
+    data_sheet_id: int, summary_sheet_id: int, table: Sequence[GoogleSheetRow], column: int, periods: Periods = MONTHLY
+) -> list[dict[str, Any]]:
+    """The spend by category and income against expenses charts over the data table, anchored at the column."""
+    rows = len(table)
+    months = [{"domain": column_range(data_sheet_id, 0, rows)}]
+    spend = {
+        "chartType": "COLUMN",
+        "stackedType": "STACKED",
+        "legendPosition": "RIGHT_LEGEND",
+        "headerCount": 1,
+        "domains": months,
+        "series": [
+            {"series": column_range(data_sheet_id, index, rows), "targetAxis": "LEFT_AXIS"}
+            for index in range(3, len(table[0]))
+        ],
+    }
+    cash_flow = {
+        "chartType": "LINE",
+        "legendPosition": "BOTTOM_LEGEND",
+        "headerCount": 1,
+        "domains": months,
+        "series": [
+            {"series": column_range(data_sheet_id, index, rows), "targetAxis": "LEFT_AXIS"} for index in (1, 2)
+        ],
+    }
+    return [
+        chart_request(f"{periods.title} spend by category", spend, summary_sheet_id, 0, column),
+        chart_request("Income vs expenses", cash_flow, summary_sheet_id, CHART_ROWS, column),
+    ]
+
+
+def refresh_charts(
+    google: GoogleClient,
+    spreadsheet_id: str,
+    source_name: str,
+    summary_name: str,
+    periods: Periods = MONTHLY,
+    amortization: Mapping[str, int] | None = None,
+) -> None:
+    """Rewrites the chart data from the transactions tab and recreates the summary tab's charts over it."""
+    transactions = amortize(google.get_transactions(spreadsheet_id, source_name), amortization or {})
+    table = chart_table(transactions, periods)
+    google.replace_rows(spreadsheet_id, data_tab(summary_name), table)
+    data_sheet_id = google.worksheet_id(spreadsheet_id, data_tab(summary_name))
+    summary_sheet_id = google.worksheet_id(spreadsheet_id, summary_name, cols=SUMMARY_COLUMNS)
+    # right of the pivot table or period table, which have a column per category plus the periods and the totals
+    column = len({transaction.category for transaction in transactions}) + 3
+    stale = google.chart_ids(spreadsheet_id, summary_name)
+    requests: list[dict[str, Any]] = [
+        {"updateSheetProperties": {"properties": {"sheetId": data_sheet_id, "hidden": True}, "fields": "hidden"}},
+        *({"deleteEmbeddedObject": {"objectId": chart_id}} for chart_id in stale),
+        *chart_requests(data_sheet_id, summary_sheet_id, table, column, periods),
+    ]
+    google.batch_update(spreadsheet_id, requests)
+    logger.info("Refreshed the charts on %s over %d periods", summary_name, len(table) - 1)
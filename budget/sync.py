@@ -0,0 +1,36 @@
+import logging
+from dataclasses import dataclass
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.main import Args, pull_sheet_edits
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class SyncArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+
+    def __post_init__(self) -> None:
+        if not self.args.ledger_file:
+            msg = "Invalid CLI Args \nSync needs the ledger, set --ledger-file"
+            raise SyncArgs.Error(msg)
+
+
+def sync(args: SyncArgs) -> int:
+    """
+    Pulls payees and categories edited in every transactions tab into the ledger, returning how many changed.
+
+    Edited transactions keep the edit when later runs record them again and when the sheet is reprojected,
+    with --sync-rules the edits are saved as mapping rules for the payees too.
+    """
+    base = args.args
+    with GoogleClient(base.google_credentials) as google, breaker("google").guard():
+        tabs = base.transaction_tabs(google.worksheet_titles(base.sheets_spreadsheet_id))
+        rows = [row for tab in tabs for row in google.get_rows(base.sheets_spreadsheet_id, tab)]
+        edits = pull_sheet_edits(base, google, rows)
+    logger.info("Synced %d edits from the sheet", edits)
+    return edits
@@ -0,0 +1,108 @@
+"""
+systemd integration of the daemon, readiness and watchdog notifications for `Type=notify` services.
+
+The daemon tells systemd it is ready once it started, and with `WatchdogSec` set it pings the
+watchdog at half the interval. The pings stop while an import makes no progress for longer than the
+interval, so systemd restarts an importer wedged mid-run. Outside systemd nothing is sent.
+`budget-import systemd-unit` prints a unit file to start from.
+"""
+
+import logging
+import os
+import shutil
+import socket
+import sys
+import threading
+from collections.abc import Callable
+from dataclasses import dataclass
+from pathlib import Path
+from typing import Final
+
+logger = logging.getLogger(__name__)
+
+WATCHDOG_SEC: Final = 900
+UNIT_TEMPLATE: Final = """\
+[Unit]
+Description=Budget importer
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart={exec_start}
+Restart=on-failure
+RestartSec=30
+# a single stage of an import, like a bank scrape, must finish within it
+WatchdogSec={watchdog_sec}
+NoNewPrivileges=yes
+PrivateTmp=yes
+
+[Install]
+WantedBy=multi-user.target
+"""
+
+
+def notify(*states: str) -> bool:
+    """Sends the states, like `READY=1`, to the service manager, returns whether there was one to send to."""
+    address = os.getenv("NOTIFY_SOCKET")
+    if not address:
+        return False
+    # a leading @ is an abstract socket, which starts with a null byte
+    target = "\0" + address[1:] if address.startswith("@") else address
+    try:
+        with socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM) as sock:
+            _ = sock.sendto("\n".join(states).encode(), target)
+    except OSError:
+        logger.exception("Failed to notify systemd")
+        return False
+    return True
+
+
+def watchdog_timeout() -> float | None:
+    """Seconds of the service's watchdog, None when it has none or it watches another process."""
+    usec = os.getenv("WATCHDOG_USEC")
+    pid = os.getenv("WATCHDOG_PID")
+    if not usec or not usec.isdigit() or (pid and pid != str(os.getpid())):
+        return None
+    return int(usec) / 1_000_000
+
+
+def start_watchdog(stalled: Callable[[float], bool], stop: threading.Event) -> threading.Thread | None:
+    """
+    Pings the watchdog at half its timeout until stop is set, skipping the pings while stalled.
+
+    Stalled is called with the timeout and tells whether the daemon made no progress for that long.
+    """
+    timeout = watchdog_timeout()
+    if not timeout:
+        return None
+
+    def ping() -> None:
+        while not stop.wait(timeout / 2):
+            if stalled(timeout):
+                logger.warning("No progress for %ds, leaving the watchdog to restart the importer", timeout)
+                continue
+            _ = notify("WATCHDOG=1")
+
+    thread = threading.Thread(target=ping, name="watchdog", daemon=True)
+    thread.start()
+    logger.info("Pinging the systemd watchdog every %ds", timeout / 2)
+    return thread
+
+
+@dataclass()
+class SystemdUnitArgs:
+    config_path: str | None
+    watchdog_sec: int = WATCHDOG_SEC
+
+
+def exec_start(config_path: str | None) -> str:
+    executable = shutil.which("budget-import") or "budget-import"
+    config = f" --config {Path(config_path).resolve()}" if config_path else ""
+    return f"{executable}{config} daemon"
+
+
+def systemd_unit(args: SystemdUnitArgs) -> None:
+    """Prints a service unit running the daemon with the current config file."""
+    _ = sys.stdout.write(UNIT_TEMPLATE.format(exec_start=exec_start(args.config_path), watchdog_sec=args.watchdog_sec))
@@ -0,0 +1,502 @@
+"""
+In-memory fakes for testing sources, destinations and plugins written against the importer, without real APIs.
+
+`FakeSheets` is a `GoogleClient` keeping its spreadsheets in dictionaries. Rows hold what the sheet would
+display, the strings `get_rows` returns, and tabs are created on first write like they are in Sheets. Row
+and column insertions and deletions, developer metadata and the row IDs of `identify` are applied, the other
+batch requests, formatting, protection, pivot tables and charts, are only recorded in `requests`.
+
+`FakeSimpleFin` is a SimpleFIN bridge served over plain HTTP on localhost, its `access_url` works with the
+real `SimpleFinClient`. Accounts and transactions are added with `add_account` and `add_transaction`, the
+errors and notices of the next responses set with `errors` and `notices`, and `statuses` scripts the status
+of the next requests, like a 429 to test throttling or a 403 for a revoked access URL. Every request's query
+is kept in `requests`. Responses carry an ETag so conditional requests are answered with a 304 when the data
+didn't change.
+
+Sample usage:
+```python
+from unittest.mock import patch
+
+from budget.testing import FakeSheets, FakeSimpleFin
+
+sheets = FakeSheets()
+sheets.set_rows("sheet", "lookup", [["STARBUCKS", "Coffee"]])
+sheets.set_rows("sheet", "transactions", [])
+with (
+    FakeSimpleFin() as bridge,
+    patch("budget.main.GoogleClient", lambda _: sheets),
+    patch("budget.main.PaperlessClient.fetch_documents", return_value=[]),
+):
+    account = bridge.add_account("ACT-1", "Checking")
+    bridge.add_transaction(account, "TRN-1", "-4.50", "STARBUCKS", datetime.now(UTC))
+    url, username, password = split_access_url(bridge.access_url)
+    main(Args(simplefin_access_url=url, simplefin_username=username, simplefin_password=password, ...))
+assert sheets.rows("sheet", "transactions")[0][1] == "STARBUCKS"
+```
+"""
+
+import hashlib
+import json
+import threading
+from base64 import b64encode
+from collections import defaultdict, deque
+from collections.abc import Mapping, Sequence
+from dataclasses import dataclass, field
+from datetime import UTC, datetime
+from http import HTTPStatus
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from types import TracebackType
+from typing import Any, Final, Self, override
+from urllib.parse import parse_qs, urlsplit
+
+from gspread.exceptions import WorksheetNotFound
+from gspread.utils import ValueInputOption
+
+from budget.clients.google import DEFAULT_LAYOUT, NO_METADATA, GoogleClient, convert_to_row
+from budget.models.google import (
+    Category,
+    DriveFile,
+    GoogleSheetRow,
+    RowMetadata,
+    SheetLayout,
+    SheetTransaction,
+    parse_date,
+)
+from budget.models.simplefin import SimpleFinAccountDict, SimpleFinTransaction, SimpleFinTransactionDict
+
+FAKE_ORG: Final = {"domain": "bank.example.com", "name": "Fake Bank", "sfin_url": None}
+
+
+def display(value: str | float | bool) -> str:
+    """A cell as the sheet displays it, numbers with the two decimals of the amount format."""
+    if isinstance(value, bool):
+        return str(value).upper()
+    if isinstance(value, float):
+        return f"{value:.2f}"
+    return str(value)
+
+
+@dataclass
+class FakeTab:
+    sheet_id: int
+    rows: list[list[str]] = field(default_factory=list)
+    # the transaction IDs rows carry as developer metadata, moved with their rows
+    row_ids: list[str | None] = field(default_factory=list)
+    metadata: dict[str, dict[str, Any]] = field(default_factory=dict)
+
+    def insert(self, index: int, rows: Sequence[list[str]]) -> None:
+        self.rows[index:index] = [list(row) for row in rows]
+        self.row_ids[index:index] = [None] * len(rows)
+
+    def delete(self, start: int, end: int) -> None:
+        del self.rows[start:end]
+        del self.row_ids[start:end]
+
+
+class FakeSheets(GoogleClient):
+    """A Google Sheets client whose spreadsheets live in memory, tabs are keyed by spreadsheet ID and title."""
+
+    def __init__(self) -> None:
+        self.spreadsheets: defaultdict[str, dict[str, FakeTab]] = defaultdict(dict)
+        # the Drive files the Drive sources list and download, by folder ID
+        self.files: dict[str, dict[DriveFile, bytes]] = {}
+        self.requests: list[dict[str, Any]] = []
+        self.next_sheet_id = 1
+
+    @override
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+
+    def set_rows(self, spreadsheet_id: str, sheet_name: str, rows: Sequence[Sequence[str | float]]) -> None:
+        """Fills a tab, creating it, like a person editing the sheet would."""
+        self.replace_rows(spreadsheet_id, sheet_name, rows)
+
+    def rows(self, spreadsheet_id: str, sheet_name: str) -> list[list[str]]:
+        return self.get_rows(spreadsheet_id, sheet_name, missing_ok=True)
+
+    def tab(self, spreadsheet_id: str, sheet_name: str, *, create: bool = False) -> FakeTab:
+        tabs = self.spreadsheets[spreadsheet_id]
+        if sheet_name not in tabs:
+            if not create:
+                raise WorksheetNotFound(sheet_name)
+            tabs[sheet_name] = FakeTab(self.next_sheet_id)
+            self.next_sheet_id += 1
+        return tabs[sheet_name]
+
+    def tab_by_id(self, spreadsheet_id: str, sheet_id: int) -> FakeTab:
+        for tab in self.spreadsheets[spreadsheet_id].values():
+            if tab.sheet_id == sheet_id:
+                return tab
+        raise WorksheetNotFound(str(sheet_id))
+
+    @override
+    def get_category_mapping(self, spreadsheet_id: str, sheet_name: str) -> tuple[set[str], dict[str, Category]]:
+        values = self.get_rows(spreadsheet_id, sheet_name)
+        categories = {row[1] for row in values if len(row) > 1 and row[1]}
+        return categories, {row[0]: Category.from_row(row) for row in values}
+
+    @override
+    def update_category_mapping(self, spreadsheet_id: str, sheet_name: str, mapping: dict[str, Category]) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        rows = {row[0]: index for index, row in enumerate(tab.rows) if row}
+        for payee, category in mapping.items():
+            values = [payee, category.category or "", category.name or ""]
+            if payee in rows:
+                tab.rows[rows[payee]][:3] = values
+            else:
+                tab.insert(len(tab.rows), [values])
+
+    @override
+    def add_unmapped_payees(self, spreadsheet_id: str, sheet_name: str, counts: Mapping[str, int], seen: str) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name, create=True)
+        rows = {row[0]: row for row in tab.rows if row}
+        for payee, count in counts.items():
+            if payee in rows:
+                row = rows[payee]
+                previous = int(row[1]) if len(row) > 1 and row[1].isdigit() else 0
+                row[1:3] = [str(previous + count), seen]
+            else:
+                tab.insert(len(tab.rows), [[payee, str(count), seen]])
+
+    @override
+    def replace_rows(
+        self,
+        spreadsheet_id: str,
+        sheet_name: str,
+        rows: Sequence[GoogleSheetRow],
+        value_input_option: ValueInputOption = ValueInputOption.raw,
+    ) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name, create=True)
+        tab.delete(0, len(tab.rows))
+        tab.insert(0, [[display(value) for value in row] for row in rows])
+
+    @override
+    def get_transactions(self, spreadsheet_id: str, sheet_name: str) -> list[SheetTransaction]:
+        rows = self.get_rows(spreadsheet_id, sheet_name)
+        return [transaction for row in rows if (transaction := SheetTransaction.from_row(row))]
+
+    @override
+    def get_rows(self, spreadsheet_id: str, sheet_name: str, *, missing_ok: bool = False) -> list[list[str]]:
+        try:
+            tab = self.tab(spreadsheet_id, sheet_name)
+        except WorksheetNotFound:
+            if missing_ok:
+                return []
+            raise
+        return [list(row) for row in tab.rows]
+
+    @override
+    def get_values(
+        self, spreadsheet_id: str, sheet_name: str, *, formulas: bool = False
+    ) -> list[list[str | float | int]]:
+        return [list(row) for row in self.get_rows(spreadsheet_id, sheet_name)]
+
+    @override
+    def update_rows(
+        self, spreadsheet_id: str, sheet_name: str, rows: dict[int, GoogleSheetRow], layout: SheetLayout
+    ) -> None:
+        del layout
+        self.restore_rows(spreadsheet_id, sheet_name, rows)
+
+    @override
+    def restore_rows(self, spreadsheet_id: str, sheet_name: str, rows: dict[int, GoogleSheetRow]) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        for index, row in rows.items():
+            tab.rows[index - 1] = [display(value) for value in row]
+
+    @override
+    def update_cells(
+        self, spreadsheet_id: str, sheet_name: str, cells: Mapping[tuple[int, int], str | float]
+    ) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        for (row, column), value in cells.items():
+            cells_row = tab.rows[row - 1]
+            cells_row.extend([""] * (column - len(cells_row)))
+            cells_row[column - 1] = display(value)
+
+    @override
+    def insert_records_to_google_sheet(
+        self,
+        spreadsheet_id: str,
+        sheet_name: str,
+        transactions: Sequence[SimpleFinTransaction],
+        layout: SheetLayout = DEFAULT_LAYOUT,
+        metadata: RowMetadata = NO_METADATA,
+        *,
+        identify: bool = False,
+    ) -> None:
+        """Inserts the rows above the first older one, keeping the first row in place as sorting by date does."""
+        tab = self.tab(spreadsheet_id, sheet_name, create=True)
+        records = [[display(value) for value in convert_to_row(tran, layout, metadata)] for tran in transactions]
+        for record in records:
+            day = parse_date(record[3])
+            first = 1 if tab.rows else 0
+            position = next(
+                (
+                    index
+                    for index in range(first, len(tab.rows))
+                    if (existing := parse_date(tab.rows[index][3]) if len(tab.rows[index]) > 3 else None) is None
+                    or (day is not None and existing < day)
+                ),
+                len(tab.rows),
+            )
+            tab.insert(position, [record])
+            if identify:
+                tab.row_ids[position] = record[0]
+
+    @override
+    def row_ids(self, spreadsheet_id: str, sheet_name: str) -> dict[int, str]:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        return {index: row_id for index, row_id in enumerate(tab.row_ids, start=1) if row_id}
+
+    @override
+    def identify_rows(self, spreadsheet_id: str, sheet_name: str, ids: Mapping[int, str]) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        tab.row_ids = [ids.get(index) for index in range(1, len(tab.rows) + 1)]
+
+    @override
+    def worksheet_titles(self, spreadsheet_id: str) -> list[str]:
+        return list(self.spreadsheets[spreadsheet_id])
+
+    @override
+    def drive_files(self, folder_id: str) -> list[DriveFile]:
+        return list(self.files.get(folder_id, {}))
+
+    @override
+    def download_file(self, file_id: str) -> bytes:
+        for files in self.files.values():
+            for file, content in files.items():
+                if file.id == file_id:
+                    return content
+        raise FileNotFoundError(file_id)
+
+    @override
+    def worksheet_id(self, spreadsheet_id: str, sheet_name: str, cols: int | None = None) -> int:
+        return self.tab(spreadsheet_id, sheet_name, create=cols is not None).sheet_id
+
+    @override
+    def batch_update(self, spreadsheet_id: str, requests: Sequence[Mapping[str, Any]]) -> None:
+        """Records the requests, applying those that change the rows, columns or developer metadata."""
+        for request in requests:
+            self.requests.append(dict(request))
+            self._apply(spreadsheet_id, request)
+
+    def _apply(self, spreadsheet_id: str, request: Mapping[str, Any]) -> None:
+        if "insertDimension" in request or "deleteDimension" in request:
+            insert = "insertDimension" in request
+            dimension = request["insertDimension" if insert else "deleteDimension"]["range"]
+            tab = self.tab_by_id(spreadsheet_id, dimension["sheetId"])
+            start, end = dimension["startIndex"], dimension["endIndex"]
+            if dimension["dimension"] == "ROWS" and insert:
+                tab.insert(start, [[] for _ in range(end - start)])
+            elif dimension["dimension"] == "ROWS":
+                tab.delete(start, end)
+            for row in tab.rows if dimension["dimension"] == "COLUMNS" else ():
+                if insert and len(row) > start:
+                    row[start:start] = [""] * (end - start)
+                elif not insert:
+                    del row[start:end]
+        elif "createDeveloperMetadata" in request:
+            entry = dict(request["createDeveloperMetadata"]["developerMetadata"])
+            entry["metadataId"] = len(self.requests)
+            tab = self.tab_by_id(spreadsheet_id, entry["location"]["sheetId"])
+            tab.metadata[entry["metadataKey"]] = entry
+        elif "updateDeveloperMetadata" in request:
+            update = request["updateDeveloperMetadata"]
+            ids = {lookup["developerMetadataLookup"]["metadataId"] for lookup in update["dataFilters"]}
+            for tab in self.spreadsheets[spreadsheet_id].values():
+                for entry in tab.metadata.values():
+                    if entry["metadataId"] in ids:
+                        entry["metadataValue"] = update["developerMetadata"]["metadataValue"]
+
+    @override
+    def chart_ids(self, spreadsheet_id: str, sheet_name: str) -> list[int]:
+        return []
+
+    @override
+    def conditional_formats(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, list[dict[str, Any]]]:
+        return self.tab(spreadsheet_id, sheet_name).sheet_id, []
+
+    @override
+    def protected_ranges(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, list[dict[str, Any]]]:
+        return self.tab(spreadsheet_id, sheet_name).sheet_id, []
+
+    @override
+    def developer_metadata(self, spreadsheet_id: str, sheet_name: str) -> tuple[int, dict[str, dict[str, Any]]]:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        return tab.sheet_id, dict(tab.metadata)
+
+    @override
+    def delete_rows(self, spreadsheet_id: str, sheet_name: str, indexes: Sequence[int]) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name)
+        for index in sorted(indexes, reverse=True):
+            tab.delete(index - 1, index)
+
+    @override
+    def append_rows(self, spreadsheet_id: str, sheet_name: str, rows: Sequence[Sequence[str]]) -> None:
+        tab = self.tab(spreadsheet_id, sheet_name, create=True)
+        tab.insert(len(tab.rows), [[display(value) for value in row] for row in rows])
+
+
+class FakeBridgeServer(ThreadingHTTPServer):
+    daemon_threads = True
+    bridge: "FakeSimpleFin"
+
+
+class FakeBridgeHandler(BaseHTTPRequestHandler):
+    server: FakeBridgeServer
+
+    def do_GET(self) -> None:  # noqa: N802
+        bridge = self.server.bridge
+        url = urlsplit(self.path)
+        query = {key: values[-1] for key, values in parse_qs(url.query).items()}
+        with bridge.lock:
+            bridge.requests.append(query)
+            status = bridge.statuses.popleft() if bridge.statuses else HTTPStatus.OK
+        if self.headers.get("Authorization") != bridge.authorization:
+            status = HTTPStatus.FORBIDDEN
+        if url.path != "/simplefin/accounts":
+            status = HTTPStatus.NOT_FOUND
+        if status != HTTPStatus.OK:
+            self.send_response(status)
+            self.send_header("Content-Length", "0")
+            if status == HTTPStatus.TOO_MANY_REQUESTS:
+                self.send_header("Retry-After", "0")
+            self.end_headers()
+            return
+        body = json.dumps(bridge.response(query)).encode()
+        etag = f'"{hashlib.sha256(body).hexdigest()[:16]}"'
+        if self.headers.get("If-None-Match") == etag:
+            self.send_response(HTTPStatus.NOT_MODIFIED)
+            self.send_header("ETag", etag)
+            self.end_headers()
+            return
+        self.send_response(HTTPStatus.OK)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(body)))
+        self.send_header("ETag", etag)
+        for notice in bridge.notices:
+            self.send_header("X-API-Message", notice)
+        self.end_headers()
+        _ = self.wfile.write(body)
+
+    @override
+    def log_message(self, format: str, *args: Any) -> None:  # noqa: A002
+        del format, args
+
+
+class FakeSimpleFin:
+    """A SimpleFIN bridge on a free localhost port while the context is open, scripted through its attributes."""
+
+    def __init__(self, username: str = "user", password: str = "secret") -> None:  # noqa: S107
+        self.username = username
+        self.password = password
+        self.accounts: list[SimpleFinAccountDict] = []
+        self.errors: list[str] = []
+        self.notices: list[str] = []
+        # the statuses of the next requests, the rest are answered normally
+        self.statuses: deque[int] = deque()
+        self.requests: list[dict[str, str]] = []
+        self.lock = threading.Lock()
+        self.server: FakeBridgeServer | None = None
+
+    def __enter__(self) -> Self:
+        self.server = FakeBridgeServer(("127.0.0.1", 0), FakeBridgeHandler)
+        self.server.bridge = self
+        threading.Thread(target=self.server.serve_forever, daemon=True).start()
+        return self
+
+    def __exit__(
+        self,
+        exc_type: type[BaseException] | None,
+        exc_val: BaseException | None,
+        exc_tb: TracebackType | None,
+    ) -> None:
+        del exc_type, exc_val, exc_tb
+        if self.server:
+            self.server.shutdown()
+            self.server.server_close()
+            self.server = None
+
+    @property
+    def access_url(self) -> str:
+        if not self.server:
+            msg = "The fake SimpleFin bridge isn't running, use it as a context manager"
+            raise RuntimeError(msg)
+        host, port = self.server.server_address[:2]
+        return f"http://{self.username}:{self.password}@{host!s}:{port}/simplefin"
+
+    @property
+    def authorization(self) -> str:
+        return f"Basic {b64encode(f'{self.username}:{self.password}'.encode()).decode('ascii')}"
+
+    def add_account(
+        self, account_id: str, name: str, *, currency: str = "USD", balance: str = "0.00"
+    ) -> SimpleFinAccountDict:
+        account: SimpleFinAccountDict = {
+            "id": account_id,
+            "name": name,
+            "currency": currency,
+            "balance": balance,
+            "available-balance": balance,
+            "balance-date": int(datetime.now(UTC).timestamp()),
+            "org": dict(FAKE_ORG),  # pyright: ignore[reportAssignmentType]
+            "holdings": [],
+            "transactions": [],
+        }
+        with self.lock:
+            self.accounts.append(account)
+        return account
+
+    def add_transaction(
+        self,
+        account: SimpleFinAccountDict,
+        transaction_id: str,
+        amount: str,
+        payee: str,
+        posted: datetime,
+        *,
+        description: str | None = None,
+        memo: str = "",
+        pending: bool = False,
+    ) -> SimpleFinTransactionDict:
+        """Adds a transaction, a pending one isn't posted yet and has only its transaction date."""
+        transaction: SimpleFinTransactionDict = {
+            "id": transaction_id,
+            "amount": amount,
+            "description": description or payee,
+            "memo": memo,
+            "payee": payee,
+            "posted": 0 if pending else int(posted.timestamp()),
+            "transacted_at": int(posted.timestamp()),
+            "pending": pending,
+        }
+        with self.lock:
+            account["transactions"].append(transaction)
+        return transaction
+
+    def response(self, query: Mapping[str, str]) -> dict[str, Any]:
+        """The accounts with the transactions in the requested range, as the bridge answers."""
+        start = int(query.get("start-date", 0))
+        end = int(query["end-date"]) if "end-date" in query else None
+        pending = query.get("pending") == "1"
+        with self.lock:
+            accounts = [
+                {
+                    **account,
+                    "transactions": [
+                        transaction
+                        for transaction in account["transactions"]
+                        if (pending or not transaction.get("pending"))
+                        and (day := transaction["posted"] or transaction["transacted_at"]) >= start
+                        and (end is None or day < end)
+                    ],
+                }
+                for account in self.accounts
+            ]
+            return {"errors": list(self.errors), "accounts": accounts}
@@ -0,0 +1,146 @@
+"""
+Credit card payments, categorized as transfers so the spending isn't counted on the card and again when it's paid.
+
+A payment shows up twice, as a debit from the bank account and a credit to the card. Each configured pair of
+accounts matches the two legs by amount within a few days of each other and gives both the transfer category,
+whatever the mapping said. A pair with a payee pattern also marks the bank's debits matching it as transfers
+when the card's side isn't imported, like a card without a source. Accounts are named by their alias or ID.
+
+Sources that know both legs of a transfer tag them with a shared `transfer:<reference>` tag, like the two
+balances of a Wise conversion. Tagged legs are paired on the tag and categorized without any configuration.
+
+Sample config:
+```yaml
+pipeline:
+  transfers:
+    category: Transfer
+    window_days: 5
+    card_payments:
+      - bank: Joint checking
+        card: Amex
+        payee: "AMEX EPAYMENT"
+      - bank: Joint checking
+        card: ACT-3c1d
+```
+"""
+
+import logging
+import re
+from collections import defaultdict
+from collections.abc import Sequence
+from dataclasses import dataclass
+from datetime import timedelta
+from typing import Any, Final, Self
+
+from budget.config import ConfigError
+from budget.models.simplefin import AccountRef, SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+TRANSFER_CATEGORY: Final = "Transfer"
+TRANSFER_WINDOW_DAYS: Final = 5
+TRANSFER_TAG: Final = "transfer:"
+
+
+def in_account(account: AccountRef | None, name: str) -> bool:
+    return account is not None and name in {account.id, account.name}
+
+
+@dataclass(frozen=True)
+class CardPayment:
+    """The bank account a card is paid from, payee is a case-insensitive regular expression of the bank's debits."""
+
+    bank: str
+    card: str
+    payee: re.Pattern[str] | None = None
+
+    @classmethod
+    def from_dict(cls, data: dict[str, Any]) -> Self:
+        if not isinstance(data, dict) or not data.get("bank") or not data.get("card"):
+            msg = f"Invalid card payment {data!r}, bank and card are required"
+            raise ConfigError(msg)
+        try:
+            payee = re.compile(str(data["payee"]), re.IGNORECASE) if data.get("payee") else None
+        except re.error as e:
+            msg = f"Invalid card payment {data!r}, bad payee pattern: {e}"
+            raise ConfigError(msg) from e
+        return cls(bank=str(data["bank"]), card=str(data["card"]), payee=payee)
+
+    def is_debit(self, transaction: SimpleFinTransaction) -> bool:
+        return (
+            transaction.amount < 0
+            and in_account(transaction.account, self.bank)
+            and (not self.payee or bool(self.payee.search(transaction.payee)))
+        )
+
+    def is_credit(self, transaction: SimpleFinTransaction) -> bool:
+        return transaction.amount > 0 and in_account(transaction.account, self.card)
+
+
+def find_payments(
+    transactions: Sequence[SimpleFinTransaction], payments: Sequence[CardPayment], window_days: int
+) -> list[SimpleFinTransaction]:
+    """
+    The legs of the card payments among the transactions.
+
+    Each debit takes the closest credit of the same amount in time, a debit without one only counts when its
+    pair has a payee pattern.
+    """
+    window = timedelta(days=window_days)
+    legs: list[SimpleFinTransaction] = []
+    # a leg belongs to one payment, the first pair to match it
+    seen: set[int] = set()
+    for payment in payments:
+        credits = [transaction for transaction in transactions if payment.is_credit(transaction)]
+        for debit in (transaction for transaction in transactions if payment.is_debit(transaction)):
+            if id(debit) in seen:
+                continue
+            candidates = [
+                credit
+                for credit in credits
+                if id(credit) not in seen
+                and credit.amount == -debit.amount
+                and abs(credit.transacted_at - debit.transacted_at) <= window
+            ]
+            if candidates:
+                credit = min(candidates, key=lambda credit: abs(credit.transacted_at - debit.transacted_at))
+                seen.update((id(debit), id(credit)))
+                legs.extend((debit, credit))
+            elif payment.payee:
+                seen.add(id(debit))
+                legs.append(debit)
+    return legs
+
+
+def find_tagged(transactions: Sequence[SimpleFinTransaction]) -> list[SimpleFinTransaction]:
+    """The legs of the transfers their source tagged, a tag needs a debit and a credit, one leg alone isn't paired."""
+    by_tag: defaultdict[str, list[SimpleFinTransaction]] = defaultdict(list)
+    for transaction in transactions:
+        for tag in transaction.tags:
+            if tag.startswith(TRANSFER_TAG):
+                by_tag[tag].append(transaction)
+    return [
+        leg
+        for legs in by_tag.values()
+        if any(leg.amount < 0 for leg in legs) and any(leg.amount > 0 for leg in legs)
+        for leg in legs
+    ]
+
+
+def categorize_transfers(
+    transactions: Sequence[SimpleFinTransaction],
+    payments: Sequence[CardPayment],
+    category: str = TRANSFER_CATEGORY,
+    window_days: int = TRANSFER_WINDOW_DAYS,
+) -> int:
+    """Gives the legs of tagged transfers and card payments the transfer category, returning how many."""
+    tagged = find_tagged(transactions)
+    paired = {id(leg) for leg in tagged}
+    legs = [*tagged, *(leg for leg in find_payments(transactions, payments, window_days) if id(leg) not in paired)]
+    for leg in legs:
+        leg.category = category
+        # categorized by the pair, the payee doesn't need a mapping rule
+        leg.mapped = True
+    if legs:
+        logger.info("Categorized %d transfer legs as %s", len(legs), category)
+    return len(legs)
@@ -0,0 +1,50 @@
+import logging
+from dataclasses import dataclass
+
+from budget.circuit import breaker
+from budget.clients.google import GoogleClient
+from budget.ledger import Ledger
+from budget.main import Args
+
+logger = logging.getLogger(__name__)
+
+
+@dataclass()
+class UndoArgs:
+    class Error(Args.Error): ...
+
+    args: Args
+    run_id: str
+
+    def __post_init__(self) -> None:
+        errors: list[str] = []
+        if not self.run_id:
+            errors.append("A run ID is required")
+        if "run_id" not in self.args.layout.columns():
+            errors.append("Undo needs the run ID column, enable --run-id-column or --import-metadata")
+
+        if errors:
+            msg = f"Invalid CLI Args \n{'\n'.join(errors)}"
+            raise UndoArgs.Error(msg)
+
+
+def undo(args: UndoArgs) -> int:
+    """
+    Deletes the rows imported by a run from every transactions tab, returning how many were deleted.
+
+    The run's transactions are removed from the ledger too, so reprojecting doesn't bring them back.
+    """
+    column = args.args.layout.columns().index("run_id")
+    spreadsheet_id = args.args.sheets_spreadsheet_id
+    with GoogleClient(args.args.google_credentials) as google, breaker("google").guard():
+        tabs = args.args.transaction_tabs(google.worksheet_titles(spreadsheet_id))
+        deleted = sum(google.delete_rows_where(spreadsheet_id, tab, column, args.run_id) for tab in tabs)
+    if args.args.ledger_file:
+        with Ledger(args.args.ledger_file) as ledger:
+            removed = ledger.delete_run(args.run_id)
+        logger.info("Removed %d transactions of run %s from the ledger", removed, args.run_id)
+    if not deleted:
+        logger.warning("No rows found for run %s", args.run_id)
+    else:
+        logger.info("Undid run %s, deleted %d rows", args.run_id, deleted)
+    return deleted
@@ -0,0 +1,105 @@
+"""
+Sandboxed categorization rules compiled to WebAssembly, requires the optional `wasm` dependencies.
+
+The module gets no imports (no WASI, no filesystem or network) and runs with a fuel budget, so a
+buggy or hostile rule can neither escape nor hang the importer. It must export:
+
+    memory                                  the linear memory used to exchange JSON
+    alloc(len: i32) -> i32                  returns a pointer to `len` writable bytes
+    categorize(ptr: i32, len: i32) -> i64   reads the transaction JSON at ptr/len and returns the
+                                            result location packed as `(ptr << 32) | len`
+
+The input is the transaction JSON (see `SimpleFinTransaction.to_dict`) and the result is a UTF-8
+JSON object `{"category": str | null, "payee": str | null, "tags": [str, ...]}`, null or missing
+values leave the transaction untouched.
+"""
+
+import json
+import logging
+from collections.abc import Sequence
+from dataclasses import dataclass, field
+from typing import Any, Final, Self
+
+from wasmtime import Config, Engine, Instance, Module, Store, Trap, WasmtimeError
+
+from budget.models.simplefin import SimpleFinTransaction
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_FUEL: Final = 10_000_000
+U32_MASK: Final = 0xFFFFFFFF
+
+
+class WasmRuleError(Exception): ...
+
+
+@dataclass
+class WasmResult:
+    category: str | None = None
+    payee: str | None = None
+    tags: list[str] = field(default_factory=list)
+
+    @classmethod
+    def from_dict(cls, data: Any) -> Self:
+        if not isinstance(data, dict):
+            msg = f"Expected a JSON object, got {type(data).__name__}"
+            raise WasmRuleError(msg)
+        return cls(
+            category=data.get("category") or None,
+            payee=data.get("payee") or None,
+            tags=[str(tag) for tag in data.get("tags") or []],
+        )
+
+
+class WasmRules:
+    path: Final[str]
+    fuel: Final[int]
+    engine: Final[Engine]
+    module: Final[Module]
+
+    def __init__(self, path: str, fuel: int = DEFAULT_FUEL) -> None:
+        self.path = path
+        self.fuel = fuel
+        config = Config()
+        config.consume_fuel = True
+        self.engine = Engine(config)
+        try:
+            self.module = Module.from_file(self.engine, path)
+        except (OSError, WasmtimeError) as e:
+            msg = f"Unable to load WASM rules {path}: {e}"
+            raise WasmRuleError(msg) from e
+
+    def categorize(self, transaction: SimpleFinTransaction) -> WasmResult:
+        """Runs the hook for a single transaction in a fresh instance."""
+        store = Store(self.engine)
+        store.set_fuel(self.fuel)
+        try:
+            exports = Instance(store, self.module, []).exports(store)
+            memory, alloc, categorize = exports["memory"], exports["alloc"], exports["categorize"]
+
+            data = json.dumps(transaction.to_dict()).encode()
+            ptr = alloc(store, len(data))
+            memory.write(store, data, ptr)
+            packed = categorize(store, ptr, len(data))
+            out_ptr, out_len = (packed >> 32) & U32_MASK, packed & U32_MASK
+            output = memory.read(store, out_ptr, out_ptr + out_len)
+        except (KeyError, Trap, WasmtimeError) as e:
+            msg = f"WASM rules {self.path} failed on transaction {transaction.id}: {e}"
+            raise WasmRuleError(msg) from e
+
+        try:
+            return WasmResult.from_dict(json.loads(output))
+        except (UnicodeDecodeError, json.JSONDecodeError) as e:
+            msg = f"WASM rules {self.path} returned invalid JSON for transaction {transaction.id}: {e}"
+            raise WasmRuleError(msg) from e
+
+    def apply(self, transactions: Sequence[SimpleFinTransaction]) -> None:
+        """Applies the hook's category, payee and tags to each transaction."""
+        for transaction in transactions:
+            result = self.categorize(transaction)
+            if result.category:
+                transaction.category = result.category
+            if result.payee:
+                transaction.payee = result.payee
+            transaction.tags.extend(tag for tag in result.tags if tag not in transaction.tags)
+        logger.info("Applied WASM rules to %d transactions", len(transactions))
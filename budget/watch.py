@@ -0,0 +1,136 @@
+"""
+Watch folder for statement files saved by hand, like the CSV, OFX or QIF exports downloaded from a bank's site.
+
+The daemon polls the folder and starts an import when new statement files appear, other runs read
+the folder too. Files are read as statement files (see budget.statements), CSV files with the folder's
+profile or, without one, the profile whose header the export has. Once the run that imported them
+succeeded files move to the `processed` subfolder, files that can't be read move to `failed` right
+away and are reported, a dry run moves nothing. A file named like one already moved gets a number,
+`statement-1.csv`, instead of replacing it. Only the top level of the folder is watched.
+
+Sample config:
+```yaml
+watch:
+  dir: ~/Downloads/statements
+  currency: USD
+  profile: chase  # optional, detected from the header otherwise
+```
+"""
+
+import logging
+import time
+from collections.abc import Sequence
+from pathlib import Path
+from typing import Final, NamedTuple, Self
+
+from budget.config import ConfigError
+from budget.csv_source import CsvProfile, detect_profile, source_profile
+from budget.models.simplefin import SimpleFinAccount
+from budget.statements import CSV_SUFFIX, StatementError, StatementOptions, is_statement, parse_statement
+
+logger = logging.getLogger(__name__)
+
+PROCESSED_DIR: Final = "processed"
+FAILED_DIR: Final = "failed"
+# files modified more recently may still be downloading
+SETTLE_SECONDS: Final = 5
+WATCH_INTERVAL: Final = 10
+
+
+class WatchFolder(NamedTuple):
+    path: Path
+    profile: CsvProfile | None = None
+    currency: str = ""
+    profiles_dir: str | None = None
+
+    @property
+    def name(self) -> str:
+        """The name of the accounts of OFX and QIF files, CSV accounts are named after their profile."""
+        return self.path.name
+
+    @classmethod
+    def from_settings(
+        cls, path: str, profile: str | None = None, currency: str | None = None, profiles_dir: str | None = None
+    ) -> Self:
+        directory = Path(path).expanduser()
+        return cls(
+            path=directory,
+            profile=source_profile({"name": directory.name, "profile": profile}, profiles_dir) if profile else None,
+            currency=(currency or "").upper(),
+            profiles_dir=profiles_dir,
+        )
+
+    def pending_files(self) -> list[Path]:
+        """The statement files in the folder that haven't changed for a few seconds, in name order."""
+        if not self.path.is_dir():
+            return []
+        settled = time.time() - SETTLE_SECONDS
+        return sorted(
+            file
+            for file in self.path.iterdir()
+            if file.is_file() and is_statement(file.name) and file.stat().st_mtime <= settled
+        )
+
+    def options(self, file: Path, content: bytes) -> StatementOptions:
+        if file.suffix.lower() != CSV_SUFFIX:
+            return StatementOptions(name=self.name, currency=self.currency)
+        try:
+            profile = self.profile or detect_profile(content.decode("utf-8-sig", errors="replace"), self.profiles_dir)
+        except ConfigError as e:
+            msg = f"Unable to detect the CSV profile of {file.name}: {e}"
+            raise StatementError(msg) from e
+        if not profile:
+            msg = f"Unable to read {file.name}, no CSV profile matches its header"
+            raise StatementError(msg)
+        return StatementOptions(name=profile.name, profile=profile, currency=self.currency)
+
+
+def unique_target(directory: Path, name: str) -> Path:
+    """The path of the name in the directory, numbered like `statement-1.csv` when the name is taken."""
+    target = directory / name
+    count = 0
+    while target.exists():
+        count += 1
+        target = directory / f"{Path(name).stem}-{count}{Path(name).suffix}"
+    return target
+
+
+def move_files(folder: WatchFolder, files: Sequence[Path], subfolder: str) -> None:
+    if not files:
+        return
+    target = folder.path / subfolder
+    target.mkdir(exist_ok=True)
+    for file in files:
+        _ = file.rename(unique_target(target, file.name))
+    logger.info("Moved %d files to %s", len(files), target)
+
+
+def fetch_watch_folder(
+    folder: WatchFolder, notices: list[str], *, dry_run: bool = False
+) -> tuple[list[SimpleFinAccount], list[Path]]:
+    """
+    Reads the settled statement files of the folder, returning their accounts and the files read.
+
+    Files that can't be read are added to the notices and moved to the failed subfolder, unless in a dry run.
+    """
+    accounts: list[SimpleFinAccount] = []
+    files: list[Path] = []
+    failed: list[Path] = []
+    for file in folder.pending_files():
+        content = file.read_bytes()
+        try:
+            accounts.extend(parse_statement(file.name, content, folder.options(file, content)))
+        except StatementError as e:
+            notices.append(f"Watch folder {folder.path}: {e}")
+            failed.append(file)
+            continue
+        files.append(file)
+    if not dry_run:
+        move_files(folder, failed, FAILED_DIR)
+    logger.info("Read %d statement files from watch folder %s", len(files), folder.path)
+    return accounts, files
+
+
+def move_processed(folder: WatchFolder, files: Sequence[Path]) -> None:
+    """Moves the files to the processed subfolder, called once their transactions are in the sheet."""
+    move_files(folder, files, PROCESSED_DIR)
@@ -0,0 +1,298 @@
+import binascii
+import hmac
+import json
+import logging
+import threading
+from base64 import b64decode
+from collections.abc import Generator, Mapping
+from contextlib import contextmanager
+from datetime import timedelta
+from html import escape
+from http import HTTPStatus
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+from typing import TYPE_CHECKING, Any, Final, override
+
+from budget.clients.google import GoogleClient
+from budget.main import sheet_transactions
+from budget.models.google import Category, SheetTransaction
+from budget.runs import ProgressEvent, Run, RunTrigger
+
+if TYPE_CHECKING:
+    from budget.daemon import Daemon
+
+logger = logging.getLogger(__name__)
+
+RECENT_RUNS: Final = 10
+UNCATEGORIZED_LIMIT: Final = 25
+# the rotated tabs of periods ended longer ago aren't read on each refresh
+UNCATEGORIZED_DAYS: Final = 90
+# the pages a browser opens, signed in with HTTP Basic as a browser can't send a bearer token
+DASHBOARD_PATHS: Final = frozenset({"/", "/run"})
+
+PAGE: Final = """<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Budget Importer</title>
+<style>
+body {{ font-family: system-ui, sans-serif; margin: 2rem; color: #222; }}
+table {{ border-collapse: collapse; margin-bottom: 2rem; }}
+td, th {{ padding: 0.3rem 0.8rem; border-bottom: 1px solid #ddd; text-align: left; }}
+.succeeded {{ color: #18794e; }} .failed {{ color: #cd2b31; }} .running {{ color: #0b68cb; }}
+.notice {{ background: #fff4d5; padding: 0.5rem; }}
+button {{ font-size: 1.1rem; padding: 0.5rem 1.2rem; }}
+</style>
+</head>
+<body>
+<h1>Budget Importer</h1>
+<h2>Last run</h2>
+{last_run}
+<form method="post" action="/run"><button type="submit"{disabled}>Import now</button></form>
+<h2>Recent imports</h2>
+{recent}
+<h2>Uncategorized transactions</h2>
+{uncategorized}
+</body>
+</html>
+"""
+
+
+def render_last_run(run: Run | None) -> str:
+    if run is None:
+        return "<p>No runs yet.</p>"
+    finished = f"{run.finished_at:%Y-%m-%d %H:%M:%S} UTC" if run.finished_at else "in progress"
+    error = f"<p class='failed'>{escape(run.error)}</p>" if run.error else ""
+    notices = "".join(f"<p class='notice'>{escape(notice)}</p>" for notice in run.notices)
+    return (
+        f"<p class='{run.status}'>{escape(run.status.capitalize())} ({escape(run.trigger)})"
+        f" &mdash; started {run.started_at:%Y-%m-%d %H:%M:%S} UTC, finished {finished},"
+        f" {len(run.transactions)} transactions imported</p>{error}{notices}"
+    )
+
+
+def render_recent(runs: list[Run]) -> str:
+    rows = [
+        f"<tr><td>{run.started_at:%Y-%m-%d %H:%M}</td><td>{escape(transaction.payee)}</td>"
+        f"<td>{transaction.amount:,.2f}</td><td>{escape(transaction.category or '')}</td></tr>"
+        for run in reversed(runs[-RECENT_RUNS:])
+        for transaction in run.transactions
+    ]
+    if not rows:
+        return "<p>Nothing imported recently.</p>"
+    return f"<table><tr><th>Run</th><th>Payee</th><th>Amount</th><th>Category</th></tr>{''.join(rows)}</table>"
+
+
+def render_uncategorized(transactions: list[SheetTransaction]) -> str:
+    uncategorized = sorted((t for t in transactions if not t.category), key=lambda t: t.date, reverse=True)
+    if not uncategorized:
+        return "<p>Everything is categorized.</p>"
+    rows = [
+        f"<tr><td>{t.date:%Y-%m-%d}</td><td>{escape(t.payee)}</td><td>{t.amount:,.2f}</td></tr>"
+        for t in uncategorized[:UNCATEGORIZED_LIMIT]
+    ]
+    return f"<table><tr><th>Date</th><th>Payee</th><th>Amount</th></tr>{''.join(rows)}</table>"
+
+
+def event_to_dict(event: ProgressEvent) -> dict[str, Any]:
+    return {"stage": event.stage, "count": event.count, "at": event.at.isoformat(), "message": event.message}
+
+
+def run_to_dict(run: Run) -> dict[str, Any]:
+    return {
+        "id": run.id,
+        "trigger": run.trigger,
+        "status": run.status,
+        "started_at": run.started_at.isoformat(),
+        "finished_at": run.finished_at.isoformat() if run.finished_at else None,
+        "error": run.error,
+        "notices": run.notices,
+        "transactions": [transaction.to_dict() for transaction in run.transactions],
+        "events": [event_to_dict(event) for event in run.events],
+    }
+
+
+def parse_mappings(body: Any) -> dict[str, Category]:
+    """Parses a `{"payee": {"category": ..., "name": ...}}` request body."""
+    if not isinstance(body, dict):
+        msg = "Expected an object keyed by payee"
+        raise ValueError(msg)  # noqa: TRY004
+    mapping: dict[str, Category] = {}
+    for payee, rule in body.items():
+        if not isinstance(rule, dict) or not set(rule) <= {"category", "name"}:
+            msg = f"Invalid mapping for {payee!r}, expected an object with category and/or name"
+            raise ValueError(msg)
+        mapping[str(payee)] = Category(category=rule.get("category"), name=rule.get("name"))
+    return mapping
+
+
+class DashboardServer(ThreadingHTTPServer):
+    daemon_threads = True
+    scheduler: "Daemon"
+    api_token: str | None = None
+
+
+class DashboardHandler(BaseHTTPRequestHandler):
+    """
+    Serves the dashboard and the REST API, both behind the API token when one is set.
+
+    The API takes the token as a bearer token. The dashboard's pages also take it as the password of HTTP
+    Basic, whatever the user name, and challenge for it, so the browser asks for it when the page is opened.
+
+    API endpoints:
+        POST /import                        start an import, 202 with the run or 409 if one is in progress
+        GET  /runs                          recent runs, newest first
+        GET  /runs/<id>                     a single run
+        GET  /transactions/pending-review   new transactions the next import would write, 409 during an import
+        GET  /mappings                      the category mapping keyed by payee
+        PUT  /mappings                      update or add mapping rules, other rules are left untouched
+    """
+
+    server: DashboardServer
+
+    def do_GET(self) -> None:  # noqa: N802
+        scheduler = self.server.scheduler
+        if not self.authorized():
+            self.unauthorized()
+        elif self.path == "/":
+            self.respond(HTTPStatus.OK, self.render().encode(), "text/html; charset=utf-8")
+        elif self.path == "/runs":
+            self.respond_json(HTTPStatus.OK, [run_to_dict(run) for run in reversed(scheduler.history)])
+        elif self.path.startswith("/runs/"):
+            run = scheduler.get_run(self.path.removeprefix("/runs/"))
+            if run:
+                self.respond_json(HTTPStatus.OK, run_to_dict(run))
+            else:
+                self.respond_json(HTTPStatus.NOT_FOUND, {"error": "Run not found"})
+        elif self.path == "/transactions/pending-review":
+            with self.upstream_errors():
+                pending = scheduler.pending_review()
+                if pending is None:
+                    self.respond_json(HTTPStatus.CONFLICT, {"error": "An import is in progress"})
+                else:
+                    self.respond_json(HTTPStatus.OK, [transaction.to_dict() for transaction in pending])
+        elif self.path == "/mappings":
+            args = scheduler.args
+            with self.upstream_errors(), GoogleClient(args.google_credentials) as google:
+                _, mapping = google.get_category_mapping(args.mapping_spreadsheet, args.mapping_range_name)
+                self.respond_json(HTTPStatus.OK, {payee: rule._asdict() for payee, rule in mapping.items()})
+        else:
+            self.respond_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+
+    def do_POST(self) -> None:  # noqa: N802
+        scheduler = self.server.scheduler
+        if not self.authorized():
+            self.unauthorized()
+        elif self.path == "/run":
+            _ = scheduler.start_import(RunTrigger.MANUAL)
+            self.send_response(HTTPStatus.SEE_OTHER)
+            self.send_header("Location", "/")
+            self.end_headers()
+        elif self.path == "/import":
+            run = scheduler.start_import(RunTrigger.API)
+            if run:
+                self.respond_json(HTTPStatus.ACCEPTED, run_to_dict(run))
+            else:
+                self.respond_json(HTTPStatus.CONFLICT, {"error": "An import is already in progress"})
+        else:
+            self.respond_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+
+    def do_PUT(self) -> None:  # noqa: N802
+        if not self.authorized():
+            self.unauthorized()
+            return
+        if self.path != "/mappings":
+            self.respond_json(HTTPStatus.NOT_FOUND, {"error": "Not found"})
+            return
+        try:
+            length = int(self.headers.get("Content-Length", 0))
+            mapping = parse_mappings(json.loads(self.rfile.read(length) or b"null"))
+        except ValueError as e:
+            self.respond_json(HTTPStatus.BAD_REQUEST, {"error": str(e)})
+            return
+        args = self.server.scheduler.args
+        with self.upstream_errors(), GoogleClient(args.google_credentials) as google:
+            google.update_category_mapping(args.mapping_spreadsheet, args.mapping_range_name, mapping)
+            self.respond_json(HTTPStatus.OK, {payee: category._asdict() for payee, category in mapping.items()})
+
+    @contextmanager
+    def upstream_errors(self) -> Generator[None, None, None]:
+        try:
+            yield
+        except Exception as e:
+            logger.exception("API request %s %s failed", self.command, self.path)
+            self.respond_json(HTTPStatus.BAD_GATEWAY, {"error": str(e) or type(e).__name__})
+
+    def authorized(self) -> bool:
+        token = self.server.api_token
+        if not token:
+            return True
+        scheme, _, credentials = self.headers.get("Authorization", "").partition(" ")
+        if scheme == "Basic" and self.path in DASHBOARD_PATHS:
+            try:
+                _, _, credentials = b64decode(credentials, validate=True).decode().partition(":")
+            except (binascii.Error, UnicodeDecodeError):
+                return False
+        elif scheme != "Bearer":
+            return False
+        return hmac.compare_digest(credentials.encode(), token.encode())
+
+    def unauthorized(self) -> None:
+        if self.path in DASHBOARD_PATHS:
+            body = b"<p>Sign in with the API token as the password.</p>"
+            challenge = {"WWW-Authenticate": 'Basic realm="Budget Importer", charset="UTF-8"'}
+            self.respond(HTTPStatus.UNAUTHORIZED, body, "text/html; charset=utf-8", challenge)
+        else:
+            self.respond_json(HTTPStatus.UNAUTHORIZED, {"error": "Unauthorized"})
+
+    def render(self) -> str:
+        scheduler = self.server.scheduler
+        args = scheduler.args
+        try:
+            since = args.clock().date() - timedelta(days=UNCATEGORIZED_DAYS)
+            with GoogleClient(args.google_credentials) as google:
+                transactions = sheet_transactions(args, google, since)
+            uncategorized = render_uncategorized(transactions)
+        except Exception:
+            logger.exception("Failed to read transactions for the dashboard")
+            uncategorized = "<p class='failed'>Unable to read the transactions sheet.</p>"
+
+        return PAGE.format(
+            last_run=render_last_run(scheduler.last_run),
+            disabled=" disabled" if scheduler.is_running else "",
+            recent=render_recent(list(scheduler.history)),
+            uncategorized=uncategorized,
+        )
+
+    def respond_json(self, status: HTTPStatus, data: Any) -> None:
+        self.respond(status, json.dumps(data).encode(), "application/json")
+
+    def respond(
+        self, status: HTTPStatus, body: bytes, content_type: str, headers: Mapping[str, str] | None = None
+    ) -> None:
+        self.send_response(status)
+        for name, value in (headers or {}).items():
+            self.send_header(name, value)
+        self.send_header("Content-Type", content_type)
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        _ = self.wfile.write(body)
+
+    @override
+    def log_message(self, format: str, *args: object) -> None:  # noqa: A002
+        logger.debug(format, *args)
+
+
+def create_server(scheduler: "Daemon", host: str, port: int, api_token: str | None = None) -> DashboardServer:
+    server = DashboardServer((host, port), DashboardHandler)
+    server.scheduler = scheduler
+    server.api_token = api_token
+    return server
+
+
+def serve_dashboard(scheduler: "Daemon", host: str, port: int, api_token: str | None = None) -> DashboardServer:
+    """Starts the dashboard web server in a background thread."""
+    server = create_server(scheduler, host, port, api_token)
+    threading.Thread(target=server.serve_forever, name="dashboard", daemon=True).start()
+    logger.info("Serving dashboard on http://%s:%d", host, port)
+    return server